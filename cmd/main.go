@@ -3,7 +3,9 @@ package main
 import (
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/LeeFred3042U/kitcat/internal/core"
 	"github.com/LeeFred3042U/kitcat/internal/models"
@@ -74,8 +76,28 @@ var commands = map[string]CommandFunc{
 			os.Exit(1)
 		}
 
+		opts, err := core.DefaultCommitOptions()
+		if err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+		// Signing flags may come before the message flag; everything after it is the message
+		var flags []string
+		for len(args) > 0 && args[0] != "-m" && args[0] != "-am" {
+			switch args[0] {
+			case "-S", "--gpg-sign":
+				opts.Sign = true
+			case "--no-gpg-sign":
+				opts.Sign = false
+			default:
+				flags = append(flags, args[0])
+			}
+			args = args[1:]
+		}
+		args = append(flags, args...)
+
 		if len(args) < 2 {
-			fmt.Println("Usage: kitcat commit <-m | -am | --amend> <message>")
+			fmt.Println("Usage: kitcat commit [-S] <-m | -am | --amend -m> <message>")
 			os.Exit(2)
 		}
 
@@ -94,7 +116,7 @@ var commands = map[string]CommandFunc{
 		// Normal commit flow
 		case "-am":
 			message = strings.Join(args[1:], " ")
-			newCommit, summary, err := core.CommitAll(message)
+			newCommit, summary, err := core.CommitAll(message, opts)
 			if err != nil {
 				if err.Error() == "nothing to commit, working tree clean" {
 					fmt.Println(err.Error())
@@ -113,7 +135,7 @@ var commands = map[string]CommandFunc{
 
 		// Handle amend or normal commit
 		if isAmend {
-			newCommit, err := core.AmendCommit(message)
+			newCommit, err := core.AmendCommit(message, opts)
 			if err != nil {
 				fmt.Println("Error:", err)
 				os.Exit(1)
@@ -127,7 +149,7 @@ var commands = map[string]CommandFunc{
 			fmt.Printf("[%s %s] %s (amended)\n", headState, newCommit.ID[:7], newCommit.Message)
 			os.Exit(0)
 		} else {
-			newCommit, summary, err := core.Commit(message)
+			newCommit, summary, err := core.CommitWithOptions(message, opts)
 			if err != nil {
 				if err.Error() == "nothing to commit, working tree clean" {
 					fmt.Println(err.Error())
@@ -142,13 +164,28 @@ var commands = map[string]CommandFunc{
 		}
 	},
 	"log": func(args []string) {
-		oneline := false
-		limit := -1
+		renames, err := core.DefaultRenameOptions()
+		if err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+		opts := core.LogOptions{Limit: -1, Renames: renames}
+		if args, err = opts.Renames.ParseFlags(args); err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(2)
+		}
+		var revisions []string
 		i := 0
 		for i < len(args) {
 			switch args[i] {
 			case "--oneline":
-				oneline = true
+				opts.Oneline = true
+				i++
+			case "--show-signature":
+				opts.ShowSignature = true
+				i++
+			case "--name-status":
+				opts.NameStatus = true
 				i++
 			case "-n":
 				if i+1 >= len(args) {
@@ -161,14 +198,18 @@ var commands = map[string]CommandFunc{
 					fmt.Println("Error: -n requires a positive integer argument")
 					os.Exit(2)
 				}
-				limit = n
+				opts.Limit = n
 				i += 2
 			default:
-				fmt.Printf("Error: unknown flag %s\n", args[i])
-				os.Exit(2)
+				if strings.HasPrefix(args[i], "-") {
+					fmt.Printf("Error: unknown flag %s\n", args[i])
+					os.Exit(2)
+				}
+				revisions = append(revisions, args[i])
+				i++
 			}
 		}
-		if err := core.ShowLog(oneline, limit); err != nil {
+		if err := core.ShowLog(opts, revisions); err != nil {
 			fmt.Println("Error:", err)
 			os.Exit(1)
 		}
@@ -186,24 +227,144 @@ var commands = map[string]CommandFunc{
 		}
 	},
 	"diff": func(args []string) {
-		staged := false
+		opts, err := core.DefaultDiffOptions()
+		if err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+		rest, err := opts.ParseFlags(args)
+		if err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(2)
+		}
+		// Without "--", arguments that are not revisions but exist on disk are paths
+		var revisions []string
+		for i, arg := range rest {
+			if _, err := core.ResolveRevision(arg); err != nil && len(opts.Paths) == 0 && !strings.Contains(arg, "..") {
+				if _, statErr := os.Stat(arg); statErr == nil {
+					opts.Paths = append(opts.Paths, rest[i:]...)
+					break
+				}
+			}
+			revisions = append(revisions, arg)
+		}
+		if err := core.Diff(opts, revisions); err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+	},
+	"format-patch": func(args []string) {
+		if !core.IsRepoInitialized() {
+			fmt.Println("Error: not a kitcat repository (or any of the parent directories): .kitcat")
+			os.Exit(1)
+		}
+		usage := "Usage: kitcat format-patch [-o <dir>] [--stdout] [-<n>] [<since> | <revision-range>]"
+		opts, err := core.DefaultFormatPatchOptions()
+		if err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+		rest, err := opts.ParseFlags(args)
+		if err != nil {
+			fmt.Println("Error:", err)
+			fmt.Println(usage)
+			os.Exit(2)
+		}
+		if len(rest) > 1 || (len(rest) == 0 && opts.Count == 0) {
+			fmt.Println(usage)
+			os.Exit(2)
+		}
+		var rev string
+		if len(rest) == 1 {
+			rev = rest[0]
+		}
+		names, err := core.FormatPatch(opts, rev)
+		if err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+		for _, name := range names {
+			fmt.Println(name)
+		}
+		os.Exit(0)
+	},
+	"apply": func(args []string) {
+		if !core.IsRepoInitialized() {
+			fmt.Println("Error: not a kitcat repository (or any of the parent directories): .kitcat")
+			os.Exit(1)
+		}
+		var opts core.ApplyOptions
+		var paths []string
 		for _, arg := range args {
 			switch arg {
-			case "--cached", "--staged":
-				staged = true
+			case "--check":
+				opts.Check = true
+			case "--index":
+				opts.Index = true
+			case "-3", "--3way":
+				opts.ThreeWay = true
 			default:
-				fmt.Println("Path filtering not supported")
+				if strings.HasPrefix(arg, "-") && arg != "-" {
+					fmt.Println("Error: unknown option", arg)
+					fmt.Println("Usage: kitcat apply [--check] [--index] [-3 | --3way] [<patch>...]")
+					os.Exit(2)
+				}
+				paths = append(paths, arg)
+			}
+		}
+		if err := core.Apply(paths, opts); err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	},
+	"am": func(args []string) {
+		if !core.IsRepoInitialized() {
+			fmt.Println("Error: not a kitcat repository (or any of the parent directories): .kitcat")
+			os.Exit(1)
+		}
+		usage := "Usage: kitcat am [-3 | --3way] [<mbox>...] | --continue | --skip | --abort"
+		if len(args) == 1 {
+			var step func() error
+			switch args[0] {
+			case "--continue":
+				step = core.AmContinue
+			case "--skip":
+				step = core.AmSkip
+			case "--abort":
+				step = core.AmAbort
+			}
+			if step != nil {
+				if err := step(); err != nil {
+					fmt.Println("Error:", err)
+					os.Exit(1)
+				}
+				os.Exit(0)
+			}
+		}
+		var opts core.AmOptions
+		var paths []string
+		for _, arg := range args {
+			switch {
+			case arg == "-3" || arg == "--3way":
+				opts.ThreeWay = true
+			case strings.HasPrefix(arg, "-"):
+				fmt.Println("Error: unknown option", arg)
+				fmt.Println(usage)
 				os.Exit(2)
+			default:
+				paths = append(paths, arg)
 			}
 		}
-		if err := core.Diff(staged); err != nil {
+		if err := core.Am(paths, opts); err != nil {
 			fmt.Println("Error:", err)
 			os.Exit(1)
 		}
+		os.Exit(0)
 	},
 	"checkout": func(args []string) {
 		if len(args) < 1 {
-			fmt.Println("Usage: kitcat checkout [-b] <branch-name> | <file-path> | <branch> -- <file-path>")
+			fmt.Println("Usage: kitcat checkout [-b] <branch-name> | <revision> | - | <file-path> | <revision> -- <file-path>")
 			os.Exit(2)
 		}
 
@@ -248,17 +409,15 @@ var commands = map[string]CommandFunc{
 				os.Exit(2)
 			}
 
-			// If a branch is specified before --, switch to it
+			// If a revision is specified before --, the files are restored from it
 			if len(branchArgs) == 1 {
-				branch := branchArgs[0]
-				if !core.IsBranch(branch) {
-					fmt.Printf("Error: Branch '%s' does not exist\n", branch)
-					os.Exit(1)
-				}
-				if err := core.CheckoutBranch(branch); err != nil {
-					fmt.Println("Error:", err)
-					os.Exit(1)
+				for _, file := range fileArgs {
+					if err := core.CheckoutFileFrom(branchArgs[0], file); err != nil {
+						fmt.Println("Error:", err)
+						os.Exit(1)
+					}
 				}
+				os.Exit(0)
 			} else if len(branchArgs) > 1 {
 				fmt.Println("Error: Too many arguments before --")
 				os.Exit(2)
@@ -278,10 +437,20 @@ var commands = map[string]CommandFunc{
 			os.Exit(0)
 		}
 
-		// No -- separator: fallback to old logic
+		// No -- separator: a branch or revision wins, otherwise it is a file
 		name := args[0]
-		if core.IsBranch(name) {
-			if err := core.CheckoutBranch(name); err != nil {
+		isRevision := name == "-" || core.IsBranch(name)
+		if !isRevision {
+			if _, err := core.ResolveCommit(name); err == nil {
+				if _, err := os.Stat(name); err == nil {
+					fmt.Printf("Error: '%s' is both a revision and a file; use '--' to separate paths from revisions\n", name)
+					os.Exit(2)
+				}
+				isRevision = true
+			}
+		}
+		if isRevision {
+			if err := core.Checkout(name); err != nil {
 				fmt.Println("Error:", err)
 				os.Exit(1)
 			}
@@ -297,11 +466,43 @@ var commands = map[string]CommandFunc{
 		}
 	},
 	"merge": func(args []string) {
+		usage := "Usage: kitcat merge [--ff | --no-ff | --ff-only] [--squash] [-X ours|theirs] <branch-name> | --continue | --abort"
 		if len(args) < 1 {
-			fmt.Println("Usage: kitcat merge <branch-name>")
+			fmt.Println(usage)
+			os.Exit(2)
+		}
+		switch args[0] {
+		case "--continue":
+			commit, summary, err := core.MergeContinue()
+			if err != nil {
+				fmt.Println("Error:", err)
+				os.Exit(1)
+			}
+			printCommitResult(commit, summary)
+			os.Exit(0)
+		case "--abort":
+			if err := core.MergeAbort(); err != nil {
+				fmt.Println("Error:", err)
+				os.Exit(1)
+			}
+			os.Exit(0)
+		}
+		opts, err := core.DefaultMergeOptions()
+		if err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+		rest, err := opts.ParseFlags(args)
+		if err != nil {
+			fmt.Println("Error:", err)
+			fmt.Println(usage)
+			os.Exit(2)
+		}
+		if len(rest) != 1 {
+			fmt.Println(usage)
 			os.Exit(2)
 		}
-		if err := core.Merge(args[0]); err != nil {
+		if err := core.MergeWithOptions(rest[0], opts); err != nil {
 			fmt.Println("Error:", err)
 			os.Exit(1)
 		}
@@ -309,12 +510,12 @@ var commands = map[string]CommandFunc{
 	},
 	"reset": func(args []string) {
 		if len(args) < 2 {
-			fmt.Println("Usage: kitcat reset --hard <commit-hash>")
+			fmt.Println("Usage: kitcat reset --hard <commit>")
 			os.Exit(2)
 		}
 		if args[0] != "--hard" {
 			fmt.Println("Error: only 'reset --hard' is currently supported")
-			fmt.Println("Usage: kitcat reset --hard <commit-hash>")
+			fmt.Println("Usage: kitcat reset --hard <commit>")
 			os.Exit(2)
 		}
 		if err := core.ResetHard(args[1]); err != nil {
@@ -422,24 +623,101 @@ var commands = map[string]CommandFunc{
 			os.Exit(1)
 		}
 
-		if len(args) == 1 && (args[0] == "--list") {
-			if err := core.PrintTags(); err != nil {
-				fmt.Println("Error:", err)
-				os.Exit(1)
+		usage := "Usage: kitcat tag [-a | -s] [-m <message>] <tag-name> [<commit>] | tag -d <tag-name>... | tag [-l] [-n[<num>]] [<pattern>]"
+		var (
+			list, del, annotate, sign, hasMessage bool
+			message                               string
+			lines                                 int
+			rest                                  []string
+		)
+		for i := 0; i < len(args); i++ {
+			arg := args[i]
+			switch {
+			case arg == "-l" || arg == "--list":
+				list = true
+			case arg == "-d" || arg == "--delete":
+				del = true
+			case arg == "-a" || arg == "--annotate":
+				annotate = true
+			case arg == "-s" || arg == "--sign":
+				annotate, sign = true, true
+			case arg == "-m" || arg == "--message":
+				if i+1 >= len(args) {
+					fmt.Println(usage)
+					os.Exit(2)
+				}
+				i++
+				message, hasMessage = args[i], true
+			case strings.HasPrefix(arg, "-m"):
+				message, hasMessage = strings.TrimPrefix(arg, "-m"), true
+			case strings.HasPrefix(arg, "--message="):
+				message, hasMessage = strings.TrimPrefix(arg, "--message="), true
+			case strings.HasPrefix(arg, "-n"):
+				lines = 1
+				if digits := strings.TrimPrefix(arg, "-n"); digits != "" {
+					n, err := strconv.Atoi(digits)
+					if err != nil || n < 0 {
+						fmt.Println(usage)
+						os.Exit(2)
+					}
+					lines = n
+				}
+			case strings.HasPrefix(arg, "-"):
+				fmt.Println(usage)
+				os.Exit(2)
+			default:
+				rest = append(rest, arg)
 			}
-			os.Exit(0)
 		}
 
-		if len(args) < 2 {
-			fmt.Println("Usage: kitcat tag <tag-name> <commit-id>")
+		var err error
+		switch {
+		case del:
+			if len(rest) == 0 || list || annotate || hasMessage {
+				fmt.Println(usage)
+				os.Exit(2)
+			}
+			for _, name := range rest {
+				if e := core.DeleteTag(name); e != nil {
+					fmt.Println("Error:", e)
+					err = e
+				}
+			}
+			if err != nil {
+				os.Exit(1)
+			}
+			os.Exit(0)
+		case list || len(rest) == 0 || (lines > 0 && !annotate && !hasMessage):
+			if len(rest) > 1 || annotate || hasMessage {
+				fmt.Println(usage)
+				os.Exit(2)
+			}
+			pattern := ""
+			if len(rest) == 1 {
+				pattern = rest[0]
+			}
+			err = core.PrintTags(pattern, lines)
+		case len(rest) > 2:
+			fmt.Println(usage)
 			os.Exit(2)
+		default:
+			revision := ""
+			if len(rest) == 2 {
+				revision = rest[1]
+			}
+			switch {
+			case hasMessage:
+				err = core.CreateAnnotatedTag(rest[0], revision, message, sign)
+			case annotate:
+				err = fmt.Errorf("annotated tags need a message; use -m <message>")
+			default:
+				err = core.CreateTag(rest[0], revision)
+			}
 		}
-
-		if err := core.CreateTag(args[0], args[1]); err != nil {
+		if err != nil {
 			fmt.Println("Error:", err)
 			os.Exit(1)
 		}
-
 		os.Exit(0)
 	},
 	"config": func(args []string) {
@@ -499,8 +777,19 @@ var commands = map[string]CommandFunc{
 		os.Exit(2)
 	},
 	"show-object": func(args []string) {
+		if len(args) == 2 && (args[0] == "-t" || args[0] == "-s") {
+			show := core.ShowObjectType
+			if args[0] == "-s" {
+				show = core.ShowObjectSize
+			}
+			if err := show(args[1]); err != nil {
+				fmt.Println("Error:", err)
+				os.Exit(1)
+			}
+			os.Exit(0)
+		}
 		if len(args) != 1 {
-			fmt.Println("Usage: kitcat show-object <hash>")
+			fmt.Println("Usage: kitcat show-object [-t | -s] <hash>")
 			os.Exit(2)
 			return
 		}
@@ -588,6 +877,209 @@ var commands = map[string]CommandFunc{
 
 		os.Exit(0)
 	},
+	"gc": func(args []string) {
+		core.EnsureArgs(args, 0, 0, "gc")
+		if !core.IsRepoInitialized() {
+			fmt.Println("Error: not a kitcat repository (or any of the parent directories): .kitcat")
+			os.Exit(1)
+		}
+		if err := core.GarbageCollect(); err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	},
+	"pack-refs": func(args []string) {
+		if !core.IsRepoInitialized() {
+			fmt.Println("Error: not a kitcat repository (or any of the parent directories): .kitcat")
+			os.Exit(1)
+		}
+		if len(args) > 1 || (len(args) == 1 && args[0] != "--all") {
+			fmt.Println("Usage: kitcat pack-refs [--all]")
+			os.Exit(2)
+		}
+		if err := core.PackRefs(len(args) == 1); err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	},
+	"verify-commit": func(args []string) {
+		if !core.IsRepoInitialized() {
+			fmt.Println("Error: not a kitcat repository (or any of the parent directories): .kitcat")
+			os.Exit(1)
+		}
+		if len(args) == 0 {
+			fmt.Println("Usage: kitcat verify-commit <commit>...")
+			os.Exit(2)
+		}
+		failed := false
+		for _, rev := range args {
+			if err := core.VerifyCommit(rev); err != nil {
+				fmt.Println("Error:", err)
+				failed = true
+			}
+		}
+		if failed {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	},
+	"verify-tag": func(args []string) {
+		if !core.IsRepoInitialized() {
+			fmt.Println("Error: not a kitcat repository (or any of the parent directories): .kitcat")
+			os.Exit(1)
+		}
+		if len(args) == 0 {
+			fmt.Println("Usage: kitcat verify-tag <tag>...")
+			os.Exit(2)
+		}
+		failed := false
+		for _, name := range args {
+			if err := core.VerifyTag(name); err != nil {
+				fmt.Println("Error:", err)
+				failed = true
+			}
+		}
+		if failed {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	},
+	"commit-graph": func(args []string) {
+		if !core.IsRepoInitialized() {
+			fmt.Println("Error: not a kitcat repository (or any of the parent directories): .kitcat")
+			os.Exit(1)
+		}
+		if len(args) != 1 || (args[0] != "write" && args[0] != "verify") {
+			fmt.Println("Usage: kitcat commit-graph <write|verify>")
+			os.Exit(2)
+		}
+		var err error
+		if args[0] == "write" {
+			err = core.WriteCommitGraph()
+		} else {
+			err = core.VerifyCommitGraph()
+		}
+		if err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	},
+	"reflog": func(args []string) {
+		if !core.IsRepoInitialized() {
+			fmt.Println("Error: not a kitcat repository (or any of the parent directories): .kitcat")
+			os.Exit(1)
+		}
+		usage := "Usage: kitcat reflog [show [<ref>]] | reflog expire [--expire=<time>] [--all | <ref>...]"
+		if len(args) > 0 && args[0] == "expire" {
+			expire := time.Now().Add(-core.DefaultReflogExpiry)
+			all := false
+			var refs []string
+			for i := 1; i < len(args); i++ {
+				arg := args[i]
+				switch {
+				case arg == "--all":
+					all = true
+				case arg == "--expire" && i+1 < len(args), strings.HasPrefix(arg, "--expire="):
+					value, ok := strings.CutPrefix(arg, "--expire=")
+					if !ok {
+						i++
+						value = args[i]
+					}
+					t, err := core.ParseExpiry(value, time.Now())
+					if err != nil {
+						fmt.Println("Error:", err)
+						os.Exit(2)
+					}
+					expire = t
+				case strings.HasPrefix(arg, "-"):
+					fmt.Println(usage)
+					os.Exit(2)
+				default:
+					refs = append(refs, arg)
+				}
+			}
+			if all == (len(refs) > 0) {
+				fmt.Println(usage)
+				os.Exit(2)
+			}
+			if err := core.ExpireReflog(expire, refs); err != nil {
+				fmt.Println("Error:", err)
+				os.Exit(1)
+			}
+			os.Exit(0)
+		}
+		if len(args) > 0 && args[0] == "show" {
+			args = args[1:]
+		}
+		if len(args) > 1 {
+			fmt.Println(usage)
+			os.Exit(2)
+		}
+		ref := ""
+		if len(args) == 1 {
+			ref = args[0]
+		}
+		if err := core.ShowReflog(ref); err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	},
+	"prune": func(args []string) {
+		if !core.IsRepoInitialized() {
+			fmt.Println("Error: not a kitcat repository (or any of the parent directories): .kitcat")
+			os.Exit(1)
+		}
+		dryRun := false
+		expire := time.Now().Add(-core.DefaultPruneExpiry)
+		for i := 0; i < len(args); i++ {
+			arg := args[i]
+			switch {
+			case arg == "-n" || arg == "--dry-run":
+				dryRun = true
+			case arg == "--expire" && i+1 < len(args), strings.HasPrefix(arg, "--expire="):
+				value, ok := strings.CutPrefix(arg, "--expire=")
+				if !ok {
+					i++
+					value = args[i]
+				}
+				t, err := core.ParseExpiry(value, time.Now())
+				if err != nil {
+					fmt.Println("Error:", err)
+					os.Exit(2)
+				}
+				expire = t
+			default:
+				fmt.Println("Usage: kitcat prune [-n | --dry-run] [--expire <time>]")
+				os.Exit(2)
+			}
+		}
+		if err := core.Prune(expire, dryRun); err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	},
+	"fsck": func(args []string) {
+		core.EnsureArgs(args, 0, 0, "fsck")
+		if !core.IsRepoInitialized() {
+			fmt.Println("Error: not a kitcat repository (or any of the parent directories): .kitcat")
+			os.Exit(1)
+		}
+		report, err := core.Fsck()
+		if err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+		report.Write(os.Stdout)
+		if len(report.Problems) > 0 {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	},
 	"stash": func(args []string) {
 		if !core.IsRepoInitialized() {
 			fmt.Println("Error: not a kitcat repository (or any of the parent directories): .kitcat")
@@ -670,6 +1162,10 @@ func main() {
 	}
 	cmd, args := os.Args[1], os.Args[2:]
 	if handler, ok := commands[cmd]; ok {
+		if err := core.OpenRepository(); err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
 		handler(args)
 	} else {
 		fmt.Println("Unknown command:", cmd)
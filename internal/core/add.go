@@ -20,29 +20,73 @@ func AddFile(path string) error {
 		return errors.New("not a kitcat repository (run `kitcat init`)")
 	}
 
+	info, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
 	hash, err := storage.HashAndStoreFile(path)
 	if err != nil {
 		return err
 	}
 
-	// Use UpdateIndex to safely update the index transactionally
-	return storage.UpdateIndex(func(index map[string]string) error {
-		// Skip if already tracked with same hash
-		if existing, ok := index[path]; ok && existing == hash {
-			return nil
+	// Use UpdateIndexEntries to safely update the index transactionally
+	return storage.UpdateIndexEntries(func(index map[string]storage.IndexEntry) error {
+		existing := index[path]
+		entry := storage.IndexEntry{
+			Hash: stagedHash(path, existing.Hash, hash),
+			Mode: storage.FileModeOf(info, existing.Mode),
 		}
-
-		index[path] = hash
+		entry.SetStat(info)
+		index[path] = entry
 		return nil
 	})
 }
 
+// stagedHash picks the hash to record in the index for a freshly hashed file.
+// If the existing entry is a legacy object with the same content, it is kept so that
+// re-adding an unchanged file does not show up as a change against older commits.
+func stagedHash(path, existing, hash string) string {
+	if existing == "" || existing == hash || !storage.IsLegacyObject(existing) {
+		return hash
+	}
+	if matches, err := storage.FileMatchesHash(path, existing); err == nil && matches {
+		return existing
+	}
+	return hash
+}
+
 // AddAll stages all changes in the working directory.
 // This includes new files, modified files, and deleted files.
 func AddAll() error {
+	if err := addAll(); err != nil {
+		return err
+	}
+	// Conflicted files present in the working directory were staged, resolving them;
+	// those the user deleted resolve as deletions
+	unmerged, err := storage.LoadUnmergedEntries()
+	if err != nil {
+		return err
+	}
+	for path := range unmerged {
+		if _, err := os.Lstat(path); os.IsNotExist(err) {
+			if err := storage.ResolveUnmerged(path); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func addAll() error {
 	// Use UpdateIndex to safely update the index transactionally.
 	// We hold the lock during the entire walk to ensure consistency.
-	return storage.UpdateIndex(func(index map[string]string) error {
+	return storage.UpdateIndexEntries(func(entries map[string]storage.IndexEntry) error {
+		// ShouldIgnore only needs to know which paths are tracked
+		index := make(map[string]string, len(entries))
+		for path, entry := range entries {
+			index[path] = entry.Hash
+		}
+
 		// Load ignore patterns
 		ignorePatterns, err := LoadIgnorePatterns()
 		if err != nil {
@@ -88,6 +132,12 @@ func AddAll() error {
 			// Mark this file as "seen" in the working directory
 			filesInWorkDir[cleanPath] = true
 
+			// Files whose stat data is unchanged since they were staged are skipped unread
+			existing, tracked := entries[cleanPath]
+			if tracked && existing.StatMatches(info) {
+				return nil
+			}
+
 			// Hash the file and add/update it in the index.
 			// This is the same logic as AddFile, but applied to every file we find
 			hash, err := storage.HashAndStoreFile(cleanPath)
@@ -96,7 +146,12 @@ func AddAll() error {
 				fmt.Printf("warning: could not add file %s: %v\n", cleanPath, err)
 				return nil
 			}
-			index[cleanPath] = hash
+			entry := storage.IndexEntry{
+				Hash: stagedHash(cleanPath, existing.Hash, hash),
+				Mode: storage.FileModeOf(info, existing.Mode),
+			}
+			entry.SetStat(info)
+			entries[cleanPath] = entry
 			return nil
 		})
 		if err != nil {
@@ -106,10 +161,10 @@ func AddAll() error {
 		// Find and handle deleted files.
 		// We loop through the original index. If a file from the index was NOT seen
 		// during our walk of the working directory, it must have been deleted
-		for pathInIndex := range index {
+		for pathInIndex := range entries {
 			if !filesInWorkDir[pathInIndex] {
 				// Remove the deleted file from our index map
-				delete(index, pathInIndex)
+				delete(entries, pathInIndex)
 			}
 		}
 
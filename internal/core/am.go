@@ -0,0 +1,398 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/LeeFred3042U/kitcat/internal/models"
+	"github.com/LeeFred3042U/kitcat/internal/storage"
+)
+
+// AmOptions controls how Am applies a patch series
+type AmOptions struct {
+	ThreeWay bool // fall back to a three-way merge when a patch's context does not match
+}
+
+// mailPatch is one message of a patch series
+type mailPatch struct {
+	AuthorName, AuthorEmail string
+	Date                    time.Time
+	Message                 string // the subject without its [PATCH] prefix, then the body
+	Diff                    []byte
+}
+
+// amState is an am run stopped at a patch that did not apply. The messages are kept in
+// AmDir as 0001, 0002, ... so the run can go on from where it stopped.
+type amState struct {
+	Next     int // the number of the patch being applied
+	Last     int
+	OrigHead string // the commit HEAD pointed to before am started, for --abort
+	ThreeWay bool
+}
+
+// Am applies the patches in the given mailbox files, or on standard input, committing each
+// with the author, date and message of its email. Files may hold a single patch, such as
+// those format-patch writes, or many, one after another, as 'format-patch --stdout' does.
+//
+// If a patch does not apply, am stops and leaves it to be resolved: fix the files, add
+// them and run AmContinue, or drop the patch with AmSkip, or go back with AmAbort.
+func Am(paths []string, opts AmOptions) error {
+	if IsAmInProgress() {
+		return errors.New("previous am still in progress: use 'kitcat am --continue', '--skip' or '--abort'")
+	}
+	if IsMergeInProgress() || IsRebaseInProgress() {
+		return errors.New("cannot apply patches while a merge or rebase is in progress")
+	}
+	if staged, err := hasStagedChanges(); err != nil {
+		return err
+	} else if staged {
+		return errors.New("your index has staged changes; commit or stash them before applying patches")
+	}
+
+	var messages [][]byte
+	if len(paths) == 0 {
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return err
+		}
+		messages = splitMailbox(data)
+	}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		messages = append(messages, splitMailbox(data)...)
+	}
+	if len(messages) == 0 {
+		return errors.New("no patches found")
+	}
+
+	var origHead string
+	if head, err := GetHeadCommit(); err == nil {
+		origHead = head.ID
+		if err := SafeWrite(OrigHeadPath, []byte(origHead+"\n"), 0o644); err != nil {
+			return err
+		}
+	}
+	state := amState{Next: 1, Last: len(messages), OrigHead: origHead, ThreeWay: opts.ThreeWay}
+	if err := os.MkdirAll(AmDir, 0o755); err != nil {
+		return err
+	}
+	for i, msg := range messages {
+		if err := os.WriteFile(amMessagePath(i+1), msg, 0o644); err != nil {
+			return err
+		}
+	}
+	if err := saveAmState(state); err != nil {
+		return err
+	}
+	return runAm(state)
+}
+
+// AmContinue commits the patch am stopped at, using what has been staged for it, and goes
+// on with the rest of the series
+func AmContinue() error {
+	state, err := loadAmState()
+	if err != nil {
+		return err
+	}
+	if unmerged, err := storage.HasUnmergedEntries(); err != nil {
+		return err
+	} else if unmerged {
+		return errors.New("you still have unmerged paths; fix them and run 'kitcat add'")
+	}
+	p, err := readAmPatch(state.Next)
+	if err != nil {
+		return err
+	}
+	if _, err := commitMailPatch(p); err != nil {
+		if errors.Is(err, errEmptyPatch) {
+			return errors.New("no changes - did you forget to use 'kitcat add'? If there is nothing left to stage, use 'kitcat am --skip'")
+		}
+		return err
+	}
+	state.Next++
+	if err := saveAmState(state); err != nil {
+		return err
+	}
+	return runAm(state)
+}
+
+// AmSkip drops the patch am stopped at, restoring the index and working tree to HEAD, and
+// goes on with the rest of the series
+func AmSkip() error {
+	state, err := loadAmState()
+	if err != nil {
+		return err
+	}
+	if head, err := GetHeadCommit(); err == nil {
+		if err := UpdateWorkspaceAndIndex(head.ID); err != nil {
+			return err
+		}
+	}
+	state.Next++
+	if err := saveAmState(state); err != nil {
+		return err
+	}
+	return runAm(state)
+}
+
+// AmAbort stops applying the series and puts the branch, index and working tree back as
+// they were before am started
+func AmAbort() error {
+	state, err := loadAmState()
+	if err != nil {
+		return err
+	}
+	if state.OrigHead != "" {
+		if err := ResetHard(state.OrigHead); err != nil {
+			return err
+		}
+	}
+	return os.RemoveAll(AmDir)
+}
+
+// IsAmInProgress reports whether am stopped at a patch that has yet to be resolved
+func IsAmInProgress() bool {
+	_, err := os.Stat(AmDir)
+	return err == nil
+}
+
+// runAm applies the patches from state.Next on, stopping at the first that fails
+func runAm(state amState) error {
+	for ; state.Next <= state.Last; state.Next++ {
+		if err := saveAmState(state); err != nil {
+			return err
+		}
+		p, err := readAmPatch(state.Next)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Applying: %s\n", subjectLine(p.Message))
+		conflicts, err := applyPatch(p.Diff, ApplyOptions{Index: true, ThreeWay: state.ThreeWay})
+		if err == nil && len(conflicts) > 0 {
+			for _, path := range conflicts {
+				fmt.Printf("CONFLICT (content): Merge conflict in %s\n", path)
+			}
+			err = errors.New("the three-way merge left conflicts")
+		}
+		if err == nil {
+			_, err = commitMailPatch(p)
+		}
+		if err != nil {
+			return fmt.Errorf("%w\nPatch failed at %04d %s\n"+
+				"When you have resolved this problem, run 'kitcat am --continue'.\n"+
+				"To skip this patch, run 'kitcat am --skip'; to restore the original branch, run 'kitcat am --abort'.",
+				err, state.Next, subjectLine(p.Message))
+		}
+	}
+	return os.RemoveAll(AmDir)
+}
+
+var errEmptyPatch = errors.New("patch is empty")
+
+// commitMailPatch commits the index with the patch's author, date and message
+func commitMailPatch(p mailPatch) (models.Commit, error) {
+	treeHash, err := storage.CreateTree()
+	if err != nil {
+		return models.Commit{}, err
+	}
+	var parents []string
+	if head, err := GetHeadCommit(); err == nil {
+		if head.TreeHash == treeHash {
+			return models.Commit{}, errEmptyPatch
+		}
+		parents = []string{head.ID}
+	}
+	sign, err := signByDefault()
+	if err != nil {
+		return models.Commit{}, err
+	}
+	commit := models.Commit{
+		Parents:     parents,
+		Message:     p.Message,
+		Timestamp:   p.Date,
+		TreeHash:    treeHash,
+		AuthorName:  p.AuthorName,
+		AuthorEmail: p.AuthorEmail,
+	}
+	return storeCommit(commit, "am: "+subjectLine(p.Message), sign)
+}
+
+// hasStagedChanges reports whether the index differs from HEAD
+func hasStagedChanges() (bool, error) {
+	if unmerged, err := storage.HasUnmergedEntries(); err != nil || unmerged {
+		return unmerged, err
+	}
+	head, err := headSnapshot()
+	if err != nil {
+		return false, err
+	}
+	index, err := indexSnapshot()
+	if err != nil {
+		return false, err
+	}
+	if len(head) != len(index) {
+		return true, nil
+	}
+	for path, entry := range index {
+		if h, ok := head[path]; !ok || !h.SameFile(entry) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// splitMailbox splits an mbox into its messages. Each starts with a "From " line at the
+// top of the file or after a blank line; a file that does not start with one is a single
+// message.
+func splitMailbox(data []byte) [][]byte {
+	var messages [][]byte
+	var cur bytes.Buffer
+	lines := splitLines(data)
+	for i, line := range lines {
+		if strings.HasPrefix(line, "From ") && i > 0 && strings.TrimSpace(lines[i-1]) == "" && cur.Len() > 0 {
+			messages = append(messages, bytes.Clone(cur.Bytes()))
+			cur.Reset()
+		}
+		cur.WriteString(line)
+	}
+	if len(bytes.TrimSpace(cur.Bytes())) > 0 {
+		messages = append(messages, cur.Bytes())
+	}
+	return messages
+}
+
+// parseMailPatch reads the author from the From header, the date from Date and the commit
+// message from Subject and the body up to the "---" line; the rest is the diff
+func parseMailPatch(msg []byte) (mailPatch, error) {
+	var p mailPatch
+	if strings.HasPrefix(string(msg), "From ") {
+		if _, rest, ok := bytes.Cut(msg, []byte("\n")); ok {
+			msg = rest
+		}
+	}
+	m, err := mail.ReadMessage(bytes.NewReader(msg))
+	if err != nil {
+		return p, fmt.Errorf("not a patch email: %w", err)
+	}
+	from, err := mail.ParseAddress(m.Header.Get("From"))
+	if err != nil {
+		return p, fmt.Errorf("patch has no valid From header: %w", err)
+	}
+	p.AuthorName, p.AuthorEmail = from.Name, from.Address
+	if p.AuthorName == "" {
+		p.AuthorName, _, _ = strings.Cut(from.Address, "@")
+	}
+	if p.Date, err = m.Header.Date(); err != nil {
+		p.Date = time.Now()
+	}
+	p.Date = p.Date.Truncate(time.Second)
+
+	subject, err := new(mime.WordDecoder).DecodeHeader(m.Header.Get("Subject"))
+	if err != nil {
+		subject = m.Header.Get("Subject")
+	}
+	subject = stripPatchPrefix(subject)
+	body, err := io.ReadAll(m.Body)
+	if err != nil {
+		return p, err
+	}
+
+	lines := splitLines(body)
+	end := len(lines)
+	for i, line := range lines {
+		line = strings.TrimRight(line, "\r\n")
+		if line == "---" {
+			end = i
+			break
+		}
+		if _, ok := cutDiffHeader(line); ok || strings.HasPrefix(line, "Index: ") {
+			end = i
+			break
+		}
+	}
+	p.Message = subject
+	if text := strings.TrimSpace(strings.Join(lines[:end], "")); text != "" {
+		p.Message += "\n\n" + text
+	}
+	p.Diff = []byte(strings.Join(lines[end:], ""))
+	return p, nil
+}
+
+// stripPatchPrefix removes "[PATCH 2/5]" and similar bracketed tags from the start of a
+// subject, along with any "Re:"
+func stripPatchPrefix(subject string) string {
+	for {
+		subject = strings.TrimSpace(subject)
+		switch {
+		case strings.HasPrefix(subject, "["):
+			end := strings.Index(subject, "]")
+			if end < 0 {
+				return subject
+			}
+			subject = subject[end+1:]
+		case len(subject) >= 3 && strings.EqualFold(subject[:3], "re:"):
+			subject = subject[3:]
+		default:
+			return subject
+		}
+	}
+}
+
+func amMessagePath(n int) string {
+	return filepath.Join(AmDir, fmt.Sprintf("%04d", n))
+}
+
+func readAmPatch(n int) (mailPatch, error) {
+	msg, err := os.ReadFile(amMessagePath(n))
+	if err != nil {
+		return mailPatch{}, err
+	}
+	return parseMailPatch(msg)
+}
+
+func saveAmState(state amState) error {
+	files := map[string]string{
+		"next":      strconv.Itoa(state.Next),
+		"last":      strconv.Itoa(state.Last),
+		"orig-head": state.OrigHead,
+		"threeway":  strconv.FormatBool(state.ThreeWay),
+	}
+	for name, value := range files {
+		if err := os.WriteFile(filepath.Join(AmDir, name), []byte(value+"\n"), 0o644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func loadAmState() (amState, error) {
+	var state amState
+	if !IsAmInProgress() {
+		return state, errors.New("no am in progress")
+	}
+	read := func(name string) string {
+		data, _ := os.ReadFile(filepath.Join(AmDir, name))
+		return strings.TrimSpace(string(data))
+	}
+	var err1, err2 error
+	state.Next, err1 = strconv.Atoi(read("next"))
+	state.Last, err2 = strconv.Atoi(read("last"))
+	if err1 != nil || err2 != nil {
+		return state, fmt.Errorf("corrupt am state in %s", AmDir)
+	}
+	state.OrigHead = read("orig-head")
+	state.ThreeWay = read("threeway") == "true"
+	return state, nil
+}
@@ -0,0 +1,65 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFormatPatchAndAm_RoundTrip(t *testing.T) {
+	dir, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	if err := SetConfig("user.name", "Ada Lovelace", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetConfig("user.email", "ada@example.com", false); err != nil {
+		t.Fatal(err)
+	}
+	commitFiles(t, "first", map[string]string{"a.txt": "one\ntwo\n"})
+	commitFiles(t, "Change two\n\nSpell it as a digit.", map[string]string{"a.txt": "one\n2\n"})
+	last := commitFiles(t, "Add b", map[string]string{"b.txt": "b\n"})
+
+	out := filepath.Join(dir, "patches")
+	opts := FormatPatchOptions{OutputDir: out, Renames: RenameOptions{Renames: true, Threshold: DefaultRenameThreshold}}
+	names, err := FormatPatch(opts, "HEAD~2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 2 || filepath.Base(names[0]) != "0001-Change-two.patch" {
+		t.Fatalf("wrote %q", names)
+	}
+	first, err := os.ReadFile(names[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"From: \"Ada Lovelace\" <ada@example.com>\n",
+		"Subject: [PATCH 1/2] Change two\n\nSpell it as a digit.\n---\n",
+		" 1 file changed, 1 insertion(+), 1 deletion(-)\n",
+		"-two\n+2\n",
+	} {
+		if !strings.Contains(string(first), want) {
+			t.Errorf("patch lacks %q:\n%s", want, first)
+		}
+	}
+
+	// Applying the series where it came from recreates the very same commits
+	if err := ResetHard("HEAD~2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := Am(names, AmOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	head, err := GetHeadCommit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if head.ID != last.ID {
+		t.Errorf("am made %+v, want %+v", head, last)
+	}
+	if IsAmInProgress() {
+		t.Error("am state left behind")
+	}
+}
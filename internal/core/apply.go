@@ -0,0 +1,590 @@
+package core
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/LeeFred3042U/kitcat/internal/diff"
+	"github.com/LeeFred3042U/kitcat/internal/storage"
+)
+
+// ApplyOptions controls how Apply changes files
+type ApplyOptions struct {
+	Check    bool // only report whether the patches apply, changing nothing
+	Index    bool // update the index as well as the working tree
+	ThreeWay bool // when a file's context no longer matches, merge with the blob the patch was made against; implies Index
+}
+
+// patchFile is one file's section of a patch. A path is empty on the side where the file
+// does not exist, and a mode is zero where the patch does not give one.
+type patchFile struct {
+	OldPath, NewPath string
+	OldMode, NewMode uint32
+	Rename, Copy     bool
+	OldHash, NewHash string // the abbreviated blob hashes of the "index" line
+	Binary           bool
+	Hunks            []diff.Hunk
+}
+
+// fileUpdate is one change applying a patch makes to the working tree
+type fileUpdate struct {
+	path     string
+	content  []byte
+	mode     uint32
+	remove   bool
+	conflict *storage.UnmergedEntry // set when a three-way merge left conflict markers in content
+	theirs   []byte                 // the patched preimage, for the conflict's "theirs" stage
+}
+
+// Apply applies each patch file to the working tree, or the patch on standard input if no
+// files are given. Patches in the format diff and format-patch write are understood, as are
+// those from git and from plain "diff -u"; paths lose their first component (a/ and b/).
+// Every file of a patch is checked before any is written, so a patch that does not apply
+// changes nothing.
+func Apply(paths []string, opts ApplyOptions) error {
+	if len(paths) == 0 {
+		paths = []string{"-"}
+	}
+	for _, path := range paths {
+		var data []byte
+		var err error
+		if path == "-" {
+			data, err = io.ReadAll(os.Stdin)
+		} else {
+			data, err = os.ReadFile(path)
+		}
+		if err != nil {
+			return err
+		}
+		conflicts, err := applyPatch(data, opts)
+		if err != nil {
+			return err
+		}
+		for _, path := range conflicts {
+			fmt.Printf("Applied patch to '%s' with conflicts.\n", path)
+		}
+		for _, path := range conflicts {
+			fmt.Printf("U %s\n", path)
+		}
+		if len(conflicts) > 0 {
+			return fmt.Errorf("patch applied with conflicts; fix them and run 'kitcat add'")
+		}
+	}
+	return nil
+}
+
+// applyPatch applies patch and returns the paths left with conflict markers by a
+// three-way merge
+func applyPatch(patch []byte, opts ApplyOptions) ([]string, error) {
+	files, err := parsePatch(patch)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no valid patches in input")
+	}
+	if opts.ThreeWay {
+		opts.Index = true
+	}
+	var index map[string]storage.IndexEntry
+	if opts.Index {
+		if index, err = storage.LoadIndexEntries(); err != nil {
+			return nil, err
+		}
+	}
+
+	var updates []fileUpdate
+	for _, f := range files {
+		u, err := planFile(f, index, opts)
+		if err != nil {
+			return nil, err
+		}
+		updates = append(updates, u...)
+	}
+	if opts.Check {
+		return nil, nil
+	}
+	return writeUpdates(updates, opts.Index)
+}
+
+// planFile works out what applying f does, without changing anything
+func planFile(f *patchFile, index map[string]storage.IndexEntry, opts ApplyOptions) ([]fileUpdate, error) {
+	for _, name := range []*string{&f.OldPath, &f.NewPath} {
+		if *name == "" {
+			continue
+		}
+		clean, err := cleanPatchPath(*name)
+		if err != nil {
+			return nil, err
+		}
+		*name = clean
+	}
+	if f.Binary {
+		return nil, fmt.Errorf("cannot apply binary patch to '%s'", f.displayPath())
+	}
+
+	var content []byte
+	var mode uint32
+	var ours *storage.IndexEntry
+	if f.OldPath == "" {
+		if _, err := os.Lstat(f.NewPath); err == nil {
+			return nil, fmt.Errorf("%s: already exists in working directory", f.NewPath)
+		}
+		if _, staged := index[f.NewPath]; staged {
+			return nil, fmt.Errorf("%s: already exists in index", f.NewPath)
+		}
+		mode = storage.ModeRegular
+	} else {
+		staged := f.OldMode
+		if entry, ok := index[f.OldPath]; ok {
+			staged = entry.Mode
+			ours = &entry
+		}
+		var err error
+		content, mode, err = readWorkingFile(f.OldPath, staged)
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("%s: does not exist in working directory", f.OldPath)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if opts.Index {
+			if ours == nil {
+				return nil, fmt.Errorf("%s: does not exist in index", f.OldPath)
+			}
+			if storage.HashObject(storage.ObjectBlob, content) != ours.Hash {
+				return nil, fmt.Errorf("%s: does not match index", f.OldPath)
+			}
+		}
+	}
+	if f.NewMode != 0 {
+		mode = f.NewMode
+	}
+
+	u := fileUpdate{path: f.NewPath, mode: mode}
+	result, err := applyHunks(content, f.Hunks)
+	if err != nil {
+		if !opts.ThreeWay || f.OldHash == "" || ours == nil {
+			return nil, fmt.Errorf("%s: %w", f.OldPath, err)
+		}
+		if u, err = threeWayFile(f, content, *ours, mode); err != nil {
+			return nil, err
+		}
+	} else {
+		u.content = result
+	}
+
+	if f.NewPath == "" {
+		if len(u.content) > 0 {
+			return nil, fmt.Errorf("%s: removal patch leaves file contents", f.OldPath)
+		}
+		return []fileUpdate{{path: f.OldPath, remove: true}}, nil
+	}
+	updates := []fileUpdate{u}
+	if f.OldPath != "" && f.OldPath != f.NewPath {
+		if _, err := os.Lstat(f.NewPath); err == nil {
+			return nil, fmt.Errorf("%s: already exists in working directory", f.NewPath)
+		}
+		if f.Rename {
+			updates = append(updates, fileUpdate{path: f.OldPath, remove: true})
+		}
+	}
+	return updates, nil
+}
+
+// cleanPatchPath normalizes a path named in a patch, rejecting one that leaves the working
+// tree, names the repository directory or leads through a symbolic link to a directory
+func cleanPatchPath(name string) (string, error) {
+	clean := path.Clean(filepath.ToSlash(name))
+	first, _, _ := strings.Cut(clean, "/")
+	if clean == "." || !IsSafePath(clean) || strings.EqualFold(first, RepoDir) {
+		return "", fmt.Errorf("invalid path '%s' in patch", name)
+	}
+	for dir := path.Dir(clean); dir != "."; dir = path.Dir(dir) {
+		if info, err := os.Lstat(filepath.FromSlash(dir)); err == nil && info.Mode()&os.ModeSymlink != 0 {
+			return "", fmt.Errorf("invalid path '%s' in patch: '%s' is a symbolic link", name, dir)
+		}
+	}
+	return clean, nil
+}
+
+// threeWayFile applies f to the blob it was made against and merges the result with ours,
+// the file as it is now. Regions both changed are written with conflict markers.
+func threeWayFile(f *patchFile, ours []byte, oursEntry storage.IndexEntry, mode uint32) (fileUpdate, error) {
+	baseHash, err := storage.ResolveObject(f.OldHash)
+	if err != nil {
+		return fileUpdate{}, fmt.Errorf("%s: patch does not apply and blob %s is not available for a three-way merge", f.OldPath, f.OldHash)
+	}
+	_, base, err := storage.ReadObject(baseHash)
+	if err != nil {
+		return fileUpdate{}, err
+	}
+	theirs, err := applyHunks(base, f.Hunks)
+	if err != nil {
+		return fileUpdate{}, fmt.Errorf("%s: patch does not apply to blob %s: %w", f.OldPath, f.OldHash, err)
+	}
+	if isBinary(base) || isBinary(ours) || isBinary(theirs) {
+		return fileUpdate{}, fmt.Errorf("%s: patch does not apply and binary files cannot be merged", f.OldPath)
+	}
+	alg, err := configuredDiffAlgorithm()
+	if err != nil {
+		return fileUpdate{}, err
+	}
+	u := fileUpdate{path: f.NewPath, mode: mode}
+	if merged, ok := mergeText(base, ours, theirs, "", alg); ok {
+		u.content = merged
+		return u, nil
+	}
+	u.content = conflictMarkers(base, ours, theirs, "ours", "theirs", alg)
+	u.theirs = theirs
+	u.conflict = &storage.UnmergedEntry{
+		Base: &storage.IndexEntry{Hash: baseHash, Mode: oursEntry.Mode},
+		Ours: &storage.IndexEntry{Hash: oursEntry.Hash, Mode: oursEntry.Mode},
+	}
+	return u, nil
+}
+
+// writeUpdates makes the planned changes to the working tree, and to the index if index is
+// set, and returns the paths left in conflict
+func writeUpdates(updates []fileUpdate, index bool) ([]string, error) {
+	for _, u := range updates {
+		if u.remove {
+			if err := os.Remove(u.path); err != nil && !os.IsNotExist(err) {
+				return nil, err
+			}
+		}
+	}
+	for _, u := range updates {
+		if !u.remove {
+			if err := writeWorkingFile(u.path, u.content, u.mode); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if !index {
+		return nil, nil
+	}
+
+	entries, err := storage.LoadIndexEntries()
+	if err != nil {
+		return nil, err
+	}
+	unmerged, err := storage.LoadUnmergedEntries()
+	if err != nil {
+		return nil, err
+	}
+	var conflicts []string
+	written := make(map[string]storage.IndexEntry)
+	for _, u := range updates {
+		switch {
+		case u.remove:
+			delete(entries, u.path)
+		case u.conflict != nil:
+			hash, err := storage.WriteObject(storage.ObjectBlob, u.theirs)
+			if err != nil {
+				return nil, err
+			}
+			u.conflict.Theirs = &storage.IndexEntry{Hash: hash, Mode: u.mode}
+			delete(entries, u.path)
+			unmerged[u.path] = *u.conflict
+			conflicts = append(conflicts, u.path)
+		default:
+			hash, err := storage.WriteObject(storage.ObjectBlob, u.content)
+			if err != nil {
+				return nil, err
+			}
+			written[u.path] = storage.IndexEntry{Hash: hash, Mode: u.mode}
+		}
+	}
+	recordStat(written)
+	for path, entry := range written {
+		entries[path] = entry
+	}
+	return conflicts, storage.WriteUnmergedIndex(entries, unmerged)
+}
+
+// displayPath is the path a patch file is known by
+func (f *patchFile) displayPath() string {
+	if f.NewPath != "" {
+		return f.NewPath
+	}
+	return f.OldPath
+}
+
+// applyHunks applies hunks to content. Each hunk is looked for where its header says,
+// shifted by however far the previous hunk moved, then ever further above and below.
+func applyHunks(content []byte, hunks []diff.Hunk) ([]byte, error) {
+	lines := splitLines(content)
+	var out []string
+	next, drift := 0, 0
+	for _, h := range hunks {
+		var old, new []string
+		for _, l := range h.Lines {
+			if l.Operation != diff.INSERT {
+				old = append(old, l.Text)
+			}
+			if l.Operation != diff.DELETE {
+				new = append(new, l.Text)
+			}
+		}
+		want := h.OldStart - 1
+		if h.OldLines == 0 {
+			want = h.OldStart // an insertion goes after line OldStart
+		}
+		at := findLines(lines, old, want+drift, next)
+		if at < 0 {
+			return nil, fmt.Errorf("patch does not apply at line %d", h.OldStart)
+		}
+		drift = at - want
+		out = append(out, lines[next:at]...)
+		out = append(out, new...)
+		next = at + len(old)
+	}
+	out = append(out, lines[next:]...)
+	return []byte(strings.Join(out, "")), nil
+}
+
+// findLines returns where want appears in lines at or after min, closest to at, or -1
+func findLines(lines, want []string, at, min int) int {
+	last := len(lines) - len(want)
+	for d := 0; at-d >= min || at+d <= last; d++ {
+		for _, i := range []int{at + d, at - d} {
+			if i >= min && i <= last && linesMatch(lines[i:i+len(want)], want) {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+func linesMatch(a, b []string) bool {
+	for i := range b {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// parsePatch splits a patch into its files. Text outside the file sections, such as the
+// mail headers and message of a format-patch file, is skipped.
+func parsePatch(patch []byte) ([]*patchFile, error) {
+	lines := splitLines(patch)
+	var files []*patchFile
+	var cur *patchFile
+	inHeader := false // between a "diff" line and the file's first hunk
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimRight(lines[i], "\r\n")
+		if rest, ok := cutDiffHeader(line); ok {
+			cur = &patchFile{}
+			cur.OldPath, cur.NewPath = headerPaths(rest)
+			files = append(files, cur)
+			inHeader = true
+			continue
+		}
+		if strings.HasPrefix(line, "--- ") && i+1 < len(lines) && strings.HasPrefix(lines[i+1], "+++ ") {
+			if !inHeader {
+				cur = &patchFile{}
+				files = append(files, cur)
+			}
+			cur.OldPath = markerPath(line[4:])
+			cur.NewPath = markerPath(strings.TrimRight(lines[i+1], "\r\n")[4:])
+			inHeader = false
+			i++
+			continue
+		}
+		if strings.HasPrefix(line, "@@ ") {
+			if cur == nil {
+				return nil, fmt.Errorf("patch fragment without header at line %d: %s", i+1, line)
+			}
+			hunk, end, err := parseHunk(lines, i)
+			if err != nil {
+				return nil, err
+			}
+			cur.Hunks = append(cur.Hunks, hunk)
+			inHeader = false
+			i = end - 1
+			continue
+		}
+		if inHeader {
+			if err := cur.parseHeaderLine(line); err != nil {
+				return nil, fmt.Errorf("line %d: %w", i+1, err)
+			}
+		}
+	}
+	return files, nil
+}
+
+// cutDiffHeader recognises the "diff --kitcat a/<old> b/<new>" line that starts a file,
+// or git's "diff --git" equivalent
+func cutDiffHeader(line string) (string, bool) {
+	if rest, ok := strings.CutPrefix(line, "diff --kitcat "); ok {
+		return rest, true
+	}
+	return strings.CutPrefix(line, "diff --git ")
+}
+
+// headerPaths splits "a/<old> b/<new>". The names may contain spaces, so where they are
+// the same, as they are unless the file was renamed, the split is in the middle.
+func headerPaths(rest string) (string, string) {
+	if n := (len(rest) - 5) / 2; n > 0 && len(rest) == 2*n+5 &&
+		strings.HasPrefix(rest, "a/") && rest[2+n:n+5] == " b/" && rest[2:2+n] == rest[n+5:] {
+		return rest[2 : 2+n], rest[n+5:]
+	}
+	if old, new, ok := strings.Cut(rest, " b/"); ok {
+		return strings.TrimPrefix(old, "a/"), new
+	}
+	return "", ""
+}
+
+// markerPath is the path of a "---" or "+++" line without its a/ or b/ prefix and any
+// timestamp after a tab, or "" for /dev/null
+func markerPath(name string) string {
+	name, _, _ = strings.Cut(name, "\t")
+	if name == "/dev/null" {
+		return ""
+	}
+	if _, rest, ok := strings.Cut(name, "/"); ok {
+		return rest
+	}
+	return name
+}
+
+// parseHeaderLine reads one of the lines between "diff" and the first hunk
+func (f *patchFile) parseHeaderLine(line string) error {
+	mode := func(value string) (uint32, error) {
+		m, err := strconv.ParseUint(value, 8, 32)
+		if err != nil {
+			return 0, fmt.Errorf("invalid mode %q", value)
+		}
+		return uint32(m), nil
+	}
+	var err error
+	switch {
+	case strings.HasPrefix(line, "new file mode "):
+		f.OldPath = ""
+		f.NewMode, err = mode(strings.TrimPrefix(line, "new file mode "))
+	case strings.HasPrefix(line, "deleted file mode "):
+		f.NewPath = ""
+		f.OldMode, err = mode(strings.TrimPrefix(line, "deleted file mode "))
+	case strings.HasPrefix(line, "old mode "):
+		f.OldMode, err = mode(strings.TrimPrefix(line, "old mode "))
+	case strings.HasPrefix(line, "new mode "):
+		f.NewMode, err = mode(strings.TrimPrefix(line, "new mode "))
+	case strings.HasPrefix(line, "rename from "):
+		f.OldPath, f.Rename = strings.TrimPrefix(line, "rename from "), true
+	case strings.HasPrefix(line, "rename to "):
+		f.NewPath, f.Rename = strings.TrimPrefix(line, "rename to "), true
+	case strings.HasPrefix(line, "copy from "):
+		f.OldPath, f.Copy = strings.TrimPrefix(line, "copy from "), true
+	case strings.HasPrefix(line, "copy to "):
+		f.NewPath, f.Copy = strings.TrimPrefix(line, "copy to "), true
+	case strings.HasPrefix(line, "index "):
+		fields := strings.Fields(strings.TrimPrefix(line, "index "))
+		if len(fields) == 0 {
+			return fmt.Errorf("invalid index line")
+		}
+		old, new, _ := strings.Cut(fields[0], "..")
+		if strings.Trim(old, "0") != "" {
+			f.OldHash = old
+		}
+		if strings.Trim(new, "0") != "" {
+			f.NewHash = new
+		}
+		if len(fields) > 1 {
+			if f.OldMode, err = mode(fields[1]); err == nil {
+				f.NewMode = f.OldMode
+			}
+		}
+	case strings.HasPrefix(line, "Binary files ") || line == "GIT binary patch":
+		f.Binary = true
+	}
+	return err
+}
+
+// parseHunk reads the hunk whose "@@" header is lines[start] and returns it with the
+// index of the first line after it
+func parseHunk(lines []string, start int) (diff.Hunk, int, error) {
+	header := strings.TrimRight(lines[start], "\r\n")
+	var h diff.Hunk
+	fields := strings.Fields(header)
+	if len(fields) < 4 || fields[3] != "@@" {
+		return h, 0, fmt.Errorf("invalid hunk header at line %d: %s", start+1, header)
+	}
+	var err1, err2 error
+	h.OldStart, h.OldLines, err1 = parseHunkRange(fields[1], "-")
+	h.NewStart, h.NewLines, err2 = parseHunkRange(fields[2], "+")
+	if err1 != nil || err2 != nil {
+		return h, 0, fmt.Errorf("invalid hunk header at line %d: %s", start+1, header)
+	}
+
+	oldLeft, newLeft := h.OldLines, h.NewLines
+	i := start + 1
+	for ; i < len(lines) && (oldLeft > 0 || newLeft > 0); i++ {
+		line := lines[i]
+		op, text := diff.EQUAL, ""
+		switch {
+		case line == "\n" || line == "\r\n":
+			text = line // a context line whose trailing space was lost
+		case line[0] == ' ':
+			text = line[1:]
+		case line[0] == '-':
+			op, text = diff.DELETE, line[1:]
+		case line[0] == '+':
+			op, text = diff.INSERT, line[1:]
+		case line[0] == '\\':
+			markNoNewline(&h)
+			continue
+		default:
+			return h, 0, fmt.Errorf("corrupt patch at line %d", i+1)
+		}
+		if op != diff.INSERT {
+			oldLeft--
+		}
+		if op != diff.DELETE {
+			newLeft--
+		}
+		h.Lines = append(h.Lines, diff.Line{Operation: op, Text: text})
+	}
+	if oldLeft != 0 || newLeft != 0 {
+		return h, 0, fmt.Errorf("truncated hunk at line %d: %s", start+1, header)
+	}
+	if i < len(lines) && strings.HasPrefix(lines[i], `\`) {
+		markNoNewline(&h)
+		i++
+	}
+	return h, i, nil
+}
+
+// markNoNewline drops the line ending of the hunk's last line, which a "\ No newline at end
+// of file" line follows
+func markNoNewline(h *diff.Hunk) {
+	if n := len(h.Lines); n > 0 {
+		h.Lines[n-1].Text = strings.TrimSuffix(h.Lines[n-1].Text, "\n")
+	}
+}
+
+// parseHunkRange parses "-<start>[,<count>]"; the count defaults to one
+func parseHunkRange(s, sign string) (int, int, error) {
+	s, ok := strings.CutPrefix(s, sign)
+	if !ok {
+		return 0, 0, fmt.Errorf("missing %s", sign)
+	}
+	startText, countText, hasCount := strings.Cut(s, ",")
+	start, err := strconv.Atoi(startText)
+	if err != nil {
+		return 0, 0, err
+	}
+	count := 1
+	if hasCount {
+		if count, err = strconv.Atoi(countText); err != nil {
+			return 0, 0, err
+		}
+	}
+	return start, count, nil
+}
@@ -0,0 +1,103 @@
+package core
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestApplyPatch_OffsetsRenamesAndNoNewline(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	// Two lines were added above the hunk since the patch was made
+	if err := os.WriteFile("a.txt", []byte("new\nlines\none\ntwo\nthree"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile("old.txt", []byte("moved\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	patch := "Some mail text before the diff\n---\n" +
+		"diff --kitcat a/a.txt b/a.txt\n" +
+		"index 1111111..2222222 100644\n" +
+		"--- a/a.txt\n" +
+		"+++ b/a.txt\n" +
+		"@@ -1,3 +1,3 @@\n" +
+		" one\n" +
+		"-two\n" +
+		"-three\n" +
+		"\\ No newline at end of file\n" +
+		"+2\n" +
+		"+three\n" +
+		"diff --kitcat a/old.txt b/new dir/new.txt\n" +
+		"similarity index 100%\n" +
+		"rename from old.txt\n" +
+		"rename to new dir/new.txt\n" +
+		"-- \nkitcat\n"
+
+	if _, err := applyPatch([]byte(patch), ApplyOptions{Check: true}); err != nil {
+		t.Fatalf("check: %v", err)
+	}
+	if _, err := os.Stat("new dir/new.txt"); err == nil {
+		t.Fatal("--check wrote files")
+	}
+	if _, err := applyPatch([]byte(patch), ApplyOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile("a.txt"); string(got) != "new\nlines\none\n2\nthree\n" {
+		t.Errorf("a.txt = %q", got)
+	}
+	if got, _ := os.ReadFile("new dir/new.txt"); string(got) != "moved\n" {
+		t.Errorf("renamed file = %q", got)
+	}
+	if _, err := os.Stat("old.txt"); !os.IsNotExist(err) {
+		t.Error("rename left the old file behind")
+	}
+
+	// The same patch no longer applies, and nothing is changed
+	_, err := applyPatch([]byte(patch), ApplyOptions{})
+	if err == nil || !strings.Contains(err.Error(), "a.txt") {
+		t.Fatalf("reapplying: %v", err)
+	}
+	if got, _ := os.ReadFile("a.txt"); string(got) != "new\nlines\none\n2\nthree\n" {
+		t.Errorf("failed patch changed a.txt to %q", got)
+	}
+}
+
+func TestApplyPatch_RejectsPathsIntoRepoDirAndThroughSymlinks(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	if err := os.MkdirAll("outside", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("outside", "link"); err != nil {
+		t.Fatal(err)
+	}
+	newFile := func(name string) string {
+		return "diff --kitcat a/" + name + " b/" + name + "\n" +
+			"new file mode 100644\n" +
+			"--- /dev/null\n" +
+			"+++ b/" + name + "\n" +
+			"@@ -0,0 +1 @@\n" +
+			"+evil\n"
+	}
+	for _, name := range []string{
+		".kitcat/evil",
+		"./.kitcat/evil",
+		".kitcat//evil",
+		"sub/../.kitcat/evil",
+		".KITCAT/evil",
+		"../evil",
+		"link/evil",
+	} {
+		if _, err := applyPatch([]byte(newFile(name)), ApplyOptions{}); err == nil {
+			t.Errorf("patch creating %q applied", name)
+		}
+	}
+	for _, path := range []string{".kitcat/evil", "outside/evil", "../evil"} {
+		if _, err := os.Lstat(path); !os.IsNotExist(err) {
+			t.Errorf("%s was written", path)
+		}
+	}
+}
@@ -10,20 +10,35 @@ import (
 	"github.com/LeeFred3042U/kitcat/internal/storage"
 )
 
-const headsDir string = ".kitcat/refs/heads"
-
-// IsValidRefName checks if the branch or tag name is safe and valid
+// IsValidRefName checks if the branch or tag name is safe and valid. Names may be
+// nested, as in "feature/login", and follow git's rules: no component may start with
+// "." or end in ".lock", and the name may not contain "..", "@{", "//", spaces, control
+// characters or any of ~ ^ : ? * [ \, nor start with "-" or "/" or end with "/" or ".".
 func IsValidRefName(name string) bool {
-	if !IsSafePath(name) {
+	if name == "" || name == "@" || name == "HEAD" || strings.HasPrefix(name, "-") {
 		return false
 	}
-	if strings.ContainsAny(name, "/\\") {
+	if !IsSafePath(name) {
 		return false
 	}
-	// Additional branch-specific checks (e.g., no spaces)
-	if strings.Contains(name, " ") {
+	for _, bad := range []string{"..", "@{", "//"} {
+		if strings.Contains(name, bad) {
+			return false
+		}
+	}
+	if strings.HasPrefix(name, "/") || strings.HasSuffix(name, "/") || strings.HasSuffix(name, ".") {
 		return false
 	}
+	for _, c := range name {
+		if c < 0x20 || c == 0x7f || strings.ContainsRune(" ~^:?*[\\", c) {
+			return false
+		}
+	}
+	for _, component := range strings.Split(name, "/") {
+		if strings.HasPrefix(component, ".") || strings.HasSuffix(component, ".lock") {
+			return false
+		}
+	}
 	return true
 }
 
@@ -42,11 +57,28 @@ func readHEAD() (string, error) {
 
 // readCommitHash reads the commit hash from the reference path
 func readCommitHash(referencePath string) (string, error) {
-	commitHash, err := os.ReadFile(filepath.Join(".kitcat", referencePath))
+	commitHash, ok, err := lookupRef(referencePath)
 	if err != nil {
 		return "", err
 	}
-	return strings.TrimSpace(string(commitHash)), nil
+	if !ok || commitHash == "" {
+		return "", fmt.Errorf("%s does not point to a commit yet", referencePath)
+	}
+	return commitHash, nil
+}
+
+// checkNewRef refuses to create ref if it, or a ref it would have to share a path with,
+// already exists. ignore names a ref that is about to go away.
+func checkNewRef(ref, ignore string) error {
+	if ref != ignore && refExists(ref) {
+		return fmt.Errorf("'%s' already exists", ref)
+	}
+	if existing, ok, err := refNameConflict(ref); err != nil {
+		return err
+	} else if ok && existing != ignore {
+		return fmt.Errorf("'%s' exists; cannot create '%s'", existing, ref)
+	}
+	return nil
 }
 
 // Create a new branch pointing to the current HEAD commit
@@ -57,6 +89,9 @@ func CreateBranch(name string) error {
 	if IsBranch(name) {
 		return fmt.Errorf("branch '%s' already exists", name)
 	}
+	if err := checkNewRef("refs/heads/"+name, ""); err != nil {
+		return err
+	}
 	head, err := readHEAD()
 	if err != nil {
 		return err
@@ -71,21 +106,12 @@ func CreateBranch(name string) error {
 		commitHash = lastCommit.ID
 	}
 
-	if err := os.MkdirAll(headsDir, 0o755); err != nil {
-		return err
-	}
-
-	branchPath := filepath.Join(headsDir, name)
-	return os.WriteFile(branchPath, []byte(strings.TrimSpace(commitHash)), 0o644)
+	return updateRef("refs/heads/"+name, strings.TrimSpace(commitHash), "branch: Created from HEAD")
 }
 
 // Checks if a branch with the given name exists.
 func IsBranch(name string) bool {
-	branchPath := filepath.Join(headsDir, name)
-	if _, err := os.Stat(branchPath); err == nil {
-		return true
-	}
-	return false
+	return IsValidRefName(name) && refExists("refs/heads/"+name)
 }
 
 // ListBranches lists all local branches and highlights the current one
@@ -101,19 +127,18 @@ func ListBranches() error {
 		}
 	}
 
-	// Read all files in the refs/heads directory
-	// Each file is a branch
-	branches, err := os.ReadDir(headsDir)
+	// Branches may be loose files, nested in directories, or packed
+	branches, err := listRefNames("refs/heads/")
 	if err != nil {
 		return err
 	}
 
 	for _, b := range branches {
-		if b.Name() == currentBranch {
+		if b == currentBranch {
 			// Print the current branch with a '*' and in color.
-			fmt.Printf("* %s%s%s\n", colorGreen, b.Name(), colorReset)
+			fmt.Printf("* %s%s%s\n", colorGreen, b, colorReset)
 		} else {
-			fmt.Printf("  %s\n", b.Name())
+			fmt.Printf("  %s\n", b)
 		}
 	}
 
@@ -138,19 +163,30 @@ func RenameCurrentBranch(newName string) error {
 	}
 
 	oldName := strings.TrimPrefix(headStr, refPrefix)
-	oldRef := filepath.Join(".kitcat", "refs", "heads", oldName)
-	newRef := filepath.Join(".kitcat", "refs", "heads", newName)
+	oldRef := "refs/heads/" + oldName
+	newRef := "refs/heads/" + newName
 
-	if _, err := os.Stat(newRef); err == nil {
+	if refExists(newRef) {
 		return fmt.Errorf("branch '%s' already exists", newName)
 	}
+	if err := checkNewRef(newRef, oldRef); err != nil {
+		return err
+	}
 
-	commitHash, err := os.ReadFile(oldRef)
+	commitHash, _, err := lookupRef(oldRef)
 	if err != nil {
 		return err
 	}
+	// The branch keeps its history under the new name. The old ref goes first, since
+	// "a" may be renamed to "a/b".
+	history, _ := os.ReadFile(filepath.Join(LogsDir, filepath.FromSlash(oldRef)))
+	if refExists(oldRef) {
+		if err := deleteRef(oldRef); err != nil {
+			return err
+		}
+	}
 
-	if err := os.WriteFile(newRef, commitHash, 0o644); err != nil {
+	if err := writeLooseRef(newRef, commitHash); err != nil {
 		return err
 	}
 
@@ -159,9 +195,16 @@ func RenameCurrentBranch(newName string) error {
 		return err
 	}
 
-	if err := os.Remove(oldRef); err != nil {
-		return err
+	if len(history) > 0 {
+		newLog := filepath.Join(LogsDir, filepath.FromSlash(newRef))
+		if err := os.MkdirAll(filepath.Dir(newLog), 0o755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(newLog, history, 0o644); err != nil {
+			return err
+		}
 	}
+	_ = appendReflog(newRef, commitHash, commitHash, fmt.Sprintf("Branch: renamed %s to %s", oldRef, newRef))
 
 	return nil
 }
@@ -182,9 +225,12 @@ func DeleteBranch(name string) error {
 		)
 	}
 
-	if err := os.Remove(filepath.Join(headsDir, name)); err != nil {
+	if !IsBranch(name) {
 		return fmt.Errorf("branch `%s` doesn't exist", name)
 	}
+	if err := deleteRef("refs/heads/" + name); err != nil {
+		return err
+	}
 
 	return nil
 }
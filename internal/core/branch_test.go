@@ -70,16 +70,15 @@ func setupTestRepo(t *testing.T) (string, func()) {
 		TreeHash:  treeHash,
 		Message:   "Initial commit",
 		Timestamp: time.Now(),
-		ID:        "test-commit-hash",
 	}
 	if err := storage.AppendCommit(commit); err != nil {
 		cleanup()
 		t.Fatalf("failed to append commit: %v", err)
 	}
 
-	// Create main branch and set HEAD
+	// Create main with no commit yet and set HEAD, so a test's first Commit is a root
 	mainBranchPath := filepath.Join(".kitcat", "refs", "heads", "main")
-	if err := os.WriteFile(mainBranchPath, []byte(commit.ID), 0644); err != nil {
+	if err := os.WriteFile(mainBranchPath, nil, 0644); err != nil {
 		cleanup()
 		t.Fatalf("failed to create main branch: %v", err)
 	}
@@ -93,6 +92,24 @@ func setupTestRepo(t *testing.T) (string, func()) {
 	return repoDir, cleanup
 }
 
+// commitFiles writes and stages files, then commits them with msg
+func commitFiles(t *testing.T, msg string, files map[string]string) models.Commit {
+	t.Helper()
+	for path, content := range files {
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := AddFile(path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c, _, err := Commit(msg)
+	if err != nil {
+		t.Fatalf("Commit(%q) failed: %v", msg, err)
+	}
+	return c
+}
+
 // TestCreateBranch_InvalidName tests that CreateBranch rejects invalid branch names
 func TestCreateBranch_InvalidName(t *testing.T) {
 	_, cleanup := setupTestRepo(t)
@@ -287,3 +304,125 @@ func TestRenameCurrentBranch_InvalidName_InvalidRename(t *testing.T) {
 		})
 	}
 }
+
+func TestIsValidRefName(t *testing.T) {
+	valid := []string{"main", "feature/login", "release/1.2", "a-b_c", "user/x/y"}
+	for _, name := range valid {
+		if !IsValidRefName(name) {
+			t.Errorf("IsValidRefName(%q) = false, want true", name)
+		}
+	}
+	invalid := []string{
+		"", "@", "HEAD", "-x", "a..b", "a/", "/a", "a//b", "a.", ".a", "a/.b",
+		"a.lock", "a/b.lock/c", "a@{1}", "a b", "a~1", "a^", "a:b", "a?", "a*", "a[b", "a\\b", "a\x7f",
+	}
+	for _, name := range invalid {
+		if IsValidRefName(name) {
+			t.Errorf("IsValidRefName(%q) = true, want false", name)
+		}
+	}
+}
+
+func TestCreateBranch_Hierarchical(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	if err := CreateBranch("feature/login"); err != nil {
+		t.Fatalf("CreateBranch(feature/login) failed: %v", err)
+	}
+	if !IsBranch("feature/login") {
+		t.Error("feature/login is not a branch")
+	}
+	// A branch cannot be both a file and a directory
+	if err := CreateBranch("feature"); err == nil {
+		t.Error("CreateBranch(feature) succeeded alongside feature/login")
+	}
+	if err := CreateBranch("feature/login/v2"); err == nil {
+		t.Error("CreateBranch(feature/login/v2) succeeded alongside feature/login")
+	}
+
+	if err := DeleteBranch("feature/login"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(".kitcat", "refs", "heads", "feature")); !os.IsNotExist(err) {
+		t.Error("empty directory left behind by deleted branch")
+	}
+	if err := CreateBranch("feature"); err != nil {
+		t.Errorf("CreateBranch(feature) failed after deleting feature/login: %v", err)
+	}
+}
+
+func TestPackRefs(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	if err := AddFile("dummy.txt"); err != nil {
+		t.Fatal(err)
+	}
+	commit, _, err := Commit("first")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := CreateBranch("release/1.2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := CreateTag("v1", "HEAD"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := PackRefs(true); err != nil {
+		t.Fatalf("PackRefs failed: %v", err)
+	}
+	for _, ref := range []string{"refs/heads/main", "refs/heads/release/1.2", "refs/tags/v1"} {
+		if _, err := os.Stat(filepath.Join(".kitcat", filepath.FromSlash(ref))); !os.IsNotExist(err) {
+			t.Errorf("%s still has a loose file", ref)
+		}
+	}
+
+	for _, rev := range []string{"main", "release/1.2", "v1", "HEAD"} {
+		if got, err := ResolveRevision(rev); err != nil || got != commit.ID {
+			t.Errorf("ResolveRevision(%q) = %s, %v; want %s", rev, got, err, commit.ID)
+		}
+	}
+	if tags, err := ListTags(""); err != nil || len(tags) != 1 || tags[0] != "v1" {
+		t.Errorf("ListTags() = %v, %v", tags, err)
+	}
+	if !IsBranch("release/1.2") {
+		t.Error("packed branch not found")
+	}
+	if err := CreateTag("v1", "HEAD"); err == nil {
+		t.Error("CreateTag overwrote a packed tag")
+	}
+
+	// Committing on a packed branch writes a loose ref that wins over the packed one
+	if err := os.WriteFile("dummy.txt", []byte("changed"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := AddFile("dummy.txt"); err != nil {
+		t.Fatal(err)
+	}
+	second, _, err := Commit("second")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := ResolveRevision("main"); got != second.ID {
+		t.Errorf("main = %s after commit, want %s", got, second.ID)
+	}
+	if second.FirstParent() != commit.ID {
+		t.Errorf("commit on packed branch has parent %s, want %s", second.FirstParent(), commit.ID)
+	}
+
+	if err := DeleteBranch("release/1.2"); err != nil {
+		t.Fatal(err)
+	}
+	if IsBranch("release/1.2") {
+		t.Error("deleted packed branch still exists")
+	}
+	packed, err := readPackedRefs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := packed["refs/heads/release/1.2"]; ok {
+		t.Error("deleted branch left in packed-refs")
+	}
+}
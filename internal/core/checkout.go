@@ -1,15 +1,11 @@
 package core
 
 import (
-	"crypto/sha1"
-	"encoding/hex"
 	"errors"
 	"fmt"
-	"io"
 	"os"
-	"path/filepath"
-	"strings"
 
+	"github.com/LeeFred3042U/kitcat/internal/models"
 	"github.com/LeeFred3042U/kitcat/internal/storage"
 )
 
@@ -20,25 +16,33 @@ func CheckoutFile(filePath string) error {
 	if err != nil {
 		return err
 	}
+	return checkoutFileFromCommit(lastCommit, filePath, "the last commit")
+}
+
+// CheckoutFileFrom restores a file to its state in the commit a revision names,
+// without moving HEAD
+func CheckoutFileFrom(revision, filePath string) error {
+	commit, err := ResolveCommit(revision)
+	if err != nil {
+		return err
+	}
+	return checkoutFileFromCommit(commit, filePath, revision)
+}
 
-	tree, err := storage.ParseTree(lastCommit.TreeHash)
+func checkoutFileFromCommit(commit models.Commit, filePath, source string) error {
+	tree, err := storage.ParseTreeEntries(commit.TreeHash)
 	if err != nil {
 		return err
 	}
 
-	blobHash, ok := tree[filePath]
+	target, ok := tree[filePath]
 	if !ok {
-		return errors.New("file not found in the last commit")
+		return fmt.Errorf("file not found in %s", source)
 	}
 
 	// SAFETY CHECK: Prevent overwriting dirty or untracked files
-	if _, err := os.Stat(filePath); err == nil {
+	if _, err := os.Lstat(filePath); err == nil {
 		// File exists, check if it is safe to overwrite
-		currentHash, err := calculateHash(filePath)
-		if err != nil {
-			return fmt.Errorf("failed to calculate hash for safety check: %v", err)
-		}
-
 		// Load index to check if the file is tracked and clean
 		index, err := storage.LoadIndex()
 		if err != nil {
@@ -47,7 +51,11 @@ func CheckoutFile(filePath string) error {
 
 		if trackedHash, ok := index[filePath]; ok {
 			// File is tracked: fail if local changes exist (Index != Disk)
-			if currentHash != trackedHash {
+			matches, err := storage.FileMatchesHash(filePath, trackedHash)
+			if err != nil {
+				return fmt.Errorf("failed to calculate hash for safety check: %v", err)
+			}
+			if !matches {
 				return fmt.Errorf("error: local changes to '%s' would be overwritten", filePath)
 			}
 		} else {
@@ -57,32 +65,50 @@ func CheckoutFile(filePath string) error {
 	}
 
 	// Safe to overwrite: Perform the checkout
-	content, err := storage.ReadObject(blobHash)
+	_, content, err := storage.ReadObject(target.Hash)
 	if err != nil {
 		return err
 	}
 
-	if err := os.WriteFile(filePath, content, 0o644); err != nil {
+	if err := writeWorkingFile(filePath, content, target.Mode); err != nil {
 		return err
 	}
 
 	// Update the index to reflect the checked-out version
-	index, err := storage.LoadIndex()
-	if err != nil {
-		return err
+	if info, err := os.Lstat(filePath); err == nil {
+		target.SetStat(info)
+	}
+	return storage.UpdateIndexEntries(func(index map[string]storage.IndexEntry) error {
+		index[filePath] = target
+		return nil
+	})
+}
+
+// Checkout switches to a branch, or detaches HEAD at the commit any other revision
+// names. "-" and @{-n} return to what was checked out before.
+func Checkout(target string) error {
+	if target == "-" {
+		target = "@{-1}"
+	}
+	if n, ok := previousCheckoutNumber(target); ok {
+		previous, err := previousCheckout(n)
+		if err != nil {
+			return fmt.Errorf("%s: %w", target, err)
+		}
+		target = previous
+	}
+	if IsBranch(target) {
+		return CheckoutBranch(target)
 	}
-	index[filePath] = blobHash
-	return storage.WriteIndex(index)
+	return CheckoutCommit(target)
 }
 
 // Switch the current HEAD to the named branch and updates the working directory.
 func CheckoutBranch(name string) error {
-	branchPath := filepath.Join(headsDir, name)
-	commitHashBytes, err := os.ReadFile(branchPath)
-	if err != nil {
+	commitHash, ok, err := lookupRef("refs/heads/" + name)
+	if err != nil || !ok || !IsValidRefName(name) {
 		return fmt.Errorf("branch '%s' not found", name)
 	}
-	commitHash := strings.TrimSpace(string(commitHashBytes))
 
 	// Get the tree of the target commit
 	// We need to find the commit object to get its tree hash
@@ -90,7 +116,7 @@ func CheckoutBranch(name string) error {
 	if err != nil {
 		return err
 	}
-	targetTree, err := storage.ParseTree(commit.TreeHash)
+	targetTree, err := storage.ParseTreeEntries(commit.TreeHash)
 	if err != nil {
 		return err
 	}
@@ -105,70 +131,72 @@ func CheckoutBranch(name string) error {
 		)
 	}
 
-	// Before making changes, we should check if the user has unstaged work
-	// that would be overwritten
-	// So the real Git would abort here
-	// For now, this is what i have done
+	// The working tree is clean, so it matches HEAD: only files that differ between
+	// the HEAD tree and the target tree need touching, and identical subtrees are skipped
+	currentTreeHash := ""
+	if headCommit, err := GetHeadCommit(); err == nil {
+		currentTreeHash = headCommit.TreeHash
+	}
+	changes, err := storage.DiffTrees(currentTreeHash, commit.TreeHash)
+	if err != nil {
+		return err
+	}
 
 	// Update the working directory to match the target tree
-	// First, delete files that are not in the target tree
-	currentIndex, _ := storage.LoadIndex()
-	for path := range currentIndex {
-		if _, existsInTarget := targetTree[path]; !existsInTarget {
-			os.Remove(path)
+	for _, change := range changes {
+		// Delete files that are not in the target tree
+		if change.NewHash == "" {
+			os.Remove(change.Path)
+			continue
 		}
-	}
-
-	// Now, write/update files from the target tree
-	for path, hash := range targetTree {
-		content, err := storage.ReadObject(hash)
+		_, content, err := storage.ReadObject(change.NewHash)
 		if err != nil {
 			return err
 		}
-		// Ensure directory exists before writing file
-		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
-			return err
-		}
-		if err := os.WriteFile(path, content, 0o644); err != nil {
+		if err := writeWorkingFile(change.Path, content, change.NewMode); err != nil {
 			return err
 		}
 	}
 
-	// Update the index to match the new tree
-	if err := storage.WriteIndex(targetTree); err != nil {
+	// Update the index to match the new tree. Unchanged files were verified clean above,
+	// so the stat data of every file in the tree can be cached.
+	recordStat(targetTree)
+	if err := storage.WriteIndexEntries(targetTree); err != nil {
 		return err
 	}
 
 	// Update HEAD to point to the new branch
+	from, oldHash := headName(), ""
+	if head, err := readHead(); err == nil {
+		oldHash = head
+	}
 	newHEADContent := fmt.Sprintf("ref: refs/heads/%s", name)
-	return os.WriteFile(".kitcat/HEAD", []byte(newHEADContent), 0o644)
+	if err := os.WriteFile(".kitcat/HEAD", []byte(newHEADContent), 0o644); err != nil {
+		return err
+	}
+	logCheckout(from, oldHash, name, commit.ID)
+	return nil
 }
 
-// CheckoutCommit moves HEAD to a specific commit and updates the working directory
+// CheckoutCommit moves HEAD to the commit a revision names and updates the working directory
 // This puts the repository in a "detached HEAD" state
-func CheckoutCommit(commitHash string) error {
+func CheckoutCommit(revision string) error {
 	// Verify the commit actually exists
-	_, err := storage.FindCommit(commitHash)
+	commit, err := ResolveCommit(revision)
 	if err != nil {
-		return fmt.Errorf("commit '%s' not found", commitHash)
+		return fmt.Errorf("commit '%s' not found: %w", revision, err)
 	}
 
-	if err := UpdateWorkspaceAndIndex(commitHash); err != nil {
-		return err
+	from, oldHash := headName(), ""
+	if head, err := readHead(); err == nil {
+		oldHash = head
 	}
-
-	return os.WriteFile(".kitcat/HEAD", []byte(commitHash), 0o644)
-}
-
-func calculateHash(path string) (string, error) {
-	f, err := os.Open(path)
-	if err != nil {
-		return "", err
+	if err := UpdateWorkspaceAndIndex(commit.ID); err != nil {
+		return err
 	}
-	defer f.Close()
-	h := sha1.New()
-	if _, err := io.Copy(h, f); err != nil {
-		return "", err
+	if err := os.WriteFile(".kitcat/HEAD", []byte(commit.ID), 0o644); err != nil {
+		return err
 	}
-	return hex.EncodeToString(h.Sum(nil)), nil
+	logCheckout(from, oldHash, commit.ID, commit.ID)
+	return nil
 }
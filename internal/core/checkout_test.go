@@ -222,3 +222,62 @@ func Test_CheckoutFile_UpdatesIndex(t *testing.T) {
 		t.Errorf("Index has wrong hash. Want %s, got %s", blobHash, storedHash)
 	}
 }
+
+// Test_CheckoutBranch_RestoresModes verifies that switching branches recreates
+// executable bits and symlinks recorded in the target tree.
+func Test_CheckoutBranch_RestoresModes(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	if err := os.WriteFile("run.sh", []byte("#!/bin/sh\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("run.sh", "link"); err != nil {
+		t.Skipf("symlinks unsupported: %v", err)
+	}
+	for _, path := range []string{"run.sh", "link"} {
+		if err := AddFile(path); err != nil {
+			t.Fatalf("AddFile(%s) failed: %v", path, err)
+		}
+	}
+	if _, _, err := Commit("add script"); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	if err := CreateBranch("feature"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Drop both files on main, then switch back to the branch that has them
+	for _, path := range []string{"run.sh", "link"} {
+		if err := RemoveFile(path); err != nil {
+			t.Fatal(err)
+		}
+		os.Remove(path)
+	}
+	if _, _, err := Commit("remove script"); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	if err := CheckoutBranch("feature"); err != nil {
+		t.Fatalf("CheckoutBranch failed: %v", err)
+	}
+
+	info, err := os.Lstat("run.sh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm()&0o111 == 0 {
+		t.Errorf("run.sh lost its executable bit: %v", info.Mode())
+	}
+	target, err := os.Readlink("link")
+	if err != nil || target != "run.sh" {
+		t.Errorf("link not restored as a symlink: %q, %v", target, err)
+	}
+
+	entries, err := storage.LoadIndexEntries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entries["run.sh"].Mode != storage.ModeExecutable || entries["link"].Mode != storage.ModeSymlink {
+		t.Errorf("index modes not restored: %+v", entries)
+	}
+}
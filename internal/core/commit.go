@@ -1,8 +1,6 @@
 package core
 
 import (
-	"crypto/sha1"
-	"encoding/hex"
 	"errors"
 	"fmt"
 	"os"
@@ -15,27 +13,36 @@ import (
 	"github.com/LeeFred3042U/kitcat/internal/storage"
 )
 
-// note task - Move commit storage from commits.log to individual objects in .kitcat/objects/
-// hashCommit creates a unique, content-based SHA-1 hash for a Commit object.
-func hashCommit(c models.Commit) string {
-	h := sha1.New()
-	h.Write([]byte(c.TreeHash))
-	h.Write([]byte(c.Parent))
-	h.Write([]byte(c.Message))
-	h.Write([]byte(c.Timestamp.UTC().Format(time.RFC3339Nano)))
-	return hex.EncodeToString(h.Sum(nil))
+// CommitOptions controls how Commit records a commit
+type CommitOptions struct {
+	Sign bool // sign the commit with the key user.signingkey names
+}
+
+// DefaultCommitOptions returns the options commits start from. commit.gpgsign set to
+// "true" signs every commit.
+func DefaultCommitOptions() (CommitOptions, error) {
+	sign, err := signByDefault()
+	return CommitOptions{Sign: sign}, err
 }
 
 // Commit creates a new snapshot of the repository based on the current state of the index
-// It prevents empty commits and returns the full commit object and a formatted summary
+// It prevents empty commits and returns the full commit object and a formatted summary.
+// While a merge is in progress the commit concludes it, taking the merged commit as a
+// second parent.
 func Commit(message string) (models.Commit, string, error) {
-	authorName, _, _ := GetConfig("user.name")
-	if authorName == "" {
-		authorName = "Unknown"
+	opts, err := DefaultCommitOptions()
+	if err != nil {
+		return models.Commit{}, "", err
 	}
-	authorEmail, _, _ := GetConfig("user.email")
-	if authorEmail == "" {
-		authorEmail = "unknown@example.com"
+	return CommitWithOptions(message, opts)
+}
+
+// CommitWithOptions is Commit with explicit options
+func CommitWithOptions(message string, opts CommitOptions) (models.Commit, string, error) {
+	if unmerged, err := storage.HasUnmergedEntries(); err != nil {
+		return models.Commit{}, "", err
+	} else if unmerged {
+		return models.Commit{}, "", errors.New("cannot commit: you have unmerged paths; fix them and run 'kitcat add'")
 	}
 
 	treeHash, err := storage.CreateTree()
@@ -43,67 +50,127 @@ func Commit(message string) (models.Commit, string, error) {
 		return models.Commit{}, "", err
 	}
 
-	var parentID, parentTreeHash string
+	var parents []string
+	var parentTreeHash string
+	// Only an unborn branch makes a root commit; a HEAD naming a missing commit is an error
 	parentCommit, err := GetHeadCommit()
-	// If error, we assume root commit (no parent) unless critical system error
-	// In strict world, we'd check error type.
-	if err == nil {
-		parentID = parentCommit.ID
+	switch {
+	case err == nil:
+		parents = []string{parentCommit.ID}
 		parentTreeHash = parentCommit.TreeHash
+	case errors.Is(err, storage.ErrNoCommits) || errors.Is(err, os.ErrNotExist):
+	default:
+		return models.Commit{}, "", fmt.Errorf("cannot read HEAD commit: %w", err)
 	}
 
-	if treeHash == parentTreeHash {
+	merging := IsMergeInProgress()
+	if merging {
+		mergeHead, _, err := loadMergeState()
+		if err != nil {
+			return models.Commit{}, "", err
+		}
+		parents = append(parents, mergeHead)
+	} else if treeHash == parentTreeHash {
 		return models.Commit{}, "", errors.New("nothing to commit, working tree clean")
 	}
 
+	reason := "commit: "
+	switch {
+	case IsRebaseInProgress():
+		reason = "rebase (pick): "
+	case merging:
+		reason = "commit (merge): "
+	case len(parents) == 0:
+		reason = "commit (initial): "
+	}
+	commit, err := createCommit(treeHash, message, parents, reason+subjectLine(message), opts.Sign)
+	if err != nil {
+		return models.Commit{}, "", err
+	}
+	if merging {
+		if err := clearMergeState(); err != nil {
+			return models.Commit{}, "", err
+		}
+	}
+	return commit, summarizeCommit(commit), nil
+}
+
+// createCommit stores a commit of treeHash with the given parents, authored by the
+// configured user and signed if sign is set, and moves the current branch to it,
+// logging reason in the reflog
+func createCommit(treeHash, message string, parents []string, reason string, sign bool) (models.Commit, error) {
+	authorName, authorEmail := userIdentity()
+
 	commit := models.Commit{
-		Parent:      parentID,
+		Parents:     parents,
 		Message:     message,
-		Timestamp:   time.Now().UTC(),
+		Timestamp:   time.Now().UTC().Truncate(time.Second),
 		TreeHash:    treeHash,
 		AuthorName:  authorName,
 		AuthorEmail: authorEmail,
 	}
-	commit.ID = hashCommit(commit)
+	return storeCommit(commit, reason, sign)
+}
+
+// storeCommit hashes commit, signs it if sign is set, saves it and moves the current
+// branch to it, logging reason in the reflog
+func storeCommit(commit models.Commit, reason string, sign bool) (models.Commit, error) {
+	commit.ID = storage.HashCommit(commit)
+	if sign {
+		if err := signCommit(&commit); err != nil {
+			return models.Commit{}, err
+		}
+	}
 
 	if err := storage.AppendCommit(commit); err != nil {
-		return models.Commit{}, "", err
+		return models.Commit{}, err
 	}
 
 	refPath, err := getCurrentBranchRefPath()
 	if err != nil {
 		headData, readErr := os.ReadFile(".kitcat/HEAD")
 		if readErr != nil {
-			return models.Commit{}, "", fmt.Errorf("could not read HEAD: %w", readErr)
+			return models.Commit{}, fmt.Errorf("could not read HEAD: %w", readErr)
 		}
 		ref := strings.TrimSpace(string(headData))
 		if !strings.HasPrefix(ref, "ref: ") {
-			return models.Commit{}, "", fmt.Errorf("cannot commit in detached HEAD state")
+			return models.Commit{}, fmt.Errorf("cannot commit in detached HEAD state")
 		}
 		refPath = strings.TrimPrefix(ref, "ref: ")
 		if err := os.MkdirAll(filepath.Dir(filepath.Join(".kitcat", refPath)), 0o755); err != nil {
-			return models.Commit{}, "", fmt.Errorf("could not create refs directory: %w", err)
+			return models.Commit{}, fmt.Errorf("could not create refs directory: %w", err)
 		}
 	}
 
-	branchFilePath := filepath.Join(".kitcat", refPath)
-	if err := SafeWrite(branchFilePath, []byte(commit.ID), 0o644); err != nil {
-		return models.Commit{}, "", fmt.Errorf("failed to update branch pointer: %w", err)
+	if err := updateRef(refPath, commit.ID, reason); err != nil {
+		return models.Commit{}, fmt.Errorf("failed to update branch pointer: %w", err)
 	}
+	return commit, nil
+}
 
-	parentTree := make(map[string]string)
-	if parentID != "" {
-		parentTree, _ = storage.ParseTree(parentCommit.TreeHash)
-	}
-	newTree, _ := storage.ParseTree(treeHash)
-	summary, _ := GenerateCommitSummary(parentTree, newTree)
+// subjectLine returns the first line of a commit message
+func subjectLine(message string) string {
+	return strings.SplitN(message, "\n", 2)[0]
+}
 
-	return commit, summary, nil
+// userIdentity returns the configured user name and email, with placeholders for
+// whichever is unset
+func userIdentity() (string, string) {
+	name, _, _ := GetConfig("user.name")
+	if name == "" {
+		name = "Unknown"
+	}
+	email, _, _ := GetConfig("user.email")
+	if email == "" {
+		email = "unknown@example.com"
+	}
+	return name, email
 }
 
 // AmendCommit updates the message of the most recent commit without changing files.
 // It loads the last commit, updates its message, re-hashes it, and updates the branch pointer.
-func AmendCommit(newMessage string) (models.Commit, error) {
+// The old signature no longer matches, so the commit is signed again only if opts.Sign is set.
+func AmendCommit(newMessage string, opts CommitOptions) (models.Commit, error) {
 	// Get the last commit
 	lastCommit, err := storage.GetLastCommit()
 	if err != nil {
@@ -115,7 +182,7 @@ func AmendCommit(newMessage string) (models.Commit, error) {
 
 	// Create a new commit with the updated message but same tree and parent
 	amendedCommit := models.Commit{
-		Parent:      lastCommit.Parent,
+		Parents:     lastCommit.Parents,
 		Message:     newMessage,
 		Timestamp:   lastCommit.Timestamp, // Keep original timestamp
 		TreeHash:    lastCommit.TreeHash,  // Same files
@@ -124,7 +191,12 @@ func AmendCommit(newMessage string) (models.Commit, error) {
 	}
 
 	// Re-hash the commit (this generates a new ID)
-	amendedCommit.ID = hashCommit(amendedCommit)
+	amendedCommit.ID = storage.HashCommit(amendedCommit)
+	if opts.Sign {
+		if err := signCommit(&amendedCommit); err != nil {
+			return models.Commit{}, err
+		}
+	}
 
 	// Save the amended commit
 	if err := storage.AppendCommit(amendedCommit); err != nil {
@@ -137,8 +209,7 @@ func AmendCommit(newMessage string) (models.Commit, error) {
 		return models.Commit{}, fmt.Errorf("failed to get current branch: %w", err)
 	}
 
-	branchFilePath := filepath.Join(".kitcat", refPath)
-	if err := SafeWrite(branchFilePath, []byte(amendedCommit.ID), 0o644); err != nil {
+	if err := updateRef(refPath, amendedCommit.ID, "commit (amend): "+subjectLine(newMessage)); err != nil {
 		return models.Commit{}, fmt.Errorf("failed to update branch pointer: %w", err)
 	}
 
@@ -146,11 +217,11 @@ func AmendCommit(newMessage string) (models.Commit, error) {
 }
 
 // CommitAll is a convenience function that implements the `commit -am` shortcut.
-func CommitAll(message string) (models.Commit, string, error) {
+func CommitAll(message string, opts CommitOptions) (models.Commit, string, error) {
 	if err := AddAll(); err != nil {
 		return models.Commit{}, "", fmt.Errorf("failed to stage changes before committing: %w", err)
 	}
-	return Commit(message)
+	return CommitWithOptions(message, opts)
 }
 
 func getCurrentBranchRefPath() (string, error) {
@@ -173,52 +244,105 @@ func pluralize(count int) string {
 	return "s"
 }
 
-// GenerateCommitSummary compares parent and new trees to create a formatted summary
-// of files changed, lines inserted, and lines deleted
-func GenerateCommitSummary(parentTree, newTree map[string]string) (string, error) {
-	filesChanged, insertions, deletions := 0, 0, 0
-	allPaths := make(map[string]bool)
-	for path := range parentTree {
-		allPaths[path] = true
-	}
-	for path := range newTree {
-		allPaths[path] = true
-	}
-
-	for path := range allPaths {
-		oldHash, inOld := parentTree[path]
-		newHash, inNew := newTree[path]
-
-		if inOld && !inNew {
-			filesChanged++
-			oldContent, _ := storage.ReadObject(oldHash)
-			deletions += len(strings.Split(string(oldContent), "\n"))
-		} else if !inOld && inNew {
-			filesChanged++
-			newContent, _ := storage.ReadObject(newHash)
-			insertions += len(strings.Split(string(newContent), "\n"))
-		} else if inOld && inNew && oldHash != newHash {
-			filesChanged++
-			oldContent, _ := storage.ReadObject(oldHash)
-			newContent, _ := storage.ReadObject(newHash)
-			d := diff.NewMyersDiff(strings.Split(string(oldContent), "\n"), strings.Split(string(newContent), "\n"))
-			for _, chk := range d.Diffs() {
-				if chk.Operation == diff.INSERT {
-					insertions += len(chk.Text)
-				}
-				if chk.Operation == diff.DELETE {
-					deletions += len(chk.Text)
-				}
-			}
+// summarizeCommit describes what a commit changed. Merge commits are compared with their
+// first parent, so the summary shows what the merge brought into the branch.
+func summarizeCommit(c models.Commit) string {
+	var parentTreeHash string
+	if first := c.FirstParent(); first != "" {
+		if parent, err := storage.FindCommit(first); err == nil {
+			parentTreeHash = parent.TreeHash
 		}
 	}
+	opts, err := DefaultRenameOptions()
+	if err != nil {
+		opts = RenameOptions{Renames: true, Threshold: DefaultRenameThreshold}
+	}
+	changes, err := treeChanges(parentTreeHash, c.TreeHash, opts)
+	if err != nil {
+		return ""
+	}
+	alg, err := configuredDiffAlgorithm()
+	if err != nil {
+		alg = diff.Myers
+	}
+	return summarizeChanges(changes, alg)
+}
+
+// summarizeChanges counts the files changed and lines inserted and deleted, then lists
+// renames, copies and mode changes one per line, as in " rename a => b (87%)". A file
+// that was renamed or copied only counts the lines that differ from its source. Lines are
+// counted from a diff made with alg.
+func summarizeChanges(changes []fileChange, alg diff.Algorithm) string {
+	insertions, deletions := 0, 0
+	var details strings.Builder
+	for _, c := range changes {
+		if st, err := changeStats(c, nil, alg); err == nil {
+			insertions += st.insertions
+			deletions += st.deletions
+		}
 
-	plural := "s"
-	if filesChanged == 1 {
-		plural = ""
+		switch {
+		case c.Status == 'R':
+			fmt.Fprintf(&details, "\n rename %s => %s (%d%%)", c.OldPath, c.Path, c.Score)
+		case c.Status == 'C':
+			fmt.Fprintf(&details, "\n copy %s => %s (%d%%)", c.OldPath, c.Path, c.Score)
+		case c.Old != nil && c.New != nil && c.Old.Mode != c.New.Mode:
+			fmt.Fprintf(&details, "\n mode change %06o => %06o %s", c.Old.Mode, c.New.Mode, c.Path)
+		}
 	}
+	return statSummary(len(changes), insertions, deletions) + details.String()
+}
+
+// statSummary is the "N files changed, I insertions(+), D deletions(-)" line
+func statSummary(files, insertions, deletions int) string {
 	return fmt.Sprintf("%d file%s changed, %d insertion%s(+), %d deletion%s(-)",
-		filesChanged, plural,
+		files, pluralize(files),
 		insertions, pluralize(insertions),
-		deletions, pluralize(deletions)), nil
+		deletions, pluralize(deletions))
+}
+
+// lineStats is how much one file change adds and removes. Binary files count no lines;
+// their sizes are kept instead.
+type lineStats struct {
+	insertions, deletions int
+	binary                bool
+	oldSize, newSize      int
+}
+
+// changeStats counts the lines a change inserts and deletes according to a diff made with
+// alg, reading content from blobs
+func changeStats(c fileChange, blobs blobSource, alg diff.Algorithm) (lineStats, error) {
+	var st lineStats
+	if c.Old != nil && c.New != nil && c.Old.Hash == c.New.Hash {
+		return st, nil
+	}
+	oldContent, err := blobs.entry(c.Old)
+	if err != nil {
+		return st, err
+	}
+	newContent, err := blobs.entry(c.New)
+	if err != nil {
+		return st, err
+	}
+	st.oldSize, st.newSize = len(oldContent), len(newContent)
+	if isBinary(oldContent) || isBinary(newContent) {
+		st.binary = true
+		return st, nil
+	}
+	st.insertions, st.deletions = countLineChanges(oldContent, newContent, alg)
+	return st, nil
+}
+
+// countLineChanges counts the lines an alg line diff from old to new inserts and deletes
+func countLineChanges(old, new []byte, alg diff.Algorithm) (int, int) {
+	insertions, deletions := 0, 0
+	for _, d := range diff.New(alg, splitLines(old), splitLines(new)).Diffs() {
+		switch d.Operation {
+		case diff.INSERT:
+			insertions += len(d.Text)
+		case diff.DELETE:
+			deletions += len(d.Text)
+		}
+	}
+	return insertions, deletions
 }
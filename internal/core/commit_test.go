@@ -0,0 +1,75 @@
+package core
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/LeeFred3042U/kitcat/internal/storage"
+)
+
+func TestCommit_MigratesLegacyRepositoryFirst(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	// A repository from before commit objects: whole commits in commits.log, refs naming
+	// IDs that no object has
+	treeHash, err := storage.CreateTree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var log strings.Builder
+	for _, c := range []map[string]any{
+		{"ID": "oldroot", "TreeHash": treeHash, "Message": "root", "Timestamp": time.Unix(1, 0).UTC()},
+		{"ID": "oldchild", "Parent": "oldroot", "TreeHash": treeHash, "Message": "child", "Timestamp": time.Unix(2, 0).UTC()},
+	} {
+		line, err := json.Marshal(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		log.Write(line)
+		log.WriteString("\n")
+	}
+	if err := os.WriteFile(".kitcat/commits.log", []byte(log.String()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(".kitcat/refs/heads/main", []byte("oldchild\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	head, err := GetHeadCommit()
+	if err != nil {
+		t.Fatalf("GetHeadCommit on a legacy repository: %v", err)
+	}
+	if head.Message != "child" {
+		t.Fatalf("HEAD is %+v, want the migrated child", head)
+	}
+
+	if err := os.WriteFile("dummy.txt", []byte("changed"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := AddFile("dummy.txt"); err != nil {
+		t.Fatal(err)
+	}
+	c, _, err := Commit("next")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.FirstParent() != head.ID {
+		t.Errorf("commit parents = %v, want [%s]", c.Parents, head.ID)
+	}
+}
+
+func TestCommit_HeadNamingMissingCommitIsAnError(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	if err := os.WriteFile(".kitcat/refs/heads/main", []byte("0123456789abcdef0123456789abcdef01234567\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := Commit("orphan"); err == nil {
+		t.Error("Commit made a root commit over a branch naming a missing commit")
+	}
+}
@@ -15,8 +15,20 @@ const (
 	IndexPath = ".kitcat/index"
 	// HeadPath is the full path to the HEAD file.
 	HeadPath = ".kitcat/HEAD"
-	// CommitsPath is the full path to the commit log file.
+	// CommitsPath is the full path to the commit journal (commit IDs in creation order).
 	CommitsPath = ".kitcat/commits.log"
 	// StashPath is the full path to the stash reference file.
 	StashPath = ".kitcat/refs/stash"
+	// MergeHeadPath holds the commit being merged while a conflicted merge is unresolved.
+	MergeHeadPath = ".kitcat/MERGE_HEAD"
+	// MergeMsgPath holds the message prepared for the merge commit.
+	MergeMsgPath = ".kitcat/MERGE_MSG"
+	// OrigHeadPath holds the commit HEAD pointed to before a merge, for aborting it.
+	OrigHeadPath = ".kitcat/ORIG_HEAD"
+	// LogsDir is the subdirectory holding one reflog file per ref.
+	LogsDir = ".kitcat/logs"
+	// PackedRefsPath lists refs that no longer have a file of their own under RefsDir.
+	PackedRefsPath = ".kitcat/packed-refs"
+	// AmDir holds the patch series am is applying while it waits for a failed patch to be resolved.
+	AmDir = ".kitcat/rebase-apply"
 )
@@ -2,8 +2,11 @@ package core
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
 
 	"github.com/LeeFred3042U/kitcat/internal/diff"
@@ -13,253 +16,545 @@ import (
 // ANSI color codes for formatting terminal output
 const (
 	colorReset = "\033[0m"
+	colorBold  = "\033[1m"
 	colorRed   = "\033[31m"
 	colorGreen = "\033[32m"
-	colorBlue  = "\033[1;34m"
+	colorCyan  = "\033[36m"
 )
 
-func isDiffBinary(content []byte) bool {
-	limit := min(len(content), 512)
-
-	for i := range limit {
-		if content[i] == 0x00 {
-			return true
-		}
+// readWorkingFile reads a working directory file the way it would be staged:
+// a symlink yields its target rather than the content it points to.
+func readWorkingFile(path string, staged uint32) ([]byte, uint32, error) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return nil, 0, err
 	}
-	return false
+	mode := storage.FileModeOf(info, staged)
+	if mode == storage.ModeSymlink {
+		target, err := os.Readlink(path)
+		return []byte(target), mode, err
+	}
+	content, err := os.ReadFile(path)
+	return content, mode, err
 }
 
-// displayDiff formats and prints the structured diff output from the Myers algorithm.
-// It iterates through each change (insertion, deletion, or equal) and applies the appropriate color
-func displayDiff(diffs []diff.Diff[string]) {
-	// Loop over each diff "chunk" provided by the algorithm
-	for _, d := range diffs {
-		lines := d.Text
-		switch d.Operation {
-		// If the operation was an INSERT, print each line in green with a '+' prefix
-		case diff.INSERT:
-			for _, line := range lines {
-				fmt.Printf("%s+ %s%s\n", colorGreen, line, colorReset)
-			}
-		// If the operation was a DELETE, print each line in red with a '-' prefix
-		case diff.DELETE:
-			for _, line := range lines {
-				fmt.Printf("%s- %s%s\n", colorRed, line, colorReset)
-			}
-		// If the lines are EQUAL, print them with the default color and two spaces for context
-		case diff.EQUAL:
-			for _, line := range lines {
-				fmt.Printf("  %s\n", line)
-			}
-		}
-	}
+// Output modes for DiffOptions.Output
+const (
+	DiffPatch      = "patch"       // unified diff of each file
+	DiffStat       = "stat"        // a histogram of changed lines per file
+	DiffNumstat    = "numstat"     // inserted and deleted line counts per file
+	DiffNameOnly   = "name-only"   // the changed paths
+	DiffNameStatus = "name-status" // the changed paths with a letter saying how
+)
+
+// DiffOptions controls what Diff compares and how it shows the changes
+type DiffOptions struct {
+	Staged  bool          // compare with the index rather than the working tree
+	Renames RenameOptions // how deleted and added files are paired
+	Context int           // unchanged lines shown around each change
+	Color   bool          // color the output with ANSI escapes
+	Output  string        // one of the Diff output modes; empty means DiffPatch
+	Paths   []string      // only compare these files and directories, if any are given
+
+	Algorithm diff.Algorithm // how lines are matched up between the old and new versions
+
+	WordDiff  string         // show changed lines word by word in this word diff form, if set
+	WordRegex *regexp.Regexp // what counts as a word; nil means runs of non-space characters
 }
 
-// Diff calculates and displays the differences between the last commit and the current staging area (index)
-// It identifies which files have been added, deleted, or modified.
-func Diff(staged bool) error {
-	// Retrieve the metadata for the most recent commit.
-	lastCommit, err := storage.GetLastCommit()
+// DefaultDiffOptions returns the options diffs start from: rename detection as configured,
+// diff.context lines of context (3 by default), the diff.algorithm algorithm (Myers by
+// default), and color when writing to a terminal
+func DefaultDiffOptions() (DiffOptions, error) {
+	opts := DiffOptions{Context: DefaultContextLines, Color: stdoutIsTerminal()}
+	renames, err := DefaultRenameOptions()
 	if err != nil {
-		// If there are no commits yet, there's nothing to compare against.
-		if err == storage.ErrNoCommits {
-			fmt.Println("No commits yet. Nothing to diff against.")
-			return nil
+		return opts, err
+	}
+	opts.Renames = renames
+	if opts.Algorithm, err = configuredDiffAlgorithm(); err != nil {
+		return opts, err
+	}
+	if value, found, err := GetConfig("diff.context"); err != nil {
+		return opts, err
+	} else if found {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return opts, fmt.Errorf("invalid diff.context value %q (expected a number of lines)", value)
 		}
-		return err
+		opts.Context = n
 	}
-
-	// Load the current staging area into a map. This represents what will be in the *next* commit
-	index, err := storage.LoadIndex()
-	if err != nil {
-		return err
+	if value, found, err := GetConfig("diff.wordregex"); err != nil {
+		return opts, err
+	} else if found {
+		if err := opts.setWordRegex(value); err != nil {
+			return opts, fmt.Errorf("invalid diff.wordregex: %w", err)
+		}
 	}
+	return opts, nil
+}
 
-	if staged {
-
-		// From the commit, get the tree object which represents the state of the repository at that time
-		// This is a map of `filePath -> contentHash`
-		tree, err := storage.ParseTree(lastCommit.TreeHash)
-		if err != nil {
-			return err
+// ParseFlags applies diff flags (--cached, -U<n>, --color, the output modes, the diff
+// algorithm, the word diff flags and the rename flags) in order and returns the arguments that are not flags.
+// Everything after "--" is a path.
+func (o *DiffOptions) ParseFlags(args []string) ([]string, error) {
+	for i, arg := range args {
+		if arg == "--" {
+			o.Paths = append(o.Paths, args[i+1:]...)
+			args = args[:i]
+			break
 		}
-
-		// First Loop: Iterate through files in the index to find additions and modifications
-		for path, indexHash := range index {
-			treeHash, ok := tree[path]
-			// If a file is in the index but not in the old tree, it's a new file.
+	}
+	args, err := o.Renames.ParseFlags(args)
+	if err != nil {
+		return nil, err
+	}
+	var rest []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--cached" || arg == "--staged":
+			o.Staged = true
+		case arg == "--color":
+			o.Color = true
+		case arg == "--no-color":
+			o.Color = false
+		case arg == "--patch" || arg == "-p":
+			o.Output = DiffPatch
+		case arg == "--stat" || arg == "--numstat" || arg == "--name-only" || arg == "--name-status":
+			o.Output = strings.TrimPrefix(arg, "--")
+		case arg == "-U" || arg == "--unified":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("%s requires a number of lines", arg)
+			}
+			i++
+			if err := o.setContext(args[i]); err != nil {
+				return nil, err
+			}
+		case strings.HasPrefix(arg, "-U"):
+			if err := o.setContext(strings.TrimPrefix(arg, "-U")); err != nil {
+				return nil, err
+			}
+		case strings.HasPrefix(arg, "--unified="):
+			if err := o.setContext(strings.TrimPrefix(arg, "--unified=")); err != nil {
+				return nil, err
+			}
+		case isAlgorithmFlag(arg):
+			if o.Algorithm, i, err = parseAlgorithmFlag(args, i); err != nil {
+				return nil, err
+			}
+		case arg == "--word-diff":
+			o.WordDiff = WordDiffPlain
+		case strings.HasPrefix(arg, "--word-diff="):
+			switch mode := strings.TrimPrefix(arg, "--word-diff="); mode {
+			case WordDiffPlain, WordDiffColor, WordDiffPorcelain:
+				o.WordDiff = mode
+			case "none":
+				o.WordDiff = ""
+			default:
+				return nil, fmt.Errorf("invalid --word-diff mode %q (expected plain, color, porcelain or none)", mode)
+			}
+		case arg == "--word-diff-regex" || strings.HasPrefix(arg, "--word-diff-regex="):
+			value, ok := strings.CutPrefix(arg, "--word-diff-regex=")
 			if !ok {
-				fmt.Printf("%sAdded file: %s%s\n", colorBlue, path, colorReset)
-
-				// Show content of added file (all lines are additions)
-				content, err := storage.ReadObject(indexHash)
-				if err != nil {
-					return err
+				if i+1 >= len(args) {
+					return nil, fmt.Errorf("%s requires a regular expression", arg)
+				}
+				i++
+				value = args[i]
+			}
+			if err := o.setWordRegex(value); err != nil {
+				return nil, err
+			}
+			if o.WordDiff == "" {
+				o.WordDiff = WordDiffPlain
+			}
+		case arg == "--color-words" || strings.HasPrefix(arg, "--color-words="):
+			if value, ok := strings.CutPrefix(arg, "--color-words="); ok {
+				if err := o.setWordRegex(value); err != nil {
+					return nil, err
 				}
-
-				contentStr := strings.TrimRight(string(content), "\n")
-				fileLines := strings.Split(contentStr, "\n")
-				emptyLines := []string{}
-
-				myers := diff.NewMyersDiff(emptyLines, fileLines)
-				diffs := myers.Diffs()
-				displayDiff(diffs)
-				continue
 			}
+			o.WordDiff = WordDiffColor
+		case strings.HasPrefix(arg, "-") && arg != "-":
+			return nil, fmt.Errorf("unknown diff option %s", arg)
+		default:
+			rest = append(rest, arg)
+		}
+	}
+	return rest, nil
+}
 
-			// If the file exists in both, but the content hash is different, it has been modified
-			if indexHash != treeHash {
-				fmt.Printf("%sModified file: %s%s\n", colorBlue, path, colorReset)
+func (o *DiffOptions) setContext(value string) error {
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return fmt.Errorf("invalid context size %q (expected a number of lines)", value)
+	}
+	o.Context = n
+	return nil
+}
 
-				// Read the old and new content from the object store.
-				oldContent, err := storage.ReadObject(treeHash)
-				if err != nil {
-					return err
-				}
-				newContent, err := storage.ReadObject(indexHash)
-				if err != nil {
-					return err
-				}
+func isAlgorithmFlag(arg string) bool {
+	switch arg {
+	case "--minimal", "--patience", "--histogram", "--diff-algorithm":
+		return true
+	}
+	return strings.HasPrefix(arg, "--diff-algorithm=")
+}
 
-				// If the Content is binary
-				if isDiffBinary(oldContent) || isDiffBinary(newContent) {
-					fmt.Println("Binary files differ")
-					continue
-				}
+// parseAlgorithmFlag reads the diff algorithm flag at args[i]: --minimal, --patience,
+// --histogram or --diff-algorithm[=]<name>. It also returns the index of the last
+// argument it used.
+func parseAlgorithmFlag(args []string, i int) (diff.Algorithm, int, error) {
+	arg := args[i]
+	switch arg {
+	case "--minimal":
+		return diff.Myers, i, nil
+	case "--patience", "--histogram":
+		return diff.Algorithm(strings.TrimPrefix(arg, "--")), i, nil
+	}
+	value, ok := strings.CutPrefix(arg, "--diff-algorithm=")
+	if !ok {
+		if i+1 >= len(args) {
+			return "", i, fmt.Errorf("%s requires an algorithm", arg)
+		}
+		i++
+		value = args[i]
+	}
+	alg, err := diff.ParseAlgorithm(value)
+	return alg, i, err
+}
 
-				// Split file content into lines to prepare for the diff algorithm
-				oldLines := strings.Split(string(oldContent), "\n")
-				newLines := strings.Split(string(newContent), "\n")
+// configuredDiffAlgorithm returns the algorithm diff.algorithm names, or Myers if it is unset
+func configuredDiffAlgorithm() (diff.Algorithm, error) {
+	value, found, err := GetConfig("diff.algorithm")
+	if err != nil || !found {
+		return diff.Myers, err
+	}
+	alg, err := diff.ParseAlgorithm(value)
+	if err != nil {
+		return diff.Myers, fmt.Errorf("invalid diff.algorithm: %w", err)
+	}
+	return alg, nil
+}
 
-				// Using the Myers algorithm to compute the differences
-				myers := diff.NewMyersDiff(oldLines, newLines)
-				diffs := myers.Diffs()
+func (o *DiffOptions) setWordRegex(value string) error {
+	re, err := regexp.Compile(value)
+	if err != nil {
+		return fmt.Errorf("invalid word regex: %w", err)
+	}
+	o.WordRegex = re
+	return nil
+}
 
-				// Display the computed differences with color
-				displayDiff(diffs)
+// Diff prints the differences between two snapshots of the repository. With no
+// revisions it compares the index with the working tree, or HEAD with the index when
+// opts.Staged is set. One revision is compared with the working tree, or the index when
+// staged. Two revisions, or "A..B", compare one commit with another, and "A...B" compares
+// B with where it branched off A.
+func Diff(opts DiffOptions, revisions []string) error {
+	if len(revisions) == 1 {
+		if left, right, ok := strings.Cut(revisions[0], "..."); ok {
+			base, err := mergeBaseRevision(left, right)
+			if err != nil {
+				return err
 			}
+			revisions = []string{base, defaultHead(right)}
+		} else if left, right, ok := strings.Cut(revisions[0], ".."); ok {
+			revisions = []string{defaultHead(left), defaultHead(right)}
 		}
+	}
 
-		// Next Loop: Iterate through files in the old tree to find deletions.
-		for path := range tree {
-			// If a file was in the old tree but is no longer in the index, it has been deleted.
-			if _, ok := index[path]; !ok {
-				fmt.Printf("%sDeleted file: %s%s\n", colorBlue, path, colorReset)
+	blobs := make(blobSource)
+	var old, new map[string]storage.IndexEntry
+	var err error
+	switch len(revisions) {
+	case 0:
+		if opts.Staged {
+			if old, err = headSnapshot(); err == nil {
+				new, err = indexSnapshot()
 			}
+		} else {
+			if old, err = indexSnapshot(); err == nil {
+				new, err = workingSnapshot(old, blobs)
+			}
+		}
+	case 1:
+		if old, err = revisionSnapshot(revisions[0]); err != nil {
+			return err
 		}
-	} else {
-		// Case B: unstaged diff (Index vs Working Directory)
-		// Equivalent to `git diff` (not `--cached`)
+		if new, err = indexSnapshot(); err == nil && !opts.Staged {
+			new, err = workingSnapshot(new, blobs)
+		}
+	case 2:
+		if opts.Staged {
+			return fmt.Errorf("--cached compares the index with one commit, not two")
+		}
+		if old, err = revisionSnapshot(revisions[0]); err == nil {
+			new, err = revisionSnapshot(revisions[1])
+		}
+	default:
+		return fmt.Errorf("too many revisions: %s", strings.Join(revisions, " "))
+	}
+	if err != nil {
+		return err
+	}
 
-		for path, indexHash := range index {
-			// Read current working directory file
-			fileContent, err := os.ReadFile(path)
-			if err != nil {
-				// File deleted from working directory (but still staged)
-				fmt.Printf("%sDeleted (unstaged): %s%s\n", colorRed, path, colorReset)
-				continue
-			}
+	if len(opts.Paths) > 0 {
+		old, new = filterPaths(old, opts.Paths), filterPaths(new, opts.Paths)
+	}
+	changes, err := compareSnapshots(old, new, blobs, opts.Renames)
+	if err != nil {
+		return err
+	}
+	return writeChanges(os.Stdout, changes, blobs, opts)
+}
 
-			// Read staged content from index
-			indexContent, err := storage.ReadObject(indexHash)
+// writeChanges prints changes in the output mode opts asks for
+func writeChanges(w io.Writer, changes []fileChange, blobs blobSource, opts DiffOptions) error {
+	switch opts.Output {
+	case DiffNameOnly:
+		for _, c := range changes {
+			fmt.Fprintln(w, c.Path)
+		}
+	case DiffNameStatus:
+		for _, c := range changes {
+			fmt.Fprintln(w, nameStatusLine(c))
+		}
+	case DiffNumstat:
+		for _, c := range changes {
+			st, err := changeStats(c, blobs, opts.Algorithm)
 			if err != nil {
-				return fmt.Errorf("failed to read index object %s: %w", indexHash, err)
+				return err
 			}
-
-			// Compare: working directory vs index (staged)
-			if string(fileContent) != string(indexContent) {
-				fmt.Printf("%sChanged (unstaged): %s%s\n", colorBlue, path, colorReset)
-
-				// If the diff is binary
-				if isDiffBinary(fileContent) || isDiffBinary(indexContent) {
-					fmt.Println("Binary files differ")
-				}
-
-				// Index content = "old" (what's staged)
-				// Working dir content = "new" (current changes)
-				indexLines := strings.Split(string(indexContent), "\n")
-				contentStr := strings.TrimRight(string(fileContent), "\n")
-				workDirLines := strings.Split(contentStr, "\n")
-
-				// Myers diff: staged (old) → working dir (new)
-				myers := diff.NewMyersDiff(indexLines, workDirLines)
-				diffs := myers.Diffs()
-				displayDiff(diffs)
+			if st.binary {
+				fmt.Fprintf(w, "-\t-\t%s\n", displayPath(c))
+			} else {
+				fmt.Fprintf(w, "%d\t%d\t%s\n", st.insertions, st.deletions, displayPath(c))
 			}
 		}
-
-		// Untracked files: exist in working directory but not staged (recursive walk)
-		var untracked []string
-		err := filepath.WalkDir(".", func(path string, d os.DirEntry, err error) error {
+	case DiffStat:
+		return writeDiffStat(w, changes, blobs, opts.Color, opts.Algorithm)
+	case "", DiffPatch:
+		out := patchWriter{w: w, context: opts.Context, color: opts.Color, words: opts.WordDiff, wordRegex: opts.WordRegex, algorithm: opts.Algorithm}
+		for _, c := range changes {
+			oldContent, err := blobs.entry(c.Old)
 			if err != nil {
 				return err
 			}
-
-			name := filepath.Base(path)
-
-			// Skip .kitcat directory
-			if name == ".kitcat" {
-				if d.IsDir() {
-					return filepath.SkipDir
-				}
-				return nil
-			}
-
-			// Skip directories (continue walking into subdirs)
-			if d.IsDir() {
-				return nil
-			}
-
-			// Only process regular files
-			if !d.Type().IsRegular() {
-				return nil
-			}
-
-			// Get relative path for comparison with index
-			relPath, err := filepath.Rel(".", path)
+			newContent, err := blobs.entry(c.New)
 			if err != nil {
 				return err
 			}
+			out.writeFile(c, oldContent, newContent)
+		}
+	default:
+		return fmt.Errorf("unknown diff output %q", opts.Output)
+	}
+	return nil
+}
 
-			// File exists on disk but not in index = untracked/new
-			if _, ok := index[relPath]; !ok {
-				untracked = append(untracked, relPath)
-			}
-			return nil
-		})
+// statWidth is how wide --stat lines may grow, graph included
+const statWidth = 80
+
+// writeDiffStat prints a line per file with its changed line count and a +/- graph,
+// scaled down if the largest change would not fit, followed by the totals. Lines are
+// counted from a diff made with alg.
+func writeDiffStat(w io.Writer, changes []fileChange, blobs blobSource, color bool, alg diff.Algorithm) error {
+	stats := make([]lineStats, len(changes))
+	nameWidth, countWidth, largest := 0, 1, 0
+	insertions, deletions := 0, 0
+	for i, c := range changes {
+		st, err := changeStats(c, blobs, alg)
 		if err != nil {
 			return err
 		}
+		stats[i] = st
+		nameWidth = max(nameWidth, len(displayPath(c)))
+		if !st.binary {
+			total := st.insertions + st.deletions
+			countWidth = max(countWidth, len(strconv.Itoa(total)))
+			largest = max(largest, total)
+			insertions += st.insertions
+			deletions += st.deletions
+		}
+	}
 
-		// Show untracked file content (all lines are additions)
-		for _, path := range untracked {
-			content, err := os.ReadFile(path)
-			if err != nil {
-				continue
-			}
+	graphWidth := max(statWidth-nameWidth-countWidth-5, 10)
+	scale := func(n int) int {
+		if largest <= graphWidth || n == 0 {
+			return n
+		}
+		return max(n*graphWidth/largest, 1)
+	}
+	paint := func(code, s string) string {
+		if !color || s == "" {
+			return s
+		}
+		return code + s + colorReset
+	}
+	for i, c := range changes {
+		st := stats[i]
+		if st.binary {
+			fmt.Fprintf(w, " %-*s | Bin %d -> %d bytes\n", nameWidth, displayPath(c), st.oldSize, st.newSize)
+			continue
+		}
+		graph := paint(colorGreen, strings.Repeat("+", scale(st.insertions))) +
+			paint(colorRed, strings.Repeat("-", scale(st.deletions)))
+		line := fmt.Sprintf(" %-*s | %*d %s", nameWidth, displayPath(c), countWidth, st.insertions+st.deletions, graph)
+		fmt.Fprintln(w, strings.TrimRight(line, " "))
+	}
+	fmt.Fprintf(w, " %s\n", statSummary(len(changes), insertions, deletions))
+	return nil
+}
 
-			// If the content is binary
-			if isDiffBinary(content) {
-				fmt.Println("Binary files differ")
-				continue
-			}
+// displayPath names a change's file, as "old => new" for a rename or copy
+func displayPath(c fileChange) string {
+	if c.Status == 'R' || c.Status == 'C' {
+		return c.OldPath + " => " + c.Path
+	}
+	return c.Path
+}
+
+// defaultHead stands in HEAD for the omitted side of a range
+func defaultHead(rev string) string {
+	if rev == "" {
+		return "HEAD"
+	}
+	return rev
+}
+
+// mergeBaseRevision returns the commit "A...B" diffs from: the best common ancestor
+func mergeBaseRevision(left, right string) (string, error) {
+	a, err := ResolveCommit(defaultHead(left))
+	if err != nil {
+		return "", err
+	}
+	b, err := ResolveCommit(defaultHead(right))
+	if err != nil {
+		return "", err
+	}
+	base, err := storage.FindMergeBase(a.ID, b.ID)
+	if err != nil {
+		return "", err
+	}
+	if base == "" {
+		return "", fmt.Errorf("%s and %s have no common ancestor", defaultHead(left), defaultHead(right))
+	}
+	return base, nil
+}
 
-			fmt.Printf("%s%sUntracked:%s %s\n", colorGreen, colorBlue, colorReset, path)
+// revisionSnapshot lists the files of the commit rev names
+func revisionSnapshot(rev string) (map[string]storage.IndexEntry, error) {
+	commit, err := ResolveCommit(rev)
+	if err != nil {
+		return nil, err
+	}
+	return storage.ParseTreeEntries(commit.TreeHash)
+}
 
-			// Trim trailing newlines and split into lines
-			contentStr := strings.TrimRight(string(content), "\n")
-			fileLines := strings.Split(contentStr, "\n")
-			emptyLines := []string{}
+// headSnapshot lists the files of the HEAD commit, or none before the first commit
+func headSnapshot() (map[string]storage.IndexEntry, error) {
+	headCommit, err := GetHeadCommit()
+	if err == storage.ErrNoCommits {
+		return make(map[string]storage.IndexEntry), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return storage.ParseTreeEntries(headCommit.TreeHash)
+}
 
-			// Myers diff: empty (old) → file content (new)
-			// All lines will show as green additions
-			myers := diff.NewMyersDiff(emptyLines, fileLines)
-			diffs := myers.Diffs()
+// indexSnapshot lists the files staged in the index
+func indexSnapshot() (map[string]storage.IndexEntry, error) {
+	return storage.LoadIndexEntries()
+}
 
-			// Display the computed differences with color
-			displayDiff(diffs)
+// workingSnapshot lists the files of the working tree: the tracked files in index as they
+// are on disk now, and untracked files that are not ignored. Files that differ from the
+// index are hashed and their content kept in blobs.
+func workingSnapshot(index map[string]storage.IndexEntry, blobs blobSource) (map[string]storage.IndexEntry, error) {
+	files := make(map[string]storage.IndexEntry, len(index))
+	tracked := make(map[string]string, len(index))
+	refresh := make(map[string]storage.IndexEntry)
+	for path, entry := range index {
+		tracked[path] = entry.Hash
+		info, err := os.Lstat(path)
+		if err != nil {
+			continue // deleted from the working tree
+		}
+		matches, err := trackedFileMatches(path, info, entry, refresh)
+		if err != nil {
+			return nil, err
 		}
+		if matches && storage.FileModeOf(info, entry.Mode) == entry.Mode {
+			files[path] = storage.IndexEntry{Hash: entry.Hash, Mode: entry.Mode}
+			continue
+		}
+		if err := addWorkingFile(files, blobs, path, entry.Mode); err != nil {
+			return nil, err
+		}
+	}
+	if len(refresh) > 0 {
+		// Best effort: a failed refresh only means hashing again next time
+		_ = storage.RefreshIndexStat(refresh)
 	}
 
+	ignorePatterns, err := LoadIgnorePatterns()
+	if err != nil {
+		return nil, err
+	}
+	err = filepath.WalkDir(".", func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == RepoDir {
+			return filepath.SkipDir
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		if _, ok := index[path]; ok || ShouldIgnore(path, ignorePatterns, tracked) {
+			return nil
+		}
+		return addWorkingFile(files, blobs, path, storage.ModeRegular)
+	})
+	return files, err
+}
+
+// addWorkingFile hashes a working tree file into files, keeping its content in blobs
+func addWorkingFile(files map[string]storage.IndexEntry, blobs blobSource, path string, staged uint32) error {
+	content, mode, err := readWorkingFile(path, staged)
+	if err != nil {
+		return err
+	}
+	hash := storage.HashObject(storage.ObjectBlob, content)
+	blobs[hash] = content
+	files[path] = storage.IndexEntry{Hash: hash, Mode: mode}
 	return nil
 }
+
+// filterPaths keeps the files that are, or are inside, one of paths. A path may also be
+// a glob such as "*.go".
+func filterPaths(files map[string]storage.IndexEntry, paths []string) map[string]storage.IndexEntry {
+	kept := make(map[string]storage.IndexEntry)
+	for file, entry := range files {
+		if matchesPathspec(file, paths) {
+			kept[file] = entry
+		}
+	}
+	return kept
+}
+
+func matchesPathspec(file string, paths []string) bool {
+	for _, p := range paths {
+		p = filepath.Clean(p)
+		if p == "." || file == p || strings.HasPrefix(file, p+string(filepath.Separator)) {
+			return true
+		}
+		if ok, _ := filepath.Match(p, file); ok {
+			return true
+		}
+	}
+	return false
+}
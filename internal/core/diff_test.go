@@ -0,0 +1,79 @@
+package core
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+)
+
+// captureStdout runs fn and returns what it printed
+func captureStdout(t *testing.T, fn func() error) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldStdout := os.Stdout
+	os.Stdout = w
+	runErr := fn()
+	w.Close()
+	os.Stdout = oldStdout
+
+	var buf bytes.Buffer
+	if _, err := buf.ReadFrom(r); err != nil {
+		t.Fatal(err)
+	}
+	if runErr != nil {
+		t.Fatalf("failed: %v\noutput:\n%s", runErr, buf.String())
+	}
+	return buf.String()
+}
+
+func TestDiff_RevisionsPathsAndOutputModes(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	if err := os.MkdirAll("docs", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	commitFiles(t, "first", map[string]string{"a.txt": "one\ntwo\nthree\n", "docs/guide.md": "guide\n"})
+	if err := CreateBranch("topic"); err != nil {
+		t.Fatal(err)
+	}
+	commitFiles(t, "second", map[string]string{"a.txt": "one\n2\nthree\nfour\n", "docs/guide.md": "guide\nmore\n"})
+
+	diff := func(opts DiffOptions, revisions ...string) string {
+		t.Helper()
+		return captureStdout(t, func() error { return Diff(opts, revisions) })
+	}
+
+	got := diff(DiffOptions{Output: DiffNumstat}, "HEAD~1", "HEAD")
+	if want := "2\t1\ta.txt\n1\t0\tdocs/guide.md\n"; got != want {
+		t.Errorf("numstat:\n%s\nwant\n%s", got, want)
+	}
+	got = diff(DiffOptions{Output: DiffNameOnly, Paths: []string{"docs"}}, "topic..main")
+	if got != "docs/guide.md\n" {
+		t.Errorf("name-only limited to docs: %q", got)
+	}
+	got = diff(DiffOptions{Output: DiffStat}, "topic...main")
+	want := " a.txt         | 3 ++-\n docs/guide.md | 1 +\n 2 files changed, 3 insertions(+), 1 deletion(-)\n"
+	if got != want {
+		t.Errorf("stat:\n%s\nwant\n%s", got, want)
+	}
+
+	// The staged diff compares with HEAD, not with the newest commit in the store
+	if err := ResetHard("topic"); err != nil {
+		t.Fatal(err)
+	}
+	if got := diff(DiffOptions{Staged: true, Output: DiffNameStatus}); got != "" {
+		t.Errorf("staged diff right after a reset: %q", got)
+	}
+	if err := os.WriteFile("a.txt", []byte("one\ntwo\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	got = diff(DiffOptions{Context: DefaultContextLines, Paths: []string{"a.txt"}})
+	if !strings.Contains(got, "diff --kitcat a/a.txt b/a.txt\n") || !strings.Contains(got, "-three\n") {
+		t.Errorf("working tree diff:\n%s", got)
+	}
+}
@@ -0,0 +1,229 @@
+package core
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/LeeFred3042U/kitcat/internal/diff"
+	"github.com/LeeFred3042U/kitcat/internal/models"
+	"github.com/LeeFred3042U/kitcat/internal/storage"
+)
+
+// mboxDate is the fixed date of the "From <commit>" line that starts each patch, which
+// only marks where a message begins
+const mboxDate = "Mon Sep 17 00:00:00 2001"
+
+// FormatPatchOptions controls which commits FormatPatch exports and where
+type FormatPatchOptions struct {
+	OutputDir string // directory for the patch files; "" is the current directory
+	Stdout    bool   // write every patch to standard output as one mailbox instead
+	Count     int    // export this many commits ending at the revision, or HEAD
+	Renames   RenameOptions
+	Algorithm diff.Algorithm // how the diffs match up lines
+}
+
+// DefaultFormatPatchOptions returns the options format-patch starts from, detecting renames
+// as diff.renames says and diffing with diff.algorithm
+func DefaultFormatPatchOptions() (FormatPatchOptions, error) {
+	renames, err := DefaultRenameOptions()
+	if err != nil {
+		return FormatPatchOptions{}, err
+	}
+	alg, err := configuredDiffAlgorithm()
+	return FormatPatchOptions{Renames: renames, Algorithm: alg}, err
+}
+
+// ParseFlags applies format-patch flags (-o <dir>, --stdout, -<n>, the diff algorithm and
+// the rename flags) and returns the arguments that are not flags
+func (o *FormatPatchOptions) ParseFlags(args []string) ([]string, error) {
+	args, err := o.Renames.ParseFlags(args)
+	if err != nil {
+		return nil, err
+	}
+	var rest []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "-o" || arg == "--output-directory":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("%s requires a directory", arg)
+			}
+			i++
+			o.OutputDir = args[i]
+		case strings.HasPrefix(arg, "--output-directory="):
+			o.OutputDir = strings.TrimPrefix(arg, "--output-directory=")
+		case arg == "--stdout":
+			o.Stdout = true
+		case isAlgorithmFlag(arg):
+			if o.Algorithm, i, err = parseAlgorithmFlag(args, i); err != nil {
+				return nil, err
+			}
+		case len(arg) > 1 && arg[0] == '-' && isDigits(arg[1:]):
+			n, err := strconv.Atoi(arg[1:])
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("invalid commit count %q", arg)
+			}
+			o.Count = n
+		case strings.HasPrefix(arg, "-") && arg != "-":
+			return nil, fmt.Errorf("unknown format-patch option %q", arg)
+		default:
+			rest = append(rest, arg)
+		}
+	}
+	return rest, nil
+}
+
+func isDigits(s string) bool {
+	return s != "" && strings.Trim(s, "0123456789") == ""
+}
+
+// FormatPatch writes each commit of rev as a patch email, oldest first, and returns the
+// names of the files written. A single revision means the commits since it, up to HEAD;
+// with opts.Count, it is the last of that many commits instead. "A..B" and other ranges
+// work as in log. Merge commits are left out.
+//
+// Each file is named after its number and subject, like 0001-Fix-the-parser.patch, and
+// holds the author, date and message as mail headers and body, followed by a diffstat and
+// the unified diff that apply and am read back.
+func FormatPatch(opts FormatPatchOptions, rev string) ([]string, error) {
+	var r RevisionRange
+	var err error
+	switch {
+	case strings.Contains(rev, ".."):
+		r, err = ParseRevisionRange(rev)
+	case opts.Count > 0:
+		r, err = ParseRevisionRange(defaultHead(rev))
+	case rev != "":
+		r, err = ParseRevisionRange(rev + "..HEAD")
+	default:
+		return nil, fmt.Errorf("format-patch needs a revision range or a commit count")
+	}
+	if err != nil {
+		return nil, err
+	}
+	ids, err := storage.RevList(r.Include, r.Exclude, 0)
+	if err != nil {
+		return nil, err
+	}
+	var commits []models.Commit
+	for _, id := range ids {
+		c, err := storage.FindCommit(id)
+		if err != nil {
+			return nil, err
+		}
+		if !c.IsMerge() {
+			commits = append(commits, c)
+		}
+		if opts.Count > 0 && len(commits) == opts.Count {
+			break
+		}
+	}
+	slices.Reverse(commits)
+
+	var names []string
+	for i, c := range commits {
+		if opts.Stdout {
+			if err := writePatchEmail(os.Stdout, c, i+1, len(commits), opts); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		name := filepath.Join(opts.OutputDir, patchFileName(i+1, subjectLine(c.Message)))
+		if err := writePatchFile(name, c, i+1, len(commits), opts); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+func writePatchFile(name string, c models.Commit, n, total int, opts FormatPatchOptions) error {
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return err
+	}
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	if err := writePatchEmail(f, c, n, total, opts); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// writePatchEmail writes commit c as patch n of total
+func writePatchEmail(w io.Writer, c models.Commit, n, total int, opts FormatPatchOptions) error {
+	var parentTree string
+	if first := c.FirstParent(); first != "" {
+		parent, err := storage.FindCommit(first)
+		if err != nil {
+			return err
+		}
+		parentTree = parent.TreeHash
+	}
+	changes, err := treeChanges(parentTree, c.TreeHash, opts.Renames)
+	if err != nil {
+		return err
+	}
+
+	prefix := "[PATCH]"
+	if total > 1 {
+		prefix = fmt.Sprintf("[PATCH %d/%d]", n, total)
+	}
+	subject, body, _ := strings.Cut(c.Message, "\n")
+	body = strings.Trim(body, "\n")
+	from := mail.Address{Name: c.AuthorName, Address: c.AuthorEmail}
+
+	fmt.Fprintf(w, "From %s %s\n", c.ID, mboxDate)
+	fmt.Fprintf(w, "From: %s\n", from.String())
+	fmt.Fprintf(w, "Date: %s\n", c.Timestamp.Format(time.RFC1123Z))
+	fmt.Fprintf(w, "Subject: %s\n\n", mime.QEncoding.Encode("utf-8", prefix+" "+subject))
+	if body != "" {
+		fmt.Fprintf(w, "%s\n", body)
+	}
+	fmt.Fprintln(w, "---")
+	if err := writeDiffStat(w, changes, nil, false, opts.Algorithm); err != nil {
+		return err
+	}
+	fmt.Fprintln(w)
+	if err := writeChanges(w, changes, nil, DiffOptions{Context: DefaultContextLines, Output: DiffPatch, Algorithm: opts.Algorithm}); err != nil {
+		return err
+	}
+	fmt.Fprint(w, "-- \nkitcat\n\n")
+	return nil
+}
+
+// patchFileName turns a subject into a file name: runs of anything but letters, digits,
+// dots and underscores become a dash, and long subjects are cut short
+func patchFileName(n int, subject string) string {
+	var sb strings.Builder
+	dash := false
+	for _, r := range subject {
+		if r < 128 && (r == '.' || r == '_' || ('0' <= r && r <= '9') || ('a' <= r && r <= 'z') || ('A' <= r && r <= 'Z')) {
+			if dash && sb.Len() > 0 {
+				sb.WriteByte('-')
+			}
+			sb.WriteRune(r)
+			dash = false
+			if sb.Len() >= 52 {
+				break
+			}
+		} else {
+			dash = true
+		}
+	}
+	name := strings.TrimRight(sb.String(), ".")
+	if name == "" {
+		name = "patch"
+	}
+	return fmt.Sprintf("%04d-%s.patch", n, name)
+}
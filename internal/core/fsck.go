@@ -0,0 +1,307 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/LeeFred3042U/kitcat/internal/storage"
+)
+
+// FsckProblem is a single integrity failure found by Fsck.
+// Kind is a stable keyword, Subject names the broken object, ref or file.
+type FsckProblem struct {
+	Kind    string
+	Subject string
+	Detail  string
+}
+
+// FsckReport collects everything Fsck found
+type FsckReport struct {
+	Objects  int
+	Problems []FsckProblem
+	Stray    []string // leftover temporary files from interrupted writes
+}
+
+// Write prints the report one item per line as "<kind> <subject> <detail>".
+// Kinds and subjects never contain spaces, so the lines can be split on the first two.
+func (r *FsckReport) Write(w io.Writer) {
+	for _, p := range r.Problems {
+		fmt.Fprintf(w, "%s %s %s\n", p.Kind, p.Subject, p.Detail)
+	}
+	for _, path := range r.Stray {
+		fmt.Fprintf(w, "stray-file %s\n", path)
+	}
+}
+
+// Fsck verifies the integrity of the repository: every object must hash to its name and
+// parse cleanly, every tree entry and commit parent must resolve, and refs, stash entries,
+// rebase state and the commit journal must point at real commits
+func Fsck() (*FsckReport, error) {
+	r := &FsckReport{}
+
+	hashes, err := storage.FindObjectsByPrefix("")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list objects: %w", err)
+	}
+	sort.Strings(hashes)
+	r.Objects = len(hashes)
+
+	types := make(map[string]string, len(hashes))
+	for _, hash := range hashes {
+		objType, err := storage.VerifyObject(hash)
+		switch {
+		case errors.Is(err, storage.ErrHashMismatch):
+			r.add("hash-mismatch", hash, err.Error())
+		case err != nil:
+			r.add("corrupt-object", hash, err.Error())
+		default:
+			types[hash] = objType
+		}
+	}
+
+	packs, err := storage.VerifyPacks()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read packs: %w", err)
+	}
+	for _, path := range sortedKeys(packs) {
+		r.add("corrupt-pack", path, packs[path].Error())
+	}
+
+	if err := storage.VerifyCommitGraph(); err != nil {
+		r.add("corrupt-commit-graph", "objects/info/commit-graph", err.Error())
+	}
+
+	// Legacy objects get their type from what refers to them as the checks run, so
+	// dispatch on the types the objects were stored with
+	stored := maps.Clone(types)
+	for _, hash := range hashes {
+		switch stored[hash] {
+		case storage.ObjectTree:
+			r.checkTree(hash, types)
+		case storage.ObjectCommit:
+			r.checkCommit(hash, types)
+		case storage.ObjectTag:
+			r.checkTag(hash, types)
+		}
+	}
+
+	r.checkJournal(types)
+	if err := r.checkRefs(types); err != nil {
+		return nil, err
+	}
+	r.checkStash(types)
+	r.checkRebaseState(types)
+
+	if r.Stray, err = findStrayFiles(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *FsckReport) add(kind, subject, detail string) {
+	r.Problems = append(r.Problems, FsckProblem{Kind: kind, Subject: subject, Detail: detail})
+}
+
+// checkTree makes sure every entry of a tree names an object of the right type
+func (r *FsckReport) checkTree(hash string, types map[string]string) {
+	entries, err := storage.ReadTree(hash)
+	if err != nil {
+		r.add("corrupt-object", hash, err.Error())
+		return
+	}
+	for _, e := range entries {
+		want := storage.ObjectBlob
+		if e.IsDir() {
+			want = storage.ObjectTree
+		}
+		got, ok := r.resolve(e.Hash, want, types)
+		switch {
+		case !ok:
+			r.add("missing-object", e.Hash, fmt.Sprintf("%s %q in tree %s", want, e.Name, hash))
+		case got != want:
+			r.add("wrong-type", e.Hash, fmt.Sprintf("%q in tree %s is a %s, expected a %s", e.Name, hash, got, want))
+		}
+	}
+}
+
+// resolve returns the type of the object hash for a referrer that expects want.
+// A legacy object carries no type, so it takes the one it is first referred to as,
+// and a legacy tree is checked once it is known to be one.
+func (r *FsckReport) resolve(hash, want string, types map[string]string) (string, bool) {
+	got, ok := types[hash]
+	if got != storage.ObjectLegacy || (want != storage.ObjectBlob && want != storage.ObjectTree) {
+		return got, ok
+	}
+	types[hash] = want
+	if want == storage.ObjectTree {
+		r.checkTree(hash, types)
+	}
+	return want, true
+}
+
+// checkCommit makes sure a commit's tree and parents exist
+func (r *FsckReport) checkCommit(hash string, types map[string]string) {
+	c, err := storage.FindCommit(hash)
+	if err != nil {
+		r.add("corrupt-object", hash, err.Error())
+		return
+	}
+	if got, _ := r.resolve(c.TreeHash, storage.ObjectTree, types); got != storage.ObjectTree {
+		r.add("missing-tree", hash, fmt.Sprintf("tree %s is missing or not a valid tree", c.TreeHash))
+	}
+	for _, parent := range c.Parents {
+		if types[parent] != storage.ObjectCommit {
+			r.add("missing-parent", hash, fmt.Sprintf("parent %s is missing or not a valid commit", parent))
+		}
+	}
+}
+
+// checkTag makes sure the object an annotated tag points to exists and has the type
+// the tag records
+func (r *FsckReport) checkTag(hash string, types map[string]string) {
+	t, err := storage.ReadTag(hash)
+	if err != nil {
+		r.add("corrupt-object", hash, err.Error())
+		return
+	}
+	switch got, ok := r.resolve(t.Object, t.Type, types); {
+	case !ok:
+		r.add("missing-object", t.Object, fmt.Sprintf("%s tagged by %s", t.Type, hash))
+	case got != t.Type:
+		r.add("wrong-type", t.Object, fmt.Sprintf("tagged by %s as a %s, but is a %s", hash, t.Type, got))
+	}
+}
+
+// checkJournal reports commit journal lines that do not name a commit
+func (r *FsckReport) checkJournal(types map[string]string) {
+	ids, err := storage.ReadCommitJournal()
+	if err != nil {
+		r.add("corrupt-journal", filepath.ToSlash(CommitsPath), err.Error())
+		return
+	}
+	for i, id := range ids {
+		if types[id] != storage.ObjectCommit {
+			r.add("bad-journal-entry", id, fmt.Sprintf("line %d of %s is not a commit", i+1, filepath.ToSlash(CommitsPath)))
+		}
+	}
+}
+
+// checkRefs verifies every branch and tag, and HEAD when it is detached.
+// An empty ref belongs to a branch with no commits yet and is not an error.
+func (r *FsckReport) checkRefs(types map[string]string) error {
+	refs, err := listRefs()
+	if err != nil {
+		return fmt.Errorf("failed to read refs: %w", err)
+	}
+	for _, name := range sortedKeys(refs) {
+		// Annotated tags are checked along with the other objects
+		if strings.HasPrefix(name, "refs/tags/") && types[refs[name]] == storage.ObjectTag {
+			continue
+		}
+		r.checkCommitRef("broken-ref", name, refs[name], types)
+	}
+
+	head, err := os.ReadFile(HeadPath)
+	if err != nil {
+		r.add("broken-ref", "HEAD", err.Error())
+		return nil
+	}
+	target := strings.TrimSpace(string(head))
+	if ref, ok := strings.CutPrefix(target, "ref: "); ok {
+		if !strings.HasPrefix(ref, "refs/") {
+			r.add("broken-ref", "HEAD", fmt.Sprintf("points outside refs/: %s", ref))
+		}
+		return nil
+	}
+	r.checkCommitRef("broken-ref", "HEAD", target, types)
+	return nil
+}
+
+func (r *FsckReport) checkCommitRef(kind, name, target string, types map[string]string) {
+	if target == "" {
+		return
+	}
+	if types[target] != storage.ObjectCommit {
+		r.add(kind, name, fmt.Sprintf("points to %s, which is not a commit", target))
+	}
+}
+
+func (r *FsckReport) checkStash(types map[string]string) {
+	stashes, err := storage.ListStashes()
+	if err != nil {
+		r.add("broken-stash", "stash", err.Error())
+		return
+	}
+	for i, id := range stashes {
+		r.checkCommitRef("broken-stash", fmt.Sprintf("stash@{%d}", i), id, types)
+	}
+}
+
+// checkRebaseState verifies the commits an interrupted rebase would go back to or replay
+func (r *FsckReport) checkRebaseState(types map[string]string) {
+	if !IsRebaseInProgress() {
+		return
+	}
+	state, err := LoadRebaseState()
+	if err != nil {
+		r.add("broken-rebase-state", "rebase-merge", err.Error())
+		return
+	}
+	r.checkCommitRef("broken-rebase-state", "rebase-merge/onto", state.Onto, types)
+	r.checkCommitRef("broken-rebase-state", "rebase-merge/orig-head", state.OrigHead, types)
+	for i, step := range state.TodoSteps {
+		fields := strings.Fields(step)
+		if len(fields) < 2 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		hash, err := storage.ResolveObject(fields[1])
+		if err != nil {
+			r.add("broken-rebase-state", fmt.Sprintf("rebase-merge/git-rebase-todo:%d", i+1), err.Error())
+			continue
+		}
+		r.checkCommitRef("broken-rebase-state", fmt.Sprintf("rebase-merge/git-rebase-todo:%d", i+1), hash, types)
+	}
+}
+
+// findStrayFiles lists temporary files left behind by interrupted writes: "*.tmp" files
+// inside the repository directory and "atomic-*" files anywhere in the working tree
+func findStrayFiles() ([]string, error) {
+	var stray []string
+	err := filepath.WalkDir(".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		inRepo := path == RepoDir || strings.HasPrefix(path, RepoDir+string(filepath.Separator))
+		if strings.HasPrefix(d.Name(), "atomic-") || inRepo && isTempFileName(d.Name()) {
+			stray = append(stray, filepath.ToSlash(path))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan for stray files: %w", err)
+	}
+	return stray, nil
+}
+
+func isTempFileName(name string) bool {
+	return strings.HasSuffix(name, ".tmp") || strings.HasPrefix(name, "atomic-")
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,114 @@
+package core
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/LeeFred3042U/kitcat/internal/storage"
+)
+
+func TestFsck_CleanRepository(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	if err := os.WriteFile("a.txt", []byte("a\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := AddFile("a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := Commit("add a"); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+
+	report, err := Fsck()
+	if err != nil {
+		t.Fatalf("Fsck failed: %v", err)
+	}
+	if len(report.Problems) != 0 || len(report.Stray) != 0 {
+		t.Errorf("expected a clean report, got %+v", report)
+	}
+}
+
+func TestFsck_ReportsBrokenRefsAndStrayFiles(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	blob, err := storage.WriteObject(storage.ObjectBlob, []byte("not a commit"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(HeadsDir, "bad"), []byte(blob), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(RepoDir, "index.tmp"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile("atomic-123", nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := Fsck()
+	if err != nil {
+		t.Fatalf("Fsck failed: %v", err)
+	}
+	found := false
+	for _, p := range report.Problems {
+		if p.Kind == "broken-ref" && p.Subject == "refs/heads/bad" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("broken ref not reported: %+v", report.Problems)
+	}
+	if len(report.Stray) != 2 {
+		t.Errorf("expected 2 stray files, got %v", report.Stray)
+	}
+}
+
+func TestFsck_LegacyRepositoryIsClean(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	// Objects from before they carried a type: raw bytes named by their SHA-1
+	writeLegacy := func(content string) string {
+		t.Helper()
+		sum := sha1.Sum([]byte(content))
+		hash := hex.EncodeToString(sum[:])
+		if err := os.WriteFile(filepath.Join(ObjectsDir, hash), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return hash
+	}
+	// Blobs that read like a commit header and like a flat tree
+	notes := writeLegacy("tree of life\nsecond\n")
+	sums := writeLegacy("da39a3ee5e6b4b0d3255bfef95601890afd80709 empty.txt\n")
+	tree := writeLegacy(sums + " SHA1SUMS\n" + notes + " notes.txt\n")
+
+	line, err := json.Marshal(map[string]any{"ID": "oldroot", "TreeHash": tree, "Message": "root", "Timestamp": time.Unix(1, 0).UTC()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(CommitsPath, append(line, '\n'), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(HeadsDir, "main"), []byte("oldroot\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := OpenRepository(); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := Fsck()
+	if err != nil {
+		t.Fatalf("Fsck failed: %v", err)
+	}
+	if len(report.Problems) != 0 {
+		t.Errorf("expected a clean report, got %+v", report.Problems)
+	}
+}
@@ -0,0 +1,50 @@
+package core
+
+import (
+	"fmt"
+
+	"github.com/LeeFred3042U/kitcat/internal/storage"
+)
+
+// GarbageCollect packs all loose objects, together with any existing packs, into a
+// single delta-compressed packfile, removes the loose copies, packs refs and refreshes
+// the commit-graph
+func GarbageCollect() error {
+	stats, err := storage.Repack()
+	if err != nil {
+		return fmt.Errorf("failed to pack objects: %w", err)
+	}
+	if stats.Objects == 0 {
+		fmt.Println("Nothing to pack")
+	} else {
+		fmt.Printf("Packed %d objects (%d stored as deltas)\n", stats.Objects, stats.Deltas)
+		fmt.Printf("Removed %d loose objects and %d old packs\n", stats.LooseRemoved, stats.PacksRemoved)
+	}
+	if err := PackRefs(true); err != nil {
+		return fmt.Errorf("failed to pack refs: %w", err)
+	}
+	return WriteCommitGraph()
+}
+
+// WriteCommitGraph rebuilds the commit-graph used to speed up history walks
+func WriteCommitGraph() error {
+	count, err := storage.WriteCommitGraph()
+	if err != nil {
+		return fmt.Errorf("failed to write commit-graph: %w", err)
+	}
+	fmt.Printf("Wrote commit-graph with %d commit%s\n", count, pluralize(count))
+	return nil
+}
+
+// VerifyCommitGraph checks the commit-graph against the commit objects it describes
+func VerifyCommitGraph() error {
+	if !storage.HasCommitGraph() {
+		fmt.Println("No commit-graph")
+		return nil
+	}
+	if err := storage.VerifyCommitGraph(); err != nil {
+		return fmt.Errorf("commit-graph is corrupt: %w", err)
+	}
+	fmt.Println("commit-graph OK")
+	return nil
+}
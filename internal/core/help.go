@@ -18,23 +18,35 @@ var helpMessages = map[string]CommandHelp{
 	},
 	"commit": {
 		Summary: "Record changes to the repository.",
-		Usage:   "Usage: kitcat commit <-m | -am | --amend> <message>\n\nCreates a new commit from the staging area.\nUse '-am' to automatically stage all tracked files before committing.\nUse '--amend' to modify the previous commit.",
+		Usage:   "Usage: kitcat commit [-S] <-m | -am | --amend -m> <message>\n\nCreates a new commit from the staging area.\nUse '-am' to automatically stage all tracked files before committing.\nUse '--amend' to modify the previous commit.\nUse '-S' to sign the commit with the ed25519 key user.signingkey points at (a PEM PKCS#8 file, e.g. from 'openssl genpkey -algorithm ed25519'); '--no-gpg-sign' overrides commit.gpgsign=true.",
 	},
 	"diff": {
-		Summary: "Show changes between the last commit and staging area",
-		Usage:   "Usage: kitcat diff\n\nShows content differences between the HEAD commit and the index",
+		Summary: "Show changes between commits, the index and the working tree",
+		Usage:   "Usage: kitcat diff [<options>] [--cached] [<commit>] [[--] <path>...]\n       kitcat diff [<options>] <commit> <commit> [[--] <path>...]\n       kitcat diff [<options>] <commit>..<commit> | <commit>...<commit>\n\nShows the changes between the index and the working tree; with --cached, between HEAD and the index.\nGiven one commit, compares it with the working tree, or with the index under --cached. Given two, or A..B, compares A with B; A...B compares B with the common ancestor of A and B. Paths limit the comparison to those files and directories.\nA deleted file and a similar added one are shown as a rename.\nFlags:\n  --stat                         Show a histogram of changed lines per file and the totals\n  --numstat                      Show inserted and deleted line counts per file\n  --name-only                    Show only the changed paths\n  --name-status                  Show the changed paths with A, D, M, T, R<score> or C<score>\n  -U<n>, --unified=<n>           Show <n> lines of context around each change (default 3, or diff.context)\n  --color, --no-color            Force color on or off; by default it is used only on a terminal\n  --word-diff[=<mode>]           Diff changed lines word by word: plain shows [-deleted-]{+inserted+}, color shows them in red and green, porcelain puts each run on a line of its own for scripts, none turns it off\n  --word-diff-regex=<regex>      What counts as a word (default: runs of non-space characters, or diff.wordregex); '.' diffs character by character. Implies --word-diff\n  --color-words[=<regex>]        Same as --word-diff=color with an optional --word-diff-regex\n  --diff-algorithm=<algorithm>   Match lines up with myers (the default, also called minimal), patience or histogram\n  --patience, --histogram        Short for --diff-algorithm=patience or histogram\n  -M[<n>], --find-renames[=<n>]  Detect renames at least <n>% similar (default 50, or diff.renamethreshold)\n  -C[<n>], --find-copies[=<n>]   Also detect files copied from existing ones\n  --no-renames                   Show renames as a deletion and an addition\nThe default patch output is a unified diff that 'patch -p1' can apply. The config key diff.renames (true, false or copies) sets the default rename detection, and diff.algorithm the default algorithm.",
+	},
+	"format-patch": {
+		Summary: "Write commits out as patch emails",
+		Usage:   "Usage: kitcat format-patch [-o <dir>] [--stdout] [-<n>] [<since> | <revision-range>]\n\nWrites each commit as a mailbox file named after its number and subject, such as 0001-Fix-the-parser.patch, holding the author, date and message as mail headers and body, then a diffstat and the unified diff. Given one commit, exports the commits since it up to HEAD; A..B exports those in B that are not in A. Merge commits are skipped. The names of the files written are printed.\nFlags:\n  -o <dir>   Write the files into <dir> instead of the current directory\n  --stdout   Print all the patches as one mailbox instead of writing files\n  -<n>       Export the last <n> commits up to the given commit, or HEAD\nRename and diff algorithm flags work as in diff. Binary changes are marked but carry no data, so apply and am cannot replay them.",
+	},
+	"apply": {
+		Summary: "Apply a patch to the working tree",
+		Usage:   "Usage: kitcat apply [--check] [--index] [-3 | --3way] [<patch>...]\n\nApplies patches written by diff or format-patch, by git, or by 'diff -u', reading standard input if no file is given. Paths lose their first component (a/ and b/). Hunks are found even if lines were added or removed above them. Every file in a patch is checked first, so a patch that does not apply changes nothing.\nFlags:\n  --check      Only report whether the patch applies\n  --index      Also update the index; the files must match it\n  -3, --3way   When a hunk no longer matches, merge with the blob named on the patch's index line, leaving conflict markers where both sides changed the same lines (implies --index)",
+	},
+	"am": {
+		Summary: "Apply a series of patch emails as commits",
+		Usage:   "Usage: kitcat am [-3 | --3way] [<mbox>...]\n       kitcat am --continue | --skip | --abort\n\nApplies each patch in the given mailbox files, or on standard input, and commits it with the author, date and message of its email, so a series from format-patch applied to the same commit gives the same commits. The index must not have staged changes.\nIf a patch does not apply, am stops: fix the files, 'kitcat add' them and run 'am --continue', or drop the patch with 'am --skip', or return to where you started with 'am --abort'.\nFlags:\n  -3, --3way   Fall back to a three-way merge as 'apply -3' does",
 	},
 	"log": {
 		Summary: "Show the commit history",
-		Usage:   "Usage: kitcat log [--oneline] [-n <limit>]\n\nDisplays the commit history for the current branch.\nFlags:\n  --oneline   Compact, single-line view\n  -n <limit>  Limits output to N commits",
+		Usage:   "Usage: kitcat log [--oneline] [-n <limit>] [--show-signature] [--name-status [-M[<n>] | -C[<n>] | --no-renames]] [<revision-range>...]\n\nDisplays the commit history for the current branch, or for the given revisions. A..B lists the commits in B that are not in A, A...B those in either but not in both, and ^A excludes A's history.\nFlags:\n  --oneline   Compact, single-line view\n  -n <limit>  Limits output to N commits\n  --show-signature  Check each commit's signature and print its status\n  --name-status     List the files each commit changed, e.g. \"R087 old new\" for a rename 87% similar; rename flags work as in diff",
 	},
 	"tag": {
-		Summary: "Create a new tag for a commit",
-		Usage:   "Usage: kitcat tag <tag-name> <commit-id>\n\nCreates a new lightweight tag that points to the specified commit",
+		Summary: "Create, list or delete tags",
+		Usage:   "Usage: kitcat tag [-a | -s] [-m <message>] <tag-name> [<commit>]\n       kitcat tag -d <tag-name>...\n       kitcat tag [-l] [-n[<num>]] [<pattern>]\n\nCreates a tag pointing to <commit>, which may be any revision such as HEAD~2 and defaults to HEAD. Without -m the tag is a lightweight ref; with -m it is an annotated tag object recording the tagger, date and message.\nFlags:\n  -a, -m <message>  Create an annotated tag (-m implies -a)\n  -s                Create a signed annotated tag with the key user.signingkey points at\n  -d                Delete the named tags\n  -l [<pattern>]    List tags, only those matching the glob <pattern> if given, e.g. 'v1.*'\n  -n[<num>]         Show the first <num> lines (default 1) of each tag's message when listing",
 	},
 	"merge": {
 		Summary: "Merge a branch into the current branch.",
-		Usage:   "Usage: kitcat merge <branch-name>\n\nJoins another branch's history into the current branch. Currently, only fast-forward merges are supported.",
+		Usage:   "Usage: kitcat merge [--ff | --no-ff | --ff-only] [--squash] [-X <option>] <branch-name> | --continue | --abort\n\nJoins another branch's history into the current branch. If the current branch has not diverged it is fast-forwarded; otherwise the changes on both sides since their merge base are combined file by file and line by line into a merge commit.\n\nOptions:\n  --no-ff          Always create a merge commit, even when a fast-forward is possible\n  --ff-only        Refuse to merge unless the branch can be fast-forwarded\n  --ff             Fast-forward when possible (the default)\n  --squash         Stage the combined result without committing or recording a merge\n  -X ours|theirs   Resolve conflicting hunks in favor of one side\n  -X patience, -X histogram, -X diff-algorithm=<algorithm>\n                   Find each side's changes with that diff algorithm\n\nDefaults come from the merge.ff config key (true, false or only), diff.algorithm and from branch.<name>.mergeoptions, e.g. \"--no-ff -X theirs\"; flags given on the command line take precedence.\n\nIf files conflict, the merge stops: conflicting hunks are written into the files between <<<<<<<, ======= and >>>>>>> markers and the paths are listed as unmerged by status. Edit them, stage them with 'kitcat add', then run 'kitcat merge --continue' to commit the merge, or 'kitcat merge --abort' to go back to where you started.",
 	},
 	"ls-files": {
 		Summary: "Show information about files in the index",
@@ -54,15 +66,15 @@ var helpMessages = map[string]CommandHelp{
 	},
 	"checkout": {
 		Summary: "Switch branches or restore working tree files",
-		Usage:   "Usage: kitcat checkout <branch> or checkout -b <new-branch>\n\nSwitches to a branch. Use -b to create a new branch and switch to it.",
+		Usage:   "Usage: kitcat checkout <branch> | <revision> | - or checkout -b <new-branch> or checkout [<revision>] -- <file>...\n\nSwitches to a branch. Any other revision detaches HEAD at that commit, and '-' returns to the previously checked out branch. Use -b to create a new branch and switch to it. With '--', the files are restored from the revision (or the last commit) instead.",
 	},
 	"show-object": {
 		Summary: "Provide content or type and size information for repository objects",
-		Usage:   "Usage: kitcat show-object <hash>\n\nShows the contents of the object identified by the hash.",
+		Usage:   "Usage: kitcat show-object [-t | -s] <object>\n\nShows the contents of the object identified by a hash or revision, e.g. HEAD^{tree}.\nFlags:\n  -t  Show the object type (blob, tree, commit or tag, or legacy for an untyped object from an older repository)\n  -s  Show the object size in bytes",
 	},
 	"branch": {
 		Summary: "List, create, or delete branches",
-		Usage:   "Usage: kitcat branch <name> or branch -m <new-name>\n\nCreates a new branch. Use -m to rename an existing branch.",
+		Usage:   "Usage: kitcat branch <name> or branch -m <new-name>\n\nCreates a new branch. Use -m to rename an existing branch.\nNames may be nested, such as feature/login, but may not contain \"..\", \"@{\", spaces or any of ~ ^ : ? * [ \\, start with \"-\" or a \".\" component, or end with \"/\", \".\" or \".lock\".",
 	},
 	"mv": {
 		Summary: "Move or rename a file, a directory, or a symlink",
@@ -70,7 +82,7 @@ var helpMessages = map[string]CommandHelp{
 	},
 	"status": {
 		Summary: "Show the working tree status",
-		Usage:   "Usage: kitcat status\n\nDisplays paths that have differences between the working tree, the index and the last commit. Shows staged, unstaged and untracked files.",
+		Usage:   "Usage: kitcat status\n\nDisplays paths that have differences between the working tree, the index and the last commit. Shows staged, unstaged and untracked files. Staged renames are shown as \"renamed: old -> new\", following diff.renames and diff.renamethreshold.",
 	},
 	"stash": {
 		Summary: "Stash the current working directory changes",
@@ -78,12 +90,44 @@ var helpMessages = map[string]CommandHelp{
 	},
 	"rebase": {
 		Summary: "Reapply commits on top of another base commit",
-		Usage:   "Usage: kitcat rebase <branch>\n\nReapplies the current branch commits on top of the specified branch, resulting in a linear commit history.",
+		Usage:   "Usage: kitcat rebase -i <commit> | --continue | --abort\n\nReapplies the current branch commits on top of the specified commit, which may be any revision such as HEAD~3 or main, resulting in a linear commit history.",
 	},
 	"grep": {
 		Summary: "Search for patterns in tracked files",
 		Usage:   "Usage: kitcat grep <pattern>\n\nSearches through tracked files in the repository and prints lines matching the given pattern.",
 	},
+	"gc": {
+		Summary: "Pack objects to save space",
+		Usage:   "Usage: kitcat gc\n\nPacks all loose objects into a single packfile, storing similar objects as deltas against each other, removes the loose copies, packs all refs into packed-refs and rewrites the commit-graph.",
+	},
+	"pack-refs": {
+		Summary: "Pack refs into a single file",
+		Usage:   "Usage: kitcat pack-refs [--all]\n\nMoves tags into .kitcat/packed-refs and deletes their individual files. With --all, branches are packed too. Packed refs work everywhere a ref does; updating one writes a new file under .kitcat/refs, which takes precedence over the packed entry.",
+	},
+	"verify-commit": {
+		Summary: "Check the signatures of commits",
+		Usage:   "Usage: kitcat verify-commit <commit>...\n\nChecks the ed25519 signature embedded in each commit and prints its status. A signature is trusted if it was made with your own user.signingkey or with a key listed in the file verify.allowedsigners names, one \"<principal> <base64 public key>\" per line. The exit status is 1 unless every commit has a good, trusted signature.",
+	},
+	"verify-tag": {
+		Summary: "Check the signatures of tags",
+		Usage:   "Usage: kitcat verify-tag <tag>...\n\nChecks the signature of each annotated tag made with 'tag -s', trusting keys the same way as verify-commit. The exit status is 1 unless every tag has a good, trusted signature.",
+	},
+	"commit-graph": {
+		Summary: "Write or verify the commit-graph",
+		Usage:   "Usage: kitcat commit-graph <write|verify>\n\nThe commit-graph stores every commit's parents and generation number so that log, merge and rebase can walk history without reading commit objects. gc rewrites it; commits made since are read from their objects until the next write.",
+	},
+	"prune": {
+		Summary: "Delete unreachable objects",
+		Usage:   "Usage: kitcat prune [-n | --dry-run] [--expire <time>]\n\nDeletes objects that cannot be reached from any branch, tag, HEAD, stash entry, in-progress rebase, reflog or the index, and drops the removed commits from the commit journal.\nOnly objects older than --expire are removed (default 2w). <time> is \"now\", \"never\", a number of days or weeks such as \"14d\" or \"2w\", or a duration such as \"36h\".\n-n, --dry-run   list what would be removed without deleting anything",
+	},
+	"reflog": {
+		Summary: "Show or expire the history of ref updates",
+		Usage:   "Usage: kitcat reflog [show [<ref>]] | reflog expire [--expire=<time>] [--all | <ref>...]\n\nEvery commit, checkout, reset, merge, rebase and stash that moves HEAD, a branch or the stash is logged with the old and new commit, who did it, when and why.\nshow (the default) lists a ref's entries newest first as <ref>@{n}, HEAD if no ref is given. Use <ref>@{n} anywhere a revision is expected, e.g. 'kitcat reset --hard HEAD@{1}'.\nexpire drops entries older than --expire (default 90d) from the named reflogs, or from all of them. <time> takes the same forms as for prune.",
+	},
+	"fsck": {
+		Summary: "Verify the integrity of the repository",
+		Usage:   "Usage: kitcat fsck\n\nRehashes every object and checks that trees, commits, refs, stash entries and rebase state only point at objects that exist.\nEach problem is printed on its own line as \"<kind> <subject> <detail>\", and the exit status is 1 if any were found.\nLeftover temporary files are listed as \"stray-file <path>\" but do not fail the check.",
+	},
 	"shortlog": {
 		Summary: "Summarize commit history by author",
 		Usage:   "Usage: kitcat shortlog\n\nDisplays a condensed summary of commit history, grouped by author, showing commit counts and messages.",
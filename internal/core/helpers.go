@@ -17,35 +17,88 @@ func UpdateWorkspaceAndIndex(commitHash string) error {
 	if err != nil {
 		return err
 	}
-	targetTree, err := storage.ParseTree(commit.TreeHash)
+	targetTree, err := storage.ParseTreeEntries(commit.TreeHash)
 	if err != nil {
 		return err
 	}
 
-	// Delete files from the current index that are not in the target tree
+	// Delete files from the current index that are not in the target tree,
+	// including those left behind by a conflicted merge
 	currentIndex, _ := storage.LoadIndex()
 	for path := range currentIndex {
 		if _, existsInTarget := targetTree[path]; !existsInTarget {
 			os.Remove(path)
 		}
 	}
+	unmerged, _ := storage.LoadUnmergedEntries()
+	for path := range unmerged {
+		if _, existsInTarget := targetTree[path]; !existsInTarget {
+			os.Remove(path)
+		}
+	}
 
 	// Write/update files from the target tree
-	for path, hash := range targetTree {
-		content, err := storage.ReadObject(hash)
+	for path, entry := range targetTree {
+		_, content, err := storage.ReadObject(entry.Hash)
 		if err != nil {
 			return err
 		}
-		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
-			return err
-		}
-		if err := SafeWrite(path, content, 0o644); err != nil {
+		if err := writeWorkingFile(path, content, entry.Mode); err != nil {
 			return err
 		}
 	}
 
 	// Update the index to match the new tree
-	return storage.WriteIndex(targetTree)
+	recordStat(targetTree)
+	return storage.WriteIndexEntries(targetTree)
+}
+
+// recordStat fills in the cached stat data of entries whose files were just written
+// to match them, so the next status does not need to read them back
+func recordStat(entries map[string]storage.IndexEntry) {
+	for path, entry := range entries {
+		if info, err := os.Lstat(path); err == nil {
+			entry.SetStat(info)
+			entries[path] = entry
+		}
+	}
+}
+
+// trackedFileMatches reports whether a tracked file still has the content of its index entry.
+// Matching stat data answers without reading the file; otherwise the file is hashed, and if
+// it turns out unchanged its fresh stat data is added to refresh for the caller to save.
+func trackedFileMatches(path string, info os.FileInfo, entry storage.IndexEntry, refresh map[string]storage.IndexEntry) (bool, error) {
+	if entry.StatMatches(info) {
+		return true, nil
+	}
+	matches, err := storage.FileMatchesHash(path, entry.Hash)
+	if err != nil || !matches {
+		return false, err
+	}
+	if storage.FileModeOf(info, entry.Mode) == entry.Mode {
+		entry.SetStat(info)
+		refresh[path] = entry
+	}
+	return true, nil
+}
+
+// writeWorkingFile puts a blob into the working directory with the given mode.
+// Symlinks are recreated from the link target stored in the blob; where they
+// cannot be created, the target is written out as a plain file instead.
+func writeWorkingFile(path string, content []byte, mode uint32) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	if mode == storage.ModeSymlink {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err := os.Symlink(string(content), path); err == nil {
+			return nil
+		}
+	}
+	// SafeWrite renames over the old path, so an existing symlink is replaced, not followed
+	return SafeWrite(path, content, storage.Perm(mode))
 }
 
 // GetHeadState returns the current branch name or detached HEAD state.
@@ -78,21 +131,21 @@ func GetHeadState() (string, error) {
 // Returns true if there are any staged or unstaged changes, false if the working tree is clean.
 func IsWorkDirDirty() (bool, error) {
 	// Load the tree from the last commit (HEAD)
-	headTree := make(map[string]string)
+	headTree := make(map[string]storage.IndexEntry)
 	lastCommit, err := GetHeadCommit() // Use GetHeadCommit, not storage.GetLastCommit
 	if err == nil {
-		tree, parseErr := storage.ParseTree(lastCommit.TreeHash)
+		tree, parseErr := storage.ParseTreeEntries(lastCommit.TreeHash)
 		if parseErr != nil {
 			return false, parseErr
 		}
 		headTree = tree
-	} else if !os.IsNotExist(err) && !strings.Contains(err.Error(), "no such file") && !strings.Contains(err.Error(), "cannot find the file") {
+	} else if err != storage.ErrNoCommits && !os.IsNotExist(err) && !strings.Contains(err.Error(), "no such file") && !strings.Contains(err.Error(), "cannot find the file") {
 		// If the error is NOT "file not found" (meaning no branch tip yet), return it.
 		return false, err
 	}
 
 	// Load the current staging area
-	index, err := storage.LoadIndex()
+	entries, err := storage.LoadIndexEntries()
 	if err != nil {
 		return false, err
 	}
@@ -102,20 +155,24 @@ func IsWorkDirDirty() (bool, error) {
 	for path := range headTree {
 		allPaths[path] = true
 	}
-	for path := range index {
+	for path := range entries {
 		allPaths[path] = true
 	}
 
 	for path := range allPaths {
-		headHash, inHead := headTree[path]
-		indexHash, inIndex := index[path]
+		headEntry, inHead := headTree[path]
+		indexEntry, inIndex := entries[path]
 
 		// If there's any difference between HEAD and index, working dir is dirty
 		if (inIndex && !inHead) || (!inIndex && inHead) ||
-			(inIndex && inHead && headHash != indexHash) {
+			(inIndex && inHead && !headEntry.SameFile(indexEntry)) {
 			return true, nil
 		}
 	}
+	index := make(map[string]string, len(entries))
+	for path, entry := range entries {
+		index[path] = entry.Hash
+	}
 
 	// Load ignore patterns
 	ignorePatterns, err := LoadIgnorePatterns()
@@ -124,6 +181,7 @@ func IsWorkDirDirty() (bool, error) {
 	}
 
 	// Check for unstaged changes (Working Directory vs. Index)
+	refresh := make(map[string]storage.IndexEntry)
 	err = filepath.Walk(".", func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
@@ -136,7 +194,7 @@ func IsWorkDirDirty() (bool, error) {
 			return nil
 		}
 
-		indexHash, isTracked := index[cleanPath]
+		indexEntry, isTracked := entries[cleanPath]
 
 		// If the file is not in the index, check if it should be ignored
 		if !isTracked {
@@ -147,16 +205,23 @@ func IsWorkDirDirty() (bool, error) {
 			return fmt.Errorf("untracked") // Use error to signal dirty state
 		}
 
-		// If the file is tracked, hash it and compare with the index
-		currentHash, hashErr := storage.HashFile(cleanPath)
+		// If the file is tracked, compare its mode and content with the index
+		if storage.FileModeOf(info, indexEntry.Mode) != indexEntry.Mode {
+			return fmt.Errorf("modified")
+		}
+		matches, hashErr := trackedFileMatches(cleanPath, info, indexEntry, refresh)
 		if hashErr != nil {
 			return hashErr
 		}
-		if currentHash != indexHash {
+		if !matches {
 			return fmt.Errorf("modified") // Use error to signal dirty state
 		}
 		return nil
 	})
+	if len(refresh) > 0 {
+		// Best effort: a failed refresh only means hashing again next time
+		_ = storage.RefreshIndexStat(refresh)
+	}
 	// If we got an "untracked" or "modified" error, the working dir is dirty
 	if err != nil {
 		if err.Error() == "untracked" || err.Error() == "modified" {
@@ -170,7 +235,8 @@ func IsWorkDirDirty() (bool, error) {
 
 // UpdateBranchPointer updates the current branch pointer or HEAD to point to a specific commit.
 // Handles both branch mode (updates refs/heads/<branch>) and detached HEAD mode (updates HEAD directly).
-func UpdateBranchPointer(commitHash string) error {
+// The reason is recorded in the reflog.
+func UpdateBranchPointer(commitHash, reason string) error {
 	headData, err := os.ReadFile(HeadPath)
 	if err != nil {
 		return fmt.Errorf("unable to read HEAD file: %w", err)
@@ -180,23 +246,22 @@ func UpdateBranchPointer(commitHash string) error {
 	// Case A: HEAD points to a branch (ref: refs/heads/<branch>)
 	if strings.HasPrefix(ref, "ref: ") {
 		refPath := strings.TrimPrefix(ref, "ref: ")
-		branchFile := filepath.Join(".kitcat", refPath)
 
-		// Verify branch file exists
-		if _, err := os.Stat(branchFile); err != nil {
+		// Verify the branch exists
+		if !refExists(refPath) {
 			branchName := strings.TrimPrefix(refPath, "refs/heads/")
 			return fmt.Errorf("current branch %s not found", branchName)
 		}
 
 		// Update the branch pointer
-		if err := SafeWrite(branchFile, []byte(commitHash), 0o644); err != nil {
+		if err := updateRef(refPath, commitHash, reason); err != nil {
 			return fmt.Errorf("failed to update branch pointer: %w", err)
 		}
 		return nil
 	}
 
 	// Case B: Detached HEAD (HEAD contains a commit hash directly)
-	if err := SafeWrite(HeadPath, []byte(commitHash), 0o644); err != nil {
+	if err := updateRef("HEAD", commitHash, reason); err != nil {
 		return fmt.Errorf("failed to update HEAD: %w", err)
 	}
 	return nil
@@ -205,21 +270,26 @@ func UpdateBranchPointer(commitHash string) error {
 // readHead returns the commit hash that HEAD currently points to.
 // This is useful for rollback operations.
 func readHead() (string, error) {
+	if err := storage.MigrateLegacyCommitLog(); err != nil {
+		return "", err
+	}
 	headData, err := os.ReadFile(HeadPath)
 	if err != nil {
 		return "", err
 	}
 	ref := strings.TrimSpace(string(headData))
 
-	// If HEAD points to a branch, read the branch file
+	// If HEAD points to a branch, read the branch, loose or packed
 	if strings.HasPrefix(ref, "ref: ") {
 		refPath := strings.TrimPrefix(ref, "ref: ")
-		branchFile := filepath.Join(".kitcat", refPath)
-		commitHash, err := os.ReadFile(branchFile)
+		commitHash, ok, err := lookupRef(refPath)
 		if err != nil {
 			return "", err
 		}
-		return strings.TrimSpace(string(commitHash)), nil
+		if !ok {
+			return "", fmt.Errorf("%s: %w", refPath, os.ErrNotExist)
+		}
+		return commitHash, nil
 	}
 
 	// Detached HEAD - ref is the commit hash
@@ -259,6 +329,19 @@ func GetHeadCommit() (models.Commit, error) {
 	return storage.FindCommit(commitHash)
 }
 
+// OpenRepository prepares the repository in the current directory for use, upgrading a
+// commit log written by older versions before anything reads the refs, HEAD or stash
+// that name its commits. Outside a repository it does nothing.
+func OpenRepository() error {
+	if !isPathExist(RepoDir) {
+		return nil
+	}
+	if err := storage.MigrateLegacyCommitLog(); err != nil {
+		return fmt.Errorf("failed to migrate legacy commit log: %w", err)
+	}
+	return nil
+}
+
 // IsRepoInitialized checks if the current directory or any parent is a valid kitcat repository.
 // If found, it changes the current working directory to the repository root.
 func IsRepoInitialized() bool {
@@ -1,64 +1,45 @@
 package core
 
 import (
-	"encoding/json"
 	"fmt"
-	"os"
+	"sort"
+
+	"github.com/LeeFred3042U/kitcat/internal/storage"
 )
 
 // IndexEntry represents a file in the staging area
 type IndexEntry struct {
 	Path string
 	Hash string
+	Mode uint32
 }
 
-// LoadIndex reads the .kitcat/index file
+// LoadIndex reads the .kitcat/index file, sorted by path
 func LoadIndex() ([]IndexEntry, error) {
-	data, err := os.ReadFile(IndexPath)
-	if os.IsNotExist(err) {
-		return []IndexEntry{}, nil
-	}
-	if err != nil {
-		return nil, err
-	}
-
-	if len(data) == 0 {
-		return []IndexEntry{}, nil
-	}
-
-	var entryMap map[string]string
-	err = json.Unmarshal(data, &entryMap)
+	entryMap, err := storage.LoadIndexEntries()
 	if err != nil {
 		return nil, fmt.Errorf("index file corrupted")
 	}
 
-	var entries []IndexEntry
-	for key, value := range entryMap {
-		entries = append(entries, IndexEntry{Path: key, Hash: value})
+	entries := []IndexEntry{}
+	for path, entry := range entryMap {
+		entries = append(entries, IndexEntry{Path: path, Hash: entry.Hash, Mode: entry.Mode})
 	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
 	return entries, nil
 }
 
 // SaveIndex writes the index back to disk
 func SaveIndex(entries []IndexEntry) error {
-	file, err := os.Create(IndexPath)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
-
-	entryMap := make(map[string]string)
+	entryMap := make(map[string]storage.IndexEntry)
 	for _, entry := range entries {
-		entryMap[entry.Path] = entry.Hash
+		mode := entry.Mode
+		if mode == 0 {
+			mode = storage.ModeRegular
+		}
+		entryMap[entry.Path] = storage.IndexEntry{Hash: entry.Hash, Mode: mode}
 	}
-
-	data, err := json.Marshal(entryMap)
-	if err != nil {
-		return err
-	}
-
-	_, err = file.Write(data)
-	if err != nil {
+	if err := storage.WriteIndexEntries(entryMap); err != nil {
 		return fmt.Errorf("unable to write to index")
 	}
 	return nil
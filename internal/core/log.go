@@ -3,55 +3,148 @@ package core
 import (
 	"fmt"
 	"sort"
+	"strings"
 
 	"github.com/LeeFred3042U/kitcat/internal/models"
 	"github.com/LeeFred3042U/kitcat/internal/storage"
 )
 
-// ShowLog prints the commit log. It accepts a boolean for oneline format
-// and an optional limit to restrict the number of commits shown (use -1 or 0 for no limit)
-func ShowLog(oneline bool, limit int) error {
-	// 1Start from HEAD (Architecture from reset-hard branch)
-	// We must walk backwards from HEAD, otherwise 'reset' changes won't be reflected
-	currentCommit, err := GetHeadCommit()
-	if err != nil {
-		// Handle the case where the repo is empty or HEAD is invalid
-		return nil
-	}
-
-	commitHash := currentCommit.ID
-	count := 0
+// LogOptions controls what ShowLog prints for each commit
+type LogOptions struct {
+	Oneline       bool // one line per commit: short hash and message
+	Limit         int  // the most commits to show; -1 or 0 for no limit
+	ShowSignature bool // check and describe each commit's signature
+	NameStatus    bool // list the files each commit changed, with renames paired by Renames
+	Renames       RenameOptions
+}
 
-	// Walk the graph (Architecture from reset-hard branch)
-	for commitHash != "" {
-		// Apply the Limit Check (Feature from main branch)
-		if limit > 0 && count >= limit {
-			break
+// ShowLog prints the commit log. revisions are revisions or ranges such as
+// "main..feature" to list; by default the history of HEAD is shown.
+func ShowLog(opts LogOptions, revisions []string) error {
+	var include, exclude []string
+	for _, rev := range revisions {
+		r, err := ParseRevisionRange(rev)
+		if err != nil {
+			return err
+		}
+		include = append(include, r.Include...)
+		exclude = append(exclude, r.Exclude...)
+	}
+	if len(include) == 0 {
+		// We must walk backwards from HEAD, otherwise 'reset' changes won't be reflected
+		currentCommit, err := GetHeadCommit()
+		if err != nil {
+			// Handle the case where the repo is empty or HEAD is invalid
+			return nil
 		}
+		include = []string{currentCommit.ID}
+	}
+
+	// The commit-graph gives the order without reading every commit object
+	ids, err := storage.RevList(include, exclude, opts.Limit)
+	if err != nil {
+		return err
+	}
 
-		commit, err := storage.FindCommit(commitHash)
+	for _, id := range ids {
+		commit, err := storage.FindCommit(id)
 		if err != nil {
 			return err
 		}
 
 		// Print Logic
-		if oneline {
+		if opts.Oneline {
+			if opts.ShowSignature {
+				printSignatureStatus(commit)
+			}
 			fmt.Printf("%s %s\n", commit.ID[:7], commit.Message)
+			if opts.NameStatus {
+				if err := printNameStatus(commit, opts.Renames); err != nil {
+					return err
+				}
+			}
 		} else {
 			fmt.Printf("commit %s\n", commit.ID)
+			if opts.ShowSignature {
+				printSignatureStatus(commit)
+			}
+			if commit.IsMerge() {
+				fmt.Printf("Merge:%s\n", abbreviateAll(commit.Parents))
+			}
 			fmt.Printf("Author: %s <%s>\n", commit.AuthorName, commit.AuthorEmail)
 			fmt.Printf("Date:   %s\n", commit.Timestamp.Local().Format("Mon Jan 02 15:04:05 2006 -0700"))
 			fmt.Printf("\n    %s\n\n", commit.Message)
+			if opts.NameStatus && !commit.IsMerge() {
+				if err := printNameStatus(commit, opts.Renames); err != nil {
+					return err
+				}
+				fmt.Println()
+			}
 		}
-
-		// Move to parent pointer
-		commitHash = commit.Parent
-		count++
 	}
 
 	return nil
 }
 
+// printNameStatus lists the files c changed from its first parent, one per line, as
+// "M\tpath", or "R087\told\tnew" for a rename with its similarity. Merges list nothing.
+func printNameStatus(c models.Commit, renames RenameOptions) error {
+	if c.IsMerge() {
+		return nil
+	}
+	var parentTree string
+	if first := c.FirstParent(); first != "" {
+		parent, err := storage.FindCommit(first)
+		if err != nil {
+			return err
+		}
+		parentTree = parent.TreeHash
+	}
+	changes, err := treeChanges(parentTree, c.TreeHash, renames)
+	if err != nil {
+		return err
+	}
+	for _, change := range changes {
+		fmt.Println(nameStatusLine(change))
+	}
+	return nil
+}
+
+// nameStatusLine formats a change as --name-status does
+func nameStatusLine(c fileChange) string {
+	switch c.Status {
+	case 'R', 'C':
+		return fmt.Sprintf("%c%03d\t%s\t%s", c.Status, c.Score, c.OldPath, c.Path)
+	case 'D':
+		return fmt.Sprintf("D\t%s", c.OldPath)
+	}
+	return fmt.Sprintf("%c\t%s", c.Status, c.Path)
+}
+
+// printSignatureStatus prints what verifying c's signature found, for log --show-signature.
+// Unsigned commits print nothing.
+func printSignatureStatus(c models.Commit) {
+	if c.Signature == "" {
+		return
+	}
+	status, err := verifySignature(c.Signature, storage.CommitSigningPayload(c))
+	if err != nil {
+		fmt.Printf("Cannot verify signature: %v\n", err)
+		return
+	}
+	fmt.Println(status)
+}
+
+// abbreviateAll renders hashes as a space-prefixed list of short hashes
+func abbreviateAll(hashes []string) string {
+	var sb strings.Builder
+	for _, h := range hashes {
+		sb.WriteString(" ")
+		sb.WriteString(h[:min(7, len(h))])
+	}
+	return sb.String()
+}
+
 // ShowShortLog prints commit messages grouped by author,
 // sorted by commit counts of each author.
 func ShowShortLog() error {
@@ -4,19 +4,34 @@ import (
 	"errors"
 	"fmt"
 	"os"
-	"path/filepath"
 	"strings"
 
+	"github.com/LeeFred3042U/kitcat/internal/models"
 	"github.com/LeeFred3042U/kitcat/internal/storage"
 )
 
-// Merge attempts to merge the given branch into the current branch
-// Currently only supports strict fast-forward merges
+// Merge attempts to merge the given branch into the current branch using the
+// configured defaults. It fast-forwards when it can and otherwise records a merge commit.
 func Merge(branchToMerge string) error {
+	opts, err := DefaultMergeOptions()
+	if err != nil {
+		return err
+	}
+	return MergeWithOptions(branchToMerge, opts)
+}
+
+// MergeWithOptions merges the given branch into the current branch as opts direct
+func MergeWithOptions(branchToMerge string, opts MergeOptions) error {
+	if err := opts.validate(); err != nil {
+		return err
+	}
 	// Guard: ensure we're inside a kitcat repo
 	if _, err := os.Stat(RepoDir); os.IsNotExist(err) {
 		return errors.New("not a kitcat repository (run `kitcat init`)")
 	}
+	if IsMergeInProgress() {
+		return errors.New("you have not concluded your merge (MERGE_HEAD exists); run 'kitcat merge --continue' or 'kitcat merge --abort'")
+	}
 
 	// Safety Check: Verify working directory is clean
 	dirty, err := IsWorkDirDirty()
@@ -30,12 +45,10 @@ func Merge(branchToMerge string) error {
 	}
 
 	// Getting the commit hash of the branch to merge
-	branchPath := filepath.Join(HeadsDir, branchToMerge)
-	featureHeadHashBytes, err := os.ReadFile(branchPath)
-	if err != nil {
+	featureHeadHash, ok, err := lookupRef("refs/heads/" + branchToMerge)
+	if err != nil || !ok || !IsValidRefName(branchToMerge) {
 		return fmt.Errorf("branch '%s' not found", branchToMerge)
 	}
-	featureHeadHash := strings.TrimSpace(string(featureHeadHashBytes))
 
 	// Getting the commit hash of the current branch (HEAD)
 	currentHeadHash, err := readHead()
@@ -50,28 +63,33 @@ func Merge(branchToMerge string) error {
 	}
 
 	// Merge Type Determination
-	switch mergeBase {
-	case currentHeadHash:
+	switch {
+	case mergeBase == featureHeadHash:
+		// already up to date
+		fmt.Println("Already up to date.")
+		return nil
+
+	case mergeBase == currentHeadHash && opts.FastForward != FastForwardNever:
 		// fast-forward
 		fmt.Printf("Updating %s..%s\n", currentHeadHash[:7], featureHeadHash[:7])
 		fmt.Println("Fast-forward")
+		if opts.Squash {
+			if err := UpdateWorkspaceAndIndex(featureHeadHash); err != nil {
+				return fmt.Errorf("failed to update workspace: %w", err)
+			}
+			fmt.Println("Squash commit -- not updating HEAD")
+			return nil
+		}
 
-	case featureHeadHash:
-		// already up to date
-		fmt.Println("Already up to date.")
-		return nil
+	case opts.FastForward == FastForwardOnly:
+		return errors.New("not possible to fast-forward, aborting")
 
 	default:
-		// diverged
-		return fmt.Errorf(
-			"fatal: Not possible to fast-forward, aborting.\n"+
-				"Merge commits are not supported. Please rebase '%s' onto the current branch",
-			branchToMerge,
-		)
+		return threeWayMerge(branchToMerge, mergeBase, currentHeadHash, featureHeadHash, opts)
 	}
 
 	// Fast-Forward Execution
-	if err := UpdateBranchPointer(featureHeadHash); err != nil {
+	if err := UpdateBranchPointer(featureHeadHash, "merge "+branchToMerge+": Fast-forward"); err != nil {
 		return fmt.Errorf("failed to update branch pointer: %w", err)
 	}
 
@@ -80,7 +98,7 @@ func Merge(branchToMerge string) error {
 	if err != nil {
 		// Attempt to roll back the branch pointer on failure
 		fmt.Printf("UpdateWorkspaceAndIndex failed: %v. Rolling back branch pointer...\n", err)
-		if rollbackErr := UpdateBranchPointer(currentHeadHash); rollbackErr != nil {
+		if rollbackErr := UpdateBranchPointer(currentHeadHash, "merge "+branchToMerge+": rolling back"); rollbackErr != nil {
 			return fmt.Errorf(
 				"failed to update workspace: %w; additionally failed to rollback branch pointer: %v",
 				err,
@@ -96,3 +114,113 @@ func Merge(branchToMerge string) error {
 
 	return nil
 }
+
+// threeWayMerge merges the diverged histories of HEAD and the given branch into a new
+// commit whose parents are both heads. If any path conflicts, the clean part of the
+// result is staged, conflicted files are written with conflict markers and the merge
+// is left in progress for merge --continue or merge --abort. A squash merge stops
+// before committing and leaves no merge in progress.
+func threeWayMerge(branchName, baseHash, oursHash, theirsHash string, opts MergeOptions) error {
+	base, err := storage.FindCommit(baseHash)
+	if err != nil {
+		return err
+	}
+	ours, err := storage.FindCommit(oursHash)
+	if err != nil {
+		return err
+	}
+	theirs, err := storage.FindCommit(theirsHash)
+	if err != nil {
+		return err
+	}
+
+	merged, conflicts, err := mergeTrees(base.TreeHash, ours.TreeHash, theirs.TreeHash, opts.Favor, opts.Algorithm)
+	if err != nil {
+		return fmt.Errorf("merge failed: %w", err)
+	}
+	if opts.Squash {
+		if err := checkoutMerge(merged, conflicts, "HEAD", branchName, opts.Algorithm); err != nil {
+			return fmt.Errorf("failed to write merge result: %w", err)
+		}
+		fmt.Println("Squash commit -- not updating HEAD")
+		if len(conflicts) > 0 {
+			printConflicts(conflicts)
+			return errors.New("automatic merge failed; fix conflicts, stage them with 'kitcat add' and commit the result")
+		}
+		fmt.Println("Automatic merge went well; stopped before committing as requested")
+		return nil
+	}
+
+	message := mergeMessage(branchName)
+	if len(conflicts) > 0 {
+		if err := checkoutMerge(merged, conflicts, "HEAD", branchName, opts.Algorithm); err != nil {
+			return fmt.Errorf("failed to write merge result: %w", err)
+		}
+		if err := saveMergeState(theirsHash, oursHash, message); err != nil {
+			return fmt.Errorf("failed to record merge state: %w", err)
+		}
+		printConflicts(conflicts)
+		return errors.New("automatic merge failed; fix conflicts, stage them with 'kitcat add' and run 'kitcat merge --continue'")
+	}
+
+	treeHash, err := storage.CreateTreeFromEntries(merged)
+	if err != nil {
+		return err
+	}
+	reason := "merge " + branchName + ": Merge made by the 'three-way' strategy."
+	sign, err := signByDefault()
+	if err != nil {
+		return err
+	}
+	commit, err := createCommit(treeHash, message, []string{oursHash, theirsHash}, reason, sign)
+	if err != nil {
+		return err
+	}
+	if err := UpdateWorkspaceAndIndex(commit.ID); err != nil {
+		fmt.Printf("UpdateWorkspaceAndIndex failed: %v. Rolling back branch pointer...\n", err)
+		if rollbackErr := UpdateBranchPointer(oursHash, "merge "+branchName+": rolling back"); rollbackErr != nil {
+			return fmt.Errorf("failed to update workspace: %w; additionally failed to rollback branch pointer: %v", err, rollbackErr)
+		}
+		return fmt.Errorf("failed to update workspace: %w; branch pointer rolled back to %s", err, oursHash)
+	}
+
+	fmt.Println("Merge made by the 'three-way' strategy.")
+	fmt.Println(" " + summarizeCommit(commit))
+	return nil
+}
+
+// mergeMessage is the default message of a merge commit, naming the current branch
+// unless it is the main one
+func mergeMessage(branchName string) string {
+	msg := fmt.Sprintf("Merge branch '%s'", branchName)
+	if current, err := GetHeadState(); err == nil && current != "main" && current != "master" && !strings.HasPrefix(current, "HEAD") {
+		msg += " into " + current
+	}
+	return msg
+}
+
+// MergeContinue concludes a conflicted merge once every conflict has been resolved and
+// staged, committing the index with both heads as parents
+func MergeContinue() (models.Commit, string, error) {
+	_, message, err := loadMergeState()
+	if err != nil {
+		return models.Commit{}, "", err
+	}
+	return Commit(message)
+}
+
+// MergeAbort abandons a conflicted merge, restoring the working directory and index to
+// the commit HEAD pointed to before it started
+func MergeAbort() error {
+	if !IsMergeInProgress() {
+		return errors.New("no merge in progress")
+	}
+	origHead, err := os.ReadFile(OrigHeadPath)
+	if err != nil {
+		return fmt.Errorf("could not read ORIG_HEAD: %w", err)
+	}
+	if err := UpdateWorkspaceAndIndex(strings.TrimSpace(string(origHead))); err != nil {
+		return err
+	}
+	return clearMergeState()
+}
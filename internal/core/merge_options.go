@@ -0,0 +1,151 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/LeeFred3042U/kitcat/internal/diff"
+)
+
+// Fast-forward modes for MergeOptions.FastForward
+const (
+	FastForwardAllowed = "ff"
+	FastForwardNever   = "no-ff"
+	FastForwardOnly    = "ff-only"
+)
+
+// Sides a merge can favor with -X
+const (
+	FavorOurs   = "ours"
+	FavorTheirs = "theirs"
+)
+
+// MergeOptions controls how Merge joins two histories
+type MergeOptions struct {
+	FastForward string         // one of the FastForward modes; empty means FastForwardAllowed
+	Squash      bool           // stage the result without committing or recording a merge
+	Favor       string         // FavorOurs or FavorTheirs settles conflicting hunks automatically
+	Algorithm   diff.Algorithm // how each side's changes to a file are found
+}
+
+// DefaultMergeOptions returns the options merges into the current branch start from.
+// merge.ff ("true", "false" or "only") sets the fast-forward mode, diff.algorithm the
+// line matching, and branch.<name>.mergeoptions holds flags, such as "--no-ff -X theirs",
+// applied on top.
+func DefaultMergeOptions() (MergeOptions, error) {
+	var opts MergeOptions
+	alg, err := configuredDiffAlgorithm()
+	if err != nil {
+		return opts, err
+	}
+	opts.Algorithm = alg
+	if ff, found, err := GetConfig("merge.ff"); err != nil {
+		return opts, err
+	} else if found {
+		switch ff {
+		case "true":
+			opts.FastForward = FastForwardAllowed
+		case "false":
+			opts.FastForward = FastForwardNever
+		case "only":
+			opts.FastForward = FastForwardOnly
+		default:
+			return opts, fmt.Errorf("invalid merge.ff value %q (expected true, false or only)", ff)
+		}
+	}
+
+	branch, err := GetHeadState()
+	if err != nil || strings.HasPrefix(branch, "HEAD") {
+		return opts, nil
+	}
+	key := "branch." + branch + ".mergeoptions"
+	flags, found, err := GetConfig(key)
+	if err != nil || !found {
+		return opts, err
+	}
+	rest, err := opts.ParseFlags(strings.Fields(flags))
+	if err != nil {
+		return opts, fmt.Errorf("%s: %w", key, err)
+	}
+	if len(rest) > 0 {
+		return opts, fmt.Errorf("%s: unexpected argument %q", key, rest[0])
+	}
+	return opts, nil
+}
+
+// ParseFlags applies merge flags to the options in order, so later flags override
+// earlier ones and the defaults, and returns the arguments that are not flags
+func (o *MergeOptions) ParseFlags(args []string) ([]string, error) {
+	var rest []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--ff":
+			o.FastForward = FastForwardAllowed
+		case arg == "--no-ff":
+			o.FastForward = FastForwardNever
+		case arg == "--ff-only":
+			o.FastForward = FastForwardOnly
+		case arg == "--squash":
+			o.Squash = true
+		case arg == "--no-squash":
+			o.Squash = false
+		case arg == "-X" || arg == "--strategy-option":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("%s requires a value", arg)
+			}
+			i++
+			if err := o.setStrategyOption(args[i]); err != nil {
+				return nil, err
+			}
+		case strings.HasPrefix(arg, "-X"):
+			if err := o.setStrategyOption(strings.TrimPrefix(arg, "-X")); err != nil {
+				return nil, err
+			}
+		case strings.HasPrefix(arg, "--strategy-option="):
+			if err := o.setStrategyOption(strings.TrimPrefix(arg, "--strategy-option=")); err != nil {
+				return nil, err
+			}
+		case strings.HasPrefix(arg, "-") && arg != "-":
+			return nil, fmt.Errorf("unknown merge option %s", arg)
+		default:
+			rest = append(rest, arg)
+		}
+	}
+	return rest, nil
+}
+
+// setStrategyOption applies one -X option: a side to favor, or the diff algorithm as
+// "patience", "histogram" or "diff-algorithm=<name>"
+func (o *MergeOptions) setStrategyOption(value string) error {
+	switch {
+	case value == FavorOurs || value == FavorTheirs:
+		o.Favor = value
+	case value == "patience" || value == "histogram":
+		o.Algorithm = diff.Algorithm(value)
+	case strings.HasPrefix(value, "diff-algorithm="):
+		alg, err := diff.ParseAlgorithm(strings.TrimPrefix(value, "diff-algorithm="))
+		if err != nil {
+			return err
+		}
+		o.Algorithm = alg
+	default:
+		return fmt.Errorf("unknown strategy option %q (expected ours, theirs, patience, histogram or diff-algorithm=<algorithm>)", value)
+	}
+	return nil
+}
+
+func (o MergeOptions) validate() error {
+	switch o.FastForward {
+	case "", FastForwardAllowed, FastForwardNever, FastForwardOnly:
+	default:
+		return fmt.Errorf("unknown fast-forward mode %q", o.FastForward)
+	}
+	if o.Squash && o.FastForward == FastForwardNever {
+		return fmt.Errorf("--squash and --no-ff cannot be combined")
+	}
+	if o.Favor != "" && o.Favor != FavorOurs && o.Favor != FavorTheirs {
+		return fmt.Errorf("unknown strategy option %q (expected ours or theirs)", o.Favor)
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// IsMergeInProgress reports whether a conflicted merge is waiting to be concluded
+func IsMergeInProgress() bool {
+	_, err := os.Stat(MergeHeadPath)
+	return err == nil
+}
+
+// saveMergeState records a merge that stopped on conflicts, so it can be continued or aborted
+func saveMergeState(mergeHead, origHead, message string) error {
+	if err := SafeWrite(OrigHeadPath, []byte(origHead+"\n"), 0o644); err != nil {
+		return err
+	}
+	if err := SafeWrite(MergeMsgPath, []byte(message+"\n"), 0o644); err != nil {
+		return err
+	}
+	return SafeWrite(MergeHeadPath, []byte(mergeHead+"\n"), 0o644)
+}
+
+// loadMergeState returns the commit being merged and the prepared merge message
+func loadMergeState() (string, string, error) {
+	head, err := os.ReadFile(MergeHeadPath)
+	if os.IsNotExist(err) {
+		return "", "", fmt.Errorf("no merge in progress")
+	}
+	if err != nil {
+		return "", "", err
+	}
+	msg, err := os.ReadFile(MergeMsgPath)
+	if err != nil && !os.IsNotExist(err) {
+		return "", "", err
+	}
+	return strings.TrimSpace(string(head)), strings.TrimSpace(string(msg)), nil
+}
+
+// clearMergeState removes the merge state files. ORIG_HEAD is kept, as git does.
+func clearMergeState() error {
+	for _, path := range []string{MergeHeadPath, MergeMsgPath} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,266 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/LeeFred3042U/kitcat/internal/diff"
+	"github.com/LeeFred3042U/kitcat/internal/storage"
+)
+
+// divergeBranches commits base on main, then theirs on a "feature" branch and ours on
+// main, leaving main checked out
+func divergeBranches(t *testing.T, base, ours, theirs map[string]string) {
+	t.Helper()
+	commitFiles(t, "base", base)
+	if err := CreateBranch("feature"); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckoutBranch("feature"); err != nil {
+		t.Fatal(err)
+	}
+	commitFiles(t, "theirs", theirs)
+	if err := CheckoutBranch("main"); err != nil {
+		t.Fatal(err)
+	}
+	commitFiles(t, "ours", ours)
+}
+
+func TestMerge_ThreeWayCreatesMergeCommit(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	divergeBranches(t,
+		map[string]string{"f.txt": "a\nb\nc\nd\ne\n"},
+		map[string]string{"f.txt": "A\nb\nc\nd\ne\n"},
+		map[string]string{"f.txt": "a\nb\nc\nd\nE\n", "new.txt": "new\n"},
+	)
+	oursHead, _ := readHead()
+	theirsHead, _ := os.ReadFile(filepath.Join(HeadsDir, "feature"))
+
+	if err := Merge("feature"); err != nil {
+		t.Fatalf("Merge failed: %v", err)
+	}
+
+	head, err := GetHeadCommit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(head.Parents) != 2 || head.Parents[0] != oursHead || head.Parents[1] != string(theirsHead) {
+		t.Errorf("merge commit parents = %v", head.Parents)
+	}
+	content, _ := os.ReadFile("f.txt")
+	if string(content) != "A\nb\nc\nd\nE\n" {
+		t.Errorf("f.txt not merged: %q", content)
+	}
+	if _, err := os.Stat("new.txt"); err != nil {
+		t.Errorf("file added on the merged branch is missing: %v", err)
+	}
+	if dirty, _ := IsWorkDirDirty(); dirty {
+		t.Error("working tree dirty after merge")
+	}
+}
+
+func TestMerge_ConflictWritesMarkers(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	divergeBranches(t,
+		map[string]string{"f.txt": "a\nb\nc\n", "g.txt": "g\n"},
+		map[string]string{"f.txt": "a\nours\nc\n"},
+		map[string]string{"f.txt": "a\ntheirs\nc\n", "g.txt": "G\n"},
+	)
+	before, _ := readHead()
+
+	if err := Merge("feature"); err == nil {
+		t.Fatal("expected the merge to stop on a conflict")
+	}
+	if after, _ := readHead(); after != before {
+		t.Error("branch moved despite the conflict")
+	}
+	if !IsMergeInProgress() {
+		t.Error("MERGE_HEAD not written")
+	}
+
+	content, _ := os.ReadFile("f.txt")
+	want := "a\n<<<<<<< HEAD\nours\n=======\ntheirs\n>>>>>>> feature\nc\n"
+	if string(content) != want {
+		t.Errorf("f.txt = %q, want %q", content, want)
+	}
+	if content, _ := os.ReadFile("g.txt"); string(content) != "G\n" {
+		t.Errorf("clean change not checked out: %q", content)
+	}
+
+	unmerged, err := storage.LoadUnmergedEntries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, ok := unmerged["f.txt"]
+	if !ok || u.Base == nil || u.Ours == nil || u.Theirs == nil {
+		t.Fatalf("unmerged entries = %v", unmerged)
+	}
+	if _, _, err := Commit("too early"); err == nil {
+		t.Error("commit succeeded with unmerged paths")
+	}
+}
+
+func TestMerge_ContinueAfterResolving(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	divergeBranches(t,
+		map[string]string{"f.txt": "base\n"},
+		map[string]string{"f.txt": "ours\n"},
+		map[string]string{"f.txt": "theirs\n"},
+	)
+	theirsHead, _ := os.ReadFile(filepath.Join(HeadsDir, "feature"))
+	Merge("feature")
+
+	if _, _, err := MergeContinue(); err == nil {
+		t.Fatal("merge --continue succeeded with unmerged paths")
+	}
+	if err := os.WriteFile("f.txt", []byte("resolved\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := AddFile("f.txt"); err != nil {
+		t.Fatal(err)
+	}
+	commit, _, err := MergeContinue()
+	if err != nil {
+		t.Fatalf("merge --continue failed: %v", err)
+	}
+	if len(commit.Parents) != 2 || commit.Parents[1] != string(theirsHead) {
+		t.Errorf("merge commit parents = %v", commit.Parents)
+	}
+	if commit.Message != "Merge branch 'feature'" {
+		t.Errorf("message = %q", commit.Message)
+	}
+	if IsMergeInProgress() {
+		t.Error("merge state left behind")
+	}
+}
+
+func TestMerge_Abort(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	divergeBranches(t,
+		map[string]string{"f.txt": "base\n"},
+		map[string]string{"f.txt": "ours\n"},
+		map[string]string{"f.txt": "theirs\n", "added.txt": "added\n"},
+	)
+	Merge("feature")
+
+	if err := MergeAbort(); err != nil {
+		t.Fatalf("merge --abort failed: %v", err)
+	}
+	if content, _ := os.ReadFile("f.txt"); string(content) != "ours\n" {
+		t.Errorf("f.txt = %q after abort", content)
+	}
+	if _, err := os.Stat("added.txt"); !os.IsNotExist(err) {
+		t.Error("file from the merged branch left behind")
+	}
+	if unmerged, _ := storage.LoadUnmergedEntries(); len(unmerged) != 0 {
+		t.Errorf("unmerged entries left behind: %v", unmerged)
+	}
+	if IsMergeInProgress() {
+		t.Error("merge state left behind")
+	}
+}
+
+func TestMerge_FavorResolvesConflicts(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	divergeBranches(t,
+		map[string]string{"f.txt": "a\nb\nc\nd\ne\n"},
+		map[string]string{"f.txt": "a\nours\nc\nd\ne\n"},
+		map[string]string{"f.txt": "a\ntheirs\nc\nd\nE\n"},
+	)
+	if err := MergeWithOptions("feature", MergeOptions{Favor: FavorOurs}); err != nil {
+		t.Fatalf("merge -X ours failed: %v", err)
+	}
+	// Only the conflicting hunk takes our side; the other change still merges in
+	if content, _ := os.ReadFile("f.txt"); string(content) != "a\nours\nc\nd\nE\n" {
+		t.Errorf("f.txt = %q", content)
+	}
+}
+
+func TestMerge_FastForwardModesAndSquash(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	divergeBranches(t,
+		map[string]string{"f.txt": "base\n"},
+		map[string]string{"o.txt": "ours\n"},
+		map[string]string{"t.txt": "theirs\n"},
+	)
+	before, _ := readHead()
+
+	if err := MergeWithOptions("feature", MergeOptions{FastForward: FastForwardOnly}); err == nil {
+		t.Error("--ff-only merged diverged branches")
+	}
+
+	if err := MergeWithOptions("feature", MergeOptions{Squash: true}); err != nil {
+		t.Fatalf("--squash failed: %v", err)
+	}
+	if after, _ := readHead(); after != before {
+		t.Error("--squash moved the branch")
+	}
+	if IsMergeInProgress() {
+		t.Error("--squash left a merge in progress")
+	}
+	commit, _, err := Commit("squashed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(commit.Parents) != 1 {
+		t.Errorf("squash commit has parents %v", commit.Parents)
+	}
+
+	// feature is now behind main, so a merge of main into it could fast-forward
+	if err := CheckoutBranch("feature"); err != nil {
+		t.Fatal(err)
+	}
+	if err := MergeWithOptions("main", MergeOptions{FastForward: FastForwardNever}); err != nil {
+		t.Fatalf("--no-ff failed: %v", err)
+	}
+	head, _ := GetHeadCommit()
+	if !head.IsMerge() {
+		t.Error("--no-ff did not create a merge commit")
+	}
+}
+
+func TestDefaultMergeOptions_BranchConfig(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	if err := SetConfig("branch.main.mergeoptions", "--no-ff -X theirs", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetConfig("diff.algorithm", "histogram", false); err != nil {
+		t.Fatal(err)
+	}
+	opts, err := DefaultMergeOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.FastForward != FastForwardNever || opts.Favor != FavorTheirs || opts.Algorithm != diff.Histogram {
+		t.Errorf("defaults = %+v", opts)
+	}
+
+	rest, err := opts.ParseFlags([]string{"--ff-only", "feature"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.FastForward != FastForwardOnly || len(rest) != 1 || rest[0] != "feature" {
+		t.Errorf("command-line flags did not override: %+v %v", opts, rest)
+	}
+	if _, err := opts.ParseFlags([]string{"-X", "mine"}); err == nil {
+		t.Error("invalid -X value accepted")
+	}
+	if _, err := opts.ParseFlags([]string{"-X", "diff-algorithm=patience"}); err != nil || opts.Algorithm != diff.Patience {
+		t.Errorf("-X diff-algorithm=patience: %v, %+v", err, opts)
+	}
+}
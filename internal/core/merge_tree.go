@@ -0,0 +1,355 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/LeeFred3042U/kitcat/internal/diff"
+	"github.com/LeeFred3042U/kitcat/internal/storage"
+)
+
+// mergeConflict is a path the three-way merge could not resolve on its own.
+// Entries are nil where the path does not exist on that side.
+type mergeConflict struct {
+	Path   string
+	Reason string
+	Base   *storage.IndexEntry
+	Ours   *storage.IndexEntry
+	Theirs *storage.IndexEntry
+}
+
+// mergeTrees merges the changes made from baseTree to oursTree with those made from
+// baseTree to theirsTree, file by file. Files changed on both sides are merged line by
+// line. It returns the merged files and the paths that conflict. An empty baseTree
+// stands for a base with no files. A favor of "ours" or "theirs" settles conflicting
+// hunks of text files in that side's favor, and alg is how lines are matched up.
+func mergeTrees(baseTree, oursTree, theirsTree, favor string, alg diff.Algorithm) (map[string]storage.IndexEntry, []mergeConflict, error) {
+	base := make(map[string]storage.IndexEntry)
+	if baseTree != "" {
+		var err error
+		if base, err = storage.ParseTreeEntries(baseTree); err != nil {
+			return nil, nil, fmt.Errorf("failed to read merge base tree: %w", err)
+		}
+	}
+	ours, err := storage.ParseTreeEntries(oursTree)
+	if err != nil {
+		return nil, nil, err
+	}
+	theirs, err := storage.ParseTreeEntries(theirsTree)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	paths := make(map[string]bool)
+	for _, tree := range []map[string]storage.IndexEntry{base, ours, theirs} {
+		for path := range tree {
+			paths[path] = true
+		}
+	}
+	sorted := make([]string, 0, len(paths))
+	for path := range paths {
+		sorted = append(sorted, path)
+	}
+	sort.Strings(sorted)
+
+	merged := make(map[string]storage.IndexEntry)
+	var conflicts []mergeConflict
+	for _, path := range sorted {
+		b, o, t := entryPtr(base, path), entryPtr(ours, path), entryPtr(theirs, path)
+		entry, reason, err := mergeEntry(b, o, t, favor, alg)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if reason != "" {
+			conflicts = append(conflicts, mergeConflict{Path: path, Reason: reason, Base: b, Ours: o, Theirs: t})
+			continue
+		}
+		if entry != nil {
+			merged[path] = *entry
+		}
+	}
+
+	// A file on one side may now sit where the other side added a directory
+	for _, path := range sorted {
+		if _, ok := merged[path]; !ok {
+			continue
+		}
+		for dir := filepath.Dir(path); dir != "." && dir != string(filepath.Separator); dir = filepath.Dir(dir) {
+			if _, clash := merged[dir]; clash {
+				conflicts = append(conflicts, mergeConflict{
+					Path:   dir,
+					Reason: "file/directory",
+					Base:   entryPtr(base, dir),
+					Ours:   entryPtr(ours, dir),
+					Theirs: entryPtr(theirs, dir),
+				})
+				delete(merged, dir)
+			}
+		}
+	}
+	return merged, conflicts, nil
+}
+
+func entryPtr(tree map[string]storage.IndexEntry, path string) *storage.IndexEntry {
+	if e, ok := tree[path]; ok {
+		return &e
+	}
+	return nil
+}
+
+func sameEntry(a, b *storage.IndexEntry) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.SameFile(*b)
+}
+
+// mergeEntry resolves one path. A nil entry with no conflict reason means the path is
+// deleted in the result.
+func mergeEntry(base, ours, theirs *storage.IndexEntry, favor string, alg diff.Algorithm) (*storage.IndexEntry, string, error) {
+	switch {
+	case sameEntry(ours, theirs):
+		return ours, "", nil
+	case sameEntry(base, ours):
+		return theirs, "", nil
+	case sameEntry(base, theirs):
+		return ours, "", nil
+	}
+
+	// Both sides changed the path, and differently
+	if ours == nil || theirs == nil {
+		return nil, "modify/delete", nil
+	}
+
+	mode := ours.Mode
+	switch {
+	case ours.Mode == theirs.Mode:
+	case base != nil && ours.Mode == base.Mode:
+		mode = theirs.Mode
+	case base != nil && theirs.Mode == base.Mode:
+	default:
+		return nil, "mode", nil
+	}
+	if ours.Hash == theirs.Hash {
+		return &storage.IndexEntry{Hash: ours.Hash, Mode: mode}, "", nil
+	}
+	if ours.Mode == storage.ModeSymlink || theirs.Mode == storage.ModeSymlink {
+		return nil, "symlink", nil
+	}
+
+	var baseContent []byte
+	if base != nil {
+		var err error
+		if _, baseContent, err = storage.ReadObject(base.Hash); err != nil {
+			return nil, "", err
+		}
+	}
+	_, oursContent, err := storage.ReadObject(ours.Hash)
+	if err != nil {
+		return nil, "", err
+	}
+	_, theirsContent, err := storage.ReadObject(theirs.Hash)
+	if err != nil {
+		return nil, "", err
+	}
+	if isBinary(baseContent) || isBinary(oursContent) || isBinary(theirsContent) {
+		return nil, "binary", nil
+	}
+
+	result, clean := mergeText(baseContent, oursContent, theirsContent, favor, alg)
+	if !clean {
+		if base == nil {
+			return nil, "add/add", nil
+		}
+		return nil, "content", nil
+	}
+	hash, err := storage.WriteObject(storage.ObjectBlob, result)
+	if err != nil {
+		return nil, "", err
+	}
+	return &storage.IndexEntry{Hash: hash, Mode: mode}, "", nil
+}
+
+// mergeText merges two versions of a text file line by line. Conflicting regions take
+// the favored side's lines; with no favor it reports false if any region conflicts.
+func mergeText(base, ours, theirs []byte, favor string, alg diff.Algorithm) ([]byte, bool) {
+	var out strings.Builder
+	for _, region := range diff.Merge3With(alg, splitLines(base), splitLines(ours), splitLines(theirs)) {
+		lines := region.Lines
+		if region.Conflict {
+			switch favor {
+			case FavorOurs:
+				lines = region.Ours
+			case FavorTheirs:
+				lines = region.Theirs
+			default:
+				return nil, false
+			}
+		}
+		for _, line := range lines {
+			out.WriteString(line)
+		}
+	}
+	return []byte(out.String()), true
+}
+
+// splitLines splits content into lines that keep their line endings, so joining them
+// restores the content exactly, including a missing final newline
+func splitLines(content []byte) []string {
+	lines := strings.SplitAfter(string(content), "\n")
+	if len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// conflictMarkers merges two versions of a text file like mergeText, but writes each
+// conflicting region out with both sides between <<<<<<<, ======= and >>>>>>> markers
+func conflictMarkers(base, ours, theirs []byte, oursLabel, theirsLabel string, alg diff.Algorithm) []byte {
+	var out strings.Builder
+	writeSide := func(lines []string) {
+		for _, line := range lines {
+			out.WriteString(line)
+			if !strings.HasSuffix(line, "\n") {
+				out.WriteString("\n")
+			}
+		}
+	}
+	for _, region := range diff.Merge3With(alg, splitLines(base), splitLines(ours), splitLines(theirs)) {
+		if !region.Conflict {
+			for _, line := range region.Lines {
+				out.WriteString(line)
+			}
+			continue
+		}
+		out.WriteString("<<<<<<< " + oursLabel + "\n")
+		writeSide(region.Ours)
+		out.WriteString("=======\n")
+		writeSide(region.Theirs)
+		out.WriteString(">>>>>>> " + theirsLabel + "\n")
+	}
+	return []byte(out.String())
+}
+
+// conflictFile is what the working directory shows for a conflicted path: the text with
+// conflict markers where both sides edited it, otherwise whichever side still has the file.
+// It reports false when no file should be written, as for a file/directory clash.
+func conflictFile(c mergeConflict, oursLabel, theirsLabel string, alg diff.Algorithm) ([]byte, uint32, bool, error) {
+	if c.Reason == "file/directory" {
+		return nil, 0, false, nil
+	}
+	read := func(e *storage.IndexEntry) ([]byte, error) {
+		if e == nil {
+			return nil, nil
+		}
+		_, content, err := storage.ReadObject(e.Hash)
+		return content, err
+	}
+	if c.Reason == "content" || c.Reason == "add/add" {
+		var sides [3][]byte
+		for i, e := range []*storage.IndexEntry{c.Base, c.Ours, c.Theirs} {
+			content, err := read(e)
+			if err != nil {
+				return nil, 0, false, err
+			}
+			sides[i] = content
+		}
+		return conflictMarkers(sides[0], sides[1], sides[2], oursLabel, theirsLabel, alg), c.Ours.Mode, true, nil
+	}
+
+	side := c.Ours
+	if side == nil {
+		side = c.Theirs
+	}
+	content, err := read(side)
+	if err != nil {
+		return nil, 0, false, err
+	}
+	return content, side.Mode, true, nil
+}
+
+// checkoutMerge writes a merge result to the working directory and index, which must
+// match HEAD beforehand. Merged paths are checked out and staged. Conflicted paths get a
+// working file from conflictFile and are recorded in the index as unmerged.
+func checkoutMerge(merged map[string]storage.IndexEntry, conflicts []mergeConflict, oursLabel, theirsLabel string, alg diff.Algorithm) error {
+	current, err := storage.LoadIndexEntries()
+	if err != nil {
+		return err
+	}
+
+	type workingFile struct {
+		content []byte
+		mode    uint32
+	}
+	files := make(map[string]workingFile)
+	unmerged := make(map[string]storage.UnmergedEntry, len(conflicts))
+	for _, c := range conflicts {
+		content, mode, ok, err := conflictFile(c, oursLabel, theirsLabel, alg)
+		if err != nil {
+			return fmt.Errorf("%s: %w", c.Path, err)
+		}
+		if ok {
+			files[c.Path] = workingFile{content, mode}
+		}
+		unmerged[c.Path] = storage.UnmergedEntry{Base: c.Base, Ours: c.Ours, Theirs: c.Theirs}
+	}
+
+	for path := range current {
+		_, inMerged := merged[path]
+		_, inFiles := files[path]
+		if !inMerged && !inFiles {
+			os.Remove(path)
+		}
+	}
+	for path, entry := range merged {
+		if prev, ok := current[path]; ok && prev.SameFile(entry) {
+			continue
+		}
+		_, content, err := storage.ReadObject(entry.Hash)
+		if err != nil {
+			return err
+		}
+		if err := writeWorkingFile(path, content, entry.Mode); err != nil {
+			return err
+		}
+	}
+	for path, f := range files {
+		if err := writeWorkingFile(path, f.content, f.mode); err != nil {
+			return err
+		}
+	}
+
+	recordStat(merged)
+	return storage.WriteUnmergedIndex(merged, unmerged)
+}
+
+// printConflicts reports each conflicted path the way git does
+func printConflicts(conflicts []mergeConflict) {
+	for _, c := range conflicts {
+		fmt.Printf("CONFLICT (%s): Merge conflict in %s\n", c.Reason, c.Path)
+	}
+}
+
+// describeUnmerged names how a conflicted path differs between the two sides, as listed
+// under "Unmerged paths" by status
+func describeUnmerged(u storage.UnmergedEntry) string {
+	switch {
+	case u.Ours != nil && u.Theirs != nil && u.Base == nil:
+		return "both added"
+	case u.Ours != nil && u.Theirs != nil:
+		return "both modified"
+	case u.Ours == nil && u.Theirs != nil && u.Base != nil:
+		return "deleted by us"
+	case u.Ours != nil && u.Theirs == nil && u.Base != nil:
+		return "deleted by them"
+	case u.Ours != nil:
+		return "added by us"
+	case u.Theirs != nil:
+		return "added by them"
+	default:
+		return "both deleted"
+	}
+}
@@ -38,12 +38,12 @@ func MoveFile(oldPath, newPath string, force bool) error {
 
 		// Only check if file is tracked in the index
 		if indexHash, exists := idx[oldPath]; exists {
-			currentHash, err := storage.HashFile(oldPath)
+			matches, err := storage.FileMatchesHash(oldPath, indexHash)
 			if err != nil {
 				return err
 			}
 
-			if currentHash != indexHash {
+			if !matches {
 				return errors.New("local changes present, use -f to force")
 			}
 		}
@@ -0,0 +1,242 @@
+package core
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/LeeFred3042U/kitcat/internal/diff"
+)
+
+// DefaultContextLines is how many unchanged lines surround each change in a unified diff
+// unless diff.context or -U says otherwise
+const DefaultContextLines = 3
+
+// noNewlineMarker follows a line that ends its file without a newline
+const noNewlineMarker = `\ No newline at end of file`
+
+// Word diff forms for DiffOptions.WordDiff
+const (
+	WordDiffPlain     = "plain"     // words inline as [-deleted-]{+inserted+}
+	WordDiffColor     = "color"     // words inline, deleted in red and inserted in green
+	WordDiffPorcelain = "porcelain" // one run of words per line, prefixed " ", "-" or "+", with "~" for line breaks
+)
+
+// patchWriter writes file changes as unified diffs, or as word diffs when words names a
+// word diff form
+type patchWriter struct {
+	w         io.Writer
+	context   int
+	color     bool
+	words     string
+	wordRegex *regexp.Regexp // what a word is; nil means diff.DefaultWordRegex
+	algorithm diff.Algorithm // how lines and words are matched up; empty means Myers
+}
+
+// paint wraps s in an ANSI color when color output is on
+func (p patchWriter) paint(color, s string) string {
+	if !p.color {
+		return s
+	}
+	return color + s + colorReset
+}
+
+// writeFile writes one file's change: the "diff --kitcat a/<old> b/<new>" header, lines
+// describing new, deleted, renamed and copied files and mode changes, then the hunks
+// turning oldContent into newContent
+func (p patchWriter) writeFile(c fileChange, oldContent, newContent []byte) {
+	oldPath, newPath := c.OldPath, c.Path
+	if c.Old == nil {
+		oldPath = c.Path
+	}
+	header := []string{fmt.Sprintf("diff --kitcat a/%s b/%s", oldPath, newPath)}
+	switch {
+	case c.Old == nil:
+		header = append(header, fmt.Sprintf("new file mode %06o", c.New.Mode))
+	case c.New == nil:
+		header = append(header, fmt.Sprintf("deleted file mode %06o", c.Old.Mode))
+	case c.Old.Mode != c.New.Mode:
+		header = append(header, fmt.Sprintf("old mode %06o", c.Old.Mode), fmt.Sprintf("new mode %06o", c.New.Mode))
+	}
+	switch c.Status {
+	case 'R':
+		header = append(header, fmt.Sprintf("similarity index %d%%", c.Score),
+			"rename from "+c.OldPath, "rename to "+c.Path)
+	case 'C':
+		header = append(header, fmt.Sprintf("similarity index %d%%", c.Score),
+			"copy from "+c.OldPath, "copy to "+c.Path)
+	}
+
+	oldHash, newHash := zeroHash, zeroHash
+	if c.Old != nil {
+		oldHash = c.Old.Hash
+	}
+	if c.New != nil {
+		newHash = c.New.Hash
+	}
+	if oldHash != newHash {
+		index := fmt.Sprintf("index %s..%s", shortHash(oldHash), shortHash(newHash))
+		if c.Old != nil && c.New != nil && c.Old.Mode == c.New.Mode {
+			index += fmt.Sprintf(" %06o", c.Old.Mode)
+		}
+		header = append(header, index)
+	}
+	for _, line := range header {
+		fmt.Fprintln(p.w, p.paint(colorBold, line))
+	}
+	if oldHash == newHash {
+		return
+	}
+
+	from, to := "a/"+oldPath, "b/"+newPath
+	if c.Old == nil {
+		from = "/dev/null"
+	}
+	if c.New == nil {
+		to = "/dev/null"
+	}
+	if isBinary(oldContent) || isBinary(newContent) {
+		fmt.Fprintf(p.w, "Binary files %s and %s differ\n", from, to)
+		return
+	}
+	fmt.Fprintln(p.w, p.paint(colorBold, "--- "+from))
+	fmt.Fprintln(p.w, p.paint(colorBold, "+++ "+to))
+	lines := diff.New(p.algorithm, splitLines(oldContent), splitLines(newContent)).Diffs()
+	hunks := diff.UnifiedFromDiffs(lines, p.context)
+	if p.words != "" {
+		p.writeWordHunks(hunks)
+	} else {
+		p.writeHunks(hunks)
+	}
+}
+
+// writeHunks writes each hunk's header and lines, marking a last line with no newline
+func (p patchWriter) writeHunks(hunks []diff.Hunk) {
+	for _, h := range hunks {
+		fmt.Fprintln(p.w, p.paint(colorCyan, h.Header()))
+		for _, line := range h.Lines {
+			prefix, color := " ", ""
+			switch line.Operation {
+			case diff.INSERT:
+				prefix, color = "+", colorGreen
+			case diff.DELETE:
+				prefix, color = "-", colorRed
+			}
+			text := strings.TrimSuffix(line.Text, "\n")
+			if color != "" {
+				text = p.paint(color, prefix+text)
+			} else {
+				text = prefix + text
+			}
+			fmt.Fprintln(p.w, text)
+			if !strings.HasSuffix(line.Text, "\n") {
+				fmt.Fprintln(p.w, noNewlineMarker)
+			}
+		}
+	}
+}
+
+// writeWordHunks writes each hunk with its runs of changed lines diffed again word by word
+func (p patchWriter) writeWordHunks(hunks []diff.Hunk) {
+	word := p.wordRegex
+	if word == nil {
+		word = diff.DefaultWordRegex
+	}
+	for _, h := range hunks {
+		fmt.Fprintln(p.w, p.paint(colorCyan, h.Header()))
+		var runs []diff.Diff[string]
+		var old, new strings.Builder
+		flush := func() {
+			if old.Len() > 0 || new.Len() > 0 {
+				runs = append(runs, diff.Words(old.String(), new.String(), word, p.algorithm)...)
+				old.Reset()
+				new.Reset()
+			}
+		}
+		for _, line := range h.Lines {
+			switch line.Operation {
+			case diff.DELETE:
+				old.WriteString(line.Text)
+			case diff.INSERT:
+				new.WriteString(line.Text)
+			default:
+				flush()
+				runs = append(runs, diff.Diff[string]{Operation: diff.EQUAL, Text: []string{line.Text}})
+			}
+		}
+		flush()
+		if p.words == WordDiffPorcelain {
+			p.writePorcelainWords(runs)
+		} else {
+			p.writeInlineWords(runs)
+		}
+	}
+}
+
+// writeInlineWords writes runs of words as the text they make up, marking deleted and
+// inserted words where they occur. Marks never span a line break.
+func (p patchWriter) writeInlineWords(runs []diff.Diff[string]) {
+	var sb strings.Builder
+	for _, r := range runs {
+		for i, part := range strings.Split(strings.Join(r.Text, ""), "\n") {
+			if i > 0 {
+				sb.WriteByte('\n')
+			}
+			if part != "" {
+				sb.WriteString(p.markWords(r.Operation, part))
+			}
+		}
+	}
+	out := sb.String()
+	if out != "" && !strings.HasSuffix(out, "\n") {
+		out += "\n"
+	}
+	fmt.Fprint(p.w, out)
+}
+
+// markWords marks words deleted or inserted the way the word diff form asks
+func (p patchWriter) markWords(op diff.Operation, words string) string {
+	color := p.words == WordDiffColor
+	switch {
+	case op == diff.DELETE && color:
+		return colorRed + words + colorReset
+	case op == diff.INSERT && color:
+		return colorGreen + words + colorReset
+	case op == diff.DELETE:
+		return p.paint(colorRed, "[-"+words+"-]")
+	case op == diff.INSERT:
+		return p.paint(colorGreen, "{+"+words+"+}")
+	}
+	return words
+}
+
+// writePorcelainWords writes each run of words on a line of its own after " ", "-" or
+// "+", and a "~" line for every line break
+func (p patchWriter) writePorcelainWords(runs []diff.Diff[string]) {
+	for _, r := range runs {
+		prefix := " "
+		switch r.Operation {
+		case diff.DELETE:
+			prefix = "-"
+		case diff.INSERT:
+			prefix = "+"
+		}
+		for i, part := range strings.Split(strings.Join(r.Text, ""), "\n") {
+			if i > 0 {
+				fmt.Fprintln(p.w, "~")
+			}
+			if part != "" {
+				fmt.Fprintln(p.w, prefix+part)
+			}
+		}
+	}
+}
+
+// stdoutIsTerminal reports whether output goes to a terminal rather than a file or pipe,
+// where color codes would end up in the text
+func stdoutIsTerminal() bool {
+	info, err := os.Stdout.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,83 @@
+package core
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/LeeFred3042U/kitcat/internal/storage"
+)
+
+func TestPatchWriter_WriteFile(t *testing.T) {
+	oldContent := []byte("one\ntwo\nthree")
+	newContent := []byte("one\n2\nthree\n")
+	old := storage.IndexEntry{Hash: storage.HashObject(storage.ObjectBlob, oldContent), Mode: storage.ModeRegular}
+	new := storage.IndexEntry{Hash: storage.HashObject(storage.ObjectBlob, newContent), Mode: storage.ModeRegular}
+
+	var buf bytes.Buffer
+	p := patchWriter{w: &buf, context: DefaultContextLines}
+	p.writeFile(fileChange{Status: 'R', OldPath: "old.txt", Path: "new.txt", Old: &old, New: &new, Score: 66}, oldContent, newContent)
+
+	want := "diff --kitcat a/old.txt b/new.txt\n" +
+		"similarity index 66%\n" +
+		"rename from old.txt\n" +
+		"rename to new.txt\n" +
+		"index " + old.Hash[:7] + ".." + new.Hash[:7] + " 100644\n" +
+		"--- a/old.txt\n" +
+		"+++ b/new.txt\n" +
+		"@@ -1,3 +1,3 @@\n" +
+		" one\n" +
+		"-two\n" +
+		"-three\n" +
+		"\\ No newline at end of file\n" +
+		"+2\n" +
+		"+three\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+
+	buf.Reset()
+	p.writeFile(fileChange{Status: 'A', Path: "new.txt", New: &new}, nil, newContent)
+	want = "diff --kitcat a/new.txt b/new.txt\n" +
+		"new file mode 100644\n" +
+		"index 0000000.." + new.Hash[:7] + "\n" +
+		"--- /dev/null\n" +
+		"+++ b/new.txt\n" +
+		"@@ -0,0 +1,3 @@\n" +
+		"+one\n" +
+		"+2\n" +
+		"+three\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestPatchWriter_WordDiff(t *testing.T) {
+	oldContent := []byte("The quick brown fox.\nSame line.\nOld ending.\n")
+	newContent := []byte("The quick red fox.\nSame line.\nA new ending.\n")
+	old := storage.IndexEntry{Hash: storage.HashObject(storage.ObjectBlob, oldContent), Mode: storage.ModeRegular}
+	new := storage.IndexEntry{Hash: storage.HashObject(storage.ObjectBlob, newContent), Mode: storage.ModeRegular}
+	change := fileChange{Status: 'M', OldPath: "a.txt", Path: "a.txt", Old: &old, New: &new}
+	header := "diff --kitcat a/a.txt b/a.txt\n" +
+		"index " + old.Hash[:7] + ".." + new.Hash[:7] + " 100644\n" +
+		"--- a/a.txt\n" +
+		"+++ b/a.txt\n" +
+		"@@ -1,3 +1,3 @@\n"
+
+	tests := []struct {
+		words string
+		want  string
+	}{
+		{WordDiffPlain, "The quick [-brown-]{+red+} fox.\nSame line.\n[-Old-]{+A new+} ending.\n"},
+		{WordDiffPorcelain, " The quick \n-brown\n+red\n  fox.\n~\n Same line.\n~\n-Old\n+A new\n  ending.\n~\n"},
+		{WordDiffColor, "The quick " + colorRed + "brown" + colorReset + colorGreen + "red" + colorReset + " fox.\nSame line.\n" +
+			colorRed + "Old" + colorReset + colorGreen + "A new" + colorReset + " ending.\n"},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		p := patchWriter{w: &buf, context: DefaultContextLines, words: tt.words}
+		p.writeFile(change, oldContent, newContent)
+		if got := buf.String(); got != header+tt.want {
+			t.Errorf("%s:\ngot\n%s\nwant\n%s", tt.words, got, header+tt.want)
+		}
+	}
+}
@@ -0,0 +1,274 @@
+package core
+
+import (
+	"bufio"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/LeeFred3042U/kitcat/internal/storage"
+)
+
+// DefaultPruneExpiry is how old an unreachable object must be before prune deletes it.
+// The grace period protects objects written by commands still running.
+const DefaultPruneExpiry = 14 * 24 * time.Hour
+
+// Prune deletes objects that cannot be reached from any branch, tag, HEAD, stash entry,
+// in-progress rebase or merge, reflog entry or the index and that were written before expire.
+// Commits removed this way are also dropped from the commit journal. With dryRun set,
+// the objects are only listed.
+func Prune(expire time.Time, dryRun bool) error {
+	reachable, err := reachableObjects()
+	if err != nil {
+		return err
+	}
+	unreachable, err := storage.UnreachableObjects(reachable, expire)
+	if err != nil {
+		return fmt.Errorf("failed to list objects: %w", err)
+	}
+
+	for _, hash := range unreachable {
+		objType, _, err := storage.ReadObject(hash)
+		if err != nil {
+			objType = "unknown"
+		}
+		fmt.Printf("%s %s\n", hash, objType)
+	}
+	if dryRun {
+		fmt.Printf("Would prune %d unreachable object%s\n", len(unreachable), pluralize(len(unreachable)))
+		return nil
+	}
+
+	if err := storage.RemoveObjects(unreachable); err != nil {
+		return fmt.Errorf("failed to remove objects: %w", err)
+	}
+	removed := make(map[string]bool, len(unreachable))
+	for _, hash := range unreachable {
+		removed[hash] = true
+	}
+	dropped, err := storage.PruneCommitJournal(removed)
+	if err != nil {
+		return fmt.Errorf("failed to update commit journal: %w", err)
+	}
+	fmt.Printf("Pruned %d unreachable object%s\n", len(unreachable), pluralize(len(unreachable)))
+	if dropped > 0 {
+		fmt.Printf("Removed %d orphaned commit%s from the journal\n", dropped, pluralize(dropped))
+	}
+	// Pruned commits must not stay behind in the commit-graph
+	if len(unreachable) > 0 && storage.HasCommitGraph() {
+		return WriteCommitGraph()
+	}
+	return nil
+}
+
+// ParseExpiry turns a prune grace period into a cut-off time. It accepts "now", "never",
+// Go durations such as "36h" and whole days or weeks such as "14d" or "2w".
+func ParseExpiry(value string, now time.Time) (time.Time, error) {
+	switch value {
+	case "now":
+		return now, nil
+	case "never":
+		return time.Time{}, nil
+	}
+	var unit time.Duration
+	switch {
+	case strings.HasSuffix(value, "d"):
+		unit = 24 * time.Hour
+	case strings.HasSuffix(value, "w"):
+		unit = 7 * 24 * time.Hour
+	}
+	if unit != 0 {
+		n, err := strconv.Atoi(value[:len(value)-1])
+		if err != nil || n < 0 {
+			return time.Time{}, fmt.Errorf("invalid expiry %q", value)
+		}
+		return now.Add(-time.Duration(n) * unit), nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		return time.Time{}, fmt.Errorf("invalid expiry %q", value)
+	}
+	return now.Add(-d), nil
+}
+
+// reachableObjects marks every object reachable from the repository's roots.
+// A root or reachable object that cannot be read aborts the walk, since pruning
+// around a hole could delete the only copies of objects it references.
+func reachableObjects() (map[string]bool, error) {
+	var roots []string
+
+	refs, err := listRefs()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read refs: %w", err)
+	}
+	for _, target := range refs {
+		roots = append(roots, target)
+	}
+	if head, err := os.ReadFile(HeadPath); err == nil {
+		if target := strings.TrimSpace(string(head)); !strings.HasPrefix(target, "ref: ") {
+			roots = append(roots, target)
+		}
+	}
+
+	stashes, err := storage.ListStashes()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read stash: %w", err)
+	}
+	roots = append(roots, stashes...)
+
+	if IsRebaseInProgress() {
+		state, err := LoadRebaseState()
+		if err != nil {
+			return nil, err
+		}
+		roots = append(roots, state.Onto, state.OrigHead)
+		for _, step := range state.TodoSteps {
+			if fields := strings.Fields(step); len(fields) >= 2 && !strings.HasPrefix(fields[0], "#") {
+				hash, err := storage.ResolveObject(fields[1])
+				if err != nil {
+					return nil, fmt.Errorf("rebase todo: %w", err)
+				}
+				roots = append(roots, hash)
+			}
+		}
+	}
+
+	logged, err := reflogHashes()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read reflog: %w", err)
+	}
+	// A reflog may outlive the objects it mentions, which is no reason to stop
+	for _, hash := range logged {
+		if storage.ObjectExists(hash) {
+			roots = append(roots, hash)
+		}
+	}
+
+	for _, path := range []string{MergeHeadPath, OrigHeadPath} {
+		if data, err := os.ReadFile(path); err == nil {
+			roots = append(roots, strings.TrimSpace(string(data)))
+		}
+	}
+
+	index, err := storage.LoadIndex()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read index: %w", err)
+	}
+	for _, hash := range index {
+		roots = append(roots, hash)
+	}
+	unmerged, err := storage.LoadUnmergedEntries()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read index: %w", err)
+	}
+	for _, u := range unmerged {
+		for _, stage := range []*storage.IndexEntry{u.Base, u.Ours, u.Theirs} {
+			if stage != nil {
+				roots = append(roots, stage.Hash)
+			}
+		}
+	}
+
+	reachable := make(map[string]bool)
+	for _, root := range roots {
+		if root == "" {
+			continue
+		}
+		if err := markReachable(root, reachable); err != nil {
+			return nil, err
+		}
+	}
+	return reachable, nil
+}
+
+// markReachable adds hash and everything it references to reachable. Legacy objects
+// carry no type, so they are followed as whatever their referrer says they are.
+func markReachable(hash string, reachable map[string]bool) error {
+	type ref struct{ hash, want string }
+	stack := []ref{{hash: hash}}
+	for len(stack) > 0 {
+		next := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		hash := next.hash
+		if reachable[hash] {
+			continue
+		}
+
+		objType, data, err := storage.ReadObject(hash)
+		if err != nil {
+			return fmt.Errorf("reachable object %s cannot be read (run fsck): %w", hash, err)
+		}
+		reachable[hash] = true
+		if objType == storage.ObjectLegacy {
+			objType = next.want
+		}
+
+		switch objType {
+		case storage.ObjectCommit:
+			c, err := storage.DecodeCommit(hash, data)
+			if err != nil {
+				return err
+			}
+			stack = append(stack, ref{c.TreeHash, storage.ObjectTree})
+			for _, p := range c.Parents {
+				stack = append(stack, ref{p, storage.ObjectCommit})
+			}
+		case storage.ObjectTree:
+			entries, err := storage.ReadTree(hash)
+			if err != nil {
+				return err
+			}
+			for _, e := range entries {
+				want := storage.ObjectBlob
+				if e.IsDir() {
+					want = storage.ObjectTree
+				}
+				stack = append(stack, ref{e.Hash, want})
+			}
+		case storage.ObjectTag:
+			t, err := storage.DecodeTag(hash, data)
+			if err != nil {
+				return err
+			}
+			stack = append(stack, ref{hash: t.Object})
+		}
+	}
+	return nil
+}
+
+// reflogHashes returns the old and new commit of every entry in every reflog.
+// Entries have git's layout: "<old> <new> <name> <<email>> <time> <zone>\t<message>".
+func reflogHashes() ([]string, error) {
+	var hashes []string
+	err := filepath.WalkDir(LogsDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) < 2 {
+				continue
+			}
+			for _, hash := range fields[:2] {
+				if strings.Trim(hash, "0") != "" {
+					hashes = append(hashes, hash)
+				}
+			}
+		}
+		return scanner.Err()
+	})
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return hashes, err
+}
@@ -0,0 +1,99 @@
+package core
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/LeeFred3042U/kitcat/internal/storage"
+)
+
+func TestPrune_RemovesAmendedCommit(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	if err := os.WriteFile("a.txt", []byte("a\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := AddFile("a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	original, _, err := Commit("first")
+	if err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	amended, err := AmendCommit("first, reworded", CommitOptions{})
+	if err != nil {
+		t.Fatalf("AmendCommit failed: %v", err)
+	}
+	// Pack everything so that pruning has to rewrite the pack
+	if _, err := storage.Repack(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing is old enough yet
+	if err := Prune(time.Now().Add(-time.Hour), false); err != nil {
+		t.Fatalf("Prune failed: %v", err)
+	}
+	if !storage.ObjectExists(original.ID) {
+		t.Fatal("grace period ignored: amended-away commit already pruned")
+	}
+
+	if err := Prune(time.Now().Add(time.Hour), true); err != nil {
+		t.Fatalf("Prune dry run failed: %v", err)
+	}
+	if !storage.ObjectExists(original.ID) {
+		t.Fatal("dry run deleted an object")
+	}
+
+	// The reflog still remembers the commit until its entries expire
+	if err := Prune(time.Now().Add(time.Hour), false); err != nil {
+		t.Fatalf("Prune failed: %v", err)
+	}
+	if !storage.ObjectExists(original.ID) {
+		t.Fatal("commit in the reflog was pruned")
+	}
+	if err := ExpireReflog(time.Now().Add(time.Hour), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Prune(time.Now().Add(time.Hour), false); err != nil {
+		t.Fatalf("Prune failed: %v", err)
+	}
+	if storage.ObjectExists(original.ID) {
+		t.Error("unreachable commit was not pruned")
+	}
+	if _, err := storage.FindCommit(amended.ID); err != nil {
+		t.Errorf("reachable commit was pruned: %v", err)
+	}
+	if _, err := storage.ParseTree(amended.TreeHash); err != nil {
+		t.Errorf("reachable tree was pruned: %v", err)
+	}
+	ids, err := storage.ReadCommitJournal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 1 || ids[0] != amended.ID {
+		t.Errorf("journal not pruned: %v", ids)
+	}
+}
+
+func TestParseExpiry(t *testing.T) {
+	now := time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)
+	tests := map[string]time.Time{
+		"now":   now,
+		"never": {},
+		"2w":    now.Add(-14 * 24 * time.Hour),
+		"3d":    now.Add(-72 * time.Hour),
+		"90m":   now.Add(-90 * time.Minute),
+	}
+	for value, want := range tests {
+		got, err := ParseExpiry(value, now)
+		if err != nil || !got.Equal(want) {
+			t.Errorf("ParseExpiry(%q) = %v, %v; want %v", value, got, err, want)
+		}
+	}
+	if _, err := ParseExpiry("soon", now); err == nil {
+		t.Error("expected an error for an invalid expiry")
+	}
+}
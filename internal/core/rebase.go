@@ -1,7 +1,6 @@
 package core
 
 import (
-	"crypto/sha1"
 	"fmt"
 	"os"
 	"os/exec"
@@ -56,7 +55,7 @@ func RebaseInteractive(commitHash string) error {
 		return fmt.Errorf("cannot rebase: you have unstaged changes")
 	}
 
-	ontoCommit, err := storage.FindCommit(commitHash)
+	ontoCommit, err := ResolveCommit(commitHash)
 	if err != nil {
 		return fmt.Errorf("invalid base commit '%s': %w", commitHash, err)
 	}
@@ -139,6 +138,7 @@ func RebaseInteractive(commitHash string) error {
 	if err := os.WriteFile(".kitcat/HEAD", []byte("ref: refs/heads/"+tmpBranch), 0o644); err != nil {
 		return fmt.Errorf("failed to update HEAD: %w", err)
 	}
+	_ = appendReflog("HEAD", headHash, ontoCommit.ID, "rebase (start): checkout "+commitHash)
 	if err := UpdateWorkspaceAndIndex(ontoCommit.ID); err != nil {
 		return fmt.Errorf("failed to checkout base: %w", err)
 	}
@@ -223,12 +223,14 @@ func RebaseAbort() error {
 	fmt.Printf("Aborting rebase. restoring HEAD to %s\n", state.OrigHead[:7])
 
 	if state.HeadName != "" {
-		if err := os.WriteFile(".kitcat/HEAD", []byte("ref: "+state.HeadName), 0o644); err != nil {
+		current, _ := readHead()
+		if err := updateRef(state.HeadName, state.OrigHead, "rebase (abort): "+state.HeadName); err != nil {
 			return err
 		}
-		if err := os.WriteFile(filepath.Join(".kitcat", state.HeadName), []byte(state.OrigHead), 0o644); err != nil {
+		if err := os.WriteFile(".kitcat/HEAD", []byte("ref: "+state.HeadName), 0o644); err != nil {
 			return err
 		}
+		_ = appendReflog("HEAD", current, state.OrigHead, "rebase (abort): returning to "+state.HeadName)
 		if err := UpdateWorkspaceAndIndex(state.OrigHead); err != nil {
 			return err
 		}
@@ -238,7 +240,7 @@ func RebaseAbort() error {
 		}
 	}
 
-	os.Remove(filepath.Join(".kitcat", "refs", "heads", "kitcat-rebase-tmp"))
+	_ = deleteRef("refs/heads/kitcat-rebase-tmp")
 	return ClearRebaseState()
 }
 
@@ -304,16 +306,19 @@ func finishRebase(state *RebaseState) error {
 	}
 
 	if state.HeadName != "" {
-		if err := os.WriteFile(".kitcat/HEAD", []byte("ref: "+state.HeadName), 0o644); err != nil {
+		// Move the branch while HEAD is still on the temporary one, so the HEAD
+		// reflog gets a single entry for the return
+		reason := fmt.Sprintf("rebase (finish): %s onto %s", state.HeadName, state.Onto)
+		if err := updateRef(state.HeadName, headHash, reason); err != nil {
 			return err
 		}
-		refPath := filepath.Join(".kitcat", state.HeadName)
-		if err := os.WriteFile(refPath, []byte(headHash), 0o644); err != nil {
+		if err := os.WriteFile(".kitcat/HEAD", []byte("ref: "+state.HeadName), 0o644); err != nil {
 			return err
 		}
+		_ = appendReflog("HEAD", headHash, headHash, "rebase (finish): returning to "+state.HeadName)
 	}
 
-	os.Remove(filepath.Join(".kitcat", "refs", "heads", "kitcat-rebase-tmp"))
+	_ = deleteRef("refs/heads/kitcat-rebase-tmp")
 	return ClearRebaseState()
 }
 
@@ -348,114 +353,51 @@ func executeSquash(hash string) error {
 
 // cherryPick applies the changes from the commit with the given hash onto the current HEAD
 // if noCommit is true, it applies the changes without creating a new commit
-// returns an error if any conflicts are detected
+// conflicts are written out with markers and recorded in the index, and reported as an error
 func cherryPick(hash string, noCommit bool) error {
 	commit, err := storage.FindCommit(hash)
 	if err != nil {
 		return err
 	}
-	parentHash := commit.Parent
-	changes, err := getChanges(parentHash, hash)
+	head, err := GetHeadCommit()
 	if err != nil {
 		return err
 	}
-	if err := applyChanges(changes); err != nil {
-		return err
-	}
-	if noCommit {
-		return nil
-	}
-	_, _, err = Commit(commit.Message)
-	if err != nil && strings.Contains(err.Error(), "nothing to commit") {
-		return nil
-	}
-	return err
-}
-
-type Change struct {
-	OldHash string
-	NewHash string
-}
-
-// getChanges computes the changes between parentHash and childHash
-// returns a map of file paths to their old and new hashes
-func getChanges(parentHash, childHash string) (map[string]Change, error) {
-	parentTree := make(map[string]string)
-	if parentHash != "" {
-		pC, err := storage.FindCommit(parentHash)
-		if err == nil {
-			parentTree, _ = storage.ParseTree(pC.TreeHash)
+	// Merges are replayed against their first parent, the branch they were made on
+	baseTree := ""
+	if parentHash := commit.FirstParent(); parentHash != "" {
+		parent, err := storage.FindCommit(parentHash)
+		if err != nil {
+			return err
 		}
+		baseTree = parent.TreeHash
 	}
 
-	childCommit, err := storage.FindCommit(childHash)
+	alg, err := configuredDiffAlgorithm()
 	if err != nil {
-		return nil, err
+		return err
 	}
-	childTree, err := storage.ParseTree(childCommit.TreeHash)
+	merged, conflicts, err := mergeTrees(baseTree, head.TreeHash, commit.TreeHash, "", alg)
 	if err != nil {
-		return nil, err
+		return err
 	}
-
-	changes := make(map[string]Change)
-	for path, hash := range childTree {
-		if pHash, ok := parentTree[path]; !ok || pHash != hash {
-			changes[path] = Change{OldHash: parentTree[path], NewHash: hash}
-		}
+	subject := subjectLine(commit.Message)
+	label := fmt.Sprintf("%s (%s)", commit.ID[:7], subject)
+	if err := checkoutMerge(merged, conflicts, "HEAD", label, alg); err != nil {
+		return err
 	}
-	for path := range parentTree {
-		if _, ok := childTree[path]; !ok {
-			changes[path] = Change{OldHash: parentTree[path], NewHash: ""}
-		}
+	if len(conflicts) > 0 {
+		printConflicts(conflicts)
+		return fmt.Errorf("could not apply %s... %s", commit.ID[:7], subject)
 	}
-	return changes, nil
-}
-
-// applyChanges applies the given changes to the working directory and index
-// returns an error if any conflicts are detected
-func applyChanges(changes map[string]Change) error {
-	headCommit, _ := GetHeadCommit()
-	headTree, _ := storage.ParseTree(headCommit.TreeHash)
-
-	for path, change := range changes {
-		targetHash := change.NewHash
-		if targetHash == "" {
-			headFileHash, existsInHead := headTree[path]
-			if existsInHead && headFileHash != change.OldHash {
-				return fmt.Errorf(
-					"conflict in %s: deleted in incoming commit, but modified in HEAD",
-					path,
-				)
-			}
-			if err := RemoveFile(path); err != nil {
-				return err
-			}
-		} else {
-			content, err := storage.ReadObject(targetHash)
-			if err != nil {
-				return err
-			}
-			headFileHash, existsInHead := headTree[path]
-			if existsInHead {
-				if headFileHash != change.OldHash {
-					return fmt.Errorf("conflict in %s: modified in incoming commit, but modified in HEAD", path)
-				}
-			} else if change.OldHash != "" {
-				return fmt.Errorf("conflict in %s: modified in incoming commit, but deleted in HEAD", path)
-			}
-
-			if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
-				return err
-			}
-			if err := os.WriteFile(path, content, 0o644); err != nil {
-				return err
-			}
-			if err := AddFile(path); err != nil {
-				return err
-			}
-		}
+	if noCommit {
+		return nil
 	}
-	return nil
+	_, _, err = Commit(commit.Message)
+	if err != nil && strings.Contains(err.Error(), "nothing to commit") {
+		return nil
+	}
+	return err
 }
 
 // generateTodo generates the initial todo content for the given commit hashes
@@ -488,30 +430,47 @@ func parseTodo(content string) []string {
 	return steps
 }
 
-// getCommitsBetween returns a list of commit hashes from start (exclusive) to end (inclusive)
-// in chronological order
+// getCommitsBetween returns the commits reachable from end but not from start, parents
+// before children. Merge commits are left out, as their changes arrive through the
+// commits being replayed.
 func getCommitsBetween(start, end string) ([]string, error) {
+	var exclude []string
+	if start != "" {
+		exclude = []string{start}
+	}
+	ids, err := storage.RevList([]string{end}, exclude, 0)
+	if err != nil {
+		return nil, err
+	}
+	inRange := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		inRange[id] = true
+	}
+
+	// RevList orders by date; walk the parents so clock skew cannot put a child first
 	var chain []string
-	curr := end
-	for curr != "" {
-		if curr == start {
-			break
+	var visit func(id string) error
+	visit = func(id string) error {
+		if !inRange[id] {
+			return nil
 		}
-		chain = append(chain, curr)
-		c, err := storage.FindCommit(curr)
+		delete(inRange, id)
+		c, err := storage.FindCommit(id)
 		if err != nil {
-			return nil, err
+			return err
 		}
-		if c.Parent == "" {
-			if start == "" {
-				return chain, nil
+		for _, parent := range c.Parents {
+			if err := visit(parent); err != nil {
+				return err
 			}
-			break
 		}
-		curr = c.Parent
+		if !c.IsMerge() {
+			chain = append(chain, id)
+		}
+		return nil
 	}
-	for i, j := 0, len(chain)-1; i < j; i, j = i+1, j-1 {
-		chain[i], chain[j] = chain[j], chain[i]
+	if err := visit(end); err != nil {
+		return nil, err
 	}
 	return chain, nil
 }
@@ -550,16 +509,8 @@ func amendCommitMessage(commitID, newVal string) error {
 	if err != nil {
 		return err
 	}
-	parentBlock := ""
-	if c.Parent != "" {
-		parentBlock = "parent " + c.Parent + "\n"
-	}
-	content := fmt.Sprintf("tree %s\n%s\n%s", c.TreeHash, parentBlock, newVal)
-	newHash, err := saveObject([]byte(content))
-	if err != nil {
-		return err
-	}
-	return UpdateBranchPointer(newHash)
+	c.Message = newVal
+	return saveAmendedCommit(c, "rebase (reword): ")
 }
 
 // amendCommit creates a new commit with the same tree and parent as prevHead but with newMsg
@@ -569,29 +520,26 @@ func amendCommit(prevHead models.Commit, newMsg string) error {
 	if err != nil {
 		return err
 	}
-	parentBlock := ""
-	if prevHead.Parent != "" {
-		parentBlock = "parent " + prevHead.Parent + "\n"
-	}
-	content := fmt.Sprintf("tree %s\n%s\n%s", treeHash, parentBlock, newMsg)
-	newHash, err := saveObject([]byte(content))
-	if err != nil {
-		return err
-	}
-	return UpdateBranchPointer(newHash)
+	prevHead.TreeHash = treeHash
+	prevHead.Message = newMsg
+	return saveAmendedCommit(prevHead, "rebase (squash): ")
 }
 
-// saveObject saves the given content as an object and returns its hash
-func saveObject(content []byte) (string, error) {
-	h := sha1.New()
-	h.Write(content)
-	hash := fmt.Sprintf("%x", h.Sum(nil))
-	objPath := filepath.Join(".kitcat", "objects", hash)
-	if err := os.MkdirAll(filepath.Dir(objPath), 0o755); err != nil {
-		return "", err
+// saveAmendedCommit stores c as a new commit object and moves the current branch to it,
+// logging reason followed by the subject. Any old signature is dropped, and the commit
+// re-signed if commit.gpgsign asks for it.
+func saveAmendedCommit(c models.Commit, reason string) error {
+	c.Signature = ""
+	c.ID = storage.HashCommit(c)
+	if sign, err := signByDefault(); err != nil {
+		return err
+	} else if sign {
+		if err := signCommit(&c); err != nil {
+			return err
+		}
 	}
-	if err := os.WriteFile(objPath, content, 0o644); err != nil {
-		return "", err
+	if err := storage.AppendCommit(c); err != nil {
+		return err
 	}
-	return hash, nil
+	return UpdateBranchPointer(c.ID, reason+subjectLine(c.Message))
 }
@@ -1,8 +1,13 @@
 package core
 
 import (
+	"fmt"
 	"reflect"
 	"testing"
+	"time"
+
+	"github.com/LeeFred3042U/kitcat/internal/models"
+	"github.com/LeeFred3042U/kitcat/internal/storage"
 )
 
 func TestParseTodo(t *testing.T) {
@@ -53,3 +58,37 @@ func TestParseTodo(t *testing.T) {
 		})
 	}
 }
+
+func TestGetCommitsBetween_SkipsMerges(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	n := 0
+	commit := func(parents ...string) string {
+		n++
+		c := models.Commit{
+			Parents:   parents,
+			TreeHash:  "4b825dc642cb6eb9a060e54bf8d69288fbee4904",
+			Message:   fmt.Sprintf("c%d", n),
+			Timestamp: time.Unix(1700000000-int64(n), 0).UTC(), // clock running backwards
+		}
+		if err := storage.AppendCommit(c); err != nil {
+			t.Fatal(err)
+		}
+		return storage.HashCommit(c)
+	}
+
+	base := commit()
+	a := commit(base)
+	b := commit(base)
+	merge := commit(a, b)
+	tip := commit(merge)
+
+	got, err := getCommitsBetween(base, tip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{a, b, tip}; !reflect.DeepEqual(got, want) {
+		t.Errorf("getCommitsBetween = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,207 @@
+package core
+
+import (
+	"bufio"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/LeeFred3042U/kitcat/internal/storage"
+)
+
+// DefaultReflogExpiry is how long reflog expire keeps entries unless told otherwise
+const DefaultReflogExpiry = 90 * 24 * time.Hour
+
+// reflogEntry is one line of a reflog: a move of the ref from Old to New
+type reflogEntry struct {
+	Old     string
+	New     string
+	Time    time.Time
+	Message string
+	line    string
+}
+
+// readReflog returns the entries of ref's reflog, oldest first. A ref that has never
+// moved has no entries.
+func readReflog(ref string) ([]reflogEntry, error) {
+	f, err := os.Open(filepath.Join(LogsDir, filepath.FromSlash(ref)))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []reflogEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		header, message, _ := strings.Cut(line, "\t")
+		fields := strings.Fields(header)
+		if len(fields) < 4 {
+			continue
+		}
+		entry := reflogEntry{Old: fields[0], New: fields[1], Message: message, line: line}
+		// The identity may contain spaces; the time and zone are always the last two fields
+		if unix, err := strconv.ParseInt(fields[len(fields)-2], 10, 64); err == nil {
+			entry.Time = time.Unix(unix, 0)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// reflogRef finds the ref whose reflog name refers to, looking it up like readRef.
+// An empty name means the current branch, or HEAD when it is detached.
+func reflogRef(name string) (string, error) {
+	switch name {
+	case "HEAD":
+		return "HEAD", nil
+	case "":
+		if ref, err := readHEAD(); err == nil {
+			return ref, nil
+		}
+		return "HEAD", nil
+	}
+	for _, ref := range refCandidates(name) {
+		if _, err := os.Stat(filepath.Join(LogsDir, filepath.FromSlash(ref))); err == nil {
+			return ref, nil
+		}
+		if refExists(ref) {
+			return ref, nil
+		}
+	}
+	return "", fmt.Errorf("unknown ref %q", name)
+}
+
+// parseReflogSelector splits "name@{n}" into name and n, reporting false for anything
+// else, including "@{-n}"
+func parseReflogSelector(rev string) (string, int, bool) {
+	if !strings.HasSuffix(rev, "}") {
+		return "", 0, false
+	}
+	at := strings.LastIndex(rev, "@{")
+	if at < 0 {
+		return "", 0, false
+	}
+	n, err := strconv.Atoi(rev[at+2 : len(rev)-1])
+	if err != nil || n < 0 || strings.HasPrefix(rev[at+2:], "-") {
+		return "", 0, false
+	}
+	return rev[:at], n, true
+}
+
+// resolveReflogEntry returns where name pointed n moves ago. stash@{n} names the nth
+// entry of the stash stack instead.
+func resolveReflogEntry(name string, n int) (string, error) {
+	if name == "stash" || name == "refs/stash" {
+		stashes, err := storage.ListStashes()
+		if err != nil {
+			return "", err
+		}
+		if n >= len(stashes) {
+			return "", fmt.Errorf("stash@{%d}: only %d stash entr%s", n, len(stashes), entrySuffix(len(stashes)))
+		}
+		return stashes[n], nil
+	}
+
+	ref, err := reflogRef(name)
+	if err != nil {
+		return "", err
+	}
+	entries, err := readReflog(ref)
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case n < len(entries):
+		return entries[len(entries)-1-n].New, nil
+	case n == len(entries) && n > 0 && entries[0].Old != zeroHash:
+		return entries[0].Old, nil
+	}
+	return "", fmt.Errorf("log for '%s' only has %d entr%s", strings.TrimPrefix(ref, "refs/heads/"), len(entries), entrySuffix(len(entries)))
+}
+
+func entrySuffix(n int) string {
+	if n == 1 {
+		return "y"
+	}
+	return "ies"
+}
+
+// ShowReflog prints the reflog of the named ref, newest first, as
+// "<hash> <name>@{n}: <reason>". An empty name shows HEAD's.
+func ShowReflog(name string) error {
+	if name == "" {
+		name = "HEAD"
+	}
+	ref, err := reflogRef(name)
+	if err != nil {
+		return err
+	}
+	entries, err := readReflog(ref)
+	if err != nil {
+		return err
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		e := entries[i]
+		fmt.Printf("%s%s%s %s@{%d}: %s\n", colorYellow, shortHash(e.New), colorReset, name, len(entries)-1-i, e.Message)
+	}
+	return nil
+}
+
+// ExpireReflog drops the entries older than expire from the reflogs of the named refs,
+// or from every reflog if none are named
+func ExpireReflog(expire time.Time, names []string) error {
+	var refs []string
+	if len(names) == 0 {
+		err := filepath.WalkDir(LogsDir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+			rel, err := filepath.Rel(LogsDir, path)
+			if err != nil {
+				return err
+			}
+			refs = append(refs, filepath.ToSlash(rel))
+			return nil
+		})
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	for _, name := range names {
+		ref, err := reflogRef(name)
+		if err != nil {
+			return err
+		}
+		refs = append(refs, ref)
+	}
+
+	removed := 0
+	for _, ref := range refs {
+		entries, err := readReflog(ref)
+		if err != nil {
+			return err
+		}
+		var kept strings.Builder
+		for _, e := range entries {
+			if e.Time.Before(expire) {
+				removed++
+				continue
+			}
+			kept.WriteString(e.line + "\n")
+		}
+		path := filepath.Join(LogsDir, filepath.FromSlash(ref))
+		if err := SafeWrite(path, []byte(kept.String()), 0o644); err != nil {
+			return fmt.Errorf("failed to rewrite reflog of %s: %w", ref, err)
+		}
+	}
+	fmt.Printf("Expired %d reflog entr%s\n", removed, entrySuffix(removed))
+	return nil
+}
@@ -0,0 +1,112 @@
+package core
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestReflog_RecordsRefUpdates(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	first := commitFiles(t, "first", map[string]string{"a.txt": "one\n"}).ID
+	second := commitFiles(t, "second", map[string]string{"a.txt": "two\n"}).ID
+	if err := CreateBranch("topic"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ResetHard("HEAD~1"); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := readReflog("refs/heads/main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The fixture's main points at no real commit, so the first one is a root
+	wantMessages := []string{"commit (initial): first", "commit: second", "reset: moving to HEAD~1"}
+	if len(entries) != len(wantMessages) {
+		t.Fatalf("main reflog has %d entries, want %d", len(entries), len(wantMessages))
+	}
+	for i, msg := range wantMessages {
+		if entries[i].Message != msg {
+			t.Errorf("entry %d: message %q, want %q", i, entries[i].Message, msg)
+		}
+	}
+	if entries[2].Old != second || entries[2].New != first {
+		t.Errorf("reset entry moved %s -> %s, want %s -> %s", entries[2].Old, entries[2].New, second, first)
+	}
+	if head, _ := readReflog("HEAD"); len(head) != 3 {
+		t.Errorf("HEAD reflog has %d entries, want 3", len(head))
+	}
+
+	tests := map[string]string{
+		"HEAD@{0}":   first,
+		"HEAD@{1}":   second,
+		"main@{1}":   second,
+		"@{1}":       second,
+		"main@{2}":   first,
+		"topic@{0}":  second,
+		"HEAD@{1}~1": first,
+	}
+	for rev, want := range tests {
+		got, err := ResolveRevision(rev)
+		if err != nil {
+			t.Errorf("ResolveRevision(%q) failed: %v", rev, err)
+		} else if got != want {
+			t.Errorf("ResolveRevision(%q) = %s, want %s", rev, got, want)
+		}
+	}
+	if _, err := ResolveRevision("topic@{5}"); err == nil {
+		t.Error("ResolveRevision past the end of the reflog succeeded")
+	}
+
+	if err := ExpireReflog(time.Now().Add(time.Hour), []string{"main"}); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := readReflog("refs/heads/main"); len(entries) != 0 {
+		t.Errorf("expire left %d entries in the main reflog", len(entries))
+	}
+	if entries, _ := readReflog("HEAD"); len(entries) != 3 {
+		t.Errorf("expiring main touched the HEAD reflog: %d entries left", len(entries))
+	}
+}
+
+func TestReflog_BranchRenameAndDelete(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	if err := AddFile("dummy.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := Commit("first"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := CreateBranch("old"); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckoutBranch("old"); err != nil {
+		t.Fatal(err)
+	}
+	if err := RenameCurrentBranch("new"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(LogsDir + "/refs/heads/old"); !os.IsNotExist(err) {
+		t.Error("reflog of the renamed branch left behind")
+	}
+	entries, err := readReflog("refs/heads/new")
+	if err != nil || len(entries) != 2 {
+		t.Fatalf("renamed branch reflog: %d entries, err %v; want 2", len(entries), err)
+	}
+
+	if err := CheckoutBranch("main"); err != nil {
+		t.Fatal(err)
+	}
+	if err := DeleteBranch("new"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(LogsDir + "/refs/heads/new"); !os.IsNotExist(err) {
+		t.Error("reflog of the deleted branch left behind")
+	}
+}
@@ -0,0 +1,358 @@
+package core
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/LeeFred3042U/kitcat/internal/storage"
+)
+
+// zeroHash stands in a reflog entry for a ref that did not exist
+const zeroHash = "0000000000000000000000000000000000000000"
+
+// listRefs reads every ref, packed or loose, and returns each one's target keyed by its
+// name relative to .kitcat, e.g. "refs/heads/main". Branches without commits map to "".
+func listRefs() (map[string]string, error) {
+	refs, err := readPackedRefs()
+	if err != nil {
+		return nil, err
+	}
+	// Loose refs are newer than their packed copies
+	err = filepath.WalkDir(RefsDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || strings.HasSuffix(path, ".lock") || isTempFileName(d.Name()) {
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		name, err := filepath.Rel(RepoDir, path)
+		if err != nil {
+			return err
+		}
+		refs[filepath.ToSlash(name)] = strings.TrimSpace(string(data))
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return refs, nil
+	}
+	return refs, err
+}
+
+// listRefNames returns the names of the refs under prefix, such as "refs/heads/",
+// with the prefix removed, in sorted order
+func listRefNames(prefix string) ([]string, error) {
+	refs, err := listRefs()
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for ref := range refs {
+		if name, ok := strings.CutPrefix(ref, prefix); ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// lookupRef returns the target of ref, such as "refs/heads/main", reading its loose file
+// and falling back to packed-refs. It reports false if the ref does not exist.
+func lookupRef(ref string) (string, bool, error) {
+	// Refs of a legacy repository still name the old commit IDs until it is migrated
+	if err := storage.MigrateLegacyCommitLog(); err != nil {
+		return "", false, err
+	}
+	data, err := os.ReadFile(filepath.Join(RepoDir, filepath.FromSlash(ref)))
+	if err == nil {
+		return strings.TrimSpace(string(data)), true, nil
+	}
+	if !os.IsNotExist(err) && !isDirError(err) {
+		return "", false, err
+	}
+	packed, err := readPackedRefs()
+	if err != nil {
+		return "", false, err
+	}
+	target, ok := packed[ref]
+	return target, ok, nil
+}
+
+// isDirError reports whether err came from reading a directory as a file, as happens when
+// looking up "refs/heads/feature" while only "refs/heads/feature/login" exists
+func isDirError(err error) bool {
+	var pathErr *fs.PathError
+	if !errors.As(err, &pathErr) {
+		return false
+	}
+	info, statErr := os.Stat(pathErr.Path)
+	return statErr == nil && info.IsDir()
+}
+
+// refExists reports whether ref exists, loose or packed
+func refExists(ref string) bool {
+	_, ok, err := lookupRef(ref)
+	return ok && err == nil
+}
+
+// refNameConflict returns an existing ref that would have to be both a file and a directory
+// alongside ref, such as "refs/heads/feature" for "refs/heads/feature/login"
+func refNameConflict(ref string) (string, bool, error) {
+	refs, err := listRefs()
+	if err != nil {
+		return "", false, err
+	}
+	for existing := range refs {
+		if strings.HasPrefix(ref, existing+"/") || strings.HasPrefix(existing, ref+"/") {
+			return existing, true, nil
+		}
+	}
+	return "", false, nil
+}
+
+// writeLooseRef points ref at hash in its own file under .kitcat/refs
+func writeLooseRef(ref, hash string) error {
+	path := filepath.Join(RepoDir, filepath.FromSlash(ref))
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return SafeWrite(path, []byte(hash), 0o644)
+}
+
+// deleteRef removes ref from both its loose file and packed-refs, along with its reflog
+func deleteRef(ref string) error {
+	if !refExists(ref) {
+		return fmt.Errorf("ref %s not found", ref)
+	}
+	path := filepath.Join(RepoDir, filepath.FromSlash(ref))
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	removeEmptyDirs(filepath.Dir(path), RefsDir)
+
+	packed, err := readPackedRefs()
+	if err != nil {
+		return err
+	}
+	if _, ok := packed[ref]; ok {
+		delete(packed, ref)
+		if err := writePackedRefs(packed); err != nil {
+			return err
+		}
+	}
+
+	logPath := filepath.Join(LogsDir, filepath.FromSlash(ref))
+	os.Remove(logPath)
+	removeEmptyDirs(filepath.Dir(logPath), LogsDir)
+	return nil
+}
+
+// removeEmptyDirs removes dir and then its parents while they are empty, so that a deleted
+// "feature/login" branch does not block a future "feature" branch. It leaves stop and the
+// namespaces directly inside it, such as refs/heads, in place.
+func removeEmptyDirs(dir, stop string) {
+	stop = filepath.Clean(stop)
+	for dir = filepath.Clean(dir); strings.HasPrefix(dir, stop+string(filepath.Separator)) && filepath.Dir(dir) != stop; dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			return
+		}
+	}
+}
+
+// readPackedRefs parses .kitcat/packed-refs, which lists one "<hash> <ref>" per line
+// after an optional "#" header. A missing file means no packed refs.
+func readPackedRefs() (map[string]string, error) {
+	refs := make(map[string]string)
+	data, err := os.ReadFile(PackedRefsPath)
+	if os.IsNotExist(err) {
+		return refs, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for i, line := range strings.Split(string(data), "\n") {
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "^") {
+			continue
+		}
+		hash, ref, ok := strings.Cut(line, " ")
+		if !ok || hash == "" || !strings.HasPrefix(ref, "refs/") {
+			return nil, fmt.Errorf("packed-refs line %d is malformed: %q", i+1, line)
+		}
+		refs[ref] = hash
+	}
+	return refs, nil
+}
+
+// writePackedRefs replaces packed-refs with refs, sorted by name, removing the file
+// when there is nothing left to pack
+func writePackedRefs(refs map[string]string) error {
+	if len(refs) == 0 {
+		if err := os.Remove(PackedRefsPath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	var b strings.Builder
+	b.WriteString("# pack-refs with: sorted\n")
+	for _, ref := range sortedKeys(refs) {
+		fmt.Fprintf(&b, "%s %s\n", refs[ref], ref)
+	}
+	return SafeWrite(PackedRefsPath, []byte(b.String()), 0o644)
+}
+
+// PackRefs moves tags, and with all set branches too, from their own files into
+// packed-refs. Branches without commits stay loose.
+func PackRefs(all bool) error {
+	refs, err := listRefs()
+	if err != nil {
+		return err
+	}
+	packed, err := readPackedRefs()
+	if err != nil {
+		return err
+	}
+	var loose []string
+	for ref, target := range refs {
+		if target == "" || (!all && !strings.HasPrefix(ref, "refs/tags/")) {
+			continue
+		}
+		if packed[ref] != target || refHasLooseFile(ref) {
+			packed[ref] = target
+			loose = append(loose, ref)
+		}
+	}
+	if err := writePackedRefs(packed); err != nil {
+		return fmt.Errorf("failed to write packed-refs: %w", err)
+	}
+	// Only drop the loose files once packed-refs holds their targets
+	for _, ref := range loose {
+		path := filepath.Join(RepoDir, filepath.FromSlash(ref))
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		removeEmptyDirs(filepath.Dir(path), RefsDir)
+	}
+	fmt.Printf("Packed %d ref%s\n", len(loose), pluralize(len(loose)))
+	return nil
+}
+
+func refHasLooseFile(ref string) bool {
+	info, err := os.Stat(filepath.Join(RepoDir, filepath.FromSlash(ref)))
+	return err == nil && !info.IsDir()
+}
+
+// appendReflog records that ref moved from oldHash to newHash in .kitcat/logs/<ref>,
+// as "<old> <new> <name> <<email>> <time> <zone>\t<message>"
+func appendReflog(ref, oldHash, newHash, message string) error {
+	if oldHash == "" {
+		oldHash = zeroHash
+	}
+	name, email := userIdentity()
+	now := time.Now()
+	line := fmt.Sprintf("%s %s %s <%s> %d %s\t%s\n", oldHash, newHash, name, email, now.Unix(), now.Format("-0700"), message)
+
+	path := filepath.Join(LogsDir, filepath.FromSlash(ref))
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.WriteString(line)
+	return err
+}
+
+// updateRef points ref, such as "refs/heads/main" or a detached "HEAD", at newHash and
+// records the move with reason in its reflog
+func updateRef(ref, newHash, reason string) error {
+	oldHash := ""
+	if ref == "HEAD" {
+		oldHash, _ = readHead()
+	} else if target, _, err := lookupRef(ref); err == nil {
+		oldHash = target
+	}
+	if err := writeLooseRef(ref, newHash); err != nil {
+		return err
+	}
+	logRefUpdate(ref, oldHash, newHash, reason)
+	return nil
+}
+
+// logRefUpdate records a move of ref in its reflog and, when HEAD is on ref, in HEAD's too.
+// The reflog is a convenience, so failing to write it does not fail the update.
+func logRefUpdate(ref, oldHash, newHash, reason string) {
+	_ = appendReflog(ref, oldHash, newHash, reason)
+	if ref == "HEAD" {
+		return
+	}
+	if data, err := os.ReadFile(HeadPath); err == nil && strings.TrimSpace(string(data)) == "ref: "+ref {
+		_ = appendReflog("HEAD", oldHash, newHash, reason)
+	}
+}
+
+// headName is what a checkout reflog entry calls the current HEAD: the branch it is
+// on, or the commit it is detached at
+func headName() string {
+	data, err := os.ReadFile(HeadPath)
+	if err != nil {
+		return ""
+	}
+	ref := strings.TrimSpace(string(data))
+	if target, ok := strings.CutPrefix(ref, "ref: "); ok {
+		return strings.TrimPrefix(target, "refs/heads/")
+	}
+	return ref
+}
+
+// logCheckout records a switch of HEAD to target in the HEAD reflog
+func logCheckout(from, oldHash, target, newHash string) {
+	_ = appendReflog("HEAD", oldHash, newHash, fmt.Sprintf("checkout: moving from %s to %s", from, target))
+}
+
+// previousCheckout returns the branch or commit HEAD was on before the nth most recent
+// checkout, as recorded in the HEAD reflog
+func previousCheckout(n int) (string, error) {
+	f, err := os.Open(filepath.Join(LogsDir, "HEAD"))
+	if os.IsNotExist(err) {
+		return "", fmt.Errorf("no previous checkout")
+	}
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	var from []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		_, message, ok := strings.Cut(scanner.Text(), "\t")
+		if !ok {
+			continue
+		}
+		rest, ok := strings.CutPrefix(message, "checkout: moving from ")
+		if !ok {
+			continue
+		}
+		if name, _, ok := strings.Cut(rest, " to "); ok {
+			from = append(from, name)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	if n > len(from) {
+		return "", fmt.Errorf("only %d checkout%s found in the reflog", len(from), pluralize(len(from)))
+	}
+	return from[len(from)-n], nil
+}
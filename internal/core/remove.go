@@ -13,6 +13,18 @@ func RemoveFile(filename string) error {
 	if !IsSafePath(filename) {
 		return fmt.Errorf("unsafe path detected: %s", filename)
 	}
+	// Removing a conflicted path resolves the conflict as a deletion
+	unmerged, err := storage.LoadUnmergedEntries()
+	if err != nil {
+		return err
+	}
+	if _, ok := unmerged[filename]; ok {
+		if err := os.Remove(filename); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return storage.ResolveUnmerged(filename)
+	}
+
 	// Use UpdateIndex to safely update the index transactionally
 	return storage.UpdateIndex(func(index map[string]string) error {
 		// First, verify the file exists in the index
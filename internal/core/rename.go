@@ -0,0 +1,375 @@
+package core
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/LeeFred3042U/kitcat/internal/diff"
+	"github.com/LeeFred3042U/kitcat/internal/storage"
+)
+
+// DefaultRenameThreshold is how similar, in percent, two files must be to pair them as a
+// rename or copy unless diff.renamethreshold or a flag says otherwise
+const DefaultRenameThreshold = 50
+
+// maxRenamePairs caps how many pairs of files are compared line by line when looking for
+// renames or copies that changed content; exact matches are always found
+const maxRenamePairs = 10000
+
+// RenameOptions controls how deleted and added files are paired up
+type RenameOptions struct {
+	Renames   bool // pair each deleted file with a similar added one
+	Copies    bool // also pair added files with similar files that still exist
+	Threshold int  // the least similarity, in percent, for a pair
+}
+
+// DefaultRenameOptions returns the options comparisons start from. diff.renames turns
+// detection on ("true", the default), off ("false") or extends it to copies ("copies"),
+// and diff.renamethreshold sets the threshold, such as "60" or "60%".
+func DefaultRenameOptions() (RenameOptions, error) {
+	opts := RenameOptions{Renames: true, Threshold: DefaultRenameThreshold}
+	if value, found, err := GetConfig("diff.renames"); err != nil {
+		return opts, err
+	} else if found {
+		switch value {
+		case "true":
+		case "false":
+			opts.Renames = false
+		case "copies", "copy":
+			opts.Copies = true
+		default:
+			return opts, fmt.Errorf("invalid diff.renames value %q (expected true, false or copies)", value)
+		}
+	}
+	if value, found, err := GetConfig("diff.renamethreshold"); err != nil {
+		return opts, err
+	} else if found {
+		threshold, err := parseThreshold(value)
+		if err != nil {
+			return opts, fmt.Errorf("diff.renamethreshold: %w", err)
+		}
+		opts.Threshold = threshold
+	}
+	return opts, nil
+}
+
+// ParseFlags applies rename detection flags (-M[<n>], -C[<n>], --find-renames[=<n>],
+// --find-copies[=<n>] and --no-renames) in order and returns the other arguments untouched
+func (o *RenameOptions) ParseFlags(args []string) ([]string, error) {
+	var rest []string
+	for _, arg := range args {
+		var threshold string
+		switch {
+		case arg == "--no-renames":
+			o.Renames, o.Copies = false, false
+			continue
+		case strings.HasPrefix(arg, "-M"):
+			o.Renames, threshold = true, arg[2:]
+		case arg == "--find-renames" || strings.HasPrefix(arg, "--find-renames="):
+			o.Renames, threshold = true, strings.TrimPrefix(strings.TrimPrefix(arg, "--find-renames"), "=")
+		case strings.HasPrefix(arg, "-C"):
+			o.Renames, o.Copies, threshold = true, true, arg[2:]
+		case arg == "--find-copies" || strings.HasPrefix(arg, "--find-copies="):
+			o.Renames, o.Copies, threshold = true, true, strings.TrimPrefix(strings.TrimPrefix(arg, "--find-copies"), "=")
+		default:
+			rest = append(rest, arg)
+			continue
+		}
+		if threshold != "" {
+			n, err := parseThreshold(threshold)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", arg, err)
+			}
+			o.Threshold = n
+		}
+	}
+	return rest, nil
+}
+
+// parseThreshold reads a similarity percentage, with or without a trailing %
+func parseThreshold(value string) (int, error) {
+	n, err := strconv.Atoi(strings.TrimSuffix(value, "%"))
+	if err != nil || n < 0 || n > 100 {
+		return 0, fmt.Errorf("invalid similarity threshold %q (expected 0 to 100)", value)
+	}
+	return n, nil
+}
+
+// fileChange is how one file differs between two snapshots. Added files have no Old
+// entry and deleted ones no New entry; renames and copies have both, under different paths.
+type fileChange struct {
+	Status  byte // 'A'dded, 'D'eleted, 'M'odified, 'T'ype changed, 'R'enamed or 'C'opied
+	OldPath string
+	Path    string
+	Old     *storage.IndexEntry
+	New     *storage.IndexEntry
+	Score   int // similarity of a rename or copy, in percent
+}
+
+// compareSnapshots lists the files that differ from old to new, sorted by path, pairing
+// deleted and added files into renames, and added files with their sources into copies,
+// as opts allows. Copies may come from any file in old. Content is read from blobs.
+func compareSnapshots(old, new map[string]storage.IndexEntry, blobs blobSource, opts RenameOptions) ([]fileChange, error) {
+	var changes, added, deleted []fileChange
+	for path, o := range old {
+		n, ok := new[path]
+		switch {
+		case !ok:
+			deleted = append(deleted, fileChange{Status: 'D', OldPath: path, Path: path, Old: &o})
+		case !o.SameFile(n):
+			status := byte('M')
+			if (o.Mode == storage.ModeSymlink) != (n.Mode == storage.ModeSymlink) {
+				status = 'T'
+			}
+			changes = append(changes, fileChange{Status: status, OldPath: path, Path: path, Old: &o, New: &n})
+		}
+	}
+	for path, n := range new {
+		if _, ok := old[path]; !ok {
+			added = append(added, fileChange{Status: 'A', Path: path, New: &n})
+		}
+	}
+
+	if opts.Renames && len(added) > 0 {
+		sortChanges(added)
+		sortChanges(deleted)
+		d := renameDetector{blobs: blobs, lines: make(map[string][]string)}
+		var paired []fileChange
+		var err error
+		if paired, added, deleted, err = d.pairRenames(added, deleted, opts.Threshold); err != nil {
+			return nil, err
+		}
+		changes = append(changes, paired...)
+		if opts.Copies {
+			if paired, added, err = d.pairCopies(added, old, opts.Threshold); err != nil {
+				return nil, err
+			}
+			changes = append(changes, paired...)
+		}
+	}
+	changes = append(append(changes, added...), deleted...)
+	sortChanges(changes)
+	return changes, nil
+}
+
+// treeChanges is compareSnapshots for two trees, either of which may be empty
+func treeChanges(oldTree, newTree string, opts RenameOptions) ([]fileChange, error) {
+	if opts.Copies {
+		// Any file in the old tree may be a copy's source, changed or not
+		old, err := treeSnapshot(oldTree)
+		if err != nil {
+			return nil, err
+		}
+		new, err := treeSnapshot(newTree)
+		if err != nil {
+			return nil, err
+		}
+		return compareSnapshots(old, new, nil, opts)
+	}
+
+	diffs, err := storage.DiffTrees(oldTree, newTree)
+	if err != nil {
+		return nil, err
+	}
+	old := make(map[string]storage.IndexEntry)
+	new := make(map[string]storage.IndexEntry)
+	for _, d := range diffs {
+		if d.OldHash != "" {
+			old[d.Path] = storage.IndexEntry{Hash: d.OldHash, Mode: d.OldMode}
+		}
+		if d.NewHash != "" {
+			new[d.Path] = storage.IndexEntry{Hash: d.NewHash, Mode: d.NewMode}
+		}
+	}
+	return compareSnapshots(old, new, nil, opts)
+}
+
+// treeSnapshot lists the files of a tree, or none for an empty hash
+func treeSnapshot(tree string) (map[string]storage.IndexEntry, error) {
+	if tree == "" {
+		return make(map[string]storage.IndexEntry), nil
+	}
+	return storage.ParseTreeEntries(tree)
+}
+
+func sortChanges(changes []fileChange) {
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+}
+
+// renameDetector scores pairs of files, keeping each blob's lines for the next comparison
+type renameDetector struct {
+	blobs blobSource
+	lines map[string][]string
+}
+
+// pairRenames pairs added files with deleted ones, exact matches first and then the most
+// similar pairs at or above threshold. It returns the renames and what is left unpaired.
+func (d *renameDetector) pairRenames(added, deleted []fileChange, threshold int) ([]fileChange, []fileChange, []fileChange, error) {
+	var renames []fileChange
+	usedAdded := make([]bool, len(added))
+	usedDeleted := make([]bool, len(deleted))
+	pair := func(a, del, score int) {
+		usedAdded[a], usedDeleted[del] = true, true
+		renames = append(renames, fileChange{
+			Status: 'R', OldPath: deleted[del].OldPath, Path: added[a].Path,
+			Old: deleted[del].Old, New: added[a].New, Score: score,
+		})
+	}
+
+	byHash := make(map[string][]int)
+	for i, del := range deleted {
+		byHash[del.Old.Hash] = append(byHash[del.Old.Hash], i)
+	}
+	for a, add := range added {
+		for _, del := range byHash[add.New.Hash] {
+			if !usedDeleted[del] && sameKind(*deleted[del].Old, *add.New) {
+				pair(a, del, 100)
+				break
+			}
+		}
+	}
+
+	if len(added)*len(deleted) <= maxRenamePairs {
+		type candidate struct{ added, deleted, score int }
+		var candidates []candidate
+		for a := range added {
+			if usedAdded[a] {
+				continue
+			}
+			for del := range deleted {
+				if usedDeleted[del] {
+					continue
+				}
+				score, err := d.similarity(*deleted[del].Old, *added[a].New)
+				if err != nil {
+					return nil, nil, nil, err
+				}
+				if score >= threshold {
+					candidates = append(candidates, candidate{a, del, score})
+				}
+			}
+		}
+		// Best pairs first; ties go to the earliest paths, as the lists are sorted
+		sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].score > candidates[j].score })
+		for _, c := range candidates {
+			if !usedAdded[c.added] && !usedDeleted[c.deleted] {
+				pair(c.added, c.deleted, c.score)
+			}
+		}
+	}
+
+	var restAdded, restDeleted []fileChange
+	for i, c := range added {
+		if !usedAdded[i] {
+			restAdded = append(restAdded, c)
+		}
+	}
+	for i, c := range deleted {
+		if !usedDeleted[i] {
+			restDeleted = append(restDeleted, c)
+		}
+	}
+	return renames, restAdded, restDeleted, nil
+}
+
+// pairCopies pairs each added file with the most similar file of sources, if one reaches
+// threshold. It returns the copies and the added files that are still unpaired.
+func (d *renameDetector) pairCopies(added []fileChange, sources map[string]storage.IndexEntry, threshold int) ([]fileChange, []fileChange, error) {
+	paths := sortedKeys(sources)
+	compareAll := len(added)*len(paths) <= maxRenamePairs
+
+	var copies, rest []fileChange
+	for _, add := range added {
+		best, bestScore := "", -1
+		for _, path := range paths {
+			src := sources[path]
+			if src.Hash != add.New.Hash && !compareAll {
+				continue
+			}
+			score, err := d.similarity(src, *add.New)
+			if err != nil {
+				return nil, nil, err
+			}
+			if score >= threshold && score > bestScore {
+				best, bestScore = path, score
+			}
+		}
+		if best == "" {
+			rest = append(rest, add)
+			continue
+		}
+		src := sources[best]
+		copies = append(copies, fileChange{Status: 'C', OldPath: best, Path: add.Path, Old: &src, New: add.New, Score: bestScore})
+	}
+	return copies, rest, nil
+}
+
+// similarity scores how alike two files are. A file never pairs with a symlink, and
+// binary files only pair when identical.
+func (d *renameDetector) similarity(a, b storage.IndexEntry) (int, error) {
+	if !sameKind(a, b) {
+		return 0, nil
+	}
+	if a.Hash == b.Hash {
+		return 100, nil
+	}
+	aLines, err := d.blobLines(a.Hash)
+	if err != nil {
+		return 0, err
+	}
+	bLines, err := d.blobLines(b.Hash)
+	if err != nil {
+		return 0, err
+	}
+	if aLines == nil || bLines == nil {
+		return 0, nil
+	}
+	return diff.Similarity(aLines, bLines), nil
+}
+
+// blobLines returns a blob's lines, or nil for binary content
+func (d *renameDetector) blobLines(hash string) ([]string, error) {
+	if lines, ok := d.lines[hash]; ok {
+		return lines, nil
+	}
+	content, err := d.blobs.read(hash)
+	if err != nil {
+		return nil, err
+	}
+	var lines []string
+	if !isBinary(content) {
+		lines = splitLines(content)
+		if lines == nil {
+			lines = []string{}
+		}
+	}
+	d.lines[hash] = lines
+	return lines, nil
+}
+
+// blobSource reads file content by blob hash. It holds the content of working tree
+// files, which are not in the object store, and reads everything else from the store.
+type blobSource map[string][]byte
+
+func (s blobSource) read(hash string) ([]byte, error) {
+	if content, ok := s[hash]; ok {
+		return content, nil
+	}
+	_, content, err := storage.ReadObject(hash)
+	return content, err
+}
+
+// entry reads the content of the file e refers to, or nothing for a nil entry
+func (s blobSource) entry(e *storage.IndexEntry) ([]byte, error) {
+	if e == nil {
+		return nil, nil
+	}
+	return s.read(e.Hash)
+}
+
+// sameKind reports whether two entries are both symlinks or both files
+func sameKind(a, b storage.IndexEntry) bool {
+	return (a.Mode == storage.ModeSymlink) == (b.Mode == storage.ModeSymlink)
+}
@@ -0,0 +1,124 @@
+package core
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/LeeFred3042U/kitcat/internal/storage"
+)
+
+func TestCompareSnapshots_RenamesAndCopies(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	blob := func(content string) storage.IndexEntry {
+		t.Helper()
+		hash, err := storage.WriteObject(storage.ObjectBlob, []byte(content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return storage.IndexEntry{Hash: hash, Mode: 0o100644}
+	}
+	lines := "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n"
+	old := map[string]storage.IndexEntry{
+		"moved.txt":   blob("moved as is\n"),
+		"edited.txt":  blob(lines),
+		"source.txt":  blob("copied from\n"),
+		"dropped.txt": blob("nothing like the rest\n"),
+	}
+	new := map[string]storage.IndexEntry{
+		"dir/moved.txt": old["moved.txt"],
+		"renamed.txt":   blob(strings.Replace(lines, "5\n", "five\n", 1)),
+		"source.txt":    old["source.txt"],
+		"copy.txt":      old["source.txt"],
+	}
+
+	describe := func(changes []fileChange) []string {
+		var out []string
+		for _, c := range changes {
+			out = append(out, nameStatusLine(c))
+		}
+		return out
+	}
+	check := func(opts RenameOptions, want ...string) {
+		t.Helper()
+		changes, err := compareSnapshots(old, new, nil, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := describe(changes); strings.Join(got, "\n") != strings.Join(want, "\n") {
+			t.Errorf("with %+v got\n%s\nwant\n%s", opts, strings.Join(got, "\n"), strings.Join(want, "\n"))
+		}
+	}
+
+	check(RenameOptions{Renames: true, Threshold: 50},
+		"A\tcopy.txt",
+		"R100\tmoved.txt\tdir/moved.txt",
+		"D\tdropped.txt",
+		"R090\tedited.txt\trenamed.txt",
+	)
+	check(RenameOptions{Renames: true, Threshold: 95},
+		"A\tcopy.txt",
+		"R100\tmoved.txt\tdir/moved.txt",
+		"D\tdropped.txt",
+		"D\tedited.txt",
+		"A\trenamed.txt",
+	)
+	check(RenameOptions{Renames: true, Copies: true, Threshold: 50},
+		"C100\tsource.txt\tcopy.txt",
+		"R100\tmoved.txt\tdir/moved.txt",
+		"D\tdropped.txt",
+		"R090\tedited.txt\trenamed.txt",
+	)
+	check(RenameOptions{},
+		"A\tcopy.txt",
+		"A\tdir/moved.txt",
+		"D\tdropped.txt",
+		"D\tedited.txt",
+		"D\tmoved.txt",
+		"A\trenamed.txt",
+	)
+}
+
+func TestRenameOptions_ParseFlags(t *testing.T) {
+	opts := RenameOptions{Renames: true, Threshold: DefaultRenameThreshold}
+	rest, err := opts.ParseFlags([]string{"--cached", "-M75%", "-C", "main"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(rest, " ") != "--cached main" {
+		t.Errorf("rest = %q", rest)
+	}
+	if !opts.Renames || !opts.Copies || opts.Threshold != 75 {
+		t.Errorf("opts = %+v", opts)
+	}
+	if _, err := opts.ParseFlags([]string{"--no-renames"}); err != nil || opts.Renames || opts.Copies {
+		t.Errorf("--no-renames left %+v, %v", opts, err)
+	}
+	if _, err := opts.ParseFlags([]string{"-M120"}); err == nil {
+		t.Error("a threshold over 100% was accepted")
+	}
+}
+
+func TestCommitSummary_CountsRenames(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	if err := AddFile("dummy.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := Commit("first"); err != nil {
+		t.Fatal(err)
+	}
+	if err := MoveFile("dummy.txt", "moved.txt", false); err != nil {
+		t.Fatal(err)
+	}
+	_, summary, err := Commit("move")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "1 file changed, 0 insertions(+), 0 deletions(-)\n rename dummy.txt => moved.txt (100%)"
+	if summary != want {
+		t.Errorf("summary = %q, want %q", summary, want)
+	}
+}
@@ -1,23 +1,18 @@
 package core
 
-import (
-	"fmt"
-
-	"github.com/LeeFred3042U/kitcat/internal/storage"
-)
+import "fmt"
 
 // ResetHard moves the current branch (or HEAD in detached state) to the specified commit
 // and forcibly updates the working directory and index to match that commit.
 // WARNING: This is a destructive operation that discards all uncommitted changes.
 func ResetHard(commitHash string) error {
-	// Step 1: Validate that the commit exists
-	commit, err := storage.FindCommit(commitHash)
+	// Step 1: Validate that the revision names a commit
+	revision := commitHash
+	commit, err := ResolveCommit(revision)
 	if err != nil {
-		if err == storage.ErrNoCommits {
-			return fmt.Errorf("fatal: invalid commit: %s", commitHash)
-		}
-		return fmt.Errorf("fatal: invalid commit: %s", commitHash)
+		return fmt.Errorf("fatal: invalid commit: %s: %w", commitHash, err)
 	}
+	commitHash = commit.ID
 
 	// Step 2: Save current HEAD for potential rollback
 	oldHeadCommit, err := readHead()
@@ -26,7 +21,7 @@ func ResetHard(commitHash string) error {
 	}
 
 	// Step 3: Update the branch pointer or HEAD
-	if err := UpdateBranchPointer(commitHash); err != nil {
+	if err := UpdateBranchPointer(commitHash, "reset: moving to "+revision); err != nil {
 		return err
 	}
 
@@ -34,7 +29,7 @@ func ResetHard(commitHash string) error {
 	// If this fails, attempt to roll back the branch pointer
 	if err := UpdateWorkspaceAndIndex(commitHash); err != nil {
 		// Attempt rollback
-		_ = UpdateBranchPointer(oldHeadCommit)
+		_ = UpdateBranchPointer(oldHeadCommit, "reset: rolling back to "+oldHeadCommit)
 		return fmt.Errorf("failed to update workspace: %w", err)
 	}
 
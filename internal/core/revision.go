@@ -0,0 +1,329 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/LeeFred3042U/kitcat/internal/models"
+	"github.com/LeeFred3042U/kitcat/internal/storage"
+)
+
+// minAbbrev is the shortest hex prefix accepted as an abbreviated object name, so that
+// short words are not mistaken for hashes
+const minAbbrev = 4
+
+// maxTagDepth bounds chains of tags pointing at tags
+const maxTagDepth = 16
+
+// ResolveRevision turns a revision expression into the hash of the object it names.
+// A revision starts with HEAD (or @), @{-n} for the nth previously checked out branch,
+// a ref such as a branch or tag name, ORIG_HEAD or MERGE_HEAD, or an object hash of at
+// least four hex digits. <ref>@{n} is where the ref pointed n moves ago, according to its
+// reflog; a bare @{n} uses the current branch. Any number of suffixes may follow:
+//
+//	~n       the nth first-parent ancestor (~ alone is ~1)
+//	^n       the nth parent (^ alone is ^1, ^0 is the commit itself)
+//	^{tree}  the commit's tree
+//	^{commit}, ^{}  the commit itself, or the commit an annotated tag points to
+func ResolveRevision(rev string) (string, error) {
+	if rev == "" {
+		return "", fmt.Errorf("empty revision")
+	}
+	base, suffixes := splitRevision(rev)
+	hash, err := resolveRevisionBase(base)
+	if err != nil {
+		return "", err
+	}
+
+	for suffixes != "" {
+		op := suffixes[0]
+		suffixes = suffixes[1:]
+
+		if op == '^' && strings.HasPrefix(suffixes, "{") {
+			end := strings.IndexByte(suffixes, '}')
+			if end < 0 {
+				return "", fmt.Errorf("bad revision %q: unterminated ^{", rev)
+			}
+			peel := suffixes[1:end]
+			suffixes = suffixes[end+1:]
+			commit, err := peelToCommit(hash, rev)
+			if err != nil {
+				return "", err
+			}
+			switch peel {
+			case "tree":
+				hash = commit.TreeHash
+			case "commit", "":
+				hash = commit.ID
+			default:
+				return "", fmt.Errorf("bad revision %q: unknown peel ^{%s}", rev, peel)
+			}
+			continue
+		}
+
+		digits := len(suffixes) - len(strings.TrimLeft(suffixes, "0123456789"))
+		n := 1
+		if digits > 0 {
+			n, err = strconv.Atoi(suffixes[:digits])
+			if err != nil {
+				return "", fmt.Errorf("bad revision %q", rev)
+			}
+			suffixes = suffixes[digits:]
+		}
+
+		commit, err := peelToCommit(hash, rev)
+		if err != nil {
+			return "", err
+		}
+		switch op {
+		case '~':
+			for range n {
+				if len(commit.Parents) == 0 {
+					return "", fmt.Errorf("revision %q goes past the root commit", rev)
+				}
+				if commit, err = storage.FindCommit(commit.FirstParent()); err != nil {
+					return "", err
+				}
+			}
+			hash = commit.ID
+		case '^':
+			if n == 0 {
+				hash = commit.ID
+			} else if n > len(commit.Parents) {
+				return "", fmt.Errorf("revision %q: commit %s has no parent %d", rev, commit.ID[:7], n)
+			} else {
+				hash = commit.Parents[n-1]
+			}
+		default:
+			return "", fmt.Errorf("bad revision %q", rev)
+		}
+	}
+	return hash, nil
+}
+
+// ResolveCommit resolves a revision expression that must name a commit
+func ResolveCommit(rev string) (models.Commit, error) {
+	hash, err := ResolveRevision(rev)
+	if err != nil {
+		return models.Commit{}, err
+	}
+	return peelToCommit(hash, rev)
+}
+
+// splitRevision separates the name a revision starts from and its ~ and ^ suffixes.
+// Braces belong to the name, so "@{-1}" and "main@{2}" stay whole.
+func splitRevision(rev string) (string, string) {
+	depth := 0
+	for i, c := range rev {
+		switch {
+		case c == '{':
+			depth++
+		case c == '}':
+			depth--
+		case depth == 0 && (c == '~' || c == '^'):
+			return rev[:i], rev[i:]
+		}
+	}
+	return rev, ""
+}
+
+// resolveRevisionBase resolves the name a revision expression starts from
+func resolveRevisionBase(name string) (string, error) {
+	switch {
+	case name == "HEAD" || name == "@":
+		hash, err := readHead()
+		if err != nil || hash == "" {
+			return "", fmt.Errorf("HEAD does not point to a commit yet")
+		}
+		return hash, nil
+
+	case strings.HasPrefix(name, "@{-"):
+		n, ok := previousCheckoutNumber(name)
+		if !ok {
+			return "", fmt.Errorf("bad revision %q", name)
+		}
+		previous, err := previousCheckout(n)
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", name, err)
+		}
+		return resolveRevisionBase(previous)
+
+	case strings.HasSuffix(name, "}"):
+		ref, n, ok := parseReflogSelector(name)
+		if !ok {
+			return "", fmt.Errorf("bad revision %q", name)
+		}
+		hash, err := resolveReflogEntry(ref, n)
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", name, err)
+		}
+		return hash, nil
+
+	case name == "ORIG_HEAD" || name == "MERGE_HEAD":
+		data, err := os.ReadFile(filepath.Join(RepoDir, name))
+		if err != nil {
+			return "", fmt.Errorf("unknown revision %q", name)
+		}
+		return strings.TrimSpace(string(data)), nil
+	}
+
+	if target, ok, err := readRef(name); err != nil {
+		return "", err
+	} else if ok {
+		return target, nil
+	}
+
+	if len(name) >= minAbbrev {
+		if hash, err := storage.ResolveObject(name); err == nil {
+			return hash, nil
+		} else if strings.Contains(err.Error(), "ambiguous") {
+			// Prefer the one commit among the candidates, if there is exactly one
+			if c, cerr := storage.FindCommit(name); cerr == nil {
+				return c.ID, nil
+			}
+			return "", err
+		}
+	}
+	return "", fmt.Errorf("unknown revision %q", name)
+}
+
+// previousCheckoutNumber parses "@{-n}", reporting false for anything else
+func previousCheckoutNumber(name string) (int, bool) {
+	digits, ok := strings.CutPrefix(name, "@{-")
+	if !ok {
+		return 0, false
+	}
+	if digits, ok = strings.CutSuffix(digits, "}"); !ok {
+		return 0, false
+	}
+	n, err := strconv.Atoi(digits)
+	return n, err == nil && n >= 1
+}
+
+// readRef looks name up the way git does: as a full ref, then under refs/, refs/tags/
+// and refs/heads/. A ref that exists but has no commit yet is an error.
+func readRef(name string) (string, bool, error) {
+	for _, ref := range refCandidates(name) {
+		target, ok, err := lookupRef(ref)
+		if err != nil {
+			return "", false, err
+		}
+		if !ok {
+			continue
+		}
+		if target == "" {
+			return "", false, fmt.Errorf("%s does not point to a commit yet", name)
+		}
+		// Tags may record an abbreviated hash
+		hash, err := storage.ResolveObject(target)
+		if err != nil {
+			return "", false, fmt.Errorf("%s points to %s: %w", ref, target, err)
+		}
+		return hash, true, nil
+	}
+	return "", false, nil
+}
+
+// refCandidates lists the refs name may stand for, in the order they are tried
+func refCandidates(name string) []string {
+	if !IsSafePath(filepath.FromSlash(name)) {
+		return nil
+	}
+	var refs []string
+	for _, ref := range []string{name, "refs/" + name, "refs/tags/" + name, "refs/heads/" + name} {
+		if strings.HasPrefix(ref, "refs/") {
+			refs = append(refs, ref)
+		}
+	}
+	return refs
+}
+
+// peelToCommit reads hash as a commit, following annotated tags to the commit they
+// tag, and names rev in the error if it does not lead to one
+func peelToCommit(hash, rev string) (models.Commit, error) {
+	objType, data, err := storage.ReadObject(hash)
+	if err != nil {
+		return models.Commit{}, err
+	}
+	for depth := 0; objType == storage.ObjectTag; depth++ {
+		tag, err := storage.DecodeTag(hash, data)
+		if err != nil {
+			return models.Commit{}, err
+		}
+		if depth >= maxTagDepth {
+			return models.Commit{}, fmt.Errorf("%s: too many nested tags", rev)
+		}
+		hash = tag.Object
+		if objType, data, err = storage.ReadObject(hash); err != nil {
+			return models.Commit{}, err
+		}
+	}
+	if objType != storage.ObjectCommit {
+		return models.Commit{}, fmt.Errorf("%s is a %s, not a commit", rev, objType)
+	}
+	return storage.FindCommit(hash)
+}
+
+// RevisionRange is the set of commits reachable from any of Include but from none of Exclude
+type RevisionRange struct {
+	Include []string
+	Exclude []string
+}
+
+// ParseRevisionRange resolves a revision range. "A..B" is the commits reachable from B
+// but not from A; "A...B" is those reachable from either but not from both. An omitted
+// side defaults to HEAD. "^A" excludes A, and a single revision includes its history.
+func ParseRevisionRange(expr string) (RevisionRange, error) {
+	var r RevisionRange
+	resolve := func(rev string) (string, error) {
+		if rev == "" {
+			rev = "HEAD"
+		}
+		c, err := ResolveCommit(rev)
+		return c.ID, err
+	}
+
+	if left, right, ok := strings.Cut(expr, "..."); ok {
+		a, err := resolve(left)
+		if err != nil {
+			return r, err
+		}
+		b, err := resolve(right)
+		if err != nil {
+			return r, err
+		}
+		// Unrelated histories have no merge base, leaving nothing to exclude
+		r.Include = []string{a, b}
+		r.Exclude, err = storage.FindMergeBases(a, b)
+		return r, err
+	}
+	if left, right, ok := strings.Cut(expr, ".."); ok {
+		a, err := resolve(left)
+		if err != nil {
+			return r, err
+		}
+		b, err := resolve(right)
+		if err != nil {
+			return r, err
+		}
+		r.Include, r.Exclude = []string{b}, []string{a}
+		return r, nil
+	}
+	if excluded, ok := strings.CutPrefix(expr, "^"); ok {
+		a, err := resolve(excluded)
+		if err != nil {
+			return r, err
+		}
+		r.Exclude = []string{a}
+		return r, nil
+	}
+	a, err := resolve(expr)
+	if err != nil {
+		return r, err
+	}
+	r.Include = []string{a}
+	return r, nil
+}
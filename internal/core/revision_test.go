@@ -0,0 +1,144 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/LeeFred3042U/kitcat/internal/models"
+	"github.com/LeeFred3042U/kitcat/internal/storage"
+)
+
+func TestResolveRevision(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	divergeBranches(t,
+		map[string]string{"base.txt": "base\n"},
+		map[string]string{"ours.txt": "ours\n"},
+		map[string]string{"theirs.txt": "theirs\n"},
+	)
+	ours, _ := GetHeadCommit()
+	base := ours.FirstParent()
+	theirsData, _ := os.ReadFile(filepath.Join(HeadsDir, "feature"))
+	theirs := string(theirsData)
+	if err := MergeWithOptions("feature", MergeOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	merge, _ := GetHeadCommit()
+	if err := CreateTag("v1", "HEAD~1"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]string{
+		"HEAD":             merge.ID,
+		"@":                merge.ID,
+		"main":             merge.ID,
+		"refs/heads/main":  merge.ID,
+		"HEAD~":            ours.ID,
+		"HEAD^1":           ours.ID,
+		"HEAD^2":           theirs,
+		"HEAD^2~1":         base,
+		"HEAD~2":           base,
+		"HEAD^0":           merge.ID,
+		"v1":               ours.ID,
+		"tags/v1":          ours.ID,
+		"feature^{commit}": theirs,
+		"HEAD^{tree}":      merge.TreeHash,
+		merge.ID[:7]:       merge.ID,
+	}
+	for rev, want := range tests {
+		got, err := ResolveRevision(rev)
+		if err != nil {
+			t.Errorf("ResolveRevision(%q) failed: %v", rev, err)
+		} else if got != want {
+			t.Errorf("ResolveRevision(%q) = %s, want %s", rev, got, want)
+		}
+	}
+
+	for _, rev := range []string{"HEAD~3", "HEAD^3", "nope", "HEAD^{blob}", "HEAD^{tree}~1"} {
+		if _, err := ResolveRevision(rev); err == nil {
+			t.Errorf("ResolveRevision(%q) succeeded", rev)
+		}
+	}
+}
+
+func TestResolveRevision_PreviousCheckout(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	divergeBranches(t,
+		map[string]string{"f.txt": "base\n"},
+		map[string]string{"f.txt": "ours\n"},
+		map[string]string{"f.txt": "theirs\n"},
+	)
+	feature, _ := os.ReadFile(filepath.Join(HeadsDir, "feature"))
+
+	// divergeBranches went main -> feature -> main
+	got, err := ResolveRevision("@{-1}")
+	if err != nil || got != string(feature) {
+		t.Errorf("@{-1} = %s, %v; want feature's head", got, err)
+	}
+
+	if err := Checkout("-"); err != nil {
+		t.Fatal(err)
+	}
+	if state, _ := GetHeadState(); state != "feature" {
+		t.Errorf("checkout - switched to %q", state)
+	}
+	if _, err := ResolveRevision("@{-9}"); err == nil {
+		t.Error("@{-9} resolved with only a few checkouts recorded")
+	}
+}
+
+func TestParseRevisionRange(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	divergeBranches(t,
+		map[string]string{"f.txt": "base\n"},
+		map[string]string{"o.txt": "ours\n"},
+		map[string]string{"t.txt": "theirs\n"},
+	)
+	ours, _ := GetHeadCommit()
+	feature, _ := os.ReadFile(filepath.Join(HeadsDir, "feature"))
+
+	r, err := ParseRevisionRange("feature..")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Include) != 1 || r.Include[0] != ours.ID || r.Exclude[0] != string(feature) {
+		t.Errorf("feature.. = %+v", r)
+	}
+
+	r, err = ParseRevisionRange("main...feature")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Include) != 2 || len(r.Exclude) != 1 || r.Exclude[0] != ours.FirstParent() {
+		t.Errorf("main...feature = %+v", r)
+	}
+
+	// A branch with no history in common with main excludes nothing
+	unrelated := models.Commit{TreeHash: ours.TreeHash, Message: "unrelated", Timestamp: time.Unix(1, 0).UTC()}
+	if err := storage.AppendCommit(unrelated); err != nil {
+		t.Fatal(err)
+	}
+	unrelatedID := storage.HashCommit(unrelated)
+	if err := os.WriteFile(filepath.Join(HeadsDir, "unrelated"), []byte(unrelatedID), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	r, err = ParseRevisionRange("main...unrelated")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Include) != 2 || r.Include[1] != unrelatedID || len(r.Exclude) != 0 {
+		t.Errorf("main...unrelated = %+v", r)
+	}
+
+	if _, err := ParseRevisionRange("main..nope"); err == nil || !strings.Contains(err.Error(), "nope") {
+		t.Errorf("expected an unknown revision error, got %v", err)
+	}
+}
@@ -6,12 +6,60 @@ import (
 	"github.com/LeeFred3042U/kitcat/internal/storage"
 )
 
-// Displays the contents of a kitcat object
+// Displays the contents of a kitcat object, given its hash or any revision expression
+// Trees are printed one entry per line as "<mode> <type> <hash>\t<name>"
 func ShowObject(hash string) error {
-	data, err := storage.ReadObject(hash)
+	hash, err := ResolveRevision(hash)
 	if err != nil {
 		return err
 	}
+	objType, data, err := storage.ReadObject(hash)
+	if err != nil {
+		return err
+	}
+	if objType == storage.ObjectTree {
+		entries, err := storage.ReadTree(hash)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			entryType := storage.ObjectBlob
+			if e.IsDir() {
+				entryType = storage.ObjectTree
+			}
+			fmt.Printf("%06o %s %s\t%s\n", e.Mode, entryType, e.Hash, e.Name)
+		}
+		return nil
+	}
 	fmt.Println(string(data))
 	return nil
 }
+
+// ShowObjectType prints the type of a kitcat object (blob, tree, commit or tag), or
+// legacy for an object stored before objects carried a type
+func ShowObjectType(hash string) error {
+	hash, err := ResolveRevision(hash)
+	if err != nil {
+		return err
+	}
+	objType, _, err := storage.ReadObject(hash)
+	if err != nil {
+		return err
+	}
+	fmt.Println(objType)
+	return nil
+}
+
+// ShowObjectSize prints the payload size of a kitcat object in bytes
+func ShowObjectSize(hash string) error {
+	hash, err := ResolveRevision(hash)
+	if err != nil {
+		return err
+	}
+	_, data, err := storage.ReadObject(hash)
+	if err != nil {
+		return err
+	}
+	fmt.Println(len(data))
+	return nil
+}
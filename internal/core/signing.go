@@ -0,0 +1,243 @@
+package core
+
+import (
+	"bufio"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/LeeFred3042U/kitcat/internal/models"
+	"github.com/LeeFred3042U/kitcat/internal/storage"
+)
+
+// signatureAlgorithm is the only kind of signature kitcat writes or checks
+const signatureAlgorithm = "ed25519"
+
+// SignatureStatus is what verifying an object's signature found
+type SignatureStatus struct {
+	Signed  bool   // the object carries a signature
+	Valid   bool   // the signature matches the object and the key it names
+	Trusted bool   // the key is the configured signing key or an allowed signer
+	KeyID   string // "SHA256:" and the base64 SHA-256 of the public key
+	Signer  string // who the allowed signers file says owns the key
+}
+
+// Good reports whether the signature is valid and made with a trusted key
+func (s SignatureStatus) Good() bool {
+	return s.Signed && s.Valid && s.Trusted
+}
+
+// String describes the status in one line, the way verify-commit prints it
+func (s SignatureStatus) String() string {
+	switch {
+	case !s.Signed:
+		return "No signature"
+	case !s.Valid:
+		return fmt.Sprintf("BAD signature with %s key %s", signatureAlgorithm, s.KeyID)
+	case !s.Trusted:
+		return fmt.Sprintf("Good signature with untrusted %s key %s", signatureAlgorithm, s.KeyID)
+	default:
+		return fmt.Sprintf("Good signature from %q with %s key %s", s.Signer, signatureAlgorithm, s.KeyID)
+	}
+}
+
+// loadSigningKey reads the private key user.signingkey names: a PEM-encoded PKCS#8
+// ed25519 key, as written by "openssl genpkey -algorithm ed25519"
+func loadSigningKey() (ed25519.PrivateKey, error) {
+	path, found, err := GetConfig("user.signingkey")
+	if err != nil {
+		return nil, err
+	}
+	if !found || path == "" {
+		return nil, errors.New("no signing key configured; set user.signingkey to the path of an ed25519 private key")
+	}
+	if rest, ok := strings.CutPrefix(path, "~/"); ok {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		path = filepath.Join(home, rest)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read signing key: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, fmt.Errorf("%s: not a PEM-encoded PKCS#8 private key", path)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	key, ok := parsed.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: not an ed25519 key", path)
+	}
+	return key, nil
+}
+
+// signPayload signs payload with the configured key, returning the value of the
+// object's signature header: "ed25519 <public key> <signature>", both in base64
+func signPayload(payload []byte) (string, error) {
+	key, err := loadSigningKey()
+	if err != nil {
+		return "", err
+	}
+	pub := key.Public().(ed25519.PublicKey)
+	return fmt.Sprintf("%s %s %s", signatureAlgorithm,
+		base64.StdEncoding.EncodeToString(pub),
+		base64.StdEncoding.EncodeToString(ed25519.Sign(key, payload))), nil
+}
+
+// signCommit sets c's signature and recomputes its ID
+func signCommit(c *models.Commit) error {
+	sig, err := signPayload(storage.CommitSigningPayload(*c))
+	if err != nil {
+		return err
+	}
+	c.Signature = sig
+	c.ID = storage.HashCommit(*c)
+	return nil
+}
+
+// signByDefault reports whether commit.gpgsign asks for every commit to be signed
+func signByDefault() (bool, error) {
+	value, found, err := GetConfig("commit.gpgsign")
+	if err != nil || !found {
+		return false, err
+	}
+	switch value {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	}
+	return false, fmt.Errorf("invalid commit.gpgsign value %q (expected true or false)", value)
+}
+
+// verifySignature checks a signature header value against the payload it signs
+func verifySignature(signature string, payload []byte) (SignatureStatus, error) {
+	if signature == "" {
+		return SignatureStatus{}, nil
+	}
+	status := SignatureStatus{Signed: true}
+	fields := strings.Fields(signature)
+	if len(fields) != 3 || fields[0] != signatureAlgorithm {
+		return status, fmt.Errorf("unsupported signature %q", signature)
+	}
+	pub, err := base64.StdEncoding.DecodeString(fields[1])
+	if err != nil || len(pub) != ed25519.PublicKeySize {
+		return status, errors.New("malformed signature public key")
+	}
+	sig, err := base64.StdEncoding.DecodeString(fields[2])
+	if err != nil {
+		return status, errors.New("malformed signature")
+	}
+	status.KeyID = keyID(pub)
+	status.Valid = ed25519.Verify(pub, payload, sig)
+
+	status.Signer, status.Trusted, err = trustedSigner(pub)
+	return status, err
+}
+
+// keyID is the fingerprint ssh-keygen would show for an ed25519 public key
+func keyID(pub []byte) string {
+	sum := sha256.Sum256(pub)
+	return "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:])
+}
+
+// trustedSigner looks pub up among the keys the user trusts: their own signing key,
+// and the "<principal> <base64 public key>" lines of the file verify.allowedsigners
+// names. It returns who owns the key and whether it was found.
+func trustedSigner(pub ed25519.PublicKey) (string, bool, error) {
+	if key, err := loadSigningKey(); err == nil && pub.Equal(key.Public()) {
+		_, email := userIdentity()
+		return email, true, nil
+	}
+
+	path, found, err := GetConfig("verify.allowedsigners")
+	if err != nil || !found || path == "" {
+		return "", false, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to read allowed signers: %w", err)
+	}
+	defer f.Close()
+
+	encoded := base64.StdEncoding.EncodeToString(pub)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if fields[len(fields)-1] == encoded {
+			return fields[0], true, nil
+		}
+	}
+	return "", false, scanner.Err()
+}
+
+// VerifyCommit checks the signature of the commit rev names, printing its status. It
+// fails unless the commit carries a good signature from a trusted key.
+func VerifyCommit(rev string) error {
+	commit, err := ResolveCommit(rev)
+	if err != nil {
+		return err
+	}
+	status, err := verifySignature(commit.Signature, storage.CommitSigningPayload(commit))
+	if err != nil {
+		return err
+	}
+	return reportSignature("commit "+shortHash(commit.ID), status)
+}
+
+// VerifyTag checks the signature of the annotated tag name, printing its status. It
+// fails unless the tag carries a good signature from a trusted key.
+func VerifyTag(name string) error {
+	id, ok, err := lookupRef("refs/tags/" + name)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("tag '%s' not found", name)
+	}
+	objType, _, err := storage.ReadObject(id)
+	if err != nil {
+		return err
+	}
+	if objType != storage.ObjectTag {
+		return fmt.Errorf("%s: cannot verify a lightweight tag", name)
+	}
+	tag, err := storage.ReadTag(id)
+	if err != nil {
+		return err
+	}
+	status, err := verifySignature(tag.Signature, storage.TagSigningPayload(tag))
+	if err != nil {
+		return err
+	}
+	return reportSignature("tag "+name, status)
+}
+
+// reportSignature prints status and turns anything but a good signature into an error
+func reportSignature(what string, status SignatureStatus) error {
+	if !status.Signed {
+		return fmt.Errorf("%s: no signature found", what)
+	}
+	fmt.Println(status)
+	if !status.Good() {
+		return fmt.Errorf("%s: signature could not be verified", what)
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package core
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/LeeFred3042U/kitcat/internal/storage"
+)
+
+// writeSigningKey stores a new ed25519 key as PEM in dir and returns its path and public key
+func writeSigningKey(t *testing.T, dir, name string) (string, ed25519.PublicKey) {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path, pub
+}
+
+func TestSignedCommitAndTag(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+	keys := t.TempDir()
+
+	if _, _, err := CommitWithOptions("unsigned", CommitOptions{Sign: true}); err == nil {
+		t.Fatal("signing without user.signingkey succeeded")
+	}
+	key, pub := writeSigningKey(t, keys, "mine.pem")
+	if err := SetConfig("user.signingkey", key, false); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := AddFile("dummy.txt"); err != nil {
+		t.Fatal(err)
+	}
+	signed, _, err := CommitWithOptions("signed", CommitOptions{Sign: true})
+	if err != nil {
+		t.Fatalf("signed commit failed: %v", err)
+	}
+	if signed.Signature == "" {
+		t.Fatal("commit -S stored no signature")
+	}
+	if err := VerifyCommit("HEAD"); err != nil {
+		t.Errorf("VerifyCommit of a commit signed with our key: %v", err)
+	}
+
+	tampered := signed
+	tampered.Message = "forged"
+	status, err := verifySignature(tampered.Signature, storage.CommitSigningPayload(tampered))
+	if err != nil || !status.Signed || status.Valid {
+		t.Errorf("tampered commit verified as %+v, %v", status, err)
+	}
+
+	if err := CreateAnnotatedTag("v1.0", "", "Release 1.0", true); err != nil {
+		t.Fatalf("signed tag failed: %v", err)
+	}
+	if err := VerifyTag("v1.0"); err != nil {
+		t.Errorf("VerifyTag: %v", err)
+	}
+	if err := CreateAnnotatedTag("v0.9", "", "Unsigned", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyTag("v0.9"); err == nil {
+		t.Error("VerifyTag of an unsigned tag succeeded")
+	}
+
+	// Someone else's key is untrusted until listed in the allowed signers file
+	other, _ := writeSigningKey(t, keys, "other.pem")
+	if err := SetConfig("user.signingkey", other, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyCommit("HEAD"); err == nil {
+		t.Error("VerifyCommit trusted a key that is neither ours nor allowed")
+	}
+	allowed := filepath.Join(keys, "allowed_signers")
+	line := "ada@example.com " + base64.StdEncoding.EncodeToString(pub) + "\n"
+	if err := os.WriteFile(allowed, []byte(line), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetConfig("verify.allowedsigners", allowed, false); err != nil {
+		t.Fatal(err)
+	}
+	commit, err := ResolveCommit("HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, err = verifySignature(commit.Signature, storage.CommitSigningPayload(commit))
+	if err != nil || !status.Good() || status.Signer != "ada@example.com" {
+		t.Errorf("allowed signer verified as %+v, %v", status, err)
+	}
+}
@@ -58,24 +58,26 @@ func StashPush(message string) error {
 
 	// Step 5: Update index with current working directory state for tracked files
 	// This ensures unstaged changes are included in the stash tree
-	index, err := storage.LoadIndex()
+	index, err := storage.LoadIndexEntries()
 	if err != nil {
 		return fmt.Errorf("failed to load index: %w", err)
 	}
 
-	for path := range index {
+	for path, entry := range index {
 		// If file exists in working directory, hash it and update index
-		if _, err := os.Stat(path); err == nil {
+		if info, err := os.Lstat(path); err == nil {
 			hash, err := storage.HashAndStoreFile(path)
 			if err != nil {
 				return fmt.Errorf("failed to hash file %s: %w", path, err)
 			}
-			index[path] = hash
+			updated := storage.IndexEntry{Hash: hash, Mode: storage.FileModeOf(info, entry.Mode)}
+			updated.SetStat(info)
+			index[path] = updated
 		}
 	}
 
 	// Write updated index to disk so CreateTree uses the current state
-	if err := storage.WriteIndex(index); err != nil {
+	if err := storage.WriteIndexEntries(index); err != nil {
 		return fmt.Errorf("failed to write updated index: %w", err)
 	}
 
@@ -106,24 +108,29 @@ func StashPush(message string) error {
 
 	// Step 8: Create the stash commit
 	stashCommit := models.Commit{
-		Parent:      headCommit.ID,
+		Parents:     []string{headCommit.ID},
 		Message:     wipMessage,
-		Timestamp:   time.Now().UTC(),
+		Timestamp:   time.Now().UTC().Truncate(time.Second),
 		TreeHash:    treeHash,
 		AuthorName:  authorName,
 		AuthorEmail: authorEmail,
 	}
-	stashCommit.ID = hashCommit(stashCommit)
+	stashCommit.ID = storage.HashCommit(stashCommit)
 
-	// Step 9: Save the stash commit to commits.log
+	// Step 9: Save the stash commit to the object store
 	if err := storage.AppendCommit(stashCommit); err != nil {
 		return fmt.Errorf("failed to save stash commit: %w", err)
 	}
 
 	// Step 10: Push the stash to the stack
+	previous := ""
+	if stashes, err := storage.ListStashes(); err == nil && len(stashes) > 0 {
+		previous = stashes[0]
+	}
 	if err := storage.PushStash(stashCommit.ID); err != nil {
 		return fmt.Errorf("failed to push stash: %w", err)
 	}
+	_ = appendReflog("refs/stash", previous, stashCommit.ID, wipMessage)
 
 	// Step 11: Perform hard reset to HEAD to clean the workspace
 	if err := ResetHard(headCommit.ID); err != nil {
@@ -18,14 +18,20 @@ func Status() error {
 		headState = "no commits yet"
 	}
 	fmt.Printf("On branch %s\n", headState)
+	if IsMergeInProgress() {
+		fmt.Println("You are in the middle of a merge (use \"kitcat merge --continue\" or \"kitcat merge --abort\")")
+	}
+	if IsAmInProgress() {
+		fmt.Println("You are in the middle of an am session (use \"kitcat am --continue\", \"kitcat am --skip\" or \"kitcat am --abort\")")
+	}
 
 	// Load the tree from the commit that HEAD points to
 	// Note: We use GetHeadCommit() instead of storage.GetLastCommit() because
 	// after a reset, HEAD might point to an earlier commit than the last in the log
-	headTree := make(map[string]string)
+	headTree := make(map[string]storage.IndexEntry)
 	headCommit, err := GetHeadCommit()
 	if err == nil {
-		tree, parseErr := storage.ParseTree(headCommit.TreeHash)
+		tree, parseErr := storage.ParseTreeEntries(headCommit.TreeHash)
 		if parseErr != nil {
 			return parseErr
 		}
@@ -35,7 +41,15 @@ func Status() error {
 	}
 
 	// Load the current staging area
-	index, err := storage.LoadIndex()
+	entries, err := storage.LoadIndexEntries()
+	if err != nil {
+		return err
+	}
+	index := make(map[string]string, len(entries))
+	for path, entry := range entries {
+		index[path] = entry.Hash
+	}
+	unmerged, err := storage.LoadUnmergedEntries()
 	if err != nil {
 		return err
 	}
@@ -46,35 +60,35 @@ func Status() error {
 		return err
 	}
 
+	renames, err := DefaultRenameOptions()
+	if err != nil {
+		return err
+	}
+
 	// Prepare slices to hold the categorized changes
 	stagedChanges := []string{}
 	unstagedChanges := []string{}
 	untrackedFiles := []string{}
 
-	// Create a set of all file paths from both HEAD and the index for a complete comparison
-	allPaths := make(map[string]bool)
-	for path := range headTree {
-		allPaths[path] = true
+	// Categorize Staged Changes (Index vs. HEAD), leaving conflicted paths to their own section
+	staged := make(map[string]storage.IndexEntry, len(entries))
+	for path, entry := range entries {
+		staged[path] = entry
 	}
-	for path := range index {
-		allPaths[path] = true
+	for path := range unmerged {
+		delete(headTree, path)
+		delete(staged, path)
 	}
-
-	// Categorize Staged Changes (Index vs. HEAD)
-	for path := range allPaths {
-		headHash, inHead := headTree[path]
-		indexHash, inIndex := index[path]
-
-		if inIndex && !inHead {
-			stagedChanges = append(stagedChanges, fmt.Sprintf("new file:  %s", path))
-		} else if !inIndex && inHead {
-			stagedChanges = append(stagedChanges, fmt.Sprintf("deleted:   %s", path))
-		} else if inIndex && inHead && headHash != indexHash {
-			stagedChanges = append(stagedChanges, fmt.Sprintf("modified:  %s", path))
-		}
+	changes, err := compareSnapshots(headTree, staged, nil, renames)
+	if err != nil {
+		return err
+	}
+	for _, c := range changes {
+		stagedChanges = append(stagedChanges, describeFileChange(c))
 	}
 
 	// Categorize Unstaged & Untracked Changes (Working Directory vs. Index)
+	refresh := make(map[string]storage.IndexEntry)
 	err = filepath.Walk(".", func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
@@ -87,7 +101,10 @@ func Status() error {
 			return nil
 		}
 
-		indexHash, isTracked := index[cleanPath]
+		indexEntry, isTracked := entries[cleanPath]
+		if _, conflicted := unmerged[cleanPath]; conflicted {
+			return nil
+		}
 
 		// If the file is not in the index, it's untracked
 		if !isTracked {
@@ -100,18 +117,26 @@ func Status() error {
 		}
 
 		// If the file is tracked, hash it and compare with the index to see if it's been modified
-		currentHash, hashErr := storage.HashFile(cleanPath)
+		matches, hashErr := trackedFileMatches(cleanPath, info, indexEntry, refresh)
 		if hashErr != nil {
 			return hashErr
 		}
-		if currentHash != indexHash {
-			unstagedChanges = append(unstagedChanges, fmt.Sprintf("modified:  %s", cleanPath))
+		workEntry := storage.IndexEntry{Hash: indexEntry.Hash, Mode: storage.FileModeOf(info, indexEntry.Mode)}
+		if !matches {
+			workEntry.Hash = ""
+		}
+		if !workEntry.SameFile(indexEntry) {
+			unstagedChanges = append(unstagedChanges, describeChange(cleanPath, indexEntry, workEntry, matches))
 		}
 		return nil
 	})
 	if err != nil {
 		return err
 	}
+	if len(refresh) > 0 {
+		// Best effort: a failed refresh only means hashing again next time
+		_ = storage.RefreshIndexStat(refresh)
+	}
 
 	// Print Final Summary - Only show sections that have content
 	if len(stagedChanges) > 0 {
@@ -121,6 +146,13 @@ func Status() error {
 		}
 	}
 
+	if len(unmerged) > 0 {
+		fmt.Println("\nUnmerged paths:")
+		for _, path := range sortedKeys(unmerged) {
+			fmt.Printf("\t%-16s %s\n", describeUnmerged(unmerged[path])+":", path)
+		}
+	}
+
 	if len(unstagedChanges) > 0 {
 		fmt.Println("\nChanges not staged for commit:")
 		for _, change := range unstagedChanges {
@@ -136,9 +168,40 @@ func Status() error {
 	}
 
 	// If all sections are empty, show a clean message
-	if len(stagedChanges) == 0 && len(unstagedChanges) == 0 && len(untrackedFiles) == 0 {
+	if len(stagedChanges) == 0 && len(unmerged) == 0 && len(unstagedChanges) == 0 && len(untrackedFiles) == 0 {
 		fmt.Println("nothing to commit, working tree clean")
 	}
 
 	return nil
 }
+
+// describeFileChange formats a status line for any kind of change
+func describeFileChange(c fileChange) string {
+	switch c.Status {
+	case 'A':
+		return fmt.Sprintf("new file:  %s", c.Path)
+	case 'D':
+		return fmt.Sprintf("deleted:   %s", c.OldPath)
+	case 'R':
+		return fmt.Sprintf("renamed:   %s -> %s", c.OldPath, c.Path)
+	case 'C':
+		return fmt.Sprintf("copied:    %s -> %s", c.OldPath, c.Path)
+	}
+	return describeChange(c.Path, *c.Old, *c.New, c.Old.Hash == c.New.Hash)
+}
+
+// describeChange formats a status line for a path present on both sides of a comparison.
+// Switching between a file and a symlink is a type change; a flipped executable bit is
+// reported with the old and new modes, and only on its own when the content is unchanged.
+func describeChange(path string, old, new storage.IndexEntry, sameContent bool) string {
+	if (old.Mode == storage.ModeSymlink) != (new.Mode == storage.ModeSymlink) {
+		return fmt.Sprintf("typechange: %s", path)
+	}
+	if old.Mode != new.Mode {
+		if sameContent {
+			return fmt.Sprintf("mode change: %s (%06o -> %06o)", path, old.Mode, new.Mode)
+		}
+		return fmt.Sprintf("modified:  %s (%06o -> %06o)", path, old.Mode, new.Mode)
+	}
+	return fmt.Sprintf("modified:  %s", path)
+}
@@ -2,87 +2,206 @@ package core
 
 import (
 	"fmt"
-	"os"
-	"path/filepath"
-	"sort"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/LeeFred3042U/kitcat/internal/models"
+	"github.com/LeeFred3042U/kitcat/internal/storage"
 )
 
-const tagsDir = ".kitcat/refs/tags"
+// Creates a new lightweight tag pointing to the commit a revision names, HEAD if it is empty
+func CreateTag(tagName, revision string) error {
+	target, err := prepareTag(tagName, revision)
+	if err != nil {
+		return err
+	}
+	if err := writeLooseRef("refs/tags/"+tagName, target); err != nil {
+		return err
+	}
+	fmt.Printf("Tag '%s' created for commit %s\n", tagName, target[:7])
+	return nil
+}
 
-// Creates a new lightweight tag pointing to a specific commit
+// CreateAnnotatedTag stores a tag object recording the tagger, date and message, and
+// points a new tag at it. With sign set the tag object is signed with the key
+// user.signingkey names.
+func CreateAnnotatedTag(tagName, revision, message string, sign bool) error {
+	target, err := prepareTag(tagName, revision)
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(message) == "" {
+		return fmt.Errorf("an annotated tag needs a message")
+	}
+	objType, _, err := storage.ReadObject(target)
+	if err != nil {
+		return err
+	}
 
-func CreateTag(tagName, commitID string) error {
-	if !IsRepoInitialized() {
-		return fmt.Errorf("not a kitcat repository (or any of the parent directories): .kitcat")
+	name, email := userIdentity()
+	tag := models.Tag{
+		Object:      target,
+		Type:        objType,
+		Name:        tagName,
+		TaggerName:  name,
+		TaggerEmail: email,
+		Timestamp:   time.Now().Truncate(time.Second),
+		Message:     strings.TrimRight(message, "\n") + "\n",
 	}
+	if sign {
+		if tag.Signature, err = signPayload(storage.TagSigningPayload(tag)); err != nil {
+			return err
+		}
+	}
+	id, err := storage.WriteTag(tag)
+	if err != nil {
+		return fmt.Errorf("failed to write tag object: %w", err)
+	}
+	if err := writeLooseRef("refs/tags/"+tagName, id); err != nil {
+		return err
+	}
+	fmt.Printf("Tag '%s' created for commit %s\n", tagName, target[:7])
+	return nil
+}
 
+// prepareTag validates a new tag's name and resolves what it will point to, which
+// must be an existing commit or tag
+func prepareTag(tagName, revision string) (string, error) {
+	if !IsRepoInitialized() {
+		return "", fmt.Errorf("not a kitcat repository (or any of the parent directories): .kitcat")
+	}
 	if !IsValidRefName(tagName) {
-		return fmt.Errorf("invalid tag name: %s", tagName)
+		return "", fmt.Errorf("invalid tag name: %s", tagName)
 	}
 
-	if err := os.MkdirAll(tagsDir, 0o755); err != nil {
-		return err
+	ref := "refs/tags/" + tagName
+	if refExists(ref) {
+		return "", fmt.Errorf("error: tag %s already exists", tagName)
+	}
+	if err := checkNewRef(ref, ""); err != nil {
+		return "", err
 	}
 
-	tagPath := filepath.Join(tagsDir, tagName)
-	// Checks if tag already exists.
-	if _, err := os.Stat(tagPath); err == nil {
-		return fmt.Errorf("error: tag %s already exists", tagName)
-	} else if !os.IsNotExist(err) {
-		return err
+	if revision == "" {
+		revision = "HEAD"
+	}
+	target, err := ResolveRevision(revision)
+	if err != nil {
+		return "", err
 	}
+	objType, _, err := storage.ReadObject(target)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", revision, err)
+	}
+	if objType != storage.ObjectCommit && objType != storage.ObjectTag {
+		return "", fmt.Errorf("%s is a %s; only commits and tags can be tagged", revision, objType)
+	}
+	return target, nil
+}
 
-	// Creates a new tag.
-	if err := os.WriteFile(tagPath, []byte(commitID), 0o644); err != nil {
+// DeleteTag removes a tag, loose or packed. The tag object of an annotated tag is left
+// for prune.
+func DeleteTag(tagName string) error {
+	ref := "refs/tags/" + tagName
+	target, ok, err := lookupRef(ref)
+	if err != nil {
 		return err
 	}
-
-	fmt.Printf("Tag '%s' created for commit %s\n", tagName, commitID)
+	if !ok || !IsValidRefName(tagName) {
+		return fmt.Errorf("tag '%s' not found", tagName)
+	}
+	if err := deleteRef(ref); err != nil {
+		return err
+	}
+	fmt.Printf("Deleted tag '%s' (was %s)\n", tagName, shortHash(target))
 	return nil
 }
 
-// ListTags returns all tag names stored in .kitcat/refs/tags
-func ListTags() ([]string, error) {
+// ListTags returns all tag names, loose or packed, that match the glob pattern, in
+// sorted order. An empty pattern matches every tag.
+func ListTags(pattern string) ([]string, error) {
 	if !IsRepoInitialized() {
 		return nil, fmt.Errorf(
 			"not a kitcat repository (or any of the parent directories): .kitcat",
 		)
 	}
-
-	if _, err := os.Stat(tagsDir); err != nil {
-		if os.IsNotExist(err) {
-			return []string{}, nil
-		}
-		return nil, err
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("invalid tag pattern %q: %w", pattern, err)
 	}
 
-	entries, err := os.ReadDir(tagsDir)
+	names, err := listRefNames("refs/tags/")
 	if err != nil {
 		return nil, err
 	}
-
-	var tags []string
-	for _, entry := range entries {
-
-		if entry.IsDir() {
-			continue
+	tags := []string{}
+	for _, name := range names {
+		if ok, _ := path.Match(pattern, name); pattern == "" || ok {
+			tags = append(tags, name)
 		}
-		tags = append(tags, entry.Name())
 	}
-
-	sort.Strings(tags)
 	return tags, nil
 }
 
-// PrintTags prints all tags, one per line
-func PrintTags() error {
-	tags, err := ListTags()
+// PrintTags prints the tags matching pattern, one per line. With lines above zero, each
+// tag is followed by up to that many lines of its message: the annotation of an
+// annotated tag, or the tagged commit's message for a lightweight one.
+func PrintTags(pattern string, lines int) error {
+	tags, err := ListTags(pattern)
 	if err != nil {
 		return err
 	}
 
 	for _, tag := range tags {
-		fmt.Println(tag)
+		if lines <= 0 {
+			fmt.Println(tag)
+			continue
+		}
+		message, err := tagMessage(tag)
+		if err != nil {
+			return err
+		}
+		msgLines := strings.Split(strings.TrimRight(message, "\n"), "\n")
+		if len(msgLines) > lines {
+			msgLines = msgLines[:lines]
+		}
+		fmt.Printf("%-15s %s\n", tag, msgLines[0])
+		for _, line := range msgLines[1:] {
+			if line == "" {
+				fmt.Println()
+				continue
+			}
+			fmt.Printf("    %s\n", line)
+		}
 	}
 	return nil
 }
+
+// tagMessage returns the message PrintTags shows for a tag
+func tagMessage(tagName string) (string, error) {
+	target, _, err := lookupRef("refs/tags/" + tagName)
+	if err != nil {
+		return "", err
+	}
+	objType, data, err := storage.ReadObject(target)
+	if err != nil {
+		return "", fmt.Errorf("tag %s: %w", tagName, err)
+	}
+	switch objType {
+	case storage.ObjectTag:
+		t, err := storage.DecodeTag(target, data)
+		return t.Message, err
+	case storage.ObjectCommit:
+		c, err := storage.DecodeCommit(target, data)
+		return c.Message, err
+	}
+	return "", nil
+}
+
+// shortHash abbreviates a hash for display
+func shortHash(hash string) string {
+	if len(hash) > 7 {
+		return hash[:7]
+	}
+	return hash
+}
@@ -0,0 +1,94 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/LeeFred3042U/kitcat/internal/storage"
+)
+
+func TestCreateAnnotatedTag(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	if err := AddFile("dummy.txt"); err != nil {
+		t.Fatal(err)
+	}
+	commit, _, err := Commit("first")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := CreateAnnotatedTag("v1.0", "", "Release 1.0", false); err != nil {
+		t.Fatalf("CreateAnnotatedTag failed: %v", err)
+	}
+	target, ok, err := lookupRef("refs/tags/v1.0")
+	if err != nil || !ok {
+		t.Fatalf("tag ref missing: %v", err)
+	}
+	tag, err := storage.ReadTag(target)
+	if err != nil {
+		t.Fatalf("tag ref does not point at a tag object: %v", err)
+	}
+	if tag.Object != commit.ID || tag.Type != storage.ObjectCommit || tag.Name != "v1.0" {
+		t.Errorf("tag records %s %s %s, want %s commit v1.0", tag.Object, tag.Type, tag.Name, commit.ID)
+	}
+	if tag.Message != "Release 1.0\n" || tag.TaggerName == "" || tag.Timestamp.IsZero() {
+		t.Errorf("tag is missing its message, tagger or date: %+v", tag)
+	}
+
+	// Annotated tags peel to their commit wherever a commit is expected
+	if c, err := ResolveCommit("v1.0"); err != nil || c.ID != commit.ID {
+		t.Errorf("ResolveCommit(v1.0) = %s, %v; want %s", c.ID, err, commit.ID)
+	}
+	if got, err := ResolveRevision("v1.0^{}"); err != nil || got != commit.ID {
+		t.Errorf("ResolveRevision(v1.0^{}) = %s, %v; want %s", got, err, commit.ID)
+	}
+
+	if err := CreateAnnotatedTag("empty", "", " ", false); err == nil {
+		t.Error("CreateAnnotatedTag accepted an empty message")
+	}
+	if err := CreateTag("bad", "0123456789abcdef0123456789abcdef01234567"); err == nil {
+		t.Error("CreateTag accepted a commit that does not exist")
+	}
+	if err := CreateTag("tree", "HEAD^{tree}"); err == nil {
+		t.Error("CreateTag accepted a tree")
+	}
+}
+
+func TestDeleteAndListTags(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	if err := AddFile("dummy.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := Commit("first"); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"v1.0", "v1.1", "v2.0", "nightly"} {
+		if err := CreateTag(name, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tags, err := ListTags("v1.*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tags) != 2 || tags[0] != "v1.0" || tags[1] != "v1.1" {
+		t.Errorf("ListTags(v1.*) = %v", tags)
+	}
+	if _, err := ListTags("v["); err == nil {
+		t.Error("ListTags accepted a malformed pattern")
+	}
+
+	if err := DeleteTag("v1.0"); err != nil {
+		t.Fatal(err)
+	}
+	if err := DeleteTag("v1.0"); err == nil {
+		t.Error("deleting a missing tag succeeded")
+	}
+	if tags, _ := ListTags(""); len(tags) != 3 {
+		t.Errorf("ListTags after delete = %v", tags)
+	}
+}
@@ -0,0 +1,130 @@
+package diff
+
+import "fmt"
+
+// Algorithm names a way of finding the differences between two sequences. They all give a
+// correct edit script; they differ in which of the possible scripts they pick.
+type Algorithm string
+
+const (
+	// Myers finds a shortest edit script. It is the default.
+	Myers Algorithm = "myers"
+	// Patience lines the sequences up on elements that occur exactly once in each, which
+	// keeps moved blocks and lines such as lone braces from being matched up by chance.
+	Patience Algorithm = "patience"
+	// Histogram extends patience to elements that repeat, anchoring on the rarest ones.
+	Histogram Algorithm = "histogram"
+)
+
+// Differ computes the differences between two sequences
+type Differ[T comparable] interface {
+	Diffs() []Diff[T]
+}
+
+// ParseAlgorithm returns the algorithm called name. "default" and "minimal" are Myers,
+// whose scripts are always minimal.
+func ParseAlgorithm(name string) (Algorithm, error) {
+	switch name {
+	case "myers", "default", "minimal":
+		return Myers, nil
+	case "patience":
+		return Patience, nil
+	case "histogram":
+		return Histogram, nil
+	}
+	return "", fmt.Errorf("unknown diff algorithm %q (expected myers, minimal, patience or histogram)", name)
+}
+
+// New returns a Differ comparing text1 with text2 using algorithm alg. An empty alg is Myers.
+func New[T comparable](alg Algorithm, text1, text2 []T) Differ[T] {
+	switch alg {
+	case Patience:
+		return NewPatienceDiff(text1, text2)
+	case Histogram:
+		return NewHistogramDiff(text1, text2)
+	}
+	return NewMyersDiff(text1, text2)
+}
+
+// script collects diffs, merging each into the previous one when they have the same operation
+type script[T comparable] []Diff[T]
+
+func (s *script[T]) add(op Operation, text []T) {
+	if len(text) == 0 {
+		return
+	}
+	if n := len(*s); n > 0 && (*s)[n-1].Operation == op {
+		(*s)[n-1].Text = append((*s)[n-1].Text, text...)
+		return
+	}
+	*s = append(*s, Diff[T]{Operation: op, Text: append([]T(nil), text...)})
+}
+
+func (s *script[T]) addAll(diffs []Diff[T]) {
+	for _, d := range diffs {
+		s.add(d.Operation, d.Text)
+	}
+}
+
+// anchoredDiff diffs text1 and text2 by trimming what they share at either end and
+// splitting the rest around matches that anchor finds, recursing on the gaps between them.
+// anchor returns matching runs as [start1, start2, length] in increasing order, or none
+// to fall back to Myers.
+func anchoredDiff[T comparable](text1, text2 []T, anchor func(a, b []T) [][3]int) []Diff[T] {
+	var out script[T]
+	var diff func(a, b []T)
+	diff = func(a, b []T) {
+		prefix := commonPrefixLength(a, b)
+		out.add(EQUAL, a[:prefix])
+		a, b = a[prefix:], b[prefix:]
+		suffix := commonSuffixLength(a, b)
+		tail := a[len(a)-suffix:]
+		a, b = a[:len(a)-suffix], b[:len(b)-suffix]
+
+		switch {
+		case len(a) == 0:
+			out.add(INSERT, b)
+		case len(b) == 0:
+			out.add(DELETE, a)
+		default:
+			matches := anchor(a, b)
+			if len(matches) == 0 {
+				out.addAll(NewMyersDiff(a, b).Diffs())
+				break
+			}
+			i, j := 0, 0
+			for _, m := range matches {
+				diff(a[i:m[0]], b[j:m[1]])
+				out.add(EQUAL, a[m[0]:m[0]+m[2]])
+				i, j = m[0]+m[2], m[1]+m[2]
+			}
+			diff(a[i:], b[j:])
+		}
+		out.add(EQUAL, tail)
+	}
+	diff(text1, text2)
+	if out == nil {
+		return []Diff[T]{}
+	}
+	return out
+}
+
+func commonPrefixLength[T comparable](a, b []T) int {
+	n := min(len(a), len(b))
+	for i := 0; i < n; i++ {
+		if a[i] != b[i] {
+			return i
+		}
+	}
+	return n
+}
+
+func commonSuffixLength[T comparable](a, b []T) int {
+	n := min(len(a), len(b))
+	for i := 1; i <= n; i++ {
+		if a[len(a)-i] != b[len(b)-i] {
+			return i - 1
+		}
+	}
+	return n
+}
@@ -0,0 +1,69 @@
+package diff
+
+// maxHistogramChain is how often an element may occur in the old sequence and still be
+// used as an anchor; more common elements, such as blank lines, are too ambiguous
+const maxHistogramChain = 64
+
+// HistogramDiff computes diffs with the histogram algorithm. It counts how often each
+// element occurs in the old sequence and looks for the longest common run that contains
+// the least frequent elements, so like patience it anchors on distinctive lines, but it
+// can also use lines that repeat. The gaps on either side of the run are diffed the same
+// way, and gaps with no usable elements are left to Myers.
+type HistogramDiff[T comparable] struct {
+	text1 []T
+	text2 []T
+}
+
+// NewHistogramDiff creates a HistogramDiff comparing text1 with text2
+func NewHistogramDiff[T comparable](text1, text2 []T) *HistogramDiff[T] {
+	return &HistogramDiff[T]{text1: text1, text2: text2}
+}
+
+// Diffs computes and returns the differences between the two texts
+func (hd *HistogramDiff[T]) Diffs() []Diff[T] {
+	return anchoredDiff(hd.text1, hd.text2, histogramAnchor[T])
+}
+
+// histogramAnchor returns the common run of a and b whose rarest element occurs least
+// often in a, preferring longer runs among equally rare ones
+func histogramAnchor[T comparable](a, b []T) [][3]int {
+	positions := make(map[T][]int)
+	for i, x := range a {
+		positions[x] = append(positions[x], i)
+	}
+
+	best, bestCount := [3]int{}, maxHistogramChain+1
+	for j := 0; j < len(b); {
+		next := j + 1
+		candidates := positions[b[j]]
+		if len(candidates) > maxHistogramChain {
+			j = next
+			continue
+		}
+		for _, i := range candidates {
+			// Grow the match at (i, j) into the whole common run around it
+			startA, startB := i, j
+			for startA > 0 && startB > 0 && a[startA-1] == b[startB-1] {
+				startA, startB = startA-1, startB-1
+			}
+			endA, endB := i+1, j+1
+			for endA < len(a) && endB < len(b) && a[endA] == b[endB] {
+				endA, endB = endA+1, endB+1
+			}
+			count := maxHistogramChain + 1
+			for k := startA; k < endA; k++ {
+				count = min(count, len(positions[a[k]]))
+			}
+			length := endA - startA
+			if count < bestCount || (count == bestCount && length > best[2]) {
+				best, bestCount = [3]int{startA, startB, length}, count
+			}
+			next = max(next, endB)
+		}
+		j = next
+	}
+	if best[2] == 0 {
+		return nil
+	}
+	return [][3]int{best}
+}
@@ -0,0 +1,97 @@
+package diff
+
+import "slices"
+
+// MergeRegion is one stretch of a three-way merge result. A resolved region holds the
+// merged elements in Lines; a conflict holds the competing versions from each side.
+type MergeRegion[T comparable] struct {
+	Conflict bool
+	Lines    []T
+	Base     []T
+	Ours     []T
+	Theirs   []T
+}
+
+// Merge3 merges the changes made from base to ours and from base to theirs.
+// Elements left alone by both sides anchor the merge. Between two anchors, a change made by
+// only one side is taken, identical changes are taken once, and anything else is a conflict.
+func Merge3[T comparable](base, ours, theirs []T) []MergeRegion[T] {
+	return Merge3With(Myers, base, ours, theirs)
+}
+
+// Merge3With is Merge3 finding what each side changed with algorithm alg
+func Merge3With[T comparable](alg Algorithm, base, ours, theirs []T) []MergeRegion[T] {
+	matchOurs := matchBase(alg, base, ours)
+	matchTheirs := matchBase(alg, base, theirs)
+
+	var regions []MergeRegion[T]
+	resolve := func(lines []T) {
+		if len(lines) == 0 {
+			return
+		}
+		if n := len(regions); n > 0 && !regions[n-1].Conflict {
+			regions[n-1].Lines = append(regions[n-1].Lines, lines...)
+			return
+		}
+		regions = append(regions, MergeRegion[T]{Lines: slices.Clone(lines)})
+	}
+
+	b, o, t := 0, 0, 0
+	for b < len(base) || o < len(ours) || t < len(theirs) {
+		// The next base element that both sides kept
+		next := b
+		for next < len(base) && (matchOurs[next] < 0 || matchTheirs[next] < 0) {
+			next++
+		}
+		if next == b && next < len(base) && matchOurs[next] == o && matchTheirs[next] == t {
+			resolve(base[b : b+1])
+			b, o, t = b+1, o+1, t+1
+			continue
+		}
+
+		endOurs, endTheirs := len(ours), len(theirs)
+		if next < len(base) {
+			endOurs, endTheirs = matchOurs[next], matchTheirs[next]
+		}
+		baseChunk, oursChunk, theirsChunk := base[b:next], ours[o:endOurs], theirs[t:endTheirs]
+		switch {
+		case slices.Equal(oursChunk, baseChunk):
+			resolve(theirsChunk)
+		case slices.Equal(theirsChunk, baseChunk), slices.Equal(oursChunk, theirsChunk):
+			resolve(oursChunk)
+		default:
+			regions = append(regions, MergeRegion[T]{
+				Conflict: true,
+				Base:     slices.Clone(baseChunk),
+				Ours:     slices.Clone(oursChunk),
+				Theirs:   slices.Clone(theirsChunk),
+			})
+		}
+		b, o, t = next, endOurs, endTheirs
+	}
+	return regions
+}
+
+// matchBase maps each element of base to its position in other, or -1 if the diff
+// from base to other does not keep it
+func matchBase[T comparable](alg Algorithm, base, other []T) []int {
+	match := make([]int, len(base))
+	b, o := 0, 0
+	for _, d := range New(alg, base, other).Diffs() {
+		switch d.Operation {
+		case EQUAL:
+			for range d.Text {
+				match[b] = o
+				b, o = b+1, o+1
+			}
+		case DELETE:
+			for range d.Text {
+				match[b] = -1
+				b++
+			}
+		case INSERT:
+			o += len(d.Text)
+		}
+	}
+	return match
+}
@@ -0,0 +1,83 @@
+package diff
+
+// PatienceDiff computes diffs with the patience algorithm: elements that occur exactly
+// once in each sequence are matched up, the longest run of those matches that is in the
+// same order on both sides becomes the anchors, and the gaps between anchors are diffed
+// the same way. Gaps with no unique elements are left to Myers.
+type PatienceDiff[T comparable] struct {
+	text1 []T
+	text2 []T
+}
+
+// NewPatienceDiff creates a PatienceDiff comparing text1 with text2
+func NewPatienceDiff[T comparable](text1, text2 []T) *PatienceDiff[T] {
+	return &PatienceDiff[T]{text1: text1, text2: text2}
+}
+
+// Diffs computes and returns the differences between the two texts
+func (pd *PatienceDiff[T]) Diffs() []Diff[T] {
+	return anchoredDiff(pd.text1, pd.text2, patienceAnchors[T])
+}
+
+// patienceAnchors returns the unique common elements of a and b that form the longest
+// sequence in the same order on both sides
+func patienceAnchors[T comparable](a, b []T) [][3]int {
+	type count struct{ inA, inB, posA, posB int }
+	counts := make(map[T]*count)
+	for i, x := range a {
+		c := counts[x]
+		if c == nil {
+			c = &count{}
+			counts[x] = c
+		}
+		c.inA++
+		c.posA = i
+	}
+	for j, x := range b {
+		if c := counts[x]; c != nil {
+			c.inB++
+			c.posB = j
+		}
+	}
+
+	// The unique pairs, in the order they appear in a
+	var pairs [][2]int
+	for _, x := range a {
+		if c := counts[x]; c.inA == 1 && c.inB == 1 {
+			pairs = append(pairs, [2]int{c.posA, c.posB})
+		}
+	}
+	if len(pairs) == 0 {
+		return nil
+	}
+
+	// Longest increasing subsequence of the b positions, by patience sorting: each pile
+	// keeps its top card, and each card remembers the top of the pile to its left
+	var tops []int // indexes into pairs
+	prev := make([]int, len(pairs))
+	for k, p := range pairs {
+		lo, hi := 0, len(tops)
+		for lo < hi {
+			mid := (lo + hi) / 2
+			if pairs[tops[mid]][1] < p[1] {
+				lo = mid + 1
+			} else {
+				hi = mid
+			}
+		}
+		prev[k] = -1
+		if lo > 0 {
+			prev[k] = tops[lo-1]
+		}
+		if lo == len(tops) {
+			tops = append(tops, k)
+		} else {
+			tops[lo] = k
+		}
+	}
+	anchors := make([][3]int, len(tops))
+	for k, i := tops[len(tops)-1], len(tops)-1; k >= 0; k, i = prev[k], i-1 {
+		anchors[i] = [3]int{pairs[k][0], pairs[k][1], 1}
+	}
+	return anchors
+}
@@ -0,0 +1,18 @@
+package diff
+
+// Similarity scores how much two sequences have in common, from 0 to 100: the elements
+// a diff keeps unchanged, as a percentage of the longer sequence. Two empty sequences
+// are identical.
+func Similarity[T comparable](a, b []T) int {
+	longest := max(len(a), len(b))
+	if longest == 0 {
+		return 100
+	}
+	common := 0
+	for _, d := range NewMyersDiff(a, b).Diffs() {
+		if d.Operation == EQUAL {
+			common += len(d.Text)
+		}
+	}
+	return common * 100 / longest
+}
@@ -0,0 +1,113 @@
+package diff
+
+import "fmt"
+
+// Line is one line of a hunk: an unchanged, inserted or deleted line of text
+type Line struct {
+	Operation Operation
+	Text      string
+}
+
+// Hunk is one "@@" block of a unified diff: a run of changes and the unchanged lines
+// around them. Starts are 1-based line numbers; a side with no lines starts at the line
+// before the hunk, as in "-0,0" for an empty old file.
+type Hunk struct {
+	OldStart, OldLines int
+	NewStart, NewLines int
+	Lines              []Line
+}
+
+// Header returns the hunk's "@@ -l,n +l,n @@" line, leaving out counts of one
+func (h Hunk) Header() string {
+	return fmt.Sprintf("@@ -%s +%s @@", hunkRange(h.OldStart, h.OldLines), hunkRange(h.NewStart, h.NewLines))
+}
+
+func hunkRange(start, lines int) string {
+	if lines == 1 {
+		return fmt.Sprint(start)
+	}
+	return fmt.Sprintf("%d,%d", start, lines)
+}
+
+// Unified turns the differences between two sequences of lines into hunks with up to
+// context unchanged lines before and after each change. Changes separated by no more
+// than twice that many unchanged lines share a hunk. Equal inputs give no hunks.
+func Unified(a, b []string, context int) []Hunk {
+	return UnifiedFromDiffs(NewMyersDiff(a, b).Diffs(), context)
+}
+
+// UnifiedFromDiffs is Unified for differences that have already been computed
+func UnifiedFromDiffs(diffs []Diff[string], context int) []Hunk {
+	context = max(context, 0)
+
+	// Flatten the diff into single lines, each knowing where it sits in both sequences
+	type item struct {
+		line     Line
+		old, new int // 0-based positions before this line
+	}
+	var items []item
+	oldPos, newPos := 0, 0
+	for _, d := range diffs {
+		for _, text := range d.Text {
+			items = append(items, item{Line{d.Operation, text}, oldPos, newPos})
+			if d.Operation != INSERT {
+				oldPos++
+			}
+			if d.Operation != DELETE {
+				newPos++
+			}
+		}
+	}
+
+	var hunks []Hunk
+	for i := 0; i < len(items); {
+		for i < len(items) && items[i].line.Operation == EQUAL {
+			i++
+		}
+		if i == len(items) {
+			break
+		}
+
+		// Extend the hunk over any change that follows within 2*context unchanged lines
+		end := i
+		for j := i; j < len(items); {
+			if items[j].line.Operation != EQUAL {
+				j++
+				end = j
+				continue
+			}
+			k := j
+			for k < len(items) && items[k].line.Operation == EQUAL {
+				k++
+			}
+			if k == len(items) || k-j > 2*context {
+				break
+			}
+			j = k
+		}
+
+		// Hunks are more than 2*context lines apart, so their context never overlaps
+		start := max(i-context, 0)
+		stop := min(end+context, len(items))
+
+		h := Hunk{OldStart: items[start].old, NewStart: items[start].new}
+		for _, it := range items[start:stop] {
+			h.Lines = append(h.Lines, it.line)
+			if it.line.Operation != INSERT {
+				h.OldLines++
+			}
+			if it.line.Operation != DELETE {
+				h.NewLines++
+			}
+		}
+		if h.OldLines > 0 {
+			h.OldStart++
+		}
+		if h.NewLines > 0 {
+			h.NewStart++
+		}
+		hunks = append(hunks, h)
+		i = stop
+	}
+	return hunks
+}
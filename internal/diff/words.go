@@ -0,0 +1,98 @@
+package diff
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// DefaultWordRegex matches the words of a word diff: runs of non-space characters
+var DefaultWordRegex = regexp.MustCompile(`\S+`)
+
+// Tokenize splits text into the matches of word and the text around them, so that gaps
+// has one more element than words and interleaving the two gives back text. Empty matches
+// are not words.
+func Tokenize(text string, word *regexp.Regexp) (words, gaps []string) {
+	last := 0
+	for _, m := range word.FindAllStringIndex(text, -1) {
+		if m[0] == m[1] {
+			continue
+		}
+		gaps = append(gaps, text[last:m[0]])
+		words = append(words, text[m[0]:m[1]])
+		last = m[1]
+	}
+	return words, append(gaps, text[last:])
+}
+
+// Words diffs two texts word by word with algorithm alg, splitting them with Tokenize.
+// Only the words are compared: the text between them is not content, so it comes from b
+// unchanged and is never marked as deleted or inserted. Each stretch of changes comes out
+// as one deletion, spanning the deleted words as they stood in a, followed by one
+// insertion, so that rewording a phrase reads as a single edit.
+func Words(a, b string, word *regexp.Regexp, alg Algorithm) []Diff[string] {
+	oldWords, oldGaps := Tokenize(a, word)
+	newWords, newGaps := Tokenize(b, word)
+
+	var out script[string]
+	i, j := 0, 0 // the next word of a and of b
+	var deleted, inserted int
+	flush := func() {
+		if deleted == 0 && inserted == 0 {
+			return
+		}
+		if inserted > 0 {
+			out.add(EQUAL, []string{newGaps[j-inserted]})
+		}
+		if deleted > 0 {
+			out.add(DELETE, []string{span(oldWords, oldGaps, i-deleted, i)})
+		}
+		if inserted > 0 {
+			out.add(INSERT, []string{span(newWords, newGaps, j-inserted, j)})
+		}
+		deleted, inserted = 0, 0
+	}
+	for _, d := range New(alg, oldWords, newWords).Diffs() {
+		switch d.Operation {
+		case DELETE:
+			deleted += len(d.Text)
+			i += len(d.Text)
+		case INSERT:
+			inserted += len(d.Text)
+			j += len(d.Text)
+		default:
+			flush()
+			for range d.Text {
+				out.add(EQUAL, []string{newGaps[j], newWords[j]})
+				i, j = i+1, j+1
+			}
+		}
+	}
+	flush()
+	out.add(EQUAL, []string{newGaps[j]})
+	return dropEmpty(out)
+}
+
+// span joins words[from:to] with the gaps between them
+func span(words, gaps []string, from, to int) string {
+	var sb strings.Builder
+	for k := from; k < to; k++ {
+		if k > from {
+			sb.WriteString(gaps[k])
+		}
+		sb.WriteString(words[k])
+	}
+	return sb.String()
+}
+
+// dropEmpty removes empty strings from each diff, and diffs left with no text
+func dropEmpty(diffs []Diff[string]) []Diff[string] {
+	var out []Diff[string]
+	for _, d := range diffs {
+		text := slices.DeleteFunc(d.Text, func(s string) bool { return s == "" })
+		if len(text) > 0 {
+			out = append(out, Diff[string]{Operation: d.Operation, Text: text})
+		}
+	}
+	return out
+}
@@ -4,10 +4,24 @@ import "time"
 
 type Commit struct {
 	ID          string
-	Parent      string
+	Parents     []string // in order; the first is the branch the commit was made on
 	Message     string
 	Timestamp   time.Time
 	TreeHash    string
 	AuthorName  string
 	AuthorEmail string
+	Signature   string // "<algorithm> <public key> <signature>" over the rest of the commit, if signed
+}
+
+// FirstParent returns the commit's first parent, or "" for a root commit
+func (c Commit) FirstParent() string {
+	if len(c.Parents) == 0 {
+		return ""
+	}
+	return c.Parents[0]
+}
+
+// IsMerge reports whether the commit has more than one parent
+func (c Commit) IsMerge() bool {
+	return len(c.Parents) > 1
 }
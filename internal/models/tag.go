@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// Tag is an annotated tag: a named, signed-off pointer to another object
+type Tag struct {
+	ID          string
+	Object      string // the tagged object
+	Type        string // the tagged object's type, usually "commit"
+	Name        string
+	TaggerName  string
+	TaggerEmail string
+	Timestamp   time.Time
+	Message     string
+	Signature   string // "<algorithm> <public key> <signature>" over the rest of the tag, if signed
+}
@@ -5,23 +5,32 @@ import (
 	"encoding/hex"
 	"io"
 	"os"
-	"path/filepath"
 )
 
-const (
-	objectsDir = ".kitcat/objects"
-)
-
-// computeFileHash computes the SHA-1 hash of a file at the given path.
-// Returns the hash as a hexadecimal string and any error encountered.
+// computeFileHash computes the blob ID of a file at the given path.
+// The hash covers the "blob <size>\0" header followed by the file content.
+// Symlinks are not followed: their blob is the link target.
 func computeFileHash(path string) (string, error) {
+	if target, ok, err := readSymlink(path); err != nil || ok {
+		if err != nil {
+			return "", err
+		}
+		return HashObject(ObjectBlob, []byte(target)), nil
+	}
+
 	f, err := os.Open(path)
 	if err != nil {
 		return "", err
 	}
 	defer f.Close()
 
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+
 	h := sha1.New()
+	h.Write(objectHeader(ObjectBlob, info.Size()))
 	if _, err := io.Copy(h, f); err != nil {
 		return "", err
 	}
@@ -29,54 +38,95 @@ func computeFileHash(path string) (string, error) {
 	return hex.EncodeToString(h.Sum(nil)), nil
 }
 
-func HashAndStoreFile(path string) (string, error) {
-	hash, err := computeFileHash(path)
+// computeLegacyFileHash computes the plain SHA-1 of a file, as used by the legacy object format
+func computeLegacyFileHash(path string) (string, error) {
+	f, err := os.Open(path)
 	if err != nil {
 		return "", err
 	}
+	defer f.Close()
 
-	objPath := filepath.Join(objectsDir, hash)
-	// ensure objects dir exists
-	if err := os.MkdirAll(objectsDir, 0o755); err != nil {
+	h := sha1.New()
+	if _, err := io.Copy(h, f); err != nil {
 		return "", err
 	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
 
-	if _, err := os.Stat(objPath); os.IsNotExist(err) {
-		// write via tmp file — read file again for storage
-		tmp := objPath + ".tmp"
-		f, err := os.Open(path)
-		if err != nil {
-			return "", err
-		}
-		out, err := os.Create(tmp)
+// HashAndStoreFile hashes a file as a blob and stores it in the object database
+func HashAndStoreFile(path string) (string, error) {
+	if target, ok, err := readSymlink(path); err != nil || ok {
 		if err != nil {
-			f.Close()
-			return "", err
-		}
-		if _, err := io.Copy(out, f); err != nil {
-			f.Close()
-			out.Close()
-			os.Remove(tmp)
-			return "", err
-		}
-		f.Close()
-		out.Close()
-		if err := os.Rename(tmp, objPath); err != nil {
-			os.Remove(tmp)
 			return "", err
 		}
+		return WriteObject(ObjectBlob, []byte(target))
 	}
-	return hash, nil
-}
 
-// Reads an object from the objects directory
-func ReadObject(hash string) ([]byte, error) {
-	objectPath := filepath.Join(objectsDir, hash)
-	return os.ReadFile(objectPath)
+	hash, err := computeFileHash(path)
+	if err != nil {
+		return "", err
+	}
+
+	// read file again for storage; the first pass only hashed it
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	if err := writeLooseObject(hash, ObjectBlob, info.Size(), f); err != nil {
+		return "", err
+	}
+	return hash, nil
 }
 
-// Computes the SHA-1 hash of a file's content
+// Computes the blob hash of a file's content
 // does not store the file in the object database
 func HashFile(path string) (string, error) {
 	return computeFileHash(path)
 }
+
+// FileMatchesHash reports whether the file at path has the content of the blob named hash.
+// Blobs from repositories created before the typed object format are compared using the
+// legacy plain SHA-1 scheme, so old index entries are not reported as modified.
+func FileMatchesHash(path, hash string) (bool, error) {
+	current, err := computeFileHash(path)
+	if err != nil {
+		return false, err
+	}
+	if current == hash {
+		return true, nil
+	}
+	if !IsLegacyObject(hash) {
+		return false, nil
+	}
+	// Legacy repositories never recorded symlinks
+	if _, ok, err := readSymlink(path); err != nil || ok {
+		return false, err
+	}
+	legacy, err := computeLegacyFileHash(path)
+	if err != nil {
+		return false, err
+	}
+	return legacy == hash, nil
+}
+
+// readSymlink returns the link target if path is a symlink
+func readSymlink(path string) (string, bool, error) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return "", false, err
+	}
+	if info.Mode()&os.ModeSymlink == 0 {
+		return "", false, nil
+	}
+	target, err := os.Readlink(path)
+	if err != nil {
+		return "", false, err
+	}
+	return target, true, nil
+}
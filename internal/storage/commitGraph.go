@@ -0,0 +1,430 @@
+package storage
+
+import (
+	"bytes"
+	"container/heap"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"os"
+	"slices"
+	"sort"
+	"sync"
+)
+
+// The commit-graph caches the ancestry of every known commit so that history walks do not
+// have to read and parse commit objects:
+//
+//	"KCGR" | uint32 version | uint32 count | uint32 edges |
+//	count * (20-byte hash | uint32 generation | int64 commit time | uint32 first edge | uint32 parents) |
+//	edges * uint32 parent position | SHA-1 of everything before
+//
+// Commits are sorted by hash and parents are stored as positions in that list. A commit's
+// generation is one more than the highest generation among its parents, so a commit can only
+// reach commits with a lower generation. Commits are immutable, so the graph never becomes
+// wrong, only incomplete: commits created after it was written are read from their objects.
+const (
+	commitGraphPath      = ".kitcat/objects/info/commit-graph"
+	commitGraphSignature = "KCGR"
+	commitGraphVersion   = 1
+
+	commitGraphEntrySize = sha1.Size + 4 + 8 + 4 + 4
+
+	// genUnknown is the generation of commits outside the graph; nothing is pruned below it
+	genUnknown = math.MaxUint32
+)
+
+// commitGraph is the decoded commit-graph file
+type commitGraph struct {
+	hashes []string // sorted
+	gens   []uint32
+	times  []int64
+	first  []uint32 // index of each commit's first parent in edges
+	counts []uint32
+	edges  []uint32
+}
+
+func (g *commitGraph) lookup(hash string) (int, bool) {
+	i := sort.SearchStrings(g.hashes, hash)
+	return i, i < len(g.hashes) && g.hashes[i] == hash
+}
+
+func (g *commitGraph) parents(pos int) []string {
+	edges := g.edges[g.first[pos] : g.first[pos]+g.counts[pos]]
+	parents := make([]string, len(edges))
+	for i, e := range edges {
+		parents[i] = g.hashes[e]
+	}
+	return parents
+}
+
+var commitGraphCache struct {
+	sync.Mutex
+	size, mtime int64
+	graph       *commitGraph
+}
+
+// HasCommitGraph reports whether a commit-graph has been written
+func HasCommitGraph() bool {
+	_, err := os.Stat(commitGraphPath)
+	return err == nil
+}
+
+// loadCommitGraph returns the commit-graph, or nil if there is none or it cannot be used
+func loadCommitGraph() *commitGraph {
+	info, err := os.Stat(commitGraphPath)
+	if err != nil {
+		return nil
+	}
+	commitGraphCache.Lock()
+	defer commitGraphCache.Unlock()
+	c := &commitGraphCache
+	if c.graph != nil && c.size == info.Size() && c.mtime == info.ModTime().UnixNano() {
+		return c.graph
+	}
+
+	content, err := os.ReadFile(commitGraphPath)
+	if err != nil {
+		return nil
+	}
+	g, err := decodeCommitGraph(content)
+	if err != nil {
+		return nil
+	}
+	c.size, c.mtime, c.graph = info.Size(), info.ModTime().UnixNano(), g
+	return g
+}
+
+func decodeCommitGraph(content []byte) (*commitGraph, error) {
+	const header = len(commitGraphSignature) + 12
+	if len(content) < header+sha1.Size || !bytes.HasPrefix(content, []byte(commitGraphSignature)) {
+		return nil, fmt.Errorf("malformed commit-graph")
+	}
+	body := content[:len(content)-sha1.Size]
+	if sum := sha1.Sum(body); !bytes.Equal(sum[:], content[len(body):]) {
+		return nil, fmt.Errorf("commit-graph checksum mismatch")
+	}
+	if v := binary.BigEndian.Uint32(content[4:]); v != commitGraphVersion {
+		return nil, fmt.Errorf("unsupported commit-graph version %d", v)
+	}
+	count := int(binary.BigEndian.Uint32(content[8:]))
+	edgeCount := int(binary.BigEndian.Uint32(content[12:]))
+	if len(body) != header+count*commitGraphEntrySize+edgeCount*4 {
+		return nil, fmt.Errorf("malformed commit-graph")
+	}
+
+	g := &commitGraph{
+		hashes: make([]string, count),
+		gens:   make([]uint32, count),
+		times:  make([]int64, count),
+		first:  make([]uint32, count),
+		counts: make([]uint32, count),
+		edges:  make([]uint32, edgeCount),
+	}
+	pos := header
+	for i := 0; i < count; i++ {
+		g.hashes[i] = hex.EncodeToString(content[pos : pos+sha1.Size])
+		pos += sha1.Size
+		g.gens[i] = binary.BigEndian.Uint32(content[pos:])
+		g.times[i] = int64(binary.BigEndian.Uint64(content[pos+4:]))
+		g.first[i] = binary.BigEndian.Uint32(content[pos+12:])
+		g.counts[i] = binary.BigEndian.Uint32(content[pos+16:])
+		pos += 20
+		if uint64(g.first[i])+uint64(g.counts[i]) > uint64(edgeCount) {
+			return nil, fmt.Errorf("malformed commit-graph")
+		}
+	}
+	for i := range g.edges {
+		g.edges[i] = binary.BigEndian.Uint32(content[pos:])
+		if int(g.edges[i]) >= count {
+			return nil, fmt.Errorf("malformed commit-graph")
+		}
+		pos += 4
+	}
+	return g, nil
+}
+
+// WriteCommitGraph rebuilds the commit-graph from every commit in the journal and their
+// ancestors. Commits whose history cannot be read completely are left out. It returns the
+// number of commits in the new graph.
+func WriteCommitGraph() (int, error) {
+	ids, err := ReadCommitJournal()
+	if err != nil {
+		return 0, err
+	}
+
+	// Read commit objects directly, since the existing graph is about to be replaced
+	r := &commitReader{nodes: make(map[string]*commitNode)}
+	for _, id := range ids {
+		r.generation(id)
+	}
+
+	var hashes []string
+	for id, n := range r.nodes {
+		if n.gen != 0 && n.gen != genUnknown {
+			hashes = append(hashes, id)
+		}
+	}
+	sort.Strings(hashes)
+	positions := make(map[string]uint32, len(hashes))
+	for i, id := range hashes {
+		positions[id] = uint32(i)
+	}
+
+	var entries, edges bytes.Buffer
+	edgeCount := 0
+	for _, id := range hashes {
+		n := r.nodes[id]
+		raw, _ := hex.DecodeString(id)
+		entries.Write(raw)
+		binary.Write(&entries, binary.BigEndian, n.gen)
+		binary.Write(&entries, binary.BigEndian, n.time)
+		binary.Write(&entries, binary.BigEndian, uint32(edgeCount))
+		binary.Write(&entries, binary.BigEndian, uint32(len(n.parents)))
+		for _, p := range n.parents {
+			binary.Write(&edges, binary.BigEndian, positions[p])
+			edgeCount++
+		}
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(commitGraphSignature)
+	binary.Write(&buf, binary.BigEndian, uint32(commitGraphVersion))
+	binary.Write(&buf, binary.BigEndian, uint32(len(hashes)))
+	binary.Write(&buf, binary.BigEndian, uint32(edgeCount))
+	buf.Write(entries.Bytes())
+	buf.Write(edges.Bytes())
+	sum := sha1.Sum(buf.Bytes())
+	buf.Write(sum[:])
+
+	if err := SafeWriteFile(commitGraphPath, buf.Bytes(), 0o644); err != nil {
+		return 0, err
+	}
+	return len(hashes), nil
+}
+
+// VerifyCommitGraph checks the commit-graph, if there is one, against the commit objects
+func VerifyCommitGraph() error {
+	content, err := os.ReadFile(commitGraphPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	g, err := decodeCommitGraph(content)
+	if err != nil {
+		return err
+	}
+	for i, id := range g.hashes {
+		c, err := readCommitObject(id)
+		if err != nil {
+			return fmt.Errorf("commit %s: %w", id, err)
+		}
+		if !slices.Equal(c.Parents, g.parents(i)) {
+			return fmt.Errorf("commit %s: parents differ from the commit object", id)
+		}
+		if c.Timestamp.Unix() != g.times[i] {
+			return fmt.Errorf("commit %s: time differs from the commit object", id)
+		}
+		var want uint32 = 1
+		for _, p := range g.parents(i) {
+			pos, _ := g.lookup(p)
+			want = max(want, g.gens[pos]+1)
+		}
+		if g.gens[i] != want {
+			return fmt.Errorf("commit %s: generation %d, expected %d", id, g.gens[i], want)
+		}
+	}
+	return nil
+}
+
+// commitNode is the part of a commit that history walks need
+type commitNode struct {
+	id      string
+	parents []string
+	time    int64
+	gen     uint32 // 0 until known, genUnknown if it cannot be computed
+}
+
+// commitReader answers ancestry questions from the commit-graph where it can and from
+// commit objects otherwise, remembering every commit it has looked at
+type commitReader struct {
+	graph *commitGraph
+	nodes map[string]*commitNode
+}
+
+func newCommitReader() *commitReader {
+	return &commitReader{graph: loadCommitGraph(), nodes: make(map[string]*commitNode)}
+}
+
+// node returns the commit with the given ID, which may be abbreviated
+func (r *commitReader) node(id string) (*commitNode, error) {
+	if n, ok := r.nodes[id]; ok {
+		return n, nil
+	}
+	if r.graph != nil {
+		if pos, ok := r.graph.lookup(id); ok {
+			n := &commitNode{id: id, parents: r.graph.parents(pos), time: r.graph.times[pos], gen: r.graph.gens[pos]}
+			r.nodes[id] = n
+			return n, nil
+		}
+	}
+	c, err := FindCommit(id)
+	if err != nil {
+		return nil, err
+	}
+	if n, ok := r.nodes[c.ID]; ok {
+		return n, nil
+	}
+	n := &commitNode{id: c.ID, parents: c.Parents, time: c.Timestamp.Unix()}
+	r.nodes[c.ID] = n
+	return n, nil
+}
+
+// generation returns the exact generation of a commit, reading the ancestors it needs.
+// It returns genUnknown if part of the history cannot be read.
+func (r *commitReader) generation(id string) uint32 {
+	start, err := r.node(id)
+	if err != nil {
+		return genUnknown
+	}
+	stack := []*commitNode{start}
+	for len(stack) > 0 {
+		n := stack[len(stack)-1]
+		if n.gen != 0 {
+			stack = stack[:len(stack)-1]
+			continue
+		}
+		gen, pending := uint32(1), false
+		for _, p := range n.parents {
+			pn, err := r.node(p)
+			if err != nil {
+				gen = genUnknown
+				break
+			}
+			if pn.gen == 0 {
+				stack = append(stack, pn)
+				pending = true
+			} else if pn.gen == genUnknown {
+				gen = genUnknown
+				break
+			} else {
+				gen = max(gen, pn.gen+1)
+			}
+		}
+		if !pending || gen == genUnknown {
+			n.gen = gen
+			stack = stack[:len(stack)-1]
+		}
+	}
+	return start.gen
+}
+
+// commitQueue pops the newest commit first: highest commit time, then highest generation
+type commitQueue []*commitNode
+
+func (q commitQueue) Len() int { return len(q) }
+func (q commitQueue) Less(i, j int) bool {
+	if q[i].time != q[j].time {
+		return q[i].time > q[j].time
+	}
+	if q[i].gen != q[j].gen {
+		return q[i].gen > q[j].gen
+	}
+	return q[i].id < q[j].id
+}
+func (q commitQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+func (q *commitQueue) Push(x any)   { *q = append(*q, x.(*commitNode)) }
+func (q *commitQueue) Pop() any {
+	old := *q
+	n := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return n
+}
+
+// generationQueue pops the commit with the highest generation first
+type generationQueue struct{ commitQueue }
+
+func (q generationQueue) Less(i, j int) bool {
+	if a, b := q.commitQueue[i], q.commitQueue[j]; a.gen != b.gen {
+		return a.gen > b.gen
+	}
+	return q.commitQueue.Less(i, j)
+}
+
+// ancestors returns the full IDs of a commit and everything it can reach
+func (r *commitReader) ancestors(id string) (map[string]bool, error) {
+	start, err := r.node(id)
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{start.id: true}
+	stack := []*commitNode{start}
+	for len(stack) > 0 {
+		n := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		for _, p := range n.parents {
+			if seen[p] {
+				continue
+			}
+			seen[p] = true
+			pn, err := r.node(p)
+			if err != nil {
+				return nil, err
+			}
+			stack = append(stack, pn)
+		}
+	}
+	return seen, nil
+}
+
+// RevList returns the IDs of the include commits and all their ancestors, newest first,
+// leaving out every commit reachable from exclude. A positive limit stops the walk after
+// that many commits.
+func RevList(include, exclude []string, limit int) ([]string, error) {
+	r := newCommitReader()
+	hidden := make(map[string]bool)
+	for _, id := range exclude {
+		ancestors, err := r.ancestors(id)
+		if err != nil {
+			return nil, err
+		}
+		for a := range ancestors {
+			hidden[a] = true
+		}
+	}
+
+	q := &commitQueue{}
+	seen := make(map[string]bool)
+	for _, id := range include {
+		n, err := r.node(id)
+		if err != nil {
+			return nil, err
+		}
+		if !seen[n.id] && !hidden[n.id] {
+			seen[n.id] = true
+			heap.Push(q, n)
+		}
+	}
+
+	var ids []string
+	for q.Len() > 0 && (limit <= 0 || len(ids) < limit) {
+		n := heap.Pop(q).(*commitNode)
+		ids = append(ids, n.id)
+		for _, p := range n.parents {
+			if seen[p] || hidden[p] {
+				continue
+			}
+			seen[p] = true
+			pn, err := r.node(p)
+			if err != nil {
+				return nil, err
+			}
+			heap.Push(q, pn)
+		}
+	}
+	return ids, nil
+}
@@ -0,0 +1,175 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/LeeFred3042U/kitcat/internal/models"
+)
+
+// appendTestCommit stores a commit with the given parents and a timestamp n seconds in
+func appendTestCommit(t *testing.T, n int, parents ...string) string {
+	t.Helper()
+	c := models.Commit{
+		Parents:     parents,
+		TreeHash:    "4b825dc642cb6eb9a060e54bf8d69288fbee4904",
+		Message:     fmt.Sprintf("commit %d", n),
+		Timestamp:   time.Unix(1700000000+int64(n), 0).UTC(),
+		AuthorName:  "Ada",
+		AuthorEmail: "ada@example.com",
+	}
+	if err := AppendCommit(c); err != nil {
+		t.Fatal(err)
+	}
+	return HashCommit(c)
+}
+
+func TestCommitGraph_AncestryWithStaleGraph(t *testing.T) {
+	chdirTemp(t)
+
+	root := appendTestCommit(t, 0)
+	base := appendTestCommit(t, 1, root)
+	left := appendTestCommit(t, 2, base)
+
+	count, err := WriteCommitGraph()
+	if err != nil || count != 3 {
+		t.Fatalf("WriteCommitGraph = %d, %v", count, err)
+	}
+	if err := VerifyCommitGraph(); err != nil {
+		t.Fatalf("VerifyCommitGraph failed: %v", err)
+	}
+
+	// These commits are newer than the graph and must be read from their objects
+	right := appendTestCommit(t, 3, base)
+	tip := appendTestCommit(t, 4, right)
+
+	for _, tc := range []struct {
+		a, d string
+		want bool
+	}{
+		{root, tip, true},
+		{base, left, true},
+		{left, tip, false},
+		{tip, root, false},
+		{right, tip, true},
+	} {
+		got, err := IsAncestor(tc.a, tc.d)
+		if err != nil || got != tc.want {
+			t.Errorf("IsAncestor(%.7s, %.7s) = %v, %v; want %v", tc.a, tc.d, got, err, tc.want)
+		}
+	}
+
+	if mb, err := FindMergeBase(left, tip); err != nil || mb != base {
+		t.Errorf("FindMergeBase = %.7s, %v; want %.7s", mb, err, base)
+	}
+
+	ids, err := RevList([]string{tip, left}, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{tip, right, left, base, root}; !slices.Equal(ids, want) {
+		t.Errorf("RevList = %v, want %v", ids, want)
+	}
+	if ids, _ := RevList([]string{tip}, nil, 2); len(ids) != 2 {
+		t.Errorf("RevList limit ignored: %v", ids)
+	}
+}
+
+func TestCommitGraph_CorruptGraphIsIgnored(t *testing.T) {
+	chdirTemp(t)
+
+	root := appendTestCommit(t, 0)
+	tip := appendTestCommit(t, 1, root)
+	if _, err := WriteCommitGraph(); err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(commitGraphPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content[len(content)/2] ^= 0xff
+	if err := os.WriteFile(commitGraphPath, content, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := VerifyCommitGraph(); err == nil {
+		t.Error("VerifyCommitGraph accepted a corrupt graph")
+	}
+	if ok, err := IsAncestor(root, tip); err != nil || !ok {
+		t.Errorf("IsAncestor with corrupt graph = %v, %v", ok, err)
+	}
+}
+
+func TestCommitGraph_MergeCommits(t *testing.T) {
+	chdirTemp(t)
+
+	root := appendTestCommit(t, 0)
+	left := appendTestCommit(t, 1, root)
+	right := appendTestCommit(t, 2, root)
+	merge := appendTestCommit(t, 3, left, right)
+	after := appendTestCommit(t, 4, merge)
+
+	c, err := FindCommit(merge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(c.Parents, []string{left, right}) {
+		t.Fatalf("parents not preserved in order: %v", c.Parents)
+	}
+
+	for _, withGraph := range []bool{false, true} {
+		if withGraph {
+			if _, err := WriteCommitGraph(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if ok, err := IsAncestor(right, after); err != nil || !ok {
+			t.Errorf("graph=%v: second parent not reachable through merge: %v, %v", withGraph, ok, err)
+		}
+		if mb, err := FindMergeBase(right, after); err != nil || mb != right {
+			t.Errorf("graph=%v: FindMergeBase = %.7s, %v; want %.7s", withGraph, mb, err, right)
+		}
+		ids, err := RevList([]string{after}, []string{left}, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := []string{after, merge, right}; !slices.Equal(ids, want) {
+			t.Errorf("graph=%v: RevList = %v, want %v", withGraph, ids, want)
+		}
+	}
+	if err := VerifyCommitGraph(); err != nil {
+		t.Errorf("VerifyCommitGraph failed: %v", err)
+	}
+}
+
+func TestFindMergeBases_CrissCrossAndUnrelated(t *testing.T) {
+	chdirTemp(t)
+
+	// a and b each merge the other's first commit, so both of those are best bases
+	root := appendTestCommit(t, 0)
+	a1 := appendTestCommit(t, 1, root)
+	b1 := appendTestCommit(t, 2, root)
+	a2 := appendTestCommit(t, 3, a1, b1)
+	b2 := appendTestCommit(t, 4, b1, a1)
+	other := appendTestCommit(t, 5)
+
+	bases, err := FindMergeBases(a2, b2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{a1, b1}
+	slices.Sort(want)
+	if !slices.Equal(bases, want) {
+		t.Errorf("FindMergeBases(criss-cross) = %v, want %v", bases, want)
+	}
+
+	if bases, err := FindMergeBases(a2, a1); err != nil || !slices.Equal(bases, []string{a1}) {
+		t.Errorf("FindMergeBases(descendant) = %v, %v; want [%s]", bases, err, a1)
+	}
+	if bases, err := FindMergeBases(a2, other); err != nil || len(bases) != 0 {
+		t.Errorf("FindMergeBases(unrelated) = %v, %v; want none", bases, err)
+	}
+}
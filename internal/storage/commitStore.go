@@ -2,24 +2,130 @@ package storage
 
 import (
 	"bufio"
-	"encoding/json"
+	"container/heap"
 	"errors"
 	"fmt"
 	"os"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/LeeFred3042U/kitcat/internal/models"
 )
 
 var ErrNoCommits = errors.New("no commits yet")
 
+// commitsPath is the commit journal: one commit ID per line, in creation order.
+// The commits themselves live in the object store.
 const commitsPath = ".kitcat/commits.log"
 
-// Appends commit as NDJSON
+// EncodeCommit serializes a commit into the payload of a commit object.
+// Every field except the ID is covered, so the ID is a hash over all of them.
+func EncodeCommit(c models.Commit) []byte {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "tree %s\n", c.TreeHash)
+	for _, parent := range c.Parents {
+		fmt.Fprintf(&sb, "parent %s\n", parent)
+	}
+	fmt.Fprintf(&sb, "author %s <%s> %d %s\n",
+		c.AuthorName, c.AuthorEmail, c.Timestamp.Unix(), c.Timestamp.Format("-0700"))
+	if c.Signature != "" {
+		fmt.Fprintf(&sb, "signature %s\n", c.Signature)
+	}
+	sb.WriteString("\n")
+	sb.WriteString(c.Message)
+	return []byte(sb.String())
+}
+
+// CommitSigningPayload is what a commit's signature signs: the commit object without
+// its signature line
+func CommitSigningPayload(c models.Commit) []byte {
+	c.Signature = ""
+	return EncodeCommit(c)
+}
+
+// DecodeCommit parses the payload of a commit object
+func DecodeCommit(id string, data []byte) (models.Commit, error) {
+	c := models.Commit{ID: id}
+	header, message, _ := strings.Cut(string(data), "\n\n")
+	c.Message = message
+
+	for _, line := range strings.Split(header, "\n") {
+		key, value, _ := strings.Cut(line, " ")
+		switch key {
+		case "tree":
+			c.TreeHash = value
+		case "parent":
+			c.Parents = append(c.Parents, value)
+		case "author":
+			name, email, ts, err := parseSignature(value)
+			if err != nil {
+				return models.Commit{}, fmt.Errorf("commit %s: %w", id, err)
+			}
+			c.AuthorName, c.AuthorEmail, c.Timestamp = name, email, ts
+		case "signature":
+			c.Signature = value
+		}
+	}
+	if c.TreeHash == "" {
+		return models.Commit{}, fmt.Errorf("commit %s has no tree", id)
+	}
+	return c, nil
+}
+
+// parseSignature parses "Name <email> <unix-seconds> <+hhmm>"
+func parseSignature(value string) (string, string, time.Time, error) {
+	open := strings.LastIndex(value, " <")
+	closeIdx := strings.LastIndex(value, ">")
+	if open < 0 || closeIdx < open {
+		return "", "", time.Time{}, fmt.Errorf("malformed signature %q", value)
+	}
+	name := value[:open]
+	email := value[open+2 : closeIdx]
+
+	fields := strings.Fields(value[closeIdx+1:])
+	if len(fields) != 2 {
+		return "", "", time.Time{}, fmt.Errorf("malformed signature date %q", value)
+	}
+	secs, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return "", "", time.Time{}, fmt.Errorf("malformed signature date %q", value)
+	}
+	zone, err := time.Parse("-0700", fields[1])
+	if err != nil {
+		return "", "", time.Time{}, fmt.Errorf("malformed signature zone %q", value)
+	}
+	_, offset := zone.Zone()
+	loc := time.UTC
+	if offset != 0 {
+		loc = time.FixedZone(fields[1], offset)
+	}
+	return name, email, time.Unix(secs, 0).In(loc), nil
+}
+
+// HashCommit returns the ID a commit will be stored under
+func HashCommit(c models.Commit) string {
+	return HashObject(ObjectCommit, EncodeCommit(c))
+}
+
+// AppendCommit stores the commit as an object and records it in the commit journal.
+// The stored ID is always derived from the content (see HashCommit); c.ID is not trusted.
 func AppendCommit(commit models.Commit) error {
-	if err := os.MkdirAll(".kitcat", 0o755); err != nil {
+	if err := MigrateLegacyCommitLog(); err != nil {
+		return err
+	}
+
+	payload := EncodeCommit(commit)
+	id := HashObject(ObjectCommit, payload)
+	existed := ObjectExists(id)
+	if _, err := WriteObject(ObjectCommit, payload); err != nil {
 		return err
 	}
+	// Re-creating an identical commit must not list it twice
+	if existed {
+		return nil
+	}
 
 	f, err := os.OpenFile(commitsPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
 	if err != nil {
@@ -33,81 +139,107 @@ func AppendCommit(commit models.Commit) error {
 	// Defer close to unlock
 	defer f.Close()
 
-	enc := json.NewEncoder(f)
-	if err := enc.Encode(commit); err != nil {
+	if _, err := fmt.Fprintln(f, id); err != nil {
 		return err
 	}
 	return f.Sync()
 }
 
-// Reads commits (NDJSON)
-func ReadCommits() ([]models.Commit, error) {
-	var commits []models.Commit
-	if _, err := os.Stat(commitsPath); os.IsNotExist(err) {
-		return commits, nil
+// ReadCommitJournal returns the commit IDs recorded in the journal, oldest first
+func ReadCommitJournal() ([]string, error) {
+	if err := MigrateLegacyCommitLog(); err != nil {
+		return nil, err
 	}
 
 	f, err := os.Open(commitsPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
 	if err != nil {
 		return nil, err
 	}
 	defer f.Close()
 
+	var ids []string
 	scanner := bufio.NewScanner(f)
 	for scanner.Scan() {
-		var c models.Commit
-		if err := json.Unmarshal(scanner.Bytes(), &c); err != nil {
+		if id := strings.TrimSpace(scanner.Text()); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids, scanner.Err()
+}
+
+// Reads all commits recorded in the journal, oldest first
+func ReadCommits() ([]models.Commit, error) {
+	ids, err := ReadCommitJournal()
+	if err != nil {
+		return nil, err
+	}
+
+	var commits []models.Commit
+	for _, id := range ids {
+		c, err := readCommitObject(id)
+		if err != nil {
 			continue
 		}
 		commits = append(commits, c)
 	}
-	return commits, scanner.Err()
+	return commits, nil
 }
 
 // Returns ErrNoCommits when none exist
 func GetLastCommit() (models.Commit, error) {
-	commits, err := ReadCommits()
+	ids, err := ReadCommitJournal()
 	if err != nil {
 		return models.Commit{}, err
 	}
-	if len(commits) == 0 {
+	if len(ids) == 0 {
 		return models.Commit{}, ErrNoCommits
 	}
-	return commits[len(commits)-1], nil
+	return readCommitObject(ids[len(ids)-1])
+}
+
+// readCommitObject loads a commit object by its full ID
+func readCommitObject(id string) (models.Commit, error) {
+	objType, data, err := ReadObject(id)
+	if err != nil {
+		return models.Commit{}, err
+	}
+	if objType != ObjectCommit {
+		return models.Commit{}, fmt.Errorf("object %s is a %s, not a commit", id, objType)
+	}
+	return DecodeCommit(id, data)
 }
 
-// Search the commit log for a commit with a matching hash
+// Look up a commit in the object store
 // Supports both full hashes and short hashes (prefix matching)
 func FindCommit(hash string) (models.Commit, error) {
-	file, err := os.Open(commitsPath)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return models.Commit{}, ErrNoCommits
-		}
+	if err := MigrateLegacyCommitLog(); err != nil {
 		return models.Commit{}, err
 	}
-	defer file.Close()
+	if hash == "" {
+		return models.Commit{}, ErrNoCommits
+	}
 
-	var matches []models.Commit
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		var commit models.Commit
-		if err := json.Unmarshal(scanner.Bytes(), &commit); err != nil {
-			continue
-		}
-		// Exact match (full hash)
-		if commit.ID == hash {
-			return commit, nil
-		}
-		// Prefix match (short hash)
-		if strings.HasPrefix(commit.ID, hash) {
-			matches = append(matches, commit)
+	// Exact match (full hash)
+	if isHexHash(hash) {
+		if ObjectExists(hash) {
+			return readCommitObject(hash)
 		}
 	}
 
-	if err := scanner.Err(); err != nil {
+	// Prefix match (short hash): only commit objects count
+	candidates, err := FindObjectsByPrefix(hash)
+	if err != nil {
 		return models.Commit{}, err
 	}
+	var matches []models.Commit
+	for _, id := range candidates {
+		if c, err := readCommitObject(id); err == nil {
+			matches = append(matches, c)
+		}
+	}
 
 	// If we found exactly one prefix match, return it
 	if len(matches) == 1 {
@@ -123,10 +255,15 @@ func FindCommit(hash string) (models.Commit, error) {
 		)
 	}
 
+	if _, err := os.Stat(commitsPath); os.IsNotExist(err) {
+		return models.Commit{}, ErrNoCommits
+	}
 	return models.Commit{}, fmt.Errorf("commit with hash %s not found", hash)
 }
 
-// IsAncestor returns true if ancestorHash is equal to or is an ancestor of descendantHash
+// IsAncestor returns true if ancestorHash is equal to or is an ancestor of descendantHash.
+// Generation numbers from the commit-graph cut the walk short: a commit cannot reach
+// anything with a generation as high as its own.
 func IsAncestor(ancestorHash, descendantHash string) (bool, error) {
 	if ancestorHash == "" || descendantHash == "" {
 		return false, nil
@@ -136,52 +273,140 @@ func IsAncestor(ancestorHash, descendantHash string) (bool, error) {
 		return true, nil
 	}
 
-	current := descendantHash
-	for current != "" {
-		c, err := FindCommit(current)
-		if err != nil {
-			return false, err
+	r := newCommitReader()
+	start, err := r.node(descendantHash)
+	if err != nil {
+		return false, err
+	}
+	// An ancestor that is not in the graph gives no bound
+	var minGen uint32
+	target := ancestorHash
+	if a, err := r.node(ancestorHash); err == nil {
+		target = a.id
+		if a.gen != genUnknown {
+			minGen = a.gen
 		}
-		if c.ID == ancestorHash {
+	}
+
+	seen := map[string]bool{start.id: true}
+	stack := []*commitNode{start}
+	for len(stack) > 0 {
+		n := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if n.id == target {
 			return true, nil
 		}
-		// walk up
-		current = c.Parent
+		if n.gen != 0 && n.gen <= minGen {
+			continue
+		}
+		for _, p := range n.parents {
+			if seen[p] {
+				continue
+			}
+			seen[p] = true
+			pn, err := r.node(p)
+			if err != nil {
+				return false, err
+			}
+			stack = append(stack, pn)
+		}
 	}
 	return false, nil
 }
 
-// FindMergeBase calculates the best common ancestor between two commits.
-// Uses a simple ancestry path intersection (assumes linear/simple branching for now).
+// FindMergeBase calculates the best common ancestor between two commits: the common
+// ancestor with the highest generation, which cannot be an ancestor of another one.
 func FindMergeBase(hash1, hash2 string) (string, error) {
 	if hash1 == hash2 {
 		return hash1, nil
 	}
 
-	// Trace ancestry of hash1
-	ancestors1 := make(map[string]bool)
-	current := hash1
-	for current != "" {
-		ancestors1[current] = true
-		c, err := FindCommit(current)
-		if err != nil {
-			return "", err
+	r := newCommitReader()
+	ancestors1, err := r.ancestors(hash1)
+	if err != nil {
+		return "", err
+	}
+	start, err := r.node(hash2)
+	if err != nil {
+		return "", err
+	}
+
+	// Walk back from hash2 highest generation first; the first commit that hash1 can
+	// also reach is the answer
+	q := &generationQueue{}
+	seen := map[string]bool{start.id: true}
+	r.generation(start.id)
+	heap.Push(q, start)
+	for q.Len() > 0 {
+		n := heap.Pop(q).(*commitNode)
+		if ancestors1[n.id] {
+			return n.id, nil
+		}
+		for _, p := range n.parents {
+			if seen[p] {
+				continue
+			}
+			seen[p] = true
+			pn, err := r.node(p)
+			if err != nil {
+				return "", err
+			}
+			r.generation(pn.id)
+			heap.Push(q, pn)
 		}
-		current = c.Parent
 	}
 
-	// Trace ancestry of hash2 and find first match
-	current = hash2
-	for current != "" {
-		if ancestors1[current] {
-			return current, nil
+	return "", fmt.Errorf("no common ancestor found")
+}
+
+// FindMergeBases returns every best common ancestor of the two commits: the common
+// ancestors that are not themselves ancestors of another common ancestor. Criss-cross
+// histories have more than one; unrelated histories have none.
+func FindMergeBases(hash1, hash2 string) ([]string, error) {
+	r := newCommitReader()
+	ancestors1, err := r.ancestors(hash1)
+	if err != nil {
+		return nil, err
+	}
+	ancestors2, err := r.ancestors(hash2)
+	if err != nil {
+		return nil, err
+	}
+
+	// Every ancestor of a common ancestor is common too, so walking back from the parents
+	// of the common ancestors marks exactly the ones that are not best
+	var stack []string
+	for id := range ancestors1 {
+		if !ancestors2[id] {
+			continue
+		}
+		n, err := r.node(id)
+		if err != nil {
+			return nil, err
 		}
-		c, err := FindCommit(current)
+		stack = append(stack, n.parents...)
+	}
+	redundant := make(map[string]bool)
+	for len(stack) > 0 {
+		id := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if redundant[id] {
+			continue
+		}
+		redundant[id] = true
+		n, err := r.node(id)
 		if err != nil {
-			return "", err
+			return nil, err
 		}
-		current = c.Parent
+		stack = append(stack, n.parents...)
 	}
 
-	return "", fmt.Errorf("no common ancestor found")
+	var bases []string
+	for id := range ancestors1 {
+		if ancestors2[id] && !redundant[id] {
+			bases = append(bases, id)
+		}
+	}
+	sort.Strings(bases)
+	return bases, nil
 }
@@ -0,0 +1,150 @@
+package storage
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/LeeFred3042U/kitcat/internal/models"
+)
+
+func TestAppendCommit_StoresCommitObject(t *testing.T) {
+	chdirTemp(t)
+
+	c := models.Commit{
+		TreeHash:    "4b825dc642cb6eb9a060e54bf8d69288fbee4904",
+		Message:     "first\n\nwith body",
+		Timestamp:   time.Unix(1700000000, 0).UTC(),
+		AuthorName:  "Ada",
+		AuthorEmail: "ada@example.com",
+	}
+	if err := AppendCommit(c); err != nil {
+		t.Fatalf("AppendCommit failed: %v", err)
+	}
+	id := HashCommit(c)
+
+	objType, _, err := ReadObject(id)
+	if err != nil {
+		t.Fatalf("commit object missing: %v", err)
+	}
+	if objType != ObjectCommit {
+		t.Errorf("expected commit object, got %s", objType)
+	}
+
+	found, err := FindCommit(id[:7])
+	if err != nil {
+		t.Fatalf("FindCommit by short hash failed: %v", err)
+	}
+	if found.ID != id || found.Message != c.Message || found.AuthorName != "Ada" ||
+		found.AuthorEmail != "ada@example.com" || !found.Timestamp.Equal(c.Timestamp) {
+		t.Errorf("round trip mismatch: %+v", found)
+	}
+
+	last, err := GetLastCommit()
+	if err != nil || last.ID != id {
+		t.Errorf("GetLastCommit = %v, %v; want %s", last.ID, err, id)
+	}
+}
+
+func TestHashCommit_CoversAuthor(t *testing.T) {
+	base := models.Commit{
+		TreeHash:    "4b825dc642cb6eb9a060e54bf8d69288fbee4904",
+		Message:     "same",
+		Timestamp:   time.Unix(1700000000, 0).UTC(),
+		AuthorName:  "Ada",
+		AuthorEmail: "ada@example.com",
+	}
+	other := base
+	other.AuthorName = "Grace"
+	if HashCommit(base) == HashCommit(other) {
+		t.Error("commits differing only by author name must not share an ID")
+	}
+	other = base
+	other.AuthorEmail = "grace@example.com"
+	if HashCommit(base) == HashCommit(other) {
+		t.Error("commits differing only by author email must not share an ID")
+	}
+}
+
+func TestCommitSignature_RoundTrip(t *testing.T) {
+	chdirTemp(t)
+
+	c := models.Commit{
+		TreeHash:    "4b825dc642cb6eb9a060e54bf8d69288fbee4904",
+		Message:     "signed",
+		Timestamp:   time.Unix(1700000000, 0).UTC(),
+		AuthorName:  "Ada",
+		AuthorEmail: "ada@example.com",
+	}
+	unsigned := CommitSigningPayload(c)
+	c.Signature = "ed25519 cHVia2V5 c2lnbmF0dXJl"
+	if string(CommitSigningPayload(c)) != string(unsigned) {
+		t.Error("the signing payload must not depend on the signature")
+	}
+	if HashCommit(c) == HashCommit(models.Commit{TreeHash: c.TreeHash, Message: c.Message,
+		Timestamp: c.Timestamp, AuthorName: c.AuthorName, AuthorEmail: c.AuthorEmail}) {
+		t.Error("the signature must be part of the commit ID")
+	}
+
+	if err := AppendCommit(c); err != nil {
+		t.Fatal(err)
+	}
+	found, err := FindCommit(HashCommit(c))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found.Signature != c.Signature || found.Message != c.Message {
+		t.Errorf("round trip lost the signature: %+v", found)
+	}
+}
+
+func TestMigrateLegacyCommitLog(t *testing.T) {
+	chdirTemp(t)
+
+	if err := os.MkdirAll(".kitcat/refs/heads", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	root := legacyCommit{ID: "oldroot", TreeHash: "t1", Message: "root", Timestamp: time.Unix(1, 0).UTC()}
+	child := legacyCommit{ID: "oldchild", Parent: "oldroot", TreeHash: "t2", Message: "child", Timestamp: time.Unix(2, 0).UTC()}
+
+	var log strings.Builder
+	for _, c := range []legacyCommit{root, child} {
+		line, err := json.Marshal(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		log.Write(line)
+		log.WriteString("\n")
+	}
+	if err := os.WriteFile(commitsPath, []byte(log.String()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(".kitcat/refs/heads/main", []byte("oldchild"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	commits, err := ReadCommits()
+	if err != nil {
+		t.Fatalf("ReadCommits failed: %v", err)
+	}
+	if len(commits) != 2 {
+		t.Fatalf("expected 2 migrated commits, got %d", len(commits))
+	}
+
+	ref, err := os.ReadFile(".kitcat/refs/heads/main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	head, err := FindCommit(strings.TrimSpace(string(ref)))
+	if err != nil {
+		t.Fatalf("branch ref was not rewritten to a migrated commit: %v", err)
+	}
+	if head.Message != "child" || head.FirstParent() != commits[0].ID {
+		t.Errorf("migrated history is wrong: %+v", head)
+	}
+	if _, err := os.Stat(legacyCommitLogBackup); err != nil {
+		t.Errorf("legacy log backup missing: %v", err)
+	}
+}
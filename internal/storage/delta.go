@@ -0,0 +1,179 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// Deltas describe a target object as a sequence of instructions against a base object.
+// The layout follows git's: the base and target sizes as varints, then a list of
+//
+//	copy:   1oooossss followed by the offset and size bytes flagged in the low 7 bits
+//	insert: 0nnnnnnn followed by n literal bytes (1 <= n <= 127)
+const (
+	deltaBlockSize = 16
+	deltaMaxInsert = 0x7f
+	deltaMaxCopy   = 0xffffff
+)
+
+var errDeltaCorrupt = errors.New("corrupt delta")
+
+// createDelta encodes target as a delta against base
+func createDelta(base, target []byte) []byte {
+	var out bytes.Buffer
+	writeDeltaVarint(&out, uint64(len(base)))
+	writeDeltaVarint(&out, uint64(len(target)))
+
+	// Index the base by fixed-size blocks; the first occurrence of a block wins
+	blocks := make(map[string]int, len(base)/deltaBlockSize+1)
+	for i := 0; i+deltaBlockSize <= len(base); i += deltaBlockSize {
+		key := string(base[i : i+deltaBlockSize])
+		if _, ok := blocks[key]; !ok {
+			blocks[key] = i
+		}
+	}
+
+	var pending []byte
+	flush := func() {
+		for len(pending) > 0 {
+			n := min(len(pending), deltaMaxInsert)
+			out.WriteByte(byte(n))
+			out.Write(pending[:n])
+			pending = pending[n:]
+		}
+	}
+
+	for i := 0; i < len(target); {
+		offset, ok := -1, false
+		if i+deltaBlockSize <= len(target) {
+			offset, ok = blocks[string(target[i:i+deltaBlockSize])]
+		}
+		if !ok {
+			pending = append(pending, target[i])
+			i++
+			continue
+		}
+
+		length := deltaBlockSize
+		for offset+length < len(base) && i+length < len(target) && base[offset+length] == target[i+length] {
+			length++
+		}
+		// Pull matching bytes back out of the pending insert
+		for len(pending) > 0 && offset > 0 && base[offset-1] == pending[len(pending)-1] {
+			pending = pending[:len(pending)-1]
+			offset--
+			i--
+			length++
+		}
+
+		flush()
+		for done := 0; done < length; {
+			n := min(length-done, deltaMaxCopy)
+			writeDeltaCopy(&out, offset+done, n)
+			done += n
+		}
+		i += length
+	}
+	flush()
+	return out.Bytes()
+}
+
+// applyDelta rebuilds a target object from its base and a delta
+func applyDelta(base, delta []byte) ([]byte, error) {
+	r := bytes.NewReader(delta)
+	baseSize, err := readDeltaVarint(r)
+	if err != nil || baseSize != uint64(len(base)) {
+		return nil, fmt.Errorf("%w: base size mismatch", errDeltaCorrupt)
+	}
+	targetSize, err := readDeltaVarint(r)
+	if err != nil {
+		return nil, errDeltaCorrupt
+	}
+
+	out := make([]byte, 0, targetSize)
+	for r.Len() > 0 {
+		op, _ := r.ReadByte()
+		if op&0x80 == 0 {
+			if op == 0 || int(op) > r.Len() {
+				return nil, errDeltaCorrupt
+			}
+			start := len(delta) - r.Len()
+			out = append(out, delta[start:start+int(op)]...)
+			r.Seek(int64(op), 1)
+			continue
+		}
+
+		var offset, size uint64
+		for bit := 0; bit < 7; bit++ {
+			if op&(1<<bit) == 0 {
+				continue
+			}
+			b, err := r.ReadByte()
+			if err != nil {
+				return nil, errDeltaCorrupt
+			}
+			if bit < 4 {
+				offset |= uint64(b) << (8 * bit)
+			} else {
+				size |= uint64(b) << (8 * (bit - 4))
+			}
+		}
+		if size == 0 {
+			size = 0x10000
+		}
+		if offset+size > uint64(len(base)) {
+			return nil, fmt.Errorf("%w: copy outside base", errDeltaCorrupt)
+		}
+		out = append(out, base[offset:offset+size]...)
+	}
+
+	if uint64(len(out)) != targetSize {
+		return nil, fmt.Errorf("%w: result size mismatch", errDeltaCorrupt)
+	}
+	return out, nil
+}
+
+// writeDeltaCopy emits a copy instruction, leaving out zero offset and size bytes
+func writeDeltaCopy(out *bytes.Buffer, offset, size int) {
+	op := byte(0x80)
+	var args []byte
+	for bit := 0; bit < 4; bit++ {
+		if b := byte(offset >> (8 * bit)); b != 0 {
+			op |= 1 << bit
+			args = append(args, b)
+		}
+	}
+	for bit := 0; bit < 3; bit++ {
+		if b := byte(size >> (8 * bit)); b != 0 {
+			op |= 1 << (4 + bit)
+			args = append(args, b)
+		}
+	}
+	out.WriteByte(op)
+	out.Write(args)
+}
+
+// writeDeltaVarint writes v seven bits at a time, least significant group first
+func writeDeltaVarint(out *bytes.Buffer, v uint64) {
+	for v >= 0x80 {
+		out.WriteByte(byte(v) | 0x80)
+		v >>= 7
+	}
+	out.WriteByte(byte(v))
+}
+
+func readDeltaVarint(r *bytes.Reader) (uint64, error) {
+	var v uint64
+	for shift := 0; shift < 64; shift += 7 {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		v |= uint64(b&0x7f) << shift
+		if b&0x80 == 0 {
+			return v, nil
+		}
+	}
+	return 0, errDeltaCorrupt
+}
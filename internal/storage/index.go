@@ -1,49 +1,203 @@
 package storage
 
 import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
 	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 )
 
 const indexPath = ".kitcat/index"
 
-// LoadIndex reads the .kitcat/index file (in JSON format) and returns it as a map
+// The index is stored as a binary file:
+//
+//	header:  "KIDX" | uint32 version | uint32 entry count
+//	entry:   int64 ctime | int64 mtime | uint64 dev | uint64 ino | uint32 mode |
+//	         int64 size | uint16 hash length | hex hash | uint32 path length | path
+//	trailer: SHA-1 of everything before it
+//
+// Version 2 adds a section for paths left in conflict by a merge, after the entries:
+//
+//	uint32 unmerged count
+//	unmerged: uint32 path length | path | 3 stages (base, ours, theirs)
+//	stage:    uint8 present | uint32 mode | uint16 hash length | hex hash
+//
+// Version 1 is written whenever there are no conflicts.
+// All integers are big-endian and entries are sorted by path.
+// Indexes written by older versions are JSON and are still read.
+const (
+	indexSignature       = "KIDX"
+	indexVersion         = 1
+	indexVersionUnmerged = 2
+)
+
+// IndexEntry is the staged state of a single path.
+// The stat fields cache what the file looked like when it was last hashed,
+// so an unchanged file can be recognised without reading it.
+type IndexEntry struct {
+	Hash string `json:"hash"`
+	Mode uint32 `json:"mode"`
+
+	Ctime int64  `json:"-"` // nanoseconds; zero where the platform has none
+	Mtime int64  `json:"-"` // nanoseconds; zero means no cached stat data
+	Dev   uint64 `json:"-"`
+	Ino   uint64 `json:"-"`
+	Size  int64  `json:"-"`
+
+	// racy is set on load when the file was modified in the same second the
+	// index was written, so its stat data cannot prove the content is unchanged
+	racy bool
+}
+
+// UnmergedEntry is a path a merge could not resolve. It holds the file as it was in the
+// merge base and on each side; a nil stage means the path does not exist there.
+// An unmerged path has no regular entry until the conflict is resolved.
+type UnmergedEntry struct {
+	Base   *IndexEntry
+	Ours   *IndexEntry
+	Theirs *IndexEntry
+}
+
+func (u UnmergedEntry) stages() [3]*IndexEntry {
+	return [3]*IndexEntry{u.Base, u.Ours, u.Theirs}
+}
+
+// SameFile reports whether two entries stage the same content with the same mode,
+// ignoring cached stat data
+func (e IndexEntry) SameFile(o IndexEntry) bool {
+	return e.Hash == o.Hash && e.Mode == o.Mode
+}
+
+// SetStat records the stat data of the file the entry was just hashed from
+func (e *IndexEntry) SetStat(info os.FileInfo) {
+	e.Ctime, e.Dev, e.Ino = fileStat(info)
+	e.Mtime = info.ModTime().UnixNano()
+	e.Size = info.Size()
+	e.racy = false
+}
+
+// StatMatches reports whether info matches the cached stat data, meaning the file
+// can be assumed to still have the entry's content without hashing it
+func (e IndexEntry) StatMatches(info os.FileInfo) bool {
+	if e.Mtime == 0 || e.racy {
+		return false
+	}
+	ctime, dev, ino := fileStat(info)
+	return e.Mtime == info.ModTime().UnixNano() &&
+		e.Size == info.Size() &&
+		e.Ctime == ctime && e.Dev == dev && e.Ino == ino &&
+		FileModeOf(info, e.Mode) == e.Mode
+}
+
+// LoadIndex reads the .kitcat/index file and returns it as a map of path -> hash
 // It returns an empty map if the file doesn't exist, which is normal for a new repository
 func LoadIndex() (map[string]string, error) {
+	entries, err := loadIndexInternal()
+	if err != nil {
+		return nil, err
+	}
+	return entriesToHashes(entries), nil
+}
+
+// LoadIndexEntries reads the index including the mode of every entry
+func LoadIndexEntries() (map[string]IndexEntry, error) {
 	return loadIndexInternal()
 }
 
-func loadIndexInternal() (map[string]string, error) {
-	index := make(map[string]string)
+// LoadUnmergedEntries returns the paths left in conflict by a merge
+func LoadUnmergedEntries() (map[string]UnmergedEntry, error) {
+	_, unmerged, err := readIndexFile()
+	return unmerged, err
+}
+
+// HasUnmergedEntries reports whether the index still records any conflicts
+func HasUnmergedEntries() (bool, error) {
+	unmerged, err := LoadUnmergedEntries()
+	return len(unmerged) > 0, err
+}
+
+func loadIndexInternal() (map[string]IndexEntry, error) {
+	index, _, err := readIndexFile()
+	return index, err
+}
+
+func readIndexFile() (map[string]IndexEntry, map[string]UnmergedEntry, error) {
+	index := make(map[string]IndexEntry)
+	unmerged := make(map[string]UnmergedEntry)
 
 	content, err := os.ReadFile(indexPath)
 	if os.IsNotExist(err) {
 		// File doesn't exist, return empty index. This is not an error ^-^
-		return index, nil
+		return index, unmerged, nil
 	}
 	if err != nil {
-		return nil, fmt.Errorf("could not read index file: %w", err)
+		return nil, nil, fmt.Errorf("could not read index file: %w", err)
 	}
 
 	// If the file is empty, avoid a JSON error.
 	if len(content) == 0 {
-		return index, nil
+		return index, unmerged, nil
+	}
+
+	if bytes.HasPrefix(content, []byte(indexSignature)) {
+		if err := decodeIndex(content, index, unmerged); err != nil {
+			return nil, nil, err
+		}
+		markRacyEntries(index)
+		return index, unmerged, nil
 	}
 
-	if err := json.Unmarshal(content, &index); err != nil {
-		return nil, fmt.Errorf("could not parse index file: %w", err)
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(content, &raw); err != nil {
+		return nil, nil, fmt.Errorf("could not parse index file: %w", err)
+	}
+	for path, value := range raw {
+		// Older indexes map each path straight to its hash
+		var hash string
+		if err := json.Unmarshal(value, &hash); err == nil {
+			index[path] = IndexEntry{Hash: hash, Mode: ModeRegular}
+			continue
+		}
+		var entry IndexEntry
+		if err := json.Unmarshal(value, &entry); err != nil {
+			return nil, nil, fmt.Errorf("could not parse index entry %s: %w", path, err)
+		}
+		if entry.Mode == 0 {
+			entry.Mode = ModeRegular
+		}
+		index[path] = entry
 	}
 
-	return index, nil
+	return index, unmerged, nil
 }
 
 // UpdateIndex safely updates the index by locking it before reading.
-// The callback function 'fn' is allowed to modify the index map.
+// The callback function 'fn' is allowed to modify the path -> hash map.
+// Paths that keep their place in the index keep their recorded mode.
 // If 'fn' returns nil, the modified index is written to disk.
 // If 'fn' returns an error, the operation is aborted and nothing is written.
+// Staging an unmerged path marks its conflict as resolved.
 func UpdateIndex(fn func(index map[string]string) error) error {
+	return UpdateIndexEntries(func(entries map[string]IndexEntry) error {
+		hashes := entriesToHashes(entries)
+		if err := fn(hashes); err != nil {
+			return err
+		}
+		merged := hashesToEntries(hashes, entries)
+		clear(entries)
+		for path, entry := range merged {
+			entries[path] = entry
+		}
+		return nil
+	})
+}
+
+// UpdateIndexEntries is UpdateIndex for callers that need to read or set modes
+func UpdateIndexEntries(fn func(index map[string]IndexEntry) error) error {
 	// Ensure the parent directory (.kitcat) exists.
 	if err := os.MkdirAll(filepath.Dir(indexPath), 0o755); err != nil {
 		return err
@@ -57,7 +211,7 @@ func UpdateIndex(fn func(index map[string]string) error) error {
 	defer unlock(l)
 
 	// 2. Load the index (without locking, since we already hold the lock)
-	index, err := loadIndexInternal()
+	index, unmerged, err := readIndexFile()
 	if err != nil {
 		return err
 	}
@@ -66,14 +220,36 @@ func UpdateIndex(fn func(index map[string]string) error) error {
 	if err := fn(index); err != nil {
 		return err // Abort transaction
 	}
+	for path := range unmerged {
+		if _, staged := index[path]; staged {
+			delete(unmerged, path)
+		}
+	}
 
 	// 4. Write the updated index (without locking, as we hold it)
-	return writeIndexInternal(index)
+	return writeIndexInternal(index, unmerged)
 }
 
-// WriteIndex writes the index map to the .kitcat/index file atomically using a JSON format
-// It uses SafeWriteFile to ensure atomic and durable writes
+// WriteIndex writes the path -> hash map to the .kitcat/index file atomically.
+// Paths already in the index keep their recorded mode; new paths are regular files.
 func WriteIndex(index map[string]string) error {
+	return UpdateIndexEntries(func(entries map[string]IndexEntry) error {
+		merged := hashesToEntries(index, entries)
+		clear(entries)
+		for path, entry := range merged {
+			entries[path] = entry
+		}
+		return nil
+	})
+}
+
+// WriteIndexEntries replaces the whole index with the given entries, dropping any conflicts
+func WriteIndexEntries(index map[string]IndexEntry) error {
+	return WriteUnmergedIndex(index, nil)
+}
+
+// WriteUnmergedIndex replaces the whole index with the given entries and conflicts
+func WriteUnmergedIndex(index map[string]IndexEntry, unmerged map[string]UnmergedEntry) error {
 	// Ensure the parent directory (.kitcat) exists.
 	if err := os.MkdirAll(filepath.Dir(indexPath), 0o755); err != nil {
 		return err
@@ -86,18 +262,284 @@ func WriteIndex(index map[string]string) error {
 	}
 	defer unlock(l)
 
-	return writeIndexInternal(index)
+	return writeIndexInternal(index, unmerged)
+}
+
+// ResolveUnmerged drops the conflicts recorded for paths, or all of them if none are given,
+// leaving the regular entries alone
+func ResolveUnmerged(paths ...string) error {
+	if err := os.MkdirAll(filepath.Dir(indexPath), 0o755); err != nil {
+		return err
+	}
+	l, err := lock(indexPath)
+	if err != nil {
+		return err
+	}
+	defer unlock(l)
+
+	index, unmerged, err := readIndexFile()
+	if err != nil {
+		return err
+	}
+	if len(paths) == 0 {
+		clear(unmerged)
+	}
+	for _, path := range paths {
+		delete(unmerged, path)
+	}
+	return writeIndexInternal(index, unmerged)
 }
 
 // writeIndexInternal writes the index without acquiring a lock.
 // Caller must ensure the lock is held.
-func writeIndexInternal(index map[string]string) error {
-	// Marshal the index to JSON with indentation
-	data, err := json.MarshalIndent(index, "", "  ")
+func writeIndexInternal(index map[string]IndexEntry, unmerged map[string]UnmergedEntry) error {
+	smudgeRacyEntries(index)
+
+	data, err := encodeIndex(index, unmerged)
 	if err != nil {
-		return fmt.Errorf("failed to marshal index: %w", err)
+		return fmt.Errorf("failed to encode index: %w", err)
 	}
 
 	// Use SafeWriteFile to atomically write the index
 	return SafeWriteFile(indexPath, data, 0644)
 }
+
+// RefreshIndexStat records fresh stat data for files that were hashed and found
+// unchanged, so the next run can skip them. Entries whose hash or mode changed in
+// the meantime are left alone.
+func RefreshIndexStat(updates map[string]IndexEntry) error {
+	return UpdateIndexEntries(func(index map[string]IndexEntry) error {
+		for path, update := range updates {
+			if current, ok := index[path]; ok && current.Hash == update.Hash && current.Mode == update.Mode {
+				index[path] = update
+			}
+		}
+		return nil
+	})
+}
+
+// markRacyEntries flags entries whose file was modified no earlier than the second the
+// index was written: a later change within that same second leaves mtime and size as
+// they were, so such entries must be verified by content.
+func markRacyEntries(index map[string]IndexEntry) {
+	info, err := os.Stat(indexPath)
+	if err != nil {
+		return
+	}
+	written := info.ModTime().Unix()
+	for path, entry := range index {
+		if entry.Mtime != 0 && entry.Mtime/1e9 >= written {
+			entry.racy = true
+			index[path] = entry
+		}
+	}
+}
+
+// smudgeRacyEntries runs before each write. Racy entries that are carried over would stop
+// looking racy once the index gets a newer timestamp, so their content is checked now and
+// the stat data of any that changed is dropped, forcing a rehash later.
+func smudgeRacyEntries(index map[string]IndexEntry) {
+	for path, entry := range index {
+		if !entry.racy {
+			continue
+		}
+		entry.racy = false
+		info, err := os.Lstat(path)
+		if err != nil || FileModeOf(info, entry.Mode) != entry.Mode {
+			entry.Mtime = 0
+		} else if matches, err := FileMatchesHash(path, entry.Hash); err != nil || !matches {
+			entry.Mtime = 0
+		}
+		index[path] = entry
+	}
+}
+
+// encodeIndex serialises the index in the binary format, sorted by path
+func encodeIndex(index map[string]IndexEntry, unmerged map[string]UnmergedEntry) ([]byte, error) {
+	paths := make([]string, 0, len(index))
+	for path := range index {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	version := indexVersion
+	if len(unmerged) > 0 {
+		version = indexVersionUnmerged
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(indexSignature)
+	binary.Write(&buf, binary.BigEndian, uint32(version))
+	binary.Write(&buf, binary.BigEndian, uint32(len(paths)))
+
+	for _, path := range paths {
+		e := index[path]
+		binary.Write(&buf, binary.BigEndian, e.Ctime)
+		binary.Write(&buf, binary.BigEndian, e.Mtime)
+		binary.Write(&buf, binary.BigEndian, e.Dev)
+		binary.Write(&buf, binary.BigEndian, e.Ino)
+		binary.Write(&buf, binary.BigEndian, e.Mode)
+		binary.Write(&buf, binary.BigEndian, e.Size)
+		binary.Write(&buf, binary.BigEndian, uint16(len(e.Hash)))
+		buf.WriteString(e.Hash)
+		binary.Write(&buf, binary.BigEndian, uint32(len(path)))
+		buf.WriteString(path)
+	}
+
+	if version == indexVersionUnmerged {
+		conflicted := make([]string, 0, len(unmerged))
+		for path := range unmerged {
+			conflicted = append(conflicted, path)
+		}
+		sort.Strings(conflicted)
+		binary.Write(&buf, binary.BigEndian, uint32(len(conflicted)))
+		for _, path := range conflicted {
+			binary.Write(&buf, binary.BigEndian, uint32(len(path)))
+			buf.WriteString(path)
+			for _, stage := range unmerged[path].stages() {
+				if stage == nil {
+					buf.WriteByte(0)
+					continue
+				}
+				buf.WriteByte(1)
+				binary.Write(&buf, binary.BigEndian, stage.Mode)
+				binary.Write(&buf, binary.BigEndian, uint16(len(stage.Hash)))
+				buf.WriteString(stage.Hash)
+			}
+		}
+	}
+
+	sum := sha1.Sum(buf.Bytes())
+	buf.Write(sum[:])
+	return buf.Bytes(), nil
+}
+
+// decodeIndex parses a binary index into index and unmerged, verifying its checksum
+func decodeIndex(content []byte, index map[string]IndexEntry, unmerged map[string]UnmergedEntry) error {
+	if len(content) < len(indexSignature)+8+sha1.Size {
+		return fmt.Errorf("index file corrupted: too short")
+	}
+	body, trailer := content[:len(content)-sha1.Size], content[len(content)-sha1.Size:]
+	if sum := sha1.Sum(body); !bytes.Equal(sum[:], trailer) {
+		return fmt.Errorf("index file corrupted: checksum mismatch")
+	}
+
+	r := bytes.NewReader(body[len(indexSignature):])
+	var version, count uint32
+	binary.Read(r, binary.BigEndian, &version)
+	binary.Read(r, binary.BigEndian, &count)
+	if version != indexVersion && version != indexVersionUnmerged {
+		return fmt.Errorf("unsupported index version %d", version)
+	}
+
+	for i := uint32(0); i < count; i++ {
+		var e IndexEntry
+		var hashLen uint16
+		fields := []any{&e.Ctime, &e.Mtime, &e.Dev, &e.Ino, &e.Mode, &e.Size, &hashLen}
+		for _, field := range fields {
+			if err := binary.Read(r, binary.BigEndian, field); err != nil {
+				return fmt.Errorf("index file corrupted: truncated entry %d", i)
+			}
+		}
+		hash, ok := readIndexString(r, int64(hashLen))
+		if !ok {
+			return fmt.Errorf("index file corrupted: truncated entry %d", i)
+		}
+		var pathLen uint32
+		if err := binary.Read(r, binary.BigEndian, &pathLen); err != nil {
+			return fmt.Errorf("index file corrupted: truncated entry %d", i)
+		}
+		path, ok := readIndexString(r, int64(pathLen))
+		if !ok {
+			return fmt.Errorf("index file corrupted: truncated entry %d", i)
+		}
+		e.Hash = hash
+		index[path] = e
+	}
+	if version == indexVersionUnmerged {
+		if err := decodeUnmerged(r, unmerged); err != nil {
+			return err
+		}
+	}
+	if r.Len() != 0 {
+		return fmt.Errorf("index file corrupted: trailing data")
+	}
+	return nil
+}
+
+// decodeUnmerged parses the conflict section of a version 2 index
+func decodeUnmerged(r *bytes.Reader, unmerged map[string]UnmergedEntry) error {
+	var count uint32
+	if err := binary.Read(r, binary.BigEndian, &count); err != nil {
+		return fmt.Errorf("index file corrupted: truncated conflict section")
+	}
+	for i := uint32(0); i < count; i++ {
+		truncated := fmt.Errorf("index file corrupted: truncated conflict %d", i)
+		var pathLen uint32
+		if err := binary.Read(r, binary.BigEndian, &pathLen); err != nil {
+			return truncated
+		}
+		path, ok := readIndexString(r, int64(pathLen))
+		if !ok {
+			return truncated
+		}
+		var stages [3]*IndexEntry
+		for s := range stages {
+			present, err := r.ReadByte()
+			if err != nil {
+				return truncated
+			}
+			if present == 0 {
+				continue
+			}
+			var e IndexEntry
+			var hashLen uint16
+			if binary.Read(r, binary.BigEndian, &e.Mode) != nil || binary.Read(r, binary.BigEndian, &hashLen) != nil {
+				return truncated
+			}
+			if e.Hash, ok = readIndexString(r, int64(hashLen)); !ok {
+				return truncated
+			}
+			stages[s] = &e
+		}
+		unmerged[path] = UnmergedEntry{Base: stages[0], Ours: stages[1], Theirs: stages[2]}
+	}
+	return nil
+}
+
+// entriesToHashes drops modes, giving the plain path -> hash view of the index
+func entriesToHashes(entries map[string]IndexEntry) map[string]string {
+	hashes := make(map[string]string, len(entries))
+	for path, entry := range entries {
+		hashes[path] = entry.Hash
+	}
+	return hashes
+}
+
+// readIndexString reads n bytes as a string, reporting false if fewer remain
+func readIndexString(r *bytes.Reader, n int64) (string, bool) {
+	if n > int64(r.Len()) {
+		return "", false
+	}
+	b := make([]byte, n)
+	r.Read(b)
+	return string(b), true
+}
+
+// hashesToEntries rebuilds entries from a path -> hash map, taking modes from previous.
+// Entries whose hash is unchanged are kept whole, including their stat data.
+func hashesToEntries(hashes map[string]string, previous map[string]IndexEntry) map[string]IndexEntry {
+	entries := make(map[string]IndexEntry, len(hashes))
+	for path, hash := range hashes {
+		prev, ok := previous[path]
+		switch {
+		case ok && prev.Hash == hash:
+			entries[path] = prev
+		case ok:
+			entries[path] = IndexEntry{Hash: hash, Mode: prev.Mode}
+		default:
+			entries[path] = IndexEntry{Hash: hash, Mode: ModeRegular}
+		}
+	}
+	return entries
+}
@@ -0,0 +1,133 @@
+package storage
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestIndex_StatCacheAndRacyClean(t *testing.T) {
+	chdirTemp(t)
+
+	if err := os.WriteFile("a.txt", []byte("one"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if err := os.Chtimes("a.txt", old, old); err != nil {
+		t.Fatal(err)
+	}
+	hash, err := HashAndStoreFile("a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, _ := os.Lstat("a.txt")
+	entry := IndexEntry{Hash: hash, Mode: ModeRegular}
+	entry.SetStat(info)
+	if err := WriteIndexEntries(map[string]IndexEntry{"a.txt": entry}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A file last modified well before the index was written is trusted by stat
+	loaded, err := LoadIndexEntries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !loaded["a.txt"].StatMatches(info) {
+		t.Fatalf("stat data did not round trip: %+v", loaded["a.txt"])
+	}
+
+	// Index written in the same second the file was modified: the entry is racy
+	if err := os.Chtimes(indexPath, old, old); err != nil {
+		t.Fatal(err)
+	}
+	loaded, _ = LoadIndexEntries()
+	if loaded["a.txt"].StatMatches(info) {
+		t.Fatal("racily clean entry must not be trusted by stat")
+	}
+
+	// Same-size change within that second: mtime and size still match the cache
+	if err := os.WriteFile("a.txt", []byte("two"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes("a.txt", old, old); err != nil {
+		t.Fatal(err)
+	}
+	// Rewriting the index gives it a newer timestamp, so the racy entry must be smudged
+	if err := UpdateIndexEntries(func(map[string]IndexEntry) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	info, _ = os.Lstat("a.txt")
+	loaded, _ = LoadIndexEntries()
+	if loaded["a.txt"].StatMatches(info) {
+		t.Error("change within the racy second went unnoticed after the index was rewritten")
+	}
+	if loaded["a.txt"].Hash != hash {
+		t.Error("smudging must keep the staged hash")
+	}
+}
+
+func TestLoadIndex_ReadsJSONIndex(t *testing.T) {
+	chdirTemp(t)
+
+	if err := os.MkdirAll(".kitcat", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	legacy := `{"a.txt": "da39a3ee5e6b4b0d3255bfef95601890afd80709", "run.sh": {"hash": "8843d7f92416211de9ebb963ff4ce28125932878", "mode": 33261}}`
+	if err := os.WriteFile(indexPath, []byte(legacy), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := LoadIndexEntries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entries["a.txt"].Mode != ModeRegular || entries["run.sh"].Mode != ModeExecutable {
+		t.Errorf("unexpected entries: %+v", entries)
+	}
+}
+
+func TestIndex_UnmergedEntries(t *testing.T) {
+	chdirTemp(t)
+
+	base := &IndexEntry{Hash: "da39a3ee5e6b4b0d3255bfef95601890afd80709", Mode: ModeRegular}
+	ours := &IndexEntry{Hash: "8843d7f92416211de9ebb963ff4ce28125932878", Mode: ModeExecutable}
+	entries := map[string]IndexEntry{"clean.txt": *base}
+	unmerged := map[string]UnmergedEntry{
+		"both.txt":    {Base: base, Ours: ours, Theirs: base},
+		"deleted.txt": {Base: base, Ours: ours},
+	}
+	if err := WriteUnmergedIndex(entries, unmerged); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadUnmergedEntries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded) != 2 || loaded["deleted.txt"].Theirs != nil || *loaded["both.txt"].Ours != *ours {
+		t.Errorf("unexpected unmerged entries: %+v", loaded)
+	}
+
+	// Staging a conflicted path resolves it; other conflicts survive the write
+	err = UpdateIndexEntries(func(index map[string]IndexEntry) error {
+		index["both.txt"] = *base
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, _ = LoadUnmergedEntries()
+	if _, ok := loaded["both.txt"]; ok || len(loaded) != 1 {
+		t.Errorf("staging did not resolve the conflict: %+v", loaded)
+	}
+
+	if err := ResolveUnmerged(); err != nil {
+		t.Fatal(err)
+	}
+	if conflicted, _ := HasUnmergedEntries(); conflicted {
+		t.Error("ResolveUnmerged left conflicts behind")
+	}
+	if index, _ := LoadIndex(); len(index) != 2 {
+		t.Errorf("regular entries changed: %v", index)
+	}
+}
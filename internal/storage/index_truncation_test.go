@@ -1,7 +1,7 @@
 package storage
 
 import (
-	"encoding/json"
+	"bytes"
 	"os"
 	"testing"
 )
@@ -43,17 +43,20 @@ func TestIndexWriteSanity(t *testing.T) {
 		t.Fatalf("Failed to read index file at %s: %v", targetPath, err)
 	}
 
-	// Assert Valid JSON
-	var loadedMap map[string]string
-	if err := json.Unmarshal(content, &loadedMap); err != nil {
-		t.Fatalf("Index file contains invalid JSON: %v", err)
+	// Assert a complete, checksummed binary index
+	if !bytes.HasPrefix(content, []byte(indexSignature)) {
+		t.Fatalf("Index file is missing its signature")
+	}
+	loadedMap := make(map[string]IndexEntry)
+	if err := decodeIndex(content, loadedMap, make(map[string]UnmergedEntry)); err != nil {
+		t.Fatalf("Index file is invalid: %v", err)
 	}
 
 	// Assert Content Integrity
 	if len(loadedMap) != 2 {
 		t.Errorf("Expected 2 entries, got %d", len(loadedMap))
 	}
-	if loadedMap["test_file.txt"] != "da39a3ee5e6b4b0d3255bfef95601890afd80709" {
+	if loadedMap["test_file.txt"].Hash != "da39a3ee5e6b4b0d3255bfef95601890afd80709" {
 		t.Errorf("Index content mismatch for test_file.txt")
 	}
 }
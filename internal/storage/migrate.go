@@ -0,0 +1,218 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/LeeFred3042U/kitcat/internal/models"
+)
+
+// legacyCommit is a commit record as older versions wrote it to commits.log.
+// Commits could only have a single parent then.
+type legacyCommit struct {
+	ID          string
+	Parent      string
+	Message     string
+	Timestamp   time.Time
+	TreeHash    string
+	AuthorName  string
+	AuthorEmail string
+}
+
+// legacyCommitLogBackup keeps the original NDJSON log after migration, for safety
+const legacyCommitLogBackup = ".kitcat/commits.log.legacy"
+
+// isLegacyCommitLog reports whether commits.log still holds NDJSON commit records
+// rather than a journal of commit IDs.
+func isLegacyCommitLog() (bool, error) {
+	f, err := os.Open(commitsPath)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return false, nil
+		}
+		if b == ' ' || b == '\n' || b == '\r' || b == '\t' {
+			continue
+		}
+		return b == '{', nil
+	}
+}
+
+// MigrateLegacyCommitLog converts a repository that kept whole commits in commits.log
+// into commit objects. Legacy commit IDs did not cover every field, so each commit is
+// re-hashed (parents first) and every reference to an old ID is rewritten: branch and
+// tag refs, a detached HEAD, the stash stack and any in-progress rebase state.
+// It must run before any of those are read; once migrated, it does nothing.
+func MigrateLegacyCommitLog() error {
+	legacy, err := isLegacyCommitLog()
+	if err != nil || !legacy {
+		return err
+	}
+
+	l, err := lock(commitsPath)
+	if err != nil {
+		return err
+	}
+	defer unlock(l)
+
+	// Another process may have finished the migration while we waited for the lock
+	if legacy, err := isLegacyCommitLog(); err != nil || !legacy {
+		return err
+	}
+
+	content, err := os.ReadFile(commitsPath)
+	if err != nil {
+		return err
+	}
+
+	var order []string
+	byID := make(map[string]legacyCommit)
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var c legacyCommit
+		if err := json.Unmarshal(scanner.Bytes(), &c); err != nil || c.ID == "" {
+			continue
+		}
+		if _, seen := byID[c.ID]; !seen {
+			order = append(order, c.ID)
+		}
+		byID[c.ID] = c
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	// Re-hash commits parents first, remembering old -> new IDs
+	mapping := make(map[string]string)
+	var convert func(oldID string, depth int) (string, error)
+	convert = func(oldID string, depth int) (string, error) {
+		if newID, ok := mapping[oldID]; ok {
+			return newID, nil
+		}
+		c, ok := byID[oldID]
+		if !ok || depth > len(byID) {
+			// Unknown parent: keep the reference unchanged
+			return oldID, nil
+		}
+		commit := models.Commit{
+			Message:     c.Message,
+			Timestamp:   c.Timestamp.UTC(),
+			TreeHash:    c.TreeHash,
+			AuthorName:  c.AuthorName,
+			AuthorEmail: c.AuthorEmail,
+		}
+		if c.Parent != "" {
+			parent, err := convert(c.Parent, depth+1)
+			if err != nil {
+				return "", err
+			}
+			commit.Parents = []string{parent}
+		}
+		newID, err := WriteObject(ObjectCommit, EncodeCommit(commit))
+		if err != nil {
+			return "", err
+		}
+		mapping[oldID] = newID
+		return newID, nil
+	}
+
+	var journal strings.Builder
+	written := make(map[string]bool)
+	for _, oldID := range order {
+		newID, err := convert(oldID, 0)
+		if err != nil {
+			return fmt.Errorf("failed to migrate commit %s: %w", oldID, err)
+		}
+		if !written[newID] {
+			written[newID] = true
+			journal.WriteString(newID + "\n")
+		}
+	}
+
+	if err := rewriteCommitReferences(mapping); err != nil {
+		return fmt.Errorf("failed to rewrite references during commit migration: %w", err)
+	}
+	if err := SafeWriteFile(legacyCommitLogBackup, content, 0o644); err != nil {
+		return err
+	}
+	return SafeWriteFile(commitsPath, []byte(journal.String()), 0o644)
+}
+
+// rewriteCommitReferences replaces old commit IDs with their migrated IDs in every
+// file that can name a commit.
+func rewriteCommitReferences(mapping map[string]string) error {
+	var files []string
+	err := filepath.WalkDir(".kitcat/refs", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	files = append(files,
+		".kitcat/HEAD",
+		stashPath,
+		".kitcat/rebase-merge/onto",
+		".kitcat/rebase-merge/orig-head",
+		".kitcat/rebase-merge/git-rebase-todo",
+	)
+
+	for _, path := range files {
+		data, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		updated := replaceCommitIDs(string(data), mapping)
+		if updated == string(data) {
+			continue
+		}
+		if err := SafeWriteFile(path, []byte(updated), 0o644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// replaceCommitIDs swaps every whitespace-separated token found in mapping
+func replaceCommitIDs(content string, mapping map[string]string) string {
+	lines := strings.Split(content, "\n")
+	for i, line := range lines {
+		fields := strings.Fields(line)
+		changed := false
+		for j, field := range fields {
+			if newID, ok := mapping[field]; ok {
+				fields[j] = newID
+				changed = true
+			}
+		}
+		if changed {
+			lines[i] = strings.Join(fields, " ")
+		}
+	}
+	return strings.Join(lines, "\n")
+}
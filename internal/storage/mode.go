@@ -0,0 +1,41 @@
+package storage
+
+import (
+	"os"
+	"runtime"
+)
+
+// FileModeOf maps the result of os.Lstat to the mode recorded in the index and in trees.
+// Windows has no executable bit, so there a regular file keeps the mode it was staged with.
+func FileModeOf(info os.FileInfo, staged uint32) uint32 {
+	if info.Mode()&os.ModeSymlink != 0 {
+		return ModeSymlink
+	}
+	if runtime.GOOS == "windows" {
+		if staged == ModeExecutable {
+			return ModeExecutable
+		}
+		return ModeRegular
+	}
+	if info.Mode().Perm()&0o111 != 0 {
+		return ModeExecutable
+	}
+	return ModeRegular
+}
+
+// ModeOfPath returns the index mode of the file at path, without following symlinks
+func ModeOfPath(path string, staged uint32) (uint32, error) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return 0, err
+	}
+	return FileModeOf(info, staged), nil
+}
+
+// Perm returns the permission bits a checked-out file with this mode should get
+func Perm(mode uint32) os.FileMode {
+	if mode == ModeExecutable {
+		return 0o755
+	}
+	return 0o644
+}
@@ -0,0 +1,340 @@
+package storage
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	objectsDir = ".kitcat/objects"
+)
+
+// Object types understood by the object store
+const (
+	ObjectBlob   = "blob"
+	ObjectTree   = "tree"
+	ObjectCommit = "commit"
+	ObjectTag    = "tag"
+
+	// ObjectLegacy is an object stored raw, before objects carried a type. Legacy objects
+	// are blobs and flat trees; which one is known only from what refers to them.
+	ObjectLegacy = "legacy"
+)
+
+// objectHeader builds the "<type> <size>\0" prefix that is hashed and stored with every object
+func objectHeader(objType string, size int64) []byte {
+	return []byte(fmt.Sprintf("%s %d\x00", objType, size))
+}
+
+// objectPath returns the location of a loose object on disk.
+// Objects are fanned out by the first two hex digits: objects/ab/cdef...
+func objectPath(hash string) string {
+	if len(hash) < 3 {
+		return flatObjectPath(hash)
+	}
+	return filepath.Join(objectsDir, hash[:2], hash[2:])
+}
+
+// flatObjectPath is where objects lived before the directory was sharded
+func flatObjectPath(hash string) string {
+	return filepath.Join(objectsDir, hash)
+}
+
+// ObjectExists reports whether the object is stored, loose or in a pack
+func ObjectExists(hash string) bool {
+	if looseObjectExists(hash) {
+		return true
+	}
+	_, _, ok := findPackedObject(hash)
+	return ok
+}
+
+// looseObjectExists reports whether the object is stored loose in either layout
+func looseObjectExists(hash string) bool {
+	if _, err := os.Stat(objectPath(hash)); err == nil {
+		return true
+	}
+	_, err := os.Stat(flatObjectPath(hash))
+	return err == nil
+}
+
+// HashObject computes the ID an object would be stored under, without writing it
+func HashObject(objType string, data []byte) string {
+	h := sha1.New()
+	h.Write(objectHeader(objType, int64(len(data))))
+	h.Write(data)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// WriteObject stores data as a typed, zlib-compressed object and returns its hash.
+// Writing an object that already exists is a no-op.
+func WriteObject(objType string, data []byte) (string, error) {
+	hash := HashObject(objType, data)
+	if err := writeLooseObject(hash, objType, int64(len(data)), bytes.NewReader(data)); err != nil {
+		return "", err
+	}
+	return hash, nil
+}
+
+// writeLooseObject compresses "<type> <size>\0<payload>" into the objects directory.
+// The payload is streamed from r, which must yield exactly size bytes.
+func writeLooseObject(hash, objType string, size int64, r io.Reader) error {
+	if ObjectExists(hash) {
+		return nil
+	}
+	path := objectPath(hash)
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(dir, "obj-*.tmp")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+
+	zw := zlib.NewWriter(tmp)
+	_, err = zw.Write(objectHeader(objType, size))
+	var n int64
+	if err == nil {
+		n, err = io.Copy(zw, r)
+	}
+	if err == nil && n != size {
+		err = fmt.Errorf("object %s changed while being stored", hash)
+	}
+	if closeErr := zw.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+
+	if err := os.Rename(tmpName, path); err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+	return nil
+}
+
+// ReadObject reads a loose or packed object and returns its type and payload.
+// Objects written before the typed format existed are returned as-is, as ObjectLegacy.
+func ReadObject(hash string) (string, []byte, error) {
+	objType, data, _, err := readObject(hash)
+	return objType, data, err
+}
+
+// readObject is ReadObject that also reports whether the object uses the legacy raw format
+func readObject(hash string) (objType string, data []byte, legacy bool, err error) {
+	return readObjectDepth(hash, 0)
+}
+
+// readObjectDepth looks for a loose object first and then in the packs.
+// depth counts the delta bases followed so far to reach this object.
+func readObjectDepth(hash string, depth int) (objType string, data []byte, legacy bool, err error) {
+	raw, err := readLooseObject(hash)
+	if os.IsNotExist(err) {
+		if p, offset, ok := findPackedObject(hash); ok {
+			objType, data, err := readPackedObject(p, offset, depth)
+			return objType, data, false, err
+		}
+	}
+	if err != nil {
+		return "", nil, false, err
+	}
+
+	objType, data, err = decodeObject(raw)
+	if err != nil {
+		// Only an object named by the plain SHA-1 of its bytes is in the legacy format;
+		// anything else that fails to decode is damaged
+		if sum := sha1.Sum(raw); hex.EncodeToString(sum[:]) != hash {
+			return "", nil, false, fmt.Errorf("object %s is corrupt: %w", hash, err)
+		}
+		return ObjectLegacy, raw, true, nil
+	}
+	return objType, data, false, nil
+}
+
+// readLooseObject returns the stored bytes of an object, looking in its shard
+// directory first and then at the flat location used by older repositories
+func readLooseObject(hash string) ([]byte, error) {
+	raw, err := os.ReadFile(objectPath(hash))
+	if os.IsNotExist(err) {
+		if flat, flatErr := os.ReadFile(flatObjectPath(hash)); flatErr == nil {
+			return flat, nil
+		}
+	}
+	return raw, err
+}
+
+// decodeObject inflates a stored object and splits it into its type and payload
+func decodeObject(raw []byte) (string, []byte, error) {
+	zr, err := zlib.NewReader(bytes.NewReader(raw))
+	if err != nil {
+		return "", nil, err
+	}
+	defer zr.Close()
+
+	buf, err := io.ReadAll(zr)
+	if err != nil {
+		return "", nil, err
+	}
+	return splitObject(buf)
+}
+
+// splitObject parses "<type> <size>\0<payload>" and validates the declared size
+func splitObject(buf []byte) (string, []byte, error) {
+	nul := bytes.IndexByte(buf, 0)
+	if nul < 0 {
+		return "", nil, fmt.Errorf("malformed object header")
+	}
+	objType, sizeStr, ok := strings.Cut(string(buf[:nul]), " ")
+	if !ok {
+		return "", nil, fmt.Errorf("malformed object header %q", buf[:nul])
+	}
+	size, err := strconv.Atoi(sizeStr)
+	if err != nil {
+		return "", nil, fmt.Errorf("malformed object size %q", sizeStr)
+	}
+	payload := buf[nul+1:]
+	if len(payload) != size {
+		return "", nil, fmt.Errorf("object size mismatch: header says %d, got %d", size, len(payload))
+	}
+	return objType, payload, nil
+}
+
+// isFlatTree reports whether tree data is in the old "hash path" line format rather
+// than binary entries. Binary entries start with an octal mode, never a 40-digit hash.
+func isFlatTree(data []byte) bool {
+	hash, _, ok := strings.Cut(string(data), " ")
+	return ok && isHexHash(hash)
+}
+
+// isHexHash reports whether s looks like a full SHA-1 hex digest
+func isHexHash(s string) bool {
+	if len(s) != 40 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+// IsLegacyObject reports whether the object is stored in the pre-typed raw format.
+// Legacy objects are named by the plain SHA-1 of their content rather than of the typed form.
+func IsLegacyObject(hash string) bool {
+	_, _, legacy, err := readObject(hash)
+	return err == nil && legacy
+}
+
+// FindObjectsByPrefix returns the IDs of all stored objects whose hash starts with prefix.
+// Shard directories, objects left in the flat layout and packs are all searched.
+func FindObjectsByPrefix(prefix string) ([]string, error) {
+	prefix = strings.ToLower(prefix)
+	matches, err := findLooseObjects(prefix)
+	if err != nil {
+		return nil, err
+	}
+	packed, err := packedObjectHashes()
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool, len(matches))
+	for _, hash := range matches {
+		seen[hash] = true
+	}
+	for _, hash := range packed {
+		if strings.HasPrefix(hash, prefix) && !seen[hash] {
+			seen[hash] = true
+			matches = append(matches, hash)
+		}
+	}
+	return matches, nil
+}
+
+// findLooseObjects lists loose objects in both layouts whose hash starts with prefix
+func findLooseObjects(prefix string) ([]string, error) {
+	entries, err := os.ReadDir(objectsDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	var matches []string
+	add := func(hash string) {
+		if isHexHash(hash) && strings.HasPrefix(hash, prefix) && !seen[hash] {
+			seen[hash] = true
+			matches = append(matches, hash)
+		}
+	}
+	for _, e := range entries {
+		name := e.Name()
+		if !e.IsDir() {
+			add(name)
+			continue
+		}
+		if len(name) != 2 || !isHexPrefix(name) {
+			continue
+		}
+		// Only shards that can hold the prefix need listing
+		if len(prefix) >= 2 && name != prefix[:2] || len(prefix) == 1 && name[0] != prefix[0] {
+			continue
+		}
+		shard, err := os.ReadDir(filepath.Join(objectsDir, name))
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range shard {
+			if !obj.IsDir() {
+				add(name + obj.Name())
+			}
+		}
+	}
+	return matches, nil
+}
+
+// ResolveObject expands a full or abbreviated object hash to the full ID of a stored object
+func ResolveObject(prefix string) (string, error) {
+	if isHexHash(prefix) && ObjectExists(strings.ToLower(prefix)) {
+		return strings.ToLower(prefix), nil
+	}
+	if !isHexPrefix(prefix) {
+		return "", fmt.Errorf("object %s not found", prefix)
+	}
+	matches, err := FindObjectsByPrefix(prefix)
+	if err != nil {
+		return "", err
+	}
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("object %s not found", prefix)
+	case 1:
+		return matches[0], nil
+	default:
+		return "", fmt.Errorf("ambiguous short hash %s (matches %d objects)", prefix, len(matches))
+	}
+}
+
+// isHexPrefix reports whether s is made only of hex digits
+func isHexPrefix(s string) bool {
+	for _, c := range strings.ToLower(s) {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return s != ""
+}
@@ -0,0 +1,268 @@
+package storage
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// chdirTemp switches into a fresh temp directory for the duration of the test
+func chdirTemp(t *testing.T) {
+	t.Helper()
+	originalWd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = os.Chdir(originalWd)
+	})
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatalf("Failed to chdir to temp dir: %v", err)
+	}
+}
+
+func TestWriteObject_RoundTrip(t *testing.T) {
+	chdirTemp(t)
+
+	payload := []byte("hello\nworld\n")
+	hash, err := WriteObject(ObjectBlob, payload)
+	if err != nil {
+		t.Fatalf("WriteObject failed: %v", err)
+	}
+
+	// Same ID as git would compute for the blob
+	if hash != "94954abda49de8615a048f8d2e64b5de848e27a1" {
+		t.Fatalf("unexpected hash %s", hash)
+	}
+
+	objType, data, err := ReadObject(hash)
+	if err != nil {
+		t.Fatalf("ReadObject failed: %v", err)
+	}
+	if objType != ObjectBlob {
+		t.Errorf("expected type blob, got %s", objType)
+	}
+	if string(data) != string(payload) {
+		t.Errorf("payload mismatch: got %q", data)
+	}
+
+	// The on-disk bytes must be compressed, not the raw payload, in the object's shard
+	raw, err := os.ReadFile(filepath.Join(objectsDir, hash[:2], hash[2:]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(raw) == string(payload) {
+		t.Error("object was stored uncompressed")
+	}
+}
+
+func TestHashAndStoreFile_MatchesHashFile(t *testing.T) {
+	chdirTemp(t)
+
+	if err := os.WriteFile("a.txt", []byte("content"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := HashAndStoreFile("a.txt")
+	if err != nil {
+		t.Fatalf("HashAndStoreFile failed: %v", err)
+	}
+	hashed, err := HashFile("a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored != hashed {
+		t.Errorf("HashAndStoreFile %s != HashFile %s", stored, hashed)
+	}
+	if stored != HashObject(ObjectBlob, []byte("content")) {
+		t.Errorf("file hash does not match blob hash")
+	}
+}
+
+func TestReadObject_LegacyRawObjects(t *testing.T) {
+	chdirTemp(t)
+
+	if err := os.MkdirAll(objectsDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	blob := []byte("old content")
+	sum := sha1.Sum(blob)
+	blobHash := hex.EncodeToString(sum[:])
+	if err := os.WriteFile(filepath.Join(objectsDir, blobHash), blob, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tree := []byte(blobHash + " file.txt\n")
+	sum = sha1.Sum(tree)
+	treeHash := hex.EncodeToString(sum[:])
+	if err := os.WriteFile(filepath.Join(objectsDir, treeHash), tree, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	objType, data, err := ReadObject(blobHash)
+	if err != nil {
+		t.Fatalf("ReadObject failed on legacy blob: %v", err)
+	}
+	if objType != ObjectLegacy || string(data) != string(blob) {
+		t.Errorf("legacy blob read as %s %q", objType, data)
+	}
+
+	parsed, err := ParseTree(treeHash)
+	if err != nil {
+		t.Fatalf("ParseTree failed on legacy tree: %v", err)
+	}
+	if parsed["file.txt"] != blobHash {
+		t.Errorf("legacy tree entry mismatch: %v", parsed)
+	}
+
+	// A working file with the legacy content is not reported as modified
+	if err := os.WriteFile("file.txt", blob, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	matches, err := FileMatchesHash("file.txt", blobHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !matches {
+		t.Error("FileMatchesHash should accept legacy blob hashes")
+	}
+}
+
+func TestHashAndStoreFile_SymlinkNotFollowed(t *testing.T) {
+	chdirTemp(t)
+
+	if err := os.WriteFile("target.txt", []byte("content"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("target.txt", "link"); err != nil {
+		t.Skipf("symlinks unsupported: %v", err)
+	}
+
+	hash, err := HashAndStoreFile("link")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := HashObject(ObjectBlob, []byte("target.txt")); hash != want {
+		t.Errorf("symlink hashed as %s, want blob of its target %s", hash, want)
+	}
+	if matches, err := FileMatchesHash("link", hash); err != nil || !matches {
+		t.Errorf("FileMatchesHash(link) = %v, %v", matches, err)
+	}
+
+	info, err := os.Lstat("link")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := FileModeOf(info, ModeRegular); mode != ModeSymlink {
+		t.Errorf("FileModeOf(link) = %o, want %o", mode, ModeSymlink)
+	}
+}
+
+func TestObjects_ShardedAndFlatLayouts(t *testing.T) {
+	chdirTemp(t)
+
+	sharded, err := WriteObject(ObjectBlob, []byte("new layout"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(objectsDir, sharded)); !os.IsNotExist(err) {
+		t.Error("new objects must not be written to the flat layout")
+	}
+
+	// An object left behind by an older version, in the flat layout
+	flatPayload := []byte("old layout")
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	zw.Write(objectHeader(ObjectBlob, int64(len(flatPayload))))
+	zw.Write(flatPayload)
+	zw.Close()
+	flat := HashObject(ObjectBlob, flatPayload)
+	if err := os.WriteFile(filepath.Join(objectsDir, flat), buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, hash := range []string{sharded, flat} {
+		if _, data, err := ReadObject(hash); err != nil || len(data) == 0 {
+			t.Errorf("ReadObject(%s) = %q, %v", hash, data, err)
+		}
+		resolved, err := ResolveObject(hash[:8])
+		if err != nil || resolved != hash {
+			t.Errorf("ResolveObject(%s) = %s, %v", hash[:8], resolved, err)
+		}
+	}
+
+	// Storing the flat object again must not duplicate it into a shard
+	if _, err := WriteObject(ObjectBlob, flatPayload); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(objectPath(flat)); !os.IsNotExist(err) {
+		t.Error("existing flat object was rewritten into a shard")
+	}
+
+	all, err := FindObjectsByPrefix("")
+	if err != nil || len(all) != 2 {
+		t.Errorf("FindObjectsByPrefix(\"\") = %v, %v; want both objects", all, err)
+	}
+}
+
+func TestReadObject_TruncatedObjectIsCorrupt(t *testing.T) {
+	chdirTemp(t)
+
+	hash, err := WriteObject(ObjectBlob, []byte("some content that compresses\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(objectsDir, hash[:2], hash[2:])
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, raw[:len(raw)/2], 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if objType, data, err := ReadObject(hash); err == nil {
+		t.Errorf("truncated object read as %s %q", objType, data)
+	}
+	if IsLegacyObject(hash) {
+		t.Error("truncated object taken for a legacy one")
+	}
+}
+
+func TestReadObject_LegacyTypeComesFromReferrer(t *testing.T) {
+	chdirTemp(t)
+
+	if err := os.MkdirAll(objectsDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	writeLegacy := func(content string) string {
+		t.Helper()
+		sum := sha1.Sum([]byte(content))
+		hash := hex.EncodeToString(sum[:])
+		if err := os.WriteFile(filepath.Join(objectsDir, hash), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return hash
+	}
+	// Blobs that look like a commit header and like a flat tree
+	notes := writeLegacy("tree of life\nsecond\n")
+	sums := writeLegacy("da39a3ee5e6b4b0d3255bfef95601890afd80709 empty.txt\n")
+	tree := writeLegacy(notes + " notes.txt\n" + sums + " SHA1SUMS\n")
+
+	for _, hash := range []string{notes, sums, tree} {
+		objType, err := VerifyObject(hash)
+		if err != nil || objType != ObjectLegacy {
+			t.Errorf("VerifyObject(%.7s) = %q, %v; want a legacy object", hash, objType, err)
+		}
+	}
+	entries, err := ReadTree(tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Hash != notes || entries[1].Hash != sums {
+		t.Errorf("legacy tree entries = %+v", entries)
+	}
+}
@@ -0,0 +1,549 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Packs hold many objects in one file. Each pack-<checksum>.pack has a matching .idx
+// that maps object hashes to offsets, and a pack is only used once its index exists.
+//
+//	pack:  "PACK" | uint32 version | uint32 count | entries | SHA-1 of everything before
+//	entry: type byte | uvarint size | [20-byte base hash if delta] | zlib data
+//	idx:   "PIDX" | uint32 version | uint32 count | count * (20-byte hash | uint64 offset) |
+//	       20-byte pack checksum | SHA-1 of everything before
+//
+// Index entries are sorted by hash. A delta entry's size and data are those of the delta,
+// which is applied to the base object to get the real payload.
+const (
+	packDir          = ".kitcat/objects/pack"
+	packSignature    = "PACK"
+	packIdxSignature = "PIDX"
+	packVersion      = 1
+
+	packBlob   byte = 1
+	packTree   byte = 2
+	packCommit byte = 3
+	packTag    byte = 4
+	packDelta  byte = 7
+
+	// maxDeltaDepth bounds delta chains, both when writing and when reading
+	maxDeltaDepth = 50
+	// deltaWindow is how many preceding objects are tried as delta bases
+	deltaWindow = 10
+	// minDeltaSize skips objects too small for a delta to pay off
+	minDeltaSize = 64
+)
+
+var packTypes = map[string]byte{ObjectBlob: packBlob, ObjectTree: packTree, ObjectCommit: packCommit, ObjectTag: packTag}
+
+// packIndex is the in-memory form of a .idx file
+type packIndex struct {
+	packPath string
+	hashes   []string // sorted
+	offsets  []uint64
+	checksum []byte // SHA-1 of the pack file, as recorded in the index
+}
+
+func (p *packIndex) find(hash string) (uint64, bool) {
+	i := sort.SearchStrings(p.hashes, hash)
+	if i < len(p.hashes) && p.hashes[i] == hash {
+		return p.offsets[i], true
+	}
+	return 0, false
+}
+
+// packCache keeps parsed indexes keyed by absolute path, size and modification time
+var packCache = struct {
+	sync.Mutex
+	entries map[string]packCacheEntry
+}{entries: make(map[string]packCacheEntry)}
+
+type packCacheEntry struct {
+	size, mtime int64
+	idx         *packIndex
+}
+
+// loadPacks returns the indexes of every pack in the repository
+func loadPacks() ([]*packIndex, error) {
+	entries, err := os.ReadDir(packDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var packs []*packIndex
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasPrefix(e.Name(), "pack-") || !strings.HasSuffix(e.Name(), ".idx") {
+			continue
+		}
+		idx, err := loadPackIndex(filepath.Join(packDir, e.Name()))
+		if os.IsNotExist(err) {
+			continue // removed by a concurrent gc
+		}
+		if err != nil {
+			return nil, err
+		}
+		packs = append(packs, idx)
+	}
+	return packs, nil
+}
+
+func loadPackIndex(path string) (*packIndex, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	packCache.Lock()
+	defer packCache.Unlock()
+	if c, ok := packCache.entries[abs]; ok && c.size == info.Size() && c.mtime == info.ModTime().UnixNano() {
+		return c.idx, nil
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	idx, err := decodePackIndex(content)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	idx.packPath = strings.TrimSuffix(path, ".idx") + ".pack"
+	packCache.entries[abs] = packCacheEntry{size: info.Size(), mtime: info.ModTime().UnixNano(), idx: idx}
+	return idx, nil
+}
+
+func decodePackIndex(content []byte) (*packIndex, error) {
+	const header = len(packIdxSignature) + 8
+	if len(content) < header+2*sha1.Size || !bytes.HasPrefix(content, []byte(packIdxSignature)) {
+		return nil, fmt.Errorf("malformed pack index")
+	}
+	body := content[:len(content)-sha1.Size]
+	if sum := sha1.Sum(body); !bytes.Equal(sum[:], content[len(body):]) {
+		return nil, fmt.Errorf("pack index checksum mismatch")
+	}
+	if v := binary.BigEndian.Uint32(content[4:]); v != packVersion {
+		return nil, fmt.Errorf("unsupported pack index version %d", v)
+	}
+	count := int(binary.BigEndian.Uint32(content[8:]))
+	if len(body) != header+count*(sha1.Size+8)+sha1.Size {
+		return nil, fmt.Errorf("malformed pack index")
+	}
+
+	idx := &packIndex{hashes: make([]string, count), offsets: make([]uint64, count)}
+	pos := header
+	for i := 0; i < count; i++ {
+		idx.hashes[i] = hex.EncodeToString(content[pos : pos+sha1.Size])
+		idx.offsets[i] = binary.BigEndian.Uint64(content[pos+sha1.Size:])
+		pos += sha1.Size + 8
+	}
+	idx.checksum = content[pos : pos+sha1.Size]
+	return idx, nil
+}
+
+// findPackedObject locates an object in any pack
+func findPackedObject(hash string) (*packIndex, uint64, bool) {
+	packs, err := loadPacks()
+	if err != nil {
+		return nil, 0, false
+	}
+	for _, p := range packs {
+		if offset, ok := p.find(hash); ok {
+			return p, offset, true
+		}
+	}
+	return nil, 0, false
+}
+
+// packedObjectHashes lists every object stored in a pack
+func packedObjectHashes() ([]string, error) {
+	packs, err := loadPacks()
+	if err != nil {
+		return nil, err
+	}
+	var hashes []string
+	for _, p := range packs {
+		hashes = append(hashes, p.hashes...)
+	}
+	return hashes, nil
+}
+
+// readPackedObject reads the object at offset, resolving delta chains through readObjectDepth
+func readPackedObject(p *packIndex, offset uint64, depth int) (string, []byte, error) {
+	f, err := os.Open(p.packPath)
+	if err != nil {
+		return "", nil, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(io.NewSectionReader(f, int64(offset), 1<<62))
+	kind, err := r.ReadByte()
+	if err != nil {
+		return "", nil, fmt.Errorf("truncated pack entry: %w", err)
+	}
+	size, err := binary.ReadUvarint(r)
+	if err != nil {
+		return "", nil, fmt.Errorf("truncated pack entry: %w", err)
+	}
+	var base string
+	if kind == packDelta {
+		raw := make([]byte, sha1.Size)
+		if _, err := io.ReadFull(r, raw); err != nil {
+			return "", nil, fmt.Errorf("truncated pack entry: %w", err)
+		}
+		base = hex.EncodeToString(raw)
+	}
+
+	zr, err := zlib.NewReader(r)
+	if err != nil {
+		return "", nil, err
+	}
+	defer zr.Close()
+	data := make([]byte, size)
+	if _, err := io.ReadFull(zr, data); err != nil {
+		return "", nil, fmt.Errorf("corrupt pack entry: %w", err)
+	}
+
+	if kind != packDelta {
+		for name, code := range packTypes {
+			if code == kind {
+				return name, data, nil
+			}
+		}
+		return "", nil, fmt.Errorf("unknown pack entry type %d", kind)
+	}
+
+	if depth >= maxDeltaDepth {
+		return "", nil, fmt.Errorf("delta chain too deep at base %s", base)
+	}
+	objType, baseData, _, err := readObjectDepth(base, depth+1)
+	if err != nil {
+		return "", nil, fmt.Errorf("delta base %s: %w", base, err)
+	}
+	data, err = applyDelta(baseData, data)
+	if err != nil {
+		return "", nil, err
+	}
+	return objType, data, nil
+}
+
+// PackStats summarises what Repack did
+type PackStats struct {
+	Objects      int // objects written to the new pack
+	Deltas       int // of which stored as deltas
+	LooseRemoved int // loose objects deleted after packing
+	PacksRemoved int // old packs replaced by the new one
+}
+
+// packCandidate is an object considered for packing
+type packCandidate struct {
+	hash    string
+	objType string
+	size    int
+	name    string // file name hint, so versions of the same file are tried against each other
+}
+
+// Repack writes every loose and packed object into a single new pack, storing objects as
+// deltas against similar ones where that saves space, then removes the loose copies and old
+// packs. Objects still in the pre-typed legacy format are left loose, since they must keep
+// their original names.
+func Repack() (PackStats, error) {
+	l, err := lockPacks()
+	if err != nil {
+		return PackStats{}, err
+	}
+	defer unlock(l)
+	return repack(true, nil)
+}
+
+// lockPacks takes the lock that serialises everything rewriting or deleting packs
+func lockPacks() (*os.File, error) {
+	if err := os.MkdirAll(packDir, 0o755); err != nil {
+		return nil, err
+	}
+	return lock(filepath.Join(packDir, "gc"))
+}
+
+// repack merges all packs, and the loose objects if includeLoose is set, into one new pack.
+// Objects in drop are left out. The caller must hold the pack lock.
+func repack(includeLoose bool, drop map[string]bool) (PackStats, error) {
+	var stats PackStats
+	var loose []string
+	if includeLoose {
+		var err error
+		if loose, err = findLooseObjects(""); err != nil {
+			return stats, err
+		}
+	}
+	oldPacks, err := loadPacks()
+	if err != nil {
+		return stats, err
+	}
+
+	seen := make(map[string]bool)
+	var candidates []*packCandidate
+	var packedLoose []string
+	names := make(map[string]string)
+	consider := func(hash string) error {
+		if seen[hash] || drop[hash] {
+			return nil
+		}
+		seen[hash] = true
+		objType, data, legacy, err := readObject(hash)
+		if err != nil {
+			return fmt.Errorf("object %s: %w", hash, err)
+		}
+		if legacy {
+			return nil
+		}
+		if objType == ObjectTree {
+			entries, err := decodeTree(data)
+			if err != nil {
+				return fmt.Errorf("tree %s: %w", hash, err)
+			}
+			for _, e := range entries {
+				names[e.Hash] = e.Name
+			}
+		}
+		candidates = append(candidates, &packCandidate{hash: hash, objType: objType, size: len(data)})
+		return nil
+	}
+	for _, hash := range loose {
+		before := len(candidates)
+		if err := consider(hash); err != nil {
+			return stats, err
+		}
+		if len(candidates) > before {
+			packedLoose = append(packedLoose, hash)
+		}
+	}
+	for _, p := range oldPacks {
+		for _, hash := range p.hashes {
+			if err := consider(hash); err != nil {
+				return stats, err
+			}
+		}
+	}
+	if len(candidates) == 0 && len(oldPacks) == 0 {
+		return stats, nil
+	}
+	for _, c := range candidates {
+		c.name = names[c.hash]
+	}
+
+	// Group objects of one type and file name, largest first: later versions then
+	// delta against bigger ones, which usually means removing rather than adding data
+	sort.Slice(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if a.objType != b.objType {
+			return a.objType < b.objType
+		}
+		if a.name != b.name {
+			return a.name < b.name
+		}
+		if a.size != b.size {
+			return a.size > b.size
+		}
+		return a.hash < b.hash
+	})
+
+	var packPath string
+	if len(candidates) > 0 {
+		var written, deltas int
+		if packPath, written, deltas, err = writePack(candidates); err != nil {
+			return stats, err
+		}
+		stats.Objects, stats.Deltas = written, deltas
+	}
+
+	// The new pack is complete and indexed, so the old copies can go
+	for _, p := range oldPacks {
+		if p.packPath == packPath {
+			continue
+		}
+		os.Remove(strings.TrimSuffix(p.packPath, ".pack") + ".idx")
+		os.Remove(p.packPath)
+		stats.PacksRemoved++
+	}
+	for _, hash := range packedLoose {
+		removed := false
+		for _, path := range []string{objectPath(hash), flatObjectPath(hash)} {
+			if os.Remove(path) == nil {
+				removed = true
+				os.Remove(filepath.Dir(path)) // drop the shard directory once empty
+			}
+		}
+		if removed {
+			stats.LooseRemoved++
+		}
+	}
+	return stats, nil
+}
+
+// windowEntry is a recently packed object kept in memory as a possible delta base
+type windowEntry struct {
+	c     *packCandidate
+	data  []byte
+	depth int
+}
+
+// writePack writes the candidates in order to a new pack and its index.
+// It returns the pack path, the number of objects and how many were stored as deltas.
+func writePack(candidates []*packCandidate) (string, int, int, error) {
+	tmp, err := os.CreateTemp(packDir, "pack-*.tmp")
+	if err != nil {
+		return "", 0, 0, err
+	}
+	tmpName := tmp.Name()
+	defer os.Remove(tmpName)
+	defer tmp.Close()
+
+	h := sha1.New()
+	cw := &countingWriter{w: io.MultiWriter(tmp, h)}
+	bw := bufio.NewWriter(cw)
+
+	header := make([]byte, 12)
+	copy(header, packSignature)
+	binary.BigEndian.PutUint32(header[4:], packVersion)
+	binary.BigEndian.PutUint32(header[8:], uint32(len(candidates)))
+	bw.Write(header)
+
+	offsets := make(map[string]uint64, len(candidates))
+	var window []windowEntry
+	deltas := 0
+	for _, c := range candidates {
+		_, data, _, err := readObject(c.hash)
+		if err != nil {
+			return "", 0, 0, err
+		}
+
+		// Try recent objects of the same type as delta bases and keep the smallest delta
+		var best []byte
+		var bestBase windowEntry
+		if len(data) >= minDeltaSize {
+			for _, w := range window {
+				if w.c.objType != c.objType || w.depth >= maxDeltaDepth {
+					continue
+				}
+				d := createDelta(w.data, data)
+				if len(d) < len(data)/2 && (best == nil || len(d) < len(best)) {
+					best, bestBase = d, w
+				}
+			}
+		}
+
+		if err := bw.Flush(); err != nil {
+			return "", 0, 0, err
+		}
+		offsets[c.hash] = uint64(cw.n)
+		depth := 0
+		if best != nil {
+			raw, _ := hex.DecodeString(bestBase.c.hash)
+			writePackEntryHeader(bw, packDelta, len(best))
+			bw.Write(raw)
+			if err := writeZlib(bw, best); err != nil {
+				return "", 0, 0, err
+			}
+			depth = bestBase.depth + 1
+			deltas++
+		} else {
+			writePackEntryHeader(bw, packTypes[c.objType], len(data))
+			if err := writeZlib(bw, data); err != nil {
+				return "", 0, 0, err
+			}
+		}
+
+		window = append(window, windowEntry{c: c, data: data, depth: depth})
+		if len(window) > deltaWindow {
+			window = window[1:]
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		return "", 0, 0, err
+	}
+	checksum := h.Sum(nil)
+	if _, err := tmp.Write(checksum); err != nil {
+		return "", 0, 0, err
+	}
+	if err := tmp.Sync(); err != nil {
+		return "", 0, 0, err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", 0, 0, err
+	}
+
+	base := filepath.Join(packDir, "pack-"+hex.EncodeToString(checksum))
+	if err := os.Rename(tmpName, base+".pack"); err != nil {
+		return "", 0, 0, err
+	}
+	if err := SafeWriteFile(base+".idx", encodePackIndex(offsets, checksum), 0o644); err != nil {
+		return "", 0, 0, err
+	}
+	return base + ".pack", len(candidates), deltas, nil
+}
+
+func writePackEntryHeader(w *bufio.Writer, kind byte, size int) {
+	w.WriteByte(kind)
+	var buf [binary.MaxVarintLen64]byte
+	w.Write(buf[:binary.PutUvarint(buf[:], uint64(size))])
+}
+
+func writeZlib(w io.Writer, data []byte) error {
+	zw := zlib.NewWriter(w)
+	if _, err := zw.Write(data); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+func encodePackIndex(offsets map[string]uint64, packChecksum []byte) []byte {
+	hashes := make([]string, 0, len(offsets))
+	for hash := range offsets {
+		hashes = append(hashes, hash)
+	}
+	sort.Strings(hashes)
+
+	var buf bytes.Buffer
+	buf.WriteString(packIdxSignature)
+	binary.Write(&buf, binary.BigEndian, uint32(packVersion))
+	binary.Write(&buf, binary.BigEndian, uint32(len(hashes)))
+	for _, hash := range hashes {
+		raw, _ := hex.DecodeString(hash)
+		buf.Write(raw)
+		binary.Write(&buf, binary.BigEndian, offsets[hash])
+	}
+	buf.Write(packChecksum)
+	sum := sha1.Sum(buf.Bytes())
+	buf.Write(sum[:])
+	return buf.Bytes()
+}
+
+// countingWriter tracks how many bytes have passed through it, giving entry offsets
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,113 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDelta_RoundTrip(t *testing.T) {
+	base := []byte(strings.Repeat("the quick brown fox jumps over the lazy dog\n", 50))
+	target := append([]byte("new first line\n"), base[:1000]...)
+	target = append(target, []byte("inserted in the middle\n")...)
+	target = append(target, base[1200:]...)
+
+	delta := createDelta(base, target)
+	if len(delta) >= len(target)/2 {
+		t.Errorf("delta of %d bytes for a %d byte target is not compact", len(delta), len(target))
+	}
+	got, err := applyDelta(base, delta)
+	if err != nil {
+		t.Fatalf("applyDelta failed: %v", err)
+	}
+	if !bytes.Equal(got, target) {
+		t.Error("delta did not reproduce the target")
+	}
+
+	if _, err := applyDelta(base[:10], delta); err == nil {
+		t.Error("applying a delta to the wrong base must fail")
+	}
+}
+
+func TestRepack_ReadsPackedObjects(t *testing.T) {
+	chdirTemp(t)
+
+	// Successive versions of one file, as a frequently edited file would produce
+	var hashes []string
+	var payloads [][]byte
+	content := strings.Repeat("line of a file that changes a little each time\n", 100)
+	for i := 0; i < 5; i++ {
+		content += fmt.Sprintf("change %d\n", i)
+		hash, err := WriteObject(ObjectBlob, []byte(content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		hashes = append(hashes, hash)
+		payloads = append(payloads, []byte(content))
+	}
+	root, err := WriteTree([]TreeEntry{{Mode: ModeRegular, Name: "file.txt", Hash: hashes[4]}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := Repack()
+	if err != nil {
+		t.Fatalf("Repack failed: %v", err)
+	}
+	if stats.Objects != 6 || stats.LooseRemoved != 6 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+	if stats.Deltas == 0 {
+		t.Error("similar blobs should have been stored as deltas")
+	}
+	if loose, _ := findLooseObjects(""); len(loose) != 0 {
+		t.Errorf("loose objects left after repack: %v", loose)
+	}
+
+	for i, hash := range hashes {
+		objType, data, err := ReadObject(hash)
+		if err != nil {
+			t.Fatalf("ReadObject(%s) after repack: %v", hash, err)
+		}
+		if objType != ObjectBlob || !bytes.Equal(data, payloads[i]) {
+			t.Errorf("packed object %s read back wrong", hash)
+		}
+	}
+	if flat, err := ParseTree(root); err != nil || flat["file.txt"] != hashes[4] {
+		t.Errorf("ParseTree on packed tree = %v, %v", flat, err)
+	}
+	if resolved, err := ResolveObject(hashes[0][:10]); err != nil || resolved != hashes[0] {
+		t.Errorf("short hash of packed object resolved to %s, %v", resolved, err)
+	}
+
+	// Writing an object that is already packed must not create a loose copy
+	if _, err := WriteObject(ObjectBlob, payloads[0]); err != nil {
+		t.Fatal(err)
+	}
+	if looseObjectExists(hashes[0]) {
+		t.Error("packed object was written loose again")
+	}
+
+	// A second repack folds new loose objects and the old pack into one
+	extra, _ := WriteObject(ObjectBlob, []byte("extra"))
+	stats, err = Repack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Objects != 7 || stats.PacksRemoved != 1 {
+		t.Errorf("unexpected stats on repack: %+v", stats)
+	}
+	packs, _ := filepath.Glob(filepath.Join(packDir, "pack-*.pack"))
+	if len(packs) != 1 {
+		t.Errorf("expected a single pack, found %v", packs)
+	}
+	if _, data, err := ReadObject(extra); err != nil || string(data) != "extra" {
+		t.Errorf("ReadObject(extra) = %q, %v", data, err)
+	}
+	if _, err := os.Stat(filepath.Join(objectsDir, extra[:2])); !os.IsNotExist(err) {
+		t.Error("empty shard directory left behind")
+	}
+}
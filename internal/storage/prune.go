@@ -0,0 +1,147 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// UnreachableObjects lists stored objects that are not in reachable and were written before
+// expire. Loose objects are dated by their file; packed objects by the pack holding them.
+func UnreachableObjects(reachable map[string]bool, expire time.Time) ([]string, error) {
+	loose, err := findLooseObjects("")
+	if err != nil {
+		return nil, err
+	}
+	packs, err := loadPacks()
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	var found []string
+	consider := func(hash string, mtime time.Time) {
+		if reachable[hash] || seen[hash] || !mtime.Before(expire) {
+			return
+		}
+		seen[hash] = true
+		found = append(found, hash)
+	}
+	for _, hash := range loose {
+		info, err := os.Stat(objectPath(hash))
+		if os.IsNotExist(err) {
+			info, err = os.Stat(flatObjectPath(hash))
+		}
+		if err != nil {
+			return nil, err
+		}
+		consider(hash, info.ModTime())
+	}
+	for _, p := range packs {
+		info, err := os.Stat(p.packPath)
+		if err != nil {
+			return nil, err
+		}
+		for _, hash := range p.hashes {
+			consider(hash, info.ModTime())
+		}
+	}
+	sort.Strings(found)
+	return found, nil
+}
+
+// RemoveObjects deletes objects from the store. Loose copies are unlinked and packs holding
+// any of the objects are rewritten without them.
+func RemoveObjects(hashes []string) error {
+	l, err := lockPacks()
+	if err != nil {
+		return err
+	}
+	defer unlock(l)
+
+	drop := make(map[string]bool, len(hashes))
+	for _, hash := range hashes {
+		drop[hash] = true
+		for _, path := range []string{objectPath(hash), flatObjectPath(hash)} {
+			if err := os.Remove(path); err == nil {
+				os.Remove(filepath.Dir(path)) // drop the shard directory once empty
+			} else if !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+
+	packs, err := loadPacks()
+	if err != nil {
+		return err
+	}
+	for _, p := range packs {
+		for _, hash := range p.hashes {
+			if drop[hash] {
+				_, err := repack(false, drop)
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// PruneCommitJournal drops journal entries for the given commits and for any commit whose
+// object no longer exists. It returns how many entries were removed.
+func PruneCommitJournal(removed map[string]bool) (int, error) {
+	if err := MigrateLegacyCommitLog(); err != nil {
+		return 0, err
+	}
+
+	f, err := os.OpenFile(commitsPath, os.O_RDWR, 0o644)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	// Same lock as AppendCommit, so no commit is appended while the journal is rewritten
+	if err := LockFile(f); err != nil {
+		f.Close()
+		return 0, err
+	}
+	defer f.Close()
+
+	var kept bytes.Buffer
+	dropped := 0
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		id := strings.TrimSpace(scanner.Text())
+		if id == "" {
+			continue
+		}
+		if removed[id] || !ObjectExists(id) {
+			dropped++
+			continue
+		}
+		fmt.Fprintln(&kept, id)
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	if dropped == 0 {
+		return 0, nil
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	if err := f.Truncate(0); err != nil {
+		return 0, err
+	}
+	if _, err := f.Write(kept.Bytes()); err != nil {
+		return 0, err
+	}
+	return dropped, f.Sync()
+}
@@ -0,0 +1,17 @@
+//go:build darwin || freebsd
+
+package storage
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileStat extracts the change time, device and inode that os.FileInfo does not expose
+func fileStat(info os.FileInfo) (ctime int64, dev, ino uint64) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, 0
+	}
+	return st.Ctimespec.Nano(), uint64(st.Dev), uint64(st.Ino)
+}
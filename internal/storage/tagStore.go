@@ -0,0 +1,79 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/LeeFred3042U/kitcat/internal/models"
+)
+
+// EncodeTag serializes an annotated tag into the payload of a tag object
+func EncodeTag(t models.Tag) []byte {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "object %s\n", t.Object)
+	fmt.Fprintf(&sb, "type %s\n", t.Type)
+	fmt.Fprintf(&sb, "tag %s\n", t.Name)
+	fmt.Fprintf(&sb, "tagger %s <%s> %d %s\n",
+		t.TaggerName, t.TaggerEmail, t.Timestamp.Unix(), t.Timestamp.Format("-0700"))
+	if t.Signature != "" {
+		fmt.Fprintf(&sb, "signature %s\n", t.Signature)
+	}
+	sb.WriteString("\n")
+	sb.WriteString(t.Message)
+	return []byte(sb.String())
+}
+
+// TagSigningPayload is what a tag's signature signs: the tag object without its
+// signature line
+func TagSigningPayload(t models.Tag) []byte {
+	t.Signature = ""
+	return EncodeTag(t)
+}
+
+// DecodeTag parses the payload of a tag object
+func DecodeTag(id string, data []byte) (models.Tag, error) {
+	t := models.Tag{ID: id}
+	header, message, _ := strings.Cut(string(data), "\n\n")
+	t.Message = message
+
+	for _, line := range strings.Split(header, "\n") {
+		key, value, _ := strings.Cut(line, " ")
+		switch key {
+		case "object":
+			t.Object = value
+		case "type":
+			t.Type = value
+		case "tag":
+			t.Name = value
+		case "tagger":
+			name, email, ts, err := parseSignature(value)
+			if err != nil {
+				return models.Tag{}, fmt.Errorf("tag %s: %w", id, err)
+			}
+			t.TaggerName, t.TaggerEmail, t.Timestamp = name, email, ts
+		case "signature":
+			t.Signature = value
+		}
+	}
+	if t.Object == "" || t.Type == "" || t.Name == "" {
+		return models.Tag{}, fmt.Errorf("tag %s is missing its object, type or name", id)
+	}
+	return t, nil
+}
+
+// WriteTag stores an annotated tag object and returns its ID
+func WriteTag(t models.Tag) (string, error) {
+	return WriteObject(ObjectTag, EncodeTag(t))
+}
+
+// ReadTag loads the annotated tag object with the given hash
+func ReadTag(hash string) (models.Tag, error) {
+	objType, data, err := ReadObject(hash)
+	if err != nil {
+		return models.Tag{}, err
+	}
+	if objType != ObjectTag {
+		return models.Tag{}, fmt.Errorf("object %s is a %s, not a tag", hash, objType)
+	}
+	return DecodeTag(hash, data)
+}
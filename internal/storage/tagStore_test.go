@@ -0,0 +1,38 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/LeeFred3042U/kitcat/internal/models"
+)
+
+func TestTag_RoundTrip(t *testing.T) {
+	chdirTemp(t)
+
+	in := models.Tag{
+		Object:      "0123456789abcdef0123456789abcdef01234567",
+		Type:        ObjectCommit,
+		Name:        "v1.0",
+		TaggerName:  "Ada Lovelace",
+		TaggerEmail: "ada@example.com",
+		Timestamp:   time.Unix(1700000000, 0).In(time.FixedZone("+0200", 2*60*60)),
+		Message:     "Release 1.0\n\nNotes.\n",
+	}
+	id, err := WriteTag(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := ReadTag(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Object != in.Object || out.Type != in.Type || out.Name != in.Name ||
+		out.TaggerName != in.TaggerName || out.TaggerEmail != in.TaggerEmail ||
+		!out.Timestamp.Equal(in.Timestamp) || out.Message != in.Message {
+		t.Errorf("round trip changed the tag:\n got %+v\nwant %+v", out, in)
+	}
+	if objType, err := VerifyObject(id); err != nil || objType != ObjectTag {
+		t.Errorf("VerifyObject = %s, %v", objType, err)
+	}
+}
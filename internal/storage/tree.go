@@ -3,71 +3,380 @@ package storage
 import (
 	"bufio"
 	"bytes"
-	"crypto/sha1"
+	"encoding/hex"
 	"fmt"
-	"os"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
 )
 
-// CreateTree creates a tree object from the current index and stores it
-// It ensures the process is deterministic by sorting the file paths
+// File modes recorded in index and tree entries
+const (
+	ModeRegular    uint32 = 0o100644
+	ModeExecutable uint32 = 0o100755
+	ModeSymlink    uint32 = 0o120000
+	ModeTree       uint32 = 0o040000
+)
+
+// TreeEntry is a single named item in a tree object: a file or a subdirectory
+type TreeEntry struct {
+	Mode uint32
+	Name string
+	Hash string
+}
+
+// IsDir reports whether the entry points to a subtree
+func (e TreeEntry) IsDir() bool {
+	return e.Mode == ModeTree
+}
+
+// sortKey orders entries like git: directories compare as if their name ended in '/'
+func (e TreeEntry) sortKey() string {
+	if e.IsDir() {
+		return e.Name + "/"
+	}
+	return e.Name
+}
+
+// TreeChange describes one file that differs between two trees, in content or mode.
+// OldHash is empty for added files and NewHash is empty for deleted files.
+type TreeChange struct {
+	Path    string
+	OldHash string
+	NewHash string
+	OldMode uint32
+	NewMode uint32
+}
+
+// WriteTree stores a single directory level as a tree object.
+// Each entry is encoded as "<octal mode> <name>\0<20-byte hash>".
+func WriteTree(entries []TreeEntry) (string, error) {
+	sorted := append([]TreeEntry(nil), entries...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].sortKey() < sorted[j].sortKey()
+	})
+
+	var buf bytes.Buffer
+	for _, e := range sorted {
+		raw, err := hex.DecodeString(e.Hash)
+		if err != nil || len(raw) != 20 {
+			return "", fmt.Errorf("invalid hash %q for tree entry %s", e.Hash, e.Name)
+		}
+		if e.Name == "" || strings.ContainsAny(e.Name, "/\x00") {
+			return "", fmt.Errorf("invalid tree entry name %q", e.Name)
+		}
+		fmt.Fprintf(&buf, "%o %s", e.Mode, e.Name)
+		buf.WriteByte(0)
+		buf.Write(raw)
+	}
+	return WriteObject(ObjectTree, buf.Bytes())
+}
+
+// CreateTree creates tree objects from the current index and returns the root tree hash.
+// One tree is written per directory, so unchanged directories keep their hash across commits.
 func CreateTree() (string, error) {
-	index, err := LoadIndex()
+	index, err := LoadIndexEntries()
 	if err != nil {
 		return "", err
 	}
+	return buildTree(index)
+}
+
+// CreateTreeFromEntries writes the trees for a set of files given by path, as CreateTree
+// does for the index, and returns the root tree hash
+func CreateTreeFromEntries(entries map[string]IndexEntry) (string, error) {
+	return buildTree(entries)
+}
 
-	var treeContent bytes.Buffer
+// dirNode is an in-memory directory used while turning flat index paths into trees
+type dirNode struct {
+	files map[string]IndexEntry
+	dirs  map[string]*dirNode
+}
+
+func newDirNode() *dirNode {
+	return &dirNode{files: make(map[string]IndexEntry), dirs: make(map[string]*dirNode)}
+}
+
+// buildTree writes the hierarchy of trees for a flat path -> entry map
+func buildTree(index map[string]IndexEntry) (string, error) {
+	root := newDirNode()
+	for path, entry := range index {
+		parts := strings.Split(filepath.ToSlash(path), "/")
+		node := root
+		for _, dir := range parts[:len(parts)-1] {
+			child, ok := node.dirs[dir]
+			if !ok {
+				child = newDirNode()
+				node.dirs[dir] = child
+			}
+			node = child
+		}
+		node.files[parts[len(parts)-1]] = entry
+	}
+	return writeDirNode(root)
+}
 
-	// Sort keys to ensure the tree content is always in the same order
-	keys := make([]string, 0, len(index))
-	for p := range index {
-		keys = append(keys, p)
+// writeDirNode writes subtrees first so their hashes can be referenced by the parent
+func writeDirNode(node *dirNode) (string, error) {
+	entries := make([]TreeEntry, 0, len(node.files)+len(node.dirs))
+	for name, file := range node.files {
+		mode := file.Mode
+		if mode == 0 {
+			mode = ModeRegular
+		}
+		entries = append(entries, TreeEntry{Mode: mode, Name: name, Hash: file.Hash})
+	}
+	for name, child := range node.dirs {
+		hash, err := writeDirNode(child)
+		if err != nil {
+			return "", err
+		}
+		entries = append(entries, TreeEntry{Mode: ModeTree, Name: name, Hash: hash})
 	}
-	sort.Strings(keys)
+	return WriteTree(entries)
+}
 
-	// Iterate over the sorted keys to build the tree content
-	for _, path := range keys {
-		hash := index[path]
-		treeContent.WriteString(fmt.Sprintf("%s %s\n", hash, path))
+// ReadTree returns the entries of a single tree object, without descending into subtrees
+func ReadTree(hash string) ([]TreeEntry, error) {
+	objType, data, legacy, err := readObject(hash)
+	if err != nil {
+		return nil, err
 	}
+	// Legacy trees carry no type, so trust the caller that asked for a tree
+	if !legacy && objType != ObjectTree {
+		return nil, fmt.Errorf("object %s is a %s, not a tree", hash, objType)
+	}
+	// Flat "hash path" trees predate per-directory trees
+	if legacy || len(data) == 0 || isFlatTree(data) {
+		return parseFlatTree(data)
+	}
+	entries, err := decodeTree(data)
+	if err != nil {
+		return nil, fmt.Errorf("tree %s: %w", hash, err)
+	}
+	return entries, nil
+}
+
+// decodeTree parses the binary entry list of a tree object
+func decodeTree(data []byte) ([]TreeEntry, error) {
+	var entries []TreeEntry
+	for len(data) > 0 {
+		sp := bytes.IndexByte(data, ' ')
+		if sp < 0 {
+			return nil, fmt.Errorf("malformed entry: missing mode")
+		}
+		mode, err := strconv.ParseUint(string(data[:sp]), 8, 32)
+		if err != nil {
+			return nil, fmt.Errorf("malformed entry mode %q", data[:sp])
+		}
+		data = data[sp+1:]
 
-	// Hash the deterministic tree content to get the tree's hash
-	h := sha1.New()
-	h.Write(treeContent.Bytes())
-	treeHash := fmt.Sprintf("%x", h.Sum(nil))
+		nul := bytes.IndexByte(data, 0)
+		if nul < 0 || len(data) < nul+21 {
+			return nil, fmt.Errorf("malformed entry: truncated name or hash")
+		}
+		name := string(data[:nul])
+		hash := hex.EncodeToString(data[nul+1 : nul+21])
+		data = data[nul+21:]
 
-	// Store the tree object in the objects directory
-	objectPath := filepath.Join(objectsDir, treeHash)
-	if err := os.WriteFile(objectPath, treeContent.Bytes(), 0644); err != nil {
-		return "", err
+		entries = append(entries, TreeEntry{Mode: uint32(mode), Name: name, Hash: hash})
 	}
+	return entries, nil
+}
 
-	return treeHash, nil
+// parseFlatTree reads the legacy single-level "hash path" tree format.
+// Entry names may contain slashes since the whole index was stored in one tree.
+func parseFlatTree(data []byte) ([]TreeEntry, error) {
+	var entries []TreeEntry
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		// The format is "hash path", so we split on the first space
+		hash, path, ok := strings.Cut(scanner.Text(), " ")
+		if ok {
+			entries = append(entries, TreeEntry{Mode: ModeRegular, Name: path, Hash: hash})
+		}
+	}
+	return entries, scanner.Err()
 }
 
-// ParseTree reads a tree object from storage and returns it as a map of path -> hash
-// This is the function that was missing
+// WalkTree calls fn for every file reachable from the tree, descending into subtrees.
+// Paths passed to fn are relative to the root tree and use the OS path separator.
+func WalkTree(hash string, fn func(path string, entry TreeEntry) error) error {
+	return walkTree(hash, "", fn)
+}
+
+func walkTree(hash, prefix string, fn func(path string, entry TreeEntry) error) error {
+	entries, err := ReadTree(hash)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		path := filepath.Join(prefix, filepath.FromSlash(e.Name))
+		if e.IsDir() {
+			if err := walkTree(e.Hash, path, fn); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := fn(path, e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ParseTree reads a tree object from storage and returns it as a map of path -> hash,
+// flattening all subtrees
 func ParseTree(hash string) (map[string]string, error) {
 	tree := make(map[string]string)
-	objectPath := filepath.Join(objectsDir, hash)
-	data, err := os.ReadFile(objectPath)
+	err := WalkTree(hash, func(path string, e TreeEntry) error {
+		tree[path] = e.Hash
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
+	return tree, nil
+}
 
-	scanner := bufio.NewScanner(bytes.NewReader(data))
-	for scanner.Scan() {
-		line := scanner.Text()
-		// The format is "hash path", so we split on the first space
-		parts := strings.SplitN(line, " ", 2)
-		if len(parts) == 2 {
-			// The hash is parts[0], the path is parts[1]
-			tree[parts[1]] = parts[0]
+// ParseTreeEntries is ParseTree keeping each file's mode, in the shape of the index
+func ParseTreeEntries(hash string) (map[string]IndexEntry, error) {
+	tree := make(map[string]IndexEntry)
+	err := WalkTree(hash, func(path string, e TreeEntry) error {
+		tree[path] = IndexEntry{Hash: e.Hash, Mode: e.Mode}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tree, nil
+}
+
+// DiffTrees lists the files that differ between two trees, sorted by path.
+// Subtrees with identical hashes are skipped without being read.
+// Either hash may be empty to stand for an empty tree.
+func DiffTrees(oldHash, newHash string) ([]TreeChange, error) {
+	var changes []TreeChange
+	if err := diffTrees(oldHash, newHash, "", &changes); err != nil {
+		return nil, err
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes, nil
+}
+
+func diffTrees(oldHash, newHash, prefix string, changes *[]TreeChange) error {
+	if oldHash == newHash {
+		return nil
+	}
+	oldEntries, oldFlat, err := readTreeMap(oldHash)
+	if err != nil {
+		return err
+	}
+	newEntries, newFlat, err := readTreeMap(newHash)
+	if err != nil {
+		return err
+	}
+	// Legacy flat trees cannot be compared level by level
+	if oldFlat || newFlat {
+		return diffFlattened(oldHash, newHash, prefix, changes)
+	}
+
+	names := make(map[string]bool)
+	for name := range oldEntries {
+		names[name] = true
+	}
+	for name := range newEntries {
+		names[name] = true
+	}
+
+	for name := range names {
+		path := filepath.Join(prefix, filepath.FromSlash(name))
+		oldEntry, inOld := oldEntries[name]
+		newEntry, inNew := newEntries[name]
+		if inOld && inNew && oldEntry == newEntry {
+			continue
+		}
+
+		// Split each side into its subtree part and its file part
+		var oldTree, newTree, oldFile, newFile string
+		var oldMode, newMode uint32
+		if inOld {
+			if oldEntry.IsDir() {
+				oldTree = oldEntry.Hash
+			} else {
+				oldFile, oldMode = oldEntry.Hash, oldEntry.Mode
+			}
+		}
+		if inNew {
+			if newEntry.IsDir() {
+				newTree = newEntry.Hash
+			} else {
+				newFile, newMode = newEntry.Hash, newEntry.Mode
+			}
+		}
+
+		if oldTree != "" || newTree != "" {
+			if err := diffTrees(oldTree, newTree, path, changes); err != nil {
+				return err
+			}
+		}
+		if oldFile != newFile || oldMode != newMode {
+			*changes = append(*changes, TreeChange{Path: path, OldHash: oldFile, NewHash: newFile, OldMode: oldMode, NewMode: newMode})
 		}
 	}
+	return nil
+}
 
-	return tree, scanner.Err()
+// readTreeMap indexes a tree's entries by name; an empty hash yields an empty map.
+// It also reports whether the tree is a legacy flat tree whose names contain slashes.
+func readTreeMap(hash string) (map[string]TreeEntry, bool, error) {
+	entries := make(map[string]TreeEntry)
+	if hash == "" {
+		return entries, false, nil
+	}
+	list, err := ReadTree(hash)
+	if err != nil {
+		return nil, false, err
+	}
+	flat := false
+	for _, e := range list {
+		entries[e.Name] = e
+		if strings.Contains(e.Name, "/") {
+			flat = true
+		}
+	}
+	return entries, flat, nil
+}
+
+// diffFlattened compares two trees by fully flattening them
+func diffFlattened(oldHash, newHash, prefix string, changes *[]TreeChange) error {
+	oldFiles, newFiles := map[string]IndexEntry{}, map[string]IndexEntry{}
+	var err error
+	if oldHash != "" {
+		if oldFiles, err = ParseTreeEntries(oldHash); err != nil {
+			return err
+		}
+	}
+	if newHash != "" {
+		if newFiles, err = ParseTreeEntries(newHash); err != nil {
+			return err
+		}
+	}
+	for path, oldFile := range oldFiles {
+		if newFile := newFiles[path]; !newFile.SameFile(oldFile) {
+			*changes = append(*changes, TreeChange{
+				Path:    filepath.Join(prefix, path),
+				OldHash: oldFile.Hash, NewHash: newFile.Hash,
+				OldMode: oldFile.Mode, NewMode: newFile.Mode,
+			})
+		}
+	}
+	for path, newFile := range newFiles {
+		if _, ok := oldFiles[path]; !ok {
+			*changes = append(*changes, TreeChange{Path: filepath.Join(prefix, path), NewHash: newFile.Hash, NewMode: newFile.Mode})
+		}
+	}
+	return nil
 }
@@ -0,0 +1,145 @@
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestCreateTree_OneTreePerDirectory(t *testing.T) {
+	chdirTemp(t)
+
+	a, _ := WriteObject(ObjectBlob, []byte("a"))
+	b, _ := WriteObject(ObjectBlob, []byte("b"))
+	index := map[string]string{
+		"top.txt":                           a,
+		filepath.Join("src", "main.go"):     b,
+		filepath.Join("src", "lib", "x.go"): a,
+	}
+	if err := WriteIndex(index); err != nil {
+		t.Fatal(err)
+	}
+
+	root, err := CreateTree()
+	if err != nil {
+		t.Fatalf("CreateTree failed: %v", err)
+	}
+
+	entries, err := ReadTree(root)
+	if err != nil {
+		t.Fatalf("ReadTree failed: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 root entries, got %v", entries)
+	}
+	var srcHash string
+	for _, e := range entries {
+		if e.Name == "src" {
+			if !e.IsDir() {
+				t.Error("src should be a subtree")
+			}
+			srcHash = e.Hash
+		}
+	}
+	if srcHash == "" {
+		t.Fatal("src subtree missing from root tree")
+	}
+
+	flat, err := ParseTree(root)
+	if err != nil {
+		t.Fatalf("ParseTree failed: %v", err)
+	}
+	if len(flat) != len(index) {
+		t.Fatalf("flattened tree has %d entries, want %d", len(flat), len(index))
+	}
+	for path, hash := range index {
+		if flat[path] != hash {
+			t.Errorf("path %s: got %s, want %s", path, flat[path], hash)
+		}
+	}
+
+	// Changing a top-level file must leave the src subtree untouched
+	index["top.txt"] = b
+	if err := WriteIndex(index); err != nil {
+		t.Fatal(err)
+	}
+	root2, err := CreateTree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries2, _ := ReadTree(root2)
+	for _, e := range entries2 {
+		if e.Name == "src" && e.Hash != srcHash {
+			t.Error("unchanged subdirectory got a new tree hash")
+		}
+	}
+
+	changes, err := DiffTrees(root, root2)
+	if err != nil {
+		t.Fatalf("DiffTrees failed: %v", err)
+	}
+	if len(changes) != 1 || changes[0].Path != "top.txt" || changes[0].OldHash != a || changes[0].NewHash != b {
+		t.Errorf("unexpected changes: %+v", changes)
+	}
+}
+
+func TestDiffTrees_AddedAndDeleted(t *testing.T) {
+	chdirTemp(t)
+
+	a, _ := WriteObject(ObjectBlob, []byte("a"))
+	oldRoot, err := buildTree(map[string]IndexEntry{filepath.Join("dir", "old.txt"): {Hash: a, Mode: ModeRegular}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	newRoot, err := buildTree(map[string]IndexEntry{filepath.Join("dir", "new.txt"): {Hash: a, Mode: ModeRegular}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changes, err := DiffTrees(oldRoot, newRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 2 {
+		t.Fatalf("expected 2 changes, got %+v", changes)
+	}
+	if changes[0].Path != filepath.Join("dir", "new.txt") || changes[0].OldHash != "" {
+		t.Errorf("expected dir/new.txt added, got %+v", changes[0])
+	}
+	if changes[1].Path != filepath.Join("dir", "old.txt") || changes[1].NewHash != "" {
+		t.Errorf("expected dir/old.txt deleted, got %+v", changes[1])
+	}
+}
+
+func TestDiffTrees_ModeOnlyChange(t *testing.T) {
+	chdirTemp(t)
+
+	a, _ := WriteObject(ObjectBlob, []byte("#!/bin/sh\n"))
+	oldRoot, err := buildTree(map[string]IndexEntry{"run.sh": {Hash: a, Mode: ModeRegular}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	newRoot, err := buildTree(map[string]IndexEntry{"run.sh": {Hash: a, Mode: ModeExecutable}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if oldRoot == newRoot {
+		t.Fatal("trees differing only by mode must have different hashes")
+	}
+
+	changes, err := DiffTrees(oldRoot, newRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || changes[0].OldHash != a || changes[0].NewHash != a ||
+		changes[0].OldMode != ModeRegular || changes[0].NewMode != ModeExecutable {
+		t.Errorf("expected a mode-only change, got %+v", changes)
+	}
+
+	entries, err := ParseTreeEntries(newRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entries["run.sh"].Mode != ModeExecutable {
+		t.Errorf("mode not preserved in tree: %+v", entries["run.sh"])
+	}
+}
@@ -0,0 +1,170 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrHashMismatch is returned by VerifyObject when an object's content does not hash to its name
+var ErrHashMismatch = errors.New("hash mismatch")
+
+// VerifyObject rehashes a stored object and checks the result against its name.
+// Trees, commits and tags are also parsed strictly, since readers skip malformed entries.
+// It returns the object's type. Legacy objects only have their hash checked: their type,
+// and so how to parse them, depends on what refers to them.
+func VerifyObject(hash string) (string, error) {
+	objType, data, legacy, err := readObject(hash)
+	if err != nil {
+		return "", err
+	}
+	if legacy {
+		if sum := sha1.Sum(data); hex.EncodeToString(sum[:]) != hash {
+			return objType, fmt.Errorf("%w: content hashes to %x", ErrHashMismatch, sum)
+		}
+		return objType, nil
+	}
+
+	if actual := HashObject(objType, data); actual != hash {
+		return objType, fmt.Errorf("%w: content hashes to %s", ErrHashMismatch, actual)
+	}
+
+	switch objType {
+	case ObjectBlob:
+		return objType, nil
+	case ObjectTree:
+		return objType, verifyTree(data)
+	case ObjectCommit:
+		c, err := DecodeCommit(hash, data)
+		if err != nil {
+			return objType, err
+		}
+		if !isHexHash(c.TreeHash) {
+			return objType, fmt.Errorf("malformed tree hash %q", c.TreeHash)
+		}
+		for _, parent := range c.Parents {
+			if !isHexHash(parent) {
+				return objType, fmt.Errorf("malformed parent hash %q", parent)
+			}
+		}
+		return objType, nil
+	case ObjectTag:
+		t, err := DecodeTag(hash, data)
+		if err != nil {
+			return objType, err
+		}
+		if !isHexHash(t.Object) {
+			return objType, fmt.Errorf("malformed tagged object hash %q", t.Object)
+		}
+		return objType, nil
+	default:
+		return objType, fmt.Errorf("unknown object type %q", objType)
+	}
+}
+
+// verifyTree checks every entry of a tree payload, in either the binary or flat format
+func verifyTree(data []byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+	if isFlatTree(data) {
+		for i, line := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+			hash, path, ok := strings.Cut(line, " ")
+			if !ok || path == "" || !isHexHash(hash) {
+				return fmt.Errorf("malformed tree line %d", i+1)
+			}
+		}
+		return nil
+	}
+
+	entries, err := decodeTree(data)
+	if err != nil {
+		return err
+	}
+	seen := make(map[string]bool, len(entries))
+	for _, e := range entries {
+		switch e.Mode {
+		case ModeRegular, ModeExecutable, ModeSymlink, ModeTree:
+		default:
+			return fmt.Errorf("entry %q has invalid mode %o", e.Name, e.Mode)
+		}
+		if e.Name == "" || e.Name == "." || e.Name == ".." || strings.ContainsAny(e.Name, "/\x00") {
+			return fmt.Errorf("invalid entry name %q", e.Name)
+		}
+		if seen[e.Name] {
+			return fmt.Errorf("duplicate entry %q", e.Name)
+		}
+		seen[e.Name] = true
+	}
+	return nil
+}
+
+// VerifyPacks checks every pack's trailing checksum against its content and its index.
+// The result maps each damaged pack file to what is wrong with it.
+func VerifyPacks() (map[string]error, error) {
+	packs, err := loadPacks()
+	if err != nil {
+		return nil, err
+	}
+	problems := make(map[string]error)
+	for _, p := range packs {
+		if err := verifyPack(p); err != nil {
+			problems[filepath.ToSlash(p.packPath)] = err
+		}
+	}
+	return problems, nil
+}
+
+func verifyPack(p *packIndex) error {
+	f, err := os.Open(p.packPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	bodySize := info.Size() - sha1.Size
+	if bodySize < int64(len(packSignature)+8) {
+		return fmt.Errorf("truncated pack")
+	}
+
+	h := sha1.New()
+	r := bufio.NewReader(io.TeeReader(io.LimitReader(f, bodySize), h))
+	header := make([]byte, len(packSignature)+8)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return err
+	}
+	if !bytes.HasPrefix(header, []byte(packSignature)) {
+		return fmt.Errorf("bad pack signature")
+	}
+	if count := int(binary.BigEndian.Uint32(header[8:])); count != len(p.hashes) {
+		return fmt.Errorf("pack holds %d objects but its index lists %d", count, len(p.hashes))
+	}
+	if _, err := io.Copy(io.Discard, r); err != nil {
+		return err
+	}
+
+	trailer := make([]byte, sha1.Size)
+	if _, err := io.ReadFull(f, trailer); err != nil {
+		return err
+	}
+	sum := h.Sum(nil)
+	if !bytes.Equal(sum, trailer) {
+		return fmt.Errorf("pack checksum mismatch")
+	}
+	if !bytes.Equal(sum, p.checksum) {
+		return fmt.Errorf("pack does not match its index")
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package storage
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestVerifyObject_DetectsCorruption(t *testing.T) {
+	chdirTemp(t)
+
+	good, _ := WriteObject(ObjectBlob, []byte("hello\n"))
+	if objType, err := VerifyObject(good); err != nil || objType != ObjectBlob {
+		t.Fatalf("VerifyObject(good) = %q, %v", objType, err)
+	}
+
+	// Swap the content of the object while keeping its name
+	bad, _ := WriteObject(ObjectBlob, []byte("original"))
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	zw.Write([]byte("blob 8\x00tampered"))
+	zw.Close()
+	if err := os.Chmod(objectPath(bad), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(objectPath(bad), buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := VerifyObject(bad); !errors.Is(err, ErrHashMismatch) {
+		t.Errorf("expected a hash mismatch, got %v", err)
+	}
+
+	// A tree whose entry cannot be parsed must be rejected even though it hashes correctly
+	tree, _ := WriteObject(ObjectTree, []byte("100644 a.txt\x00short"))
+	if _, err := VerifyObject(tree); err == nil || errors.Is(err, ErrHashMismatch) {
+		t.Errorf("expected a malformed tree error, got %v", err)
+	}
+}
@@ -0,0 +1,78 @@
+package diff_test
+
+import (
+	"math/rand"
+	"reflect"
+	"slices"
+	"testing"
+
+	"github.com/LeeFred3042U/kitcat/internal/diff"
+)
+
+var algorithms = []diff.Algorithm{diff.Myers, diff.Patience, diff.Histogram}
+
+// sides rebuilds the two sequences a diff compares
+func sides(diffs []diff.Diff[string]) (a, b []string) {
+	for _, d := range diffs {
+		if d.Operation != diff.INSERT {
+			a = append(a, d.Text...)
+		}
+		if d.Operation != diff.DELETE {
+			b = append(b, d.Text...)
+		}
+	}
+	return a, b
+}
+
+func TestAlgorithms_ReproduceBothSides(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	alphabet := []string{"a", "b", "c", "{", "}", ""}
+	random := func() []string {
+		s := make([]string, rng.Intn(30))
+		for i := range s {
+			s[i] = alphabet[rng.Intn(len(alphabet))]
+		}
+		return s
+	}
+	for _, alg := range algorithms {
+		for n := 0; n < 300; n++ {
+			a, b := random(), random()
+			gotA, gotB := sides(diff.New(alg, a, b).Diffs())
+			if !slices.Equal(gotA, a) || !slices.Equal(gotB, b) {
+				t.Fatalf("%s: diff of %q and %q gives %q and %q", alg, a, b, gotA, gotB)
+			}
+		}
+	}
+}
+
+func TestAlgorithms_AnchorOnUniqueLines(t *testing.T) {
+	a := []string{"A", "X", "B", "X", "C"}
+	b := []string{"C", "X", "A", "X", "B"}
+	want := []diff.Diff[string]{
+		{Operation: diff.INSERT, Text: []string{"C", "X"}},
+		{Operation: diff.EQUAL, Text: []string{"A", "X", "B"}},
+		{Operation: diff.DELETE, Text: []string{"X", "C"}},
+	}
+	for _, alg := range []diff.Algorithm{diff.Patience, diff.Histogram} {
+		if got := diff.New(alg, a, b).Diffs(); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %v, want %v", alg, got, want)
+		}
+	}
+}
+
+func TestParseAlgorithm(t *testing.T) {
+	for name, want := range map[string]diff.Algorithm{
+		"myers":     diff.Myers,
+		"default":   diff.Myers,
+		"minimal":   diff.Myers,
+		"patience":  diff.Patience,
+		"histogram": diff.Histogram,
+	} {
+		if got, err := diff.ParseAlgorithm(name); err != nil || got != want {
+			t.Errorf("ParseAlgorithm(%q) = %q, %v; want %q", name, got, err, want)
+		}
+	}
+	if _, err := diff.ParseAlgorithm("fastest"); err == nil {
+		t.Error("ParseAlgorithm accepted an unknown algorithm")
+	}
+}
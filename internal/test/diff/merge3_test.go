@@ -0,0 +1,67 @@
+package diff_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/LeeFred3042U/kitcat/internal/diff"
+)
+
+func TestMerge3(t *testing.T) {
+	split := func(s string) []string {
+		if s == "" {
+			return nil
+		}
+		return strings.Split(s, ",")
+	}
+	tests := []struct {
+		name                 string
+		base, ours, theirs   string
+		want                 string // resolved result, if there is no conflict
+		conflict             bool
+		wantOurs, wantTheirs string
+	}{
+		{name: "Unchanged", base: "a,b,c", ours: "a,b,c", theirs: "a,b,c", want: "a,b,c"},
+		{name: "OnlyOursChanged", base: "a,b,c", ours: "a,B,c", theirs: "a,b,c", want: "a,B,c"},
+		{name: "OnlyTheirsChanged", base: "a,b,c", ours: "a,b,c", theirs: "a,b,c,d", want: "a,b,c,d"},
+		{name: "DisjointChanges", base: "a,b,c,d,e", ours: "A,b,c,d,e", theirs: "a,b,c,d,E", want: "A,b,c,d,E"},
+		{name: "SameChangeOnBothSides", base: "a,b,c", ours: "a,x,c", theirs: "a,x,c", want: "a,x,c"},
+		{name: "BothDelete", base: "a,b,c", ours: "a,c", theirs: "a,c", want: "a,c"},
+		{name: "InsertAndDelete", base: "a,b,c", ours: "z,a,b,c", theirs: "a,c", want: "z,a,c"},
+		{name: "ConflictingEdits", base: "a,b,c", ours: "a,x,c", theirs: "a,y,c", conflict: true, wantOurs: "x", wantTheirs: "y"},
+		{name: "ConflictingAppends", base: "a", ours: "a,x", theirs: "a,y", conflict: true, wantOurs: "x", wantTheirs: "y"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			regions := diff.Merge3(split(tt.base), split(tt.ours), split(tt.theirs))
+			var merged []string
+			var conflicts []diff.MergeRegion[string]
+			for _, r := range regions {
+				if r.Conflict {
+					conflicts = append(conflicts, r)
+					continue
+				}
+				merged = append(merged, r.Lines...)
+			}
+
+			if !tt.conflict {
+				if len(conflicts) != 0 {
+					t.Fatalf("unexpected conflicts: %+v", conflicts)
+				}
+				if !reflect.DeepEqual(merged, split(tt.want)) {
+					t.Errorf("merged = %v, want %v", merged, split(tt.want))
+				}
+				return
+			}
+			if len(conflicts) != 1 {
+				t.Fatalf("expected one conflict, got %+v", regions)
+			}
+			c := conflicts[0]
+			if !reflect.DeepEqual(c.Ours, split(tt.wantOurs)) || !reflect.DeepEqual(c.Theirs, split(tt.wantTheirs)) {
+				t.Errorf("conflict = %+v, want ours %v theirs %v", c, tt.wantOurs, tt.wantTheirs)
+			}
+		})
+	}
+}
@@ -0,0 +1,27 @@
+package diff_test
+
+import (
+	"testing"
+
+	"github.com/LeeFred3042U/kitcat/internal/diff"
+)
+
+func TestSimilarity(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b []string
+		want int
+	}{
+		{"both empty", nil, nil, 100},
+		{"identical", []string{"a", "b"}, []string{"a", "b"}, 100},
+		{"one side empty", []string{"a"}, nil, 0},
+		{"nothing shared", []string{"a", "b"}, []string{"c", "d"}, 0},
+		{"one line edited", []string{"a", "b", "c", "d"}, []string{"a", "b", "x", "d"}, 75},
+		{"lines appended", []string{"a", "b"}, []string{"a", "b", "c", "d"}, 50},
+	}
+	for _, tt := range tests {
+		if got := diff.Similarity(tt.a, tt.b); got != tt.want {
+			t.Errorf("%s: Similarity = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package diff_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/LeeFred3042U/kitcat/internal/diff"
+)
+
+// render writes hunks the way a unified diff shows them
+func render(hunks []diff.Hunk) string {
+	var sb strings.Builder
+	for _, h := range hunks {
+		sb.WriteString(h.Header() + "\n")
+		for _, l := range h.Lines {
+			prefix := " "
+			switch l.Operation {
+			case diff.INSERT:
+				prefix = "+"
+			case diff.DELETE:
+				prefix = "-"
+			}
+			sb.WriteString(prefix + l.Text + "\n")
+		}
+	}
+	return sb.String()
+}
+
+func numbered(from, to int) []string {
+	var lines []string
+	for i := from; i <= to; i++ {
+		lines = append(lines, fmt.Sprint(i))
+	}
+	return lines
+}
+
+func TestUnified(t *testing.T) {
+	twenty := numbered(1, 20)
+	edited := append([]string(nil), twenty...)
+	edited[1] = "two"
+	edited[17] = "eighteen"
+	nearby := append([]string(nil), twenty...)
+	nearby[4] = "five"
+	nearby[10] = "eleven"
+
+	tests := []struct {
+		name    string
+		a, b    []string
+		context int
+		want    string
+	}{
+		{"equal", twenty, twenty, 3, ""},
+		{"new file", nil, []string{"a", "b"}, 3, "@@ -0,0 +1,2 @@\n+a\n+b\n"},
+		{"deleted file", []string{"a"}, nil, 3, "@@ -1 +0,0 @@\n-a\n"},
+		{
+			"separate hunks", twenty, edited, 3,
+			"@@ -1,5 +1,5 @@\n 1\n-2\n+two\n 3\n 4\n 5\n" +
+				"@@ -15,6 +15,6 @@\n 15\n 16\n 17\n-18\n+eighteen\n 19\n 20\n",
+		},
+		{
+			"close changes share a hunk", twenty, nearby, 3,
+			"@@ -2,13 +2,13 @@\n 2\n 3\n 4\n-5\n+five\n 6\n 7\n 8\n 9\n 10\n-11\n+eleven\n 12\n 13\n 14\n",
+		},
+		{
+			"no context", twenty, nearby, 0,
+			"@@ -5 +5 @@\n-5\n+five\n@@ -11 +11 @@\n-11\n+eleven\n",
+		},
+		{"pure insertion", []string{"a", "c"}, []string{"a", "b", "c"}, 0, "@@ -1,0 +2 @@\n+b\n"},
+	}
+	for _, tt := range tests {
+		if got := render(diff.Unified(tt.a, tt.b, tt.context)); got != tt.want {
+			t.Errorf("%s:\ngot\n%s\nwant\n%s", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package diff_test
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/LeeFred3042U/kitcat/internal/diff"
+)
+
+func TestTokenize(t *testing.T) {
+	text := "one  two\nthree"
+	words, gaps := diff.Tokenize(text, diff.DefaultWordRegex)
+	if strings.Join(words, "|") != "one|two|three" || strings.Join(gaps, "|") != "|  |\n|" {
+		t.Errorf("Tokenize = %q, %q", words, gaps)
+	}
+	words, gaps = diff.Tokenize(text, regexp.MustCompile(`[a-z]`))
+	var sb strings.Builder
+	for i, w := range words {
+		sb.WriteString(gaps[i] + w)
+	}
+	if sb.String()+gaps[len(words)] != text {
+		t.Error("interleaving words and gaps does not give back the text")
+	}
+}
+
+func TestWords(t *testing.T) {
+	tests := []struct {
+		name, a, b string
+		word       *regexp.Regexp
+		want       string
+	}{
+		{"one word", "the brown fox", "the red fox", diff.DefaultWordRegex, "the [-brown-]{+red+} fox"},
+		{"phrase", "the brown fox jumps", "the red dog jumps", diff.DefaultWordRegex, "the [-brown fox-]{+red dog+} jumps"},
+		{"punctuation", "lazy dog.", "lazy dog!", diff.DefaultWordRegex, "lazy [-dog.-]{+dog!+}"},
+		{"characters", "lazy dog.", "lazy dog!", regexp.MustCompile(`.`), "lazy dog[-.-]{+!+}"},
+		{"only matches are words", "a b c d", "a B c d e", regexp.MustCompile(`[a-z]`), "a[-b-] B c d {+e+}"},
+		{"spacing is not content", "one  two\tthree", "one two  three", diff.DefaultWordRegex, "one two  three"},
+		{"deletion", "keep this and that", "keep that", diff.DefaultWordRegex, "keep[-this and-] that"},
+	}
+	for _, tt := range tests {
+		var sb strings.Builder
+		for _, d := range diff.Words(tt.a, tt.b, tt.word, diff.Myers) {
+			text := strings.Join(d.Text, "")
+			switch d.Operation {
+			case diff.DELETE:
+				text = "[-" + text + "-]"
+			case diff.INSERT:
+				text = "{+" + text + "+}"
+			}
+			sb.WriteString(text)
+		}
+		if got := sb.String(); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}